@@ -0,0 +1,192 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the admin page showing the database's query
+ * statistics, the state of the thumbnail jobs and image cache, and the
+ * `Server-Timing` header of the responses.
+ */
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mwat56/kaliber/db"
+	"github.com/mwat56/sessions"
+)
+
+const (
+	// Max. number of query shapes listed in a `Server-Timing` header.
+	serverTimingShapes = 8
+)
+
+type (
+	// `tTimingWriter` adds the `Server-Timing` header to a response.
+	tTimingWriter struct {
+		http.ResponseWriter
+		ctx     context.Context // the request's timing context
+		details bool            // flag whether to list the query shapes
+		start   time.Time       // the time the request arrived
+		wrote   bool            // flag whether the header was written
+	}
+)
+
+// `handleAdmin()` serves the `/admin` page.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aOptions` The current query options to use.
+//	`aSession` The current user session.
+func (ph *TPageHandler) handleAdmin(aWriter http.ResponseWriter, aRequest *http.Request, aOptions *db.TQueryOptions, aSession *sessions.TSession) {
+	if (nil == ph.auth) || (nil == aRequest.URL.User) ||
+		!ph.isAdmin(aRequest.URL.User.Username()) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+
+	pageData := ph.basicTemplateData(aRequest, aOptions).
+		Set("CacheStats", db.QueryCacheStats()).
+		Set("ImageCache", ImageCacheStats()).
+		Set("QueryTimeout", time.Duration(AppArgs.QueryTimeout)*time.Second).
+		Set("SID", aSession.ID()).
+		Set("SIDNAME", sessions.SIDname()).
+		Set("ShowForm", false).
+		Set("SlowQuery", time.Duration(AppArgs.SlowQuery)*time.Millisecond).
+		Set("Thumbs", ThumbnailStats()).
+		Set("Timings", db.QueryTimings())
+	aWriter.Header().Set(`Cache-Control`, `no-store`)
+	ph.handleReply(`admin`, aWriter, aRequest, aOptions, aSession, pageData)
+} // handleAdmin()
+
+// `isAdmin()` returns whether `aUser` has one of the roles allowed
+// to see the admin page.
+//
+//	`aUser` The user to check.
+func (ph *TPageHandler) isAdmin(aUser string) bool {
+	if nil == ph.totp {
+		return false
+	}
+
+	// The roles are matched the same way as the TOTP roles:
+	return totpRequired(splitList(AppArgs.AdminRoles), ph.userRoles(aUser))
+} // isAdmin()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `serverTiming()` returns the value of a `Server-Timing` header
+// listing the total duration of `aTimings` and – if `aDetails` is
+// `true` – the durations of the (slowest) query shapes.
+//
+// The shapes reveal the database's structure so they are meant
+// for the administrators only.
+//
+//	`aTimings` The timings of the request's queries.
+//	`aDetails` Flag whether to list the query shapes.
+func serverTiming(aTimings []db.TQueryTiming, aDetails bool) string {
+	if 0 == len(aTimings) {
+		return ``
+	}
+	var (
+		count uint64
+		total time.Duration
+	)
+	for _, timing := range aTimings {
+		count += timing.Count
+		total += timing.Total
+	}
+	if !aDetails {
+		return fmt.Sprintf(`db;dur=%.3f`, msecs(total))
+	}
+	entries := make([]string, 0, serverTimingShapes+1)
+	entries = append(entries,
+		fmt.Sprintf(`db;dur=%.3f;desc="%d queries"`, msecs(total), count))
+
+	quote := strings.NewReplacer(`"`, `'`, `\`, `/`)
+	for idx, timing := range aTimings {
+		if serverTimingShapes <= idx {
+			break
+		}
+		desc := timing.Shape
+		if 48 < len(desc) {
+			desc = desc[:45] + `...`
+		}
+		entries = append(entries, fmt.Sprintf(`%s;dur=%.3f;desc="%dx %s"`,
+			timing.ID, msecs(timing.Total), timing.Count, quote.Replace(desc)))
+	}
+
+	return strings.Join(entries, `, `)
+} // serverTiming()
+
+// `msecs()` returns `aDuration` as fractional milliseconds.
+//
+//	`aDuration` The duration to convert.
+func msecs(aDuration time.Duration) float64 {
+	return float64(aDuration) / float64(time.Millisecond)
+} // msecs()
+
+// `newTimingWriter()` returns a response writer adding the
+// `Server-Timing` header to the response of `aRequest`, along with
+// the request using a timing context.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+func newTimingWriter(aWriter http.ResponseWriter, aRequest *http.Request) (*tTimingWriter, *http.Request) {
+	aRequest = aRequest.WithContext(db.NewTimingContext(aRequest.Context()))
+
+	return &tTimingWriter{
+		ResponseWriter: aWriter,
+		ctx:            aRequest.Context(),
+		start:          time.Now(),
+	}, aRequest
+} // newTimingWriter()
+
+// Flush sends any buffered data to the client.
+func (tw *tTimingWriter) Flush() {
+	tw.setHeader()
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+} // Flush()
+
+// `setHeader()` adds the `Server-Timing` header (once).
+func (tw *tTimingWriter) setHeader() {
+	if tw.wrote {
+		return
+	}
+	tw.wrote = true
+	timings := serverTiming(db.ContextTimings(tw.ctx), tw.details)
+	if 0 < len(timings) {
+		timings += `, `
+	}
+	tw.Header().Set(`Server-Timing`, timings+
+		fmt.Sprintf(`app;dur=%.3f`, msecs(time.Since(tw.start))))
+} // setHeader()
+
+// Write sends `aData` as (part of) the response body.
+//
+//	`aData` The data to send.
+func (tw *tTimingWriter) Write(aData []byte) (int, error) {
+	tw.setHeader()
+
+	return tw.ResponseWriter.Write(aData)
+} // Write()
+
+// WriteHeader sends the response header with `aStatus`.
+//
+//	`aStatus` The HTTP status code to send.
+func (tw *tTimingWriter) WriteHeader(aStatus int) {
+	tw.setHeader()
+	tw.ResponseWriter.WriteHeader(aStatus)
+} // WriteHeader()
+
+/* _EoF_ */
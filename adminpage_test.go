@@ -0,0 +1,65 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwat56/kaliber/db"
+)
+
+func Test_serverTiming(t *testing.T) {
+	t1 := db.TQueryTiming{Count: 2, ID: `sql-00000001`, Shape: `SELECT id FROM books`, Total: 3 * time.Millisecond}
+	t2 := db.TQueryTiming{Count: 1, ID: `sql-00000002`, Shape: `SELECT "x" FROM books WHERE 1 = 1 AND 2 = 2 AND 3 = 3 AND 4 = 4`, Total: time.Millisecond}
+	tests := []struct {
+		name     string
+		aTimings []db.TQueryTiming
+		aDetails bool
+		want     string
+	}{
+		// TODO: Add test cases.
+		{" 1", nil, true, ``},
+		{" 2", []db.TQueryTiming{t1}, true, `db;dur=3.000;desc="2 queries", sql-00000001;dur=3.000;desc="2x SELECT id FROM books"`},
+		{" 3", []db.TQueryTiming{t1, t2}, true, `db;dur=4.000;desc="3 queries", sql-00000001;dur=3.000;desc="2x SELECT id FROM books", sql-00000002;dur=1.000;desc="1x SELECT 'x' FROM books WHERE 1 = 1 AND 2 = 2 A..."`},
+		{" 4", []db.TQueryTiming{t1, t2}, false, `db;dur=4.000`},
+		{" 5", nil, false, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serverTiming(tt.aTimings, tt.aDetails); got != tt.want {
+				t.Errorf("serverTiming() = %q,\nwant %q", got, tt.want)
+			}
+		})
+	}
+} // Test_serverTiming()
+
+func Test_tTimingWriter(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(`GET`, `/faq`, nil)
+	tw, req := newTimingWriter(recorder, request)
+	if 0 != len(db.ContextTimings(req.Context())) {
+		t.Errorf("ContextTimings() of a new request isn't empty")
+	}
+	tw.WriteHeader(http.StatusNoContent)
+	_, _ = tw.Write([]byte(`x`))
+
+	header := recorder.Header().Values(`Server-Timing`)
+	if (1 != len(header)) || !strings.HasPrefix(header[0], `app;dur=`) {
+		t.Errorf("Server-Timing = %q, want one 'app' entry", header)
+	}
+	if http.StatusNoContent != recorder.Code {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusNoContent)
+	}
+} // Test_tTimingWriter()
+
+/* _EoF_ */
@@ -0,0 +1,499 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the API tokens used by e-reader apps and
+ * scripts instead of the users' real passwords.
+ */
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes of the API tokens.
+const (
+	// TokenScopeDownload allows to download the documents' files.
+	TokenScopeDownload = `download`
+
+	// TokenScopeRead allows to read the documents' metadata.
+	TokenScopeRead = `read`
+)
+
+const (
+	// Prefix of all API tokens (to tell them from passwords).
+	tokenPrefix = `kbr_`
+
+	// Minimal time between two writes of the last-used timestamps.
+	tokenSaveInterval = time.Minute
+)
+
+type (
+	// TTokenInfo describes an API token (without its secret part).
+	TTokenInfo struct {
+		Created  time.Time // time the token was created
+		Expires  time.Time // expiry time (zero: never)
+		ID       string    // the token's public ID
+		LastUsed time.Time // time of the token's last use
+		Name     string    // description given by the user
+		Scopes   []string  // what the token may be used for
+		User     string    // the user the token belongs to
+	}
+
+	// `tToken` is an API token as stored in the token file.
+	tToken struct {
+		TTokenInfo
+		hash  string    // SHA256 of the token's secret part
+		saved time.Time // `LastUsed` value last written to disk
+	}
+
+	// TTokenStore manages the users' API tokens.
+	//
+	// The data is kept in a text file next to the password file;
+	// each line holds the colon separated fields
+	//
+	//	id:user:scope,scope:created:expires:lastUsed:hash:name
+	TTokenStore struct {
+		filename string             // name of the data file
+		mtx      *sync.Mutex        // guard for `tokens`
+		tokens   map[string]*tToken // list of tokens by ID
+	}
+)
+
+// Expired returns whether the token is expired at `aTime`.
+//
+//	`aTime` The time to check.
+func (ti TTokenInfo) Expired(aTime time.Time) bool {
+	return (!ti.Expires.IsZero()) && (!aTime.Before(ti.Expires))
+} // Expired()
+
+// HasScope returns whether the token allows `aScope`.
+//
+//	`aScope` The scope to check.
+func (ti TTokenInfo) HasScope(aScope string) bool {
+	for _, scope := range ti.Scopes {
+		if scope == aScope {
+			return true
+		}
+	}
+
+	return false
+} // HasScope()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// NewTokenStore returns a new `TTokenStore` instance using `aFilename`.
+//
+// A missing file is not an error; it gets created when the first
+// token is added.
+//
+//	`aFilename` The name of the data file to use.
+func NewTokenStore(aFilename string) (*TTokenStore, error) {
+	result := &TTokenStore{
+		filename: aFilename,
+		mtx:      new(sync.Mutex),
+		tokens:   make(map[string]*tToken, 16),
+	}
+	if err := result.load(); nil != err {
+		return nil, err
+	}
+
+	return result, nil
+} // NewTokenStore()
+
+// TokensFilename returns the name of the API token file.
+//
+// It's the password file's name with a `.tokens` extension or –
+// if there's no password file – `tokens.db` in the private directory
+// (see `privateFilename()`).
+func TokensFilename() string {
+	return tokensFilename(AppArgs.PassFile)
+} // TokensFilename()
+
+// `tokensFilename()` returns the name of the API token file belonging to
+// the password file `aPassFile`.
+//
+//	`aPassFile` The name of the password file in use.
+func tokensFilename(aPassFile string) string {
+	return privateFilename(aPassFile, `.tokens`, `tokens.db`)
+} // tokensFilename()
+
+// `parseScopes()` returns the valid scopes listed in `aList`.
+//
+//	`aList` Comma separated list of scopes.
+func parseScopes(aList string) ([]string, error) {
+	var result []string
+	for _, scope := range splitList(strings.ToLower(aList)) {
+		switch scope {
+		case TokenScopeDownload, TokenScopeRead:
+			result = append(result, scope)
+		default:
+			return nil, fmt.Errorf("invalid token scope '%s'", scope)
+		}
+	}
+	if 0 == len(result) {
+		return nil, errors.New(`missing token scope`)
+	}
+	sort.Strings(result)
+
+	return result, nil
+} // parseScopes()
+
+// `tokenHash()` returns the hash stored for a token's secret part.
+//
+//	`aSecret` The secret part of a token.
+func tokenHash(aSecret string) string {
+	sum := sha256.Sum256([]byte(aSecret))
+
+	return hex.EncodeToString(sum[:])
+} // tokenHash()
+
+// `tokenFromRequest()` returns the API token sent with `aRequest`
+// (if any).
+//
+// The token is looked for in the `Authorization: Bearer` header,
+// the BasicAuth password, and the `token` URL parameter.
+//
+//	`aRequest` The HTTP request received by the server.
+func tokenFromRequest(aRequest *http.Request) string {
+	if auth := aRequest.Header.Get(`Authorization`); 7 < len(auth) {
+		if strings.EqualFold(`Bearer `, auth[:7]) {
+			return strings.TrimSpace(auth[7:])
+		}
+	}
+	if _, pass, ok := aRequest.BasicAuth(); ok && strings.HasPrefix(pass, tokenPrefix) {
+		return pass
+	}
+	if token := aRequest.URL.Query().Get(`token`); strings.HasPrefix(token, tokenPrefix) {
+		return token
+	}
+
+	return ``
+} // tokenFromRequest()
+
+// `tokenScope()` returns the scope needed to serve `aRequest`.
+//
+// Only the pages explicitly listed here can be accessed with an API
+// token: the metadata pages need the `read` scope and the documents'
+// files the `download` scope.
+// An empty string is returned for all other pages (e.g. the admin
+// or token management pages, or routes added later without being
+// listed here), which then require a password login.
+//
+//	`aRequest` The HTTP request received by the server.
+func tokenScope(aRequest *http.Request) string {
+	path, _ := URLparts(aRequest.URL.Path)
+	switch path {
+	case ``, `authors`, `back`, `changes`, `collage`, `cover`, `css`,
+		`doc`, `favicon.ico`, `first`, `fonts`, `format`, `img`, `js`,
+		`languages`, `last`, `next`, `post`, `prev`, `publisher`, `qo`,
+		`search`, `series`, `tags`, `thumb`:
+		return TokenScopeRead
+
+	case `comic`, `file`, `read`:
+		return TokenScopeDownload
+	}
+
+	return ``
+} // tokenScope()
+
+// Add creates a new API token for `aUser`, returning the token
+// which is shown only once (only its hash gets stored).
+//
+//	`aUser` The token's owner.
+//	`aName` A description of the token's use.
+//	`aScopes` The scopes allowed for the token.
+//	`aExpires` The expiry time (zero: never).
+func (ts *TTokenStore) Add(aUser, aName string, aScopes []string, aExpires time.Time) (string, error) {
+	if (0 == len(aUser)) || strings.ContainsAny(aUser, ":\n") {
+		return ``, fmt.Errorf("Add: invalid username '%s'", aUser)
+	}
+	if 0 == len(aScopes) {
+		return ``, errors.New(`Add: missing token scope`)
+	}
+	aName = strings.Map(func(r rune) rune {
+		if ('\n' == r) || ('\r' == r) {
+			return ' '
+		}
+		return r
+	}, strings.TrimSpace(aName))
+
+	buf := make([]byte, 4+24)
+	if _, err := rand.Read(buf); nil != err {
+		return ``, err
+	}
+	id := hex.EncodeToString(buf[:4])
+	secret := base64.RawURLEncoding.EncodeToString(buf[4:])
+
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	if _, exists := ts.tokens[id]; exists {
+		return ``, errors.New(`Add: token ID collision, please retry`)
+	}
+	now := time.Now().Truncate(time.Second)
+	ts.tokens[id] = &tToken{
+		TTokenInfo: TTokenInfo{
+			Created: now,
+			Expires: aExpires.Truncate(time.Second),
+			ID:      id,
+			Name:    aName,
+			Scopes:  append([]string{}, aScopes...),
+			User:    aUser,
+		},
+		hash: tokenHash(secret),
+	}
+	if err := ts.save(); nil != err {
+		delete(ts.tokens, id)
+		return ``, err
+	}
+
+	return tokenPrefix + id + `_` + secret, nil
+} // Add()
+
+// Check verifies `aToken` for `aScope`, returning the token's user.
+//
+//	`aToken` The token sent by the remote user.
+//	`aScope` The scope needed for the current request.
+func (ts *TTokenStore) Check(aToken, aScope string) (string, error) {
+	if !strings.HasPrefix(aToken, tokenPrefix) {
+		return ``, errors.New(`Check: not an API token`)
+	}
+	id, secret, ok := strings.Cut(aToken[len(tokenPrefix):], `_`)
+	if !ok {
+		return ``, errors.New(`Check: malformed API token`)
+	}
+
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	token, ok := ts.tokens[id]
+	if (!ok) || (1 != subtle.ConstantTimeCompare([]byte(token.hash), []byte(tokenHash(secret)))) {
+		return ``, errors.New(`Check: unknown API token`)
+	}
+	now := time.Now()
+	if token.Expired(now) {
+		return ``, fmt.Errorf("Check: API token '%s' expired", id)
+	}
+	if (0 == len(aScope)) || !token.HasScope(aScope) {
+		return ``, fmt.Errorf("Check: API token '%s' lacks scope '%s'", id, aScope)
+	}
+
+	token.LastUsed = now.Truncate(time.Second)
+	if tokenSaveInterval <= token.LastUsed.Sub(token.saved) {
+		// a failed write is not a reason to deny the access
+		_ = ts.save()
+	}
+
+	return token.User, nil
+} // Check()
+
+// IsAuthenticated checks `aRequest` for an API token, returning
+// `nil` if a valid token with the scope needed was found, or an
+// `error` otherwise.
+//
+// On success the token's user is stored in `aRequest.URL.User`.
+//
+// This method implements the `TAuthenticator` interface.
+//
+//	`aRequest` The HTTP request received by the server.
+func (ts *TTokenStore) IsAuthenticated(aRequest *http.Request) error {
+	token := tokenFromRequest(aRequest)
+	if 0 == len(token) {
+		return errors.New(`IsAuthenticated: missing API token`)
+	}
+	user, err := ts.Check(token, tokenScope(aRequest))
+	if nil != err {
+		return err
+	}
+	aRequest.URL.User = url.User(user)
+
+	return nil
+} // IsAuthenticated()
+
+// List returns the tokens of `aUser` (or all tokens if `aUser`
+// is empty), sorted by user and creation time.
+//
+//	`aUser` The user whose tokens to list.
+func (ts *TTokenStore) List(aUser string) []TTokenInfo {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	result := make([]TTokenInfo, 0, len(ts.tokens))
+	for _, token := range ts.tokens {
+		if (0 == len(aUser)) || (aUser == token.User) {
+			result = append(result, token.TTokenInfo)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].User != result[j].User {
+			return result[i].User < result[j].User
+		}
+		if !result[i].Created.Equal(result[j].Created) {
+			return result[i].Created.Before(result[j].Created)
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+} // List()
+
+// `load()` reads the data file.
+func (ts *TTokenStore) load() error {
+	file, err := os.Open(ts.filename) // #nosec G304
+	if nil != err {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	parseTime := func(aValue string) (time.Time, error) {
+		sec, err := strconv.ParseInt(aValue, 10, 64)
+		if (nil != err) || (0 == sec) {
+			return time.Time{}, err
+		}
+		return time.Unix(sec, 0), nil
+	}
+
+	tokens := make(map[string]*tToken, 16)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if (0 == len(line)) || ('#' == line[0]) {
+			continue
+		}
+		fields := strings.SplitN(line, `:`, 8)
+		if 8 != len(fields) {
+			return fmt.Errorf("%s:%d: invalid number of fields", ts.filename, lineNo)
+		}
+		token := &tToken{
+			TTokenInfo: TTokenInfo{
+				ID:     fields[0],
+				Name:   fields[7],
+				Scopes: splitList(fields[2]),
+				User:   fields[1],
+			},
+			hash: fields[6],
+		}
+		if token.Created, err = parseTime(fields[3]); nil == err {
+			if token.Expires, err = parseTime(fields[4]); nil == err {
+				token.LastUsed, err = parseTime(fields[5])
+			}
+		}
+		if nil != err {
+			return fmt.Errorf("%s:%d: %w", ts.filename, lineNo, err)
+		}
+		token.saved = token.LastUsed
+		tokens[token.ID] = token
+	}
+	if err = scanner.Err(); nil != err {
+		return err
+	}
+	ts.tokens = tokens
+
+	return nil
+} // load()
+
+// Remove deletes the token `aID`; if `aUser` is not empty the token
+// must belong to that user.
+//
+//	`aID` The ID of the token to remove.
+//	`aUser` The (optional) owner of the token.
+func (ts *TTokenStore) Remove(aID, aUser string) error {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	token, ok := ts.tokens[aID]
+	if (!ok) || ((0 < len(aUser)) && (aUser != token.User)) {
+		return fmt.Errorf("Remove: unknown API token '%s'", aID)
+	}
+	delete(ts.tokens, aID)
+
+	return ts.save()
+} // Remove()
+
+// RemoveUser deletes all tokens of `aUser`, returning their number.
+//
+//	`aUser` The user whose tokens to remove.
+func (ts *TTokenStore) RemoveUser(aUser string) (int, error) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	count := 0
+	for id, token := range ts.tokens {
+		if aUser == token.User {
+			delete(ts.tokens, id)
+			count++
+		}
+	}
+	if 0 == count {
+		return 0, nil
+	}
+
+	return count, ts.save()
+} // RemoveUser()
+
+// `save()` writes the data file.
+//
+// NOTE: The caller is expected to hold the lock.
+func (ts *TTokenStore) save() error {
+	ids := make([]string, 0, len(ts.tokens))
+	for id := range ts.tokens {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	unix := func(aTime time.Time) int64 {
+		if aTime.IsZero() {
+			return 0
+		}
+		return aTime.Unix()
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Kaliber API tokens: id:user:scopes:created:expires:lastUsed:hash:name\n")
+	for _, id := range ids {
+		token := ts.tokens[id]
+		fmt.Fprintf(&sb, "%s:%s:%s:%d:%d:%d:%s:%s\n", id, token.User,
+			strings.Join(token.Scopes, `,`), unix(token.Created),
+			unix(token.Expires), unix(token.LastUsed), token.hash, token.Name)
+	}
+
+	tmpName := ts.filename + `~`
+	if err := os.WriteFile(tmpName, []byte(sb.String()), 0600); nil != err {
+		return err
+	}
+	if err := os.Rename(tmpName, ts.filename); nil != err {
+		return err
+	}
+	for _, token := range ts.tokens {
+		token.saved = token.LastUsed
+	}
+
+	return nil
+} // save()
+
+/* _EoF_ */
@@ -0,0 +1,154 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		aList   string
+		want    []string
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `read`, []string{`read`}, false},
+		{" 2", ` Download , read`, []string{`download`, `read`}, false},
+		{" 3", ``, nil, true},
+		{" 4", `read,write`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseScopes(tt.aList)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("parseScopes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // Test_parseScopes()
+
+func TestTTokenStore(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), `pwaccess.db.tokens`)
+	ts, err := NewTokenStore(fName)
+	if nil != err {
+		t.Fatalf("NewTokenStore() error = %v", err)
+	}
+	readTok, err := ts.Add(`alice`, `reader app`, []string{TokenScopeRead}, time.Time{})
+	if nil != err {
+		t.Fatalf("TTokenStore.Add() error = %v", err)
+	}
+	dlTok, _ := ts.Add(`alice`, "script\nname", []string{TokenScopeDownload, TokenScopeRead}, time.Time{})
+	oldTok, _ := ts.Add(`bob`, `expired`, []string{TokenScopeRead}, time.Now().Add(-time.Hour))
+	if !strings.HasPrefix(readTok, tokenPrefix) {
+		t.Errorf("TTokenStore.Add() = %q, want prefix %q", readTok, tokenPrefix)
+	}
+	if _, err = ts.Add(`carol:x`, ``, []string{TokenScopeRead}, time.Time{}); nil == err {
+		t.Error("TTokenStore.Add() with invalid user: error = nil")
+	}
+
+	// Data must survive a reload:
+	if ts, err = NewTokenStore(fName); nil != err {
+		t.Fatalf("NewTokenStore() reload error = %v", err)
+	}
+	names := []string{}
+	for _, ti := range ts.List(`alice`) {
+		names = append(names, ti.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{`reader app`, `script name`}) {
+		t.Errorf("TTokenStore.List() names = %v", names)
+	}
+
+	tests := []struct {
+		name     string
+		aPath    string
+		aToken   string
+		aHow     string // `bearer`, `basic`, or `url`
+		wantUser string
+		wantErr  bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `/doc/1/x`, readTok, `bearer`, `alice`, false},
+		{" 2", `/file/1/x.epub`, readTok, `bearer`, ``, true},
+		{" 3", `/file/1/x.epub`, dlTok, `basic`, `alice`, false},
+		{" 4", `/file/1/x.epub`, dlTok, `url`, `alice`, false},
+		{" 5", `/doc/1/x`, oldTok, `bearer`, ``, true},
+		{" 6", `/doc/1/x`, readTok + `x`, `bearer`, ``, true},
+		{" 7", `/tokens`, dlTok, `bearer`, ``, true},
+		{" 8", `/doc/1/x`, `password`, `basic`, ``, true},
+		{" 9", `/read/1/2`, readTok, `bearer`, ``, true},
+		{"10", `/read/1/2`, dlTok, `bearer`, `alice`, false},
+		{"11", `/comic/1/img/2`, readTok, `bearer`, ``, true},
+		{"12", `/comic/1/img/2`, dlTok, `url`, `alice`, false},
+		{"13", `/admin`, dlTok, `bearer`, ``, true},
+		{"14", `/changes/atom`, readTok, `bearer`, `alice`, false},
+		{"15", `/certs/x`, dlTok, `bearer`, ``, true},
+		{"16", `/no/such/page`, readTok, `bearer`, ``, true},
+		{"17", `/`, readTok, `bearer`, `alice`, false},
+		{"18", `/js/blurhash.js`, readTok, `bearer`, `alice`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.aPath
+			if `url` == tt.aHow {
+				target += `?token=` + tt.aToken
+			}
+			req := httptest.NewRequest(`GET`, target, nil)
+			switch tt.aHow {
+			case `basic`:
+				req.SetBasicAuth(`whoever`, tt.aToken)
+			case `bearer`:
+				req.Header.Set(`Authorization`, `Bearer `+tt.aToken)
+			}
+			err := ts.IsAuthenticated(req)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("TTokenStore.IsAuthenticated() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := req.URL.User.Username(); got != tt.wantUser {
+				t.Errorf("TTokenStore.IsAuthenticated() user = %q, want %q", got, tt.wantUser)
+			}
+		})
+	}
+
+	for _, ti := range ts.List(`alice`) {
+		if ti.LastUsed.IsZero() {
+			t.Errorf("TTokenStore.Check() didn't set LastUsed of '%s'", ti.ID)
+		}
+	}
+
+	id := ts.List(`alice`)[0].ID
+	if err = ts.Remove(id, `bob`); nil == err {
+		t.Error("TTokenStore.Remove() of foreign token: error = nil")
+	}
+	if err = ts.Remove(id, `alice`); nil != err {
+		t.Errorf("TTokenStore.Remove() error = %v", err)
+	}
+	if n, _ := ts.RemoveUser(`alice`); 1 != n {
+		t.Errorf("TTokenStore.RemoveUser() = %d, want 1", n)
+	}
+	if got := ts.List(``); (1 != len(got)) || (`bob` != got[0].User) {
+		t.Errorf("TTokenStore.List() = %v", got)
+	}
+} // TestTTokenStore()
@@ -25,6 +25,7 @@ import (
 	"github.com/mwat56/apachelogger"
 	"github.com/mwat56/errorhandler"
 	"github.com/mwat56/kaliber"
+	"github.com/mwat56/kaliber/db"
 	"github.com/mwat56/sessions"
 )
 
@@ -37,6 +38,24 @@ func exit(aMessage string) {
 
 // `userCmdline()` checks for and executes user/password handling functions.
 func userCmdline() {
+	// The API token and TOTP data files don't depend on the
+	// authentication backend:
+	if 0 < len(kaliber.AppArgs.TokenAdd) {
+		kaliber.TokenAdd(kaliber.AppArgs.TokenAdd, kaliber.TokensFilename())
+	}
+	if 0 < len(kaliber.AppArgs.TokenDelete) {
+		kaliber.TokenDelete(kaliber.AppArgs.TokenDelete, kaliber.TokensFilename())
+	}
+	if kaliber.AppArgs.TokenList {
+		kaliber.TokenList(kaliber.TokensFilename())
+	}
+	if 0 < len(kaliber.AppArgs.UserRoles) {
+		kaliber.UserRoles(kaliber.AppArgs.UserRoles, kaliber.TOTPfilename())
+	}
+	if 0 < len(kaliber.AppArgs.UserTOTP) {
+		kaliber.UserTOTP(kaliber.AppArgs.UserTOTP, kaliber.TOTPfilename())
+	}
+
 	if 0 == len(kaliber.AppArgs.PassFile) {
 		return // without user file nothing to do
 	}
@@ -59,6 +78,18 @@ func userCmdline() {
 	}
 } // userCmdline()
 
+// `cacheCmdline()` checks for and executes the image cache
+// maintenance functions.
+func cacheCmdline() {
+	// Both `kaliber.CacheXxx()` calls terminate the program:
+	if kaliber.AppArgs.CacheRebuild {
+		kaliber.CacheRebuild()
+	}
+	if kaliber.AppArgs.CachePrune {
+		kaliber.CachePrune()
+	}
+} // cacheCmdline()
+
 // `setupSignals()` configures the capture of the interrupts `SIGINT`
 // and `SIGTERM` to terminate the program gracefully.
 //
@@ -74,6 +105,7 @@ func setupSignals(aServer *http.Server) {
 			apachelogger.Err(`Kaliber/catchSignals`, msg)
 			log.Println(msg)
 			runtime.Gosched() // let the logger write
+			db.CloseComics()
 			if err := aServer.Shutdown(context.Background()); nil != err {
 				exit(fmt.Sprintf("%s: %v", os.Args[0], err))
 			}
@@ -94,6 +126,9 @@ func main() {
 	// Handle commandline user/password maintenance:
 	userCmdline()
 
+	// Handle commandline image cache maintenance:
+	cacheCmdline()
+
 	if ph, err = kaliber.NewPageHandler(); nil != err {
 		kaliber.ShowHelp()
 		exit(fmt.Sprintf("%s: %v", Me, err))
@@ -101,6 +136,20 @@ func main() {
 	// Setup the errorpage handler:
 	handler := errorhandler.Wrap(ph, ph)
 
+	// Select the virtual host's handler by the `Host` header
+	// falling back to the default site:
+	if hosts := kaliber.VirtualHosts(); 0 < len(hosts) {
+		hs := kaliber.NewHostSwitch(handler)
+		for _, host := range hosts {
+			hph, err := kaliber.NewHostHandler(host)
+			if nil != err {
+				exit(fmt.Sprintf("%s: host '%s': %v", Me, host.Host, err))
+			}
+			hs.Add(host, errorhandler.Wrap(hph, hph))
+		}
+		handler = hs
+	}
+
 	// Inspect `sessiondir` config option and setup the session handler
 	if 0 < len(kaliber.AppArgs.SessionDir) {
 		// an empty string means: no automatic session handling
@@ -0,0 +1,164 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the pluggable authentication backends used
+ * by `TPageHandler`.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mwat56/passlist"
+)
+
+// Names of the available authentication backends
+// (INI setting `authBackend`).
+const (
+	AuthBackendHtpasswd = `htpasswd`
+	AuthBackendLDAP     = `ldap`
+	AuthBackendPasslist = `passlist`
+)
+
+type (
+	// TAuthenticator is the interface implemented by all
+	// authentication backends.
+	//
+	// The method signature is the one used by `passlist.TPassList`
+	// so that the original password file can be used unchanged.
+	TAuthenticator interface {
+		// IsAuthenticated checks `aRequest` for authentication data,
+		// returning `nil` for successful authentication, or an `error`
+		// otherwise.
+		//
+		// On success the username is stored in `aRequest.URL.User`.
+		IsAuthenticated(aRequest *http.Request) error
+	}
+
+	// `tAuthChain` is a list of authenticators tried in turn.
+	tAuthChain []TAuthenticator
+
+	// `tGroupLister` is implemented by authenticators which know
+	// about the groups a user belongs to (e.g. `TLDAPauth`).
+	tGroupLister interface {
+		Groups(aUser string) []string
+	}
+)
+
+// Groups returns the groups of `aUser` as known by the chained
+// authenticators.
+//
+//	`aUser` The user to lookup.
+func (ac tAuthChain) Groups(aUser string) (rList []string) {
+	for _, auth := range ac {
+		if gl, ok := auth.(tGroupLister); ok {
+			rList = append(rList, gl.Groups(aUser)...)
+		}
+	}
+
+	return
+} // Groups()
+
+// IsAuthenticated returns `nil` if one of the chained authenticators
+// accepts `aRequest`, or the last error encountered otherwise.
+//
+//	`aRequest` The HTTP request received by the server.
+func (ac tAuthChain) IsAuthenticated(aRequest *http.Request) (rErr error) {
+	rErr = errors.New(`IsAuthenticated: no authenticator available`)
+	for _, auth := range ac {
+		if rErr = auth.IsAuthenticated(aRequest); nil == rErr {
+			return
+		}
+	}
+
+	return
+} // IsAuthenticated()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// NewAuthenticator returns the authentication backend configured
+// by `AppArgs`.
+//
+// If neither a backend nor a trusted reverse proxy is configured
+// the function returns `nil` (i.e. authentication is disabled).
+// A configured backend which can't be used results in an error
+// which the caller must not ignore.
+func NewAuthenticator() (TAuthenticator, error) {
+	return newAuthenticator(AppArgs.PassFile)
+} // NewAuthenticator()
+
+// `newAuthenticator()` returns the authentication backend configured
+// by `AppArgs` using the password file `aPassFile`.
+//
+//	`aPassFile` The password file of the `passlist` backend.
+func newAuthenticator(aPassFile string) (TAuthenticator, error) {
+	var (
+		backend  TAuthenticator
+		err      error
+		proxyErr error
+		result   tAuthChain
+	)
+	if 0 < len(strings.TrimSpace(AppArgs.AuthProxyHdr)) {
+		// The proxy is the first link of the chain so that its users
+		// don't need any BasicAuth data.
+		if pa := NewProxyAuth(AppArgs.AuthProxyHdr, AppArgs.AuthProxyIPs); nil != pa {
+			result = append(result, pa)
+		} else {
+			proxyErr = errors.New("missing or invalid `authProxyTrusted` value")
+		}
+	}
+
+	switch strings.ToLower(AppArgs.AuthBackend) {
+	case AuthBackendHtpasswd:
+		if 0 == len(AppArgs.HtpasswdFile) {
+			err = errors.New("missing `htpasswdFile` value")
+		} else {
+			var hp *THtpasswd
+			if hp, err = NewHtpasswd(AppArgs.HtpasswdFile); nil == err {
+				backend = hp
+			}
+		}
+
+	case AuthBackendLDAP:
+		var la *TLDAPauth
+		if la, err = NewLDAPauth(AppArgs.LDAP); nil == err {
+			backend = la
+		}
+
+	case AuthBackendPasslist, ``:
+		if 0 < len(aPassFile) {
+			var ul *passlist.TPassList
+			if ul, err = passlist.LoadPasswords(aPassFile); nil == err {
+				backend = ul
+			}
+		}
+
+	default:
+		err = fmt.Errorf("unknown `authBackend` value '%s'", AppArgs.AuthBackend)
+	}
+	if nil != backend {
+		result = append(result, backend)
+	}
+	err = errors.Join(proxyErr, err)
+
+	switch len(result) {
+	case 0:
+		return nil, err
+	case 1:
+		return result[0], err
+	}
+
+	return result, err
+} // newAuthenticator()
+
+/* _EoF_ */
@@ -0,0 +1,57 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"testing"
+)
+
+func Test_newAuthenticator(t *testing.T) {
+	saved := AppArgs
+	t.Cleanup(func() { AppArgs = saved })
+
+	tests := []struct {
+		name      string
+		aHeader   string
+		aTrusted  string
+		aBackend  string
+		aPassFile string
+		wantAuth  bool
+		wantErr   bool
+	}{
+		// TODO: Add test cases.
+		{" 1", ``, ``, ``, ``, false, false},
+		{" 2", `X-Remote-User`, `127.0.0.1`, ``, ``, true, false},
+		{" 3", `X-Remote-User`, ``, ``, ``, false, true},
+		{" 4", `X-Remote-User`, `no.such.address`, ``, ``, false, true},
+		{" 5", ``, ``, `passlist`, ``, false, false},
+		{" 6", ``, ``, `ldpa`, ``, false, true},
+		{" 7", ``, ``, `htpasswd`, ``, false, true},
+		{" 8", ``, ``, `ldap`, ``, false, true},
+		{" 9", `X-Remote-User`, `127.0.0.1`, `ldap`, ``, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AppArgs.AuthProxyHdr = tt.aHeader
+			AppArgs.AuthProxyIPs = tt.aTrusted
+			AppArgs.AuthBackend = tt.aBackend
+			AppArgs.HtpasswdFile = ``
+			AppArgs.LDAP = TLDAPconfig{URL: `ldap://[bad`}
+			got, err := newAuthenticator(tt.aPassFile)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("newAuthenticator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (nil != got) != tt.wantAuth {
+				t.Errorf("newAuthenticator() = %v, wantAuth %v", got, tt.wantAuth)
+			}
+		})
+	}
+} // Test_newAuthenticator()
+
+/* _EoF_ */
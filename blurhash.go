@@ -0,0 +1,205 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the BlurHash (see https://blurha.sh/) and the
+ * dominant colour of a cover image which allow the browser to paint
+ * a placeholder before the actual thumbnail is loaded.
+ */
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+const (
+	// The characters used by the BlurHash's base83 encoding.
+	bhCharacters = `0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~`
+
+	// Number of horizontal and vertical components of a cover's
+	// BlurHash (covers are usually portrait images).
+	bhXComponents, bhYComponents = 3, 4
+
+	// The width the image is scaled to before computing the hash.
+	bhSampleWidth = 32
+)
+
+// `blurHash()` returns the BlurHash of `aImage` with `aX` horizontal
+// and `aY` vertical components.
+//
+//	`aImage` The image to compute the hash for.
+//	`aX` The number of horizontal components (`1` to `9`).
+//	`aY` The number of vertical components (`1` to `9`).
+func blurHash(aImage image.Image, aX, aY int) string {
+	bounds := aImage.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if (0 >= width) || (0 >= height) {
+		return ``
+	}
+
+	// the image's pixels in linear RGB:
+	pixels := make([][3]float64, 0, width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := aImage.At(x, y).RGBA()
+			pixels = append(pixels, [3]float64{
+				sRGBToLinear(r >> 8), sRGBToLinear(g >> 8), sRGBToLinear(b >> 8)})
+		}
+	}
+
+	factors := make([][3]float64, 0, aX*aY)
+	for j := 0; j < aY; j++ {
+		for i := 0; i < aX; i++ {
+			normalisation := 2.0
+			if (0 == i) && (0 == j) {
+				normalisation = 1.0
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				cosY := math.Cos(math.Pi * float64(j*y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := normalisation * cosY *
+						math.Cos(math.Pi*float64(i*x)/float64(width))
+					pixel := pixels[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+			scale := 1.0 / float64(width*height)
+			factors = append(factors, [3]float64{
+				factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var result strings.Builder
+	result.WriteString(encode83((aX-1)+(aY-1)*9, 1))
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if 0 < len(ac) {
+		actualMax := 0.0
+		for _, factor := range ac {
+			for _, value := range factor {
+				actualMax = math.Max(actualMax, math.Abs(value))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		result.WriteString(encode83(quantisedMax, 1))
+	} else {
+		result.WriteString(encode83(0, 1))
+	}
+	result.WriteString(encode83((linearToSRGB(dc[0])<<16)+
+		(linearToSRGB(dc[1])<<8)+linearToSRGB(dc[2]), 4))
+	for _, factor := range ac {
+		quant := func(aValue float64) int {
+			return int(math.Max(0, math.Min(18,
+				math.Floor(signPow(aValue/maxValue, 0.5)*9+9.5))))
+		}
+		result.WriteString(encode83(quant(factor[0])*19*19+
+			quant(factor[1])*19+quant(factor[2]), 2))
+	}
+
+	return result.String()
+} // blurHash()
+
+// `dominantColor()` returns the most frequent colour (`#rrggbb`)
+// of `aImage`.
+//
+// Similar colours are counted together; the result is the average
+// of the largest group.
+//
+//	`aImage` The image to inspect.
+func dominantColor(aImage image.Image) string {
+	type tBucket struct {
+		count   int
+		r, g, b uint32
+	}
+	var (
+		buckets [4096]tBucket // 4 bits per colour channel
+		best    int
+	)
+	bounds := aImage.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := aImage.At(x, y).RGBA()
+			r, g, b = r>>8, g>>8, b>>8
+			idx := int((r>>4)<<8 | (g>>4)<<4 | b>>4)
+			bucket := &buckets[idx]
+			bucket.count++
+			bucket.r += r
+			bucket.g += g
+			bucket.b += b
+			if bucket.count > buckets[best].count {
+				best = idx
+			}
+		}
+	}
+	bucket := buckets[best]
+	if 0 == bucket.count {
+		return ``
+	}
+	count := uint32(bucket.count)
+
+	return fmt.Sprintf("#%02x%02x%02x", bucket.r/count, bucket.g/count, bucket.b/count)
+} // dominantColor()
+
+// `encode83()` returns `aValue` as a base83 number with `aLength`
+// digits.
+//
+//	`aValue` The value to encode.
+//	`aLength` The number of digits.
+func encode83(aValue, aLength int) string {
+	result := make([]byte, aLength)
+	for idx := aLength - 1; 0 <= idx; idx-- {
+		result[idx] = bhCharacters[aValue%83]
+		aValue /= 83
+	}
+
+	return string(result)
+} // encode83()
+
+// `linearToSRGB()` returns the sRGB value (`0` to `255`) of the
+// linear colour value `aValue`.
+//
+//	`aValue` The linear value (`0.0` to `1.0`).
+func linearToSRGB(aValue float64) int {
+	value := math.Max(0, math.Min(1, aValue))
+	if 0.0031308 >= value {
+		return int(value*12.92*255 + 0.5)
+	}
+
+	return int((1.055*math.Pow(value, 1/2.4)-0.055)*255 + 0.5)
+} // linearToSRGB()
+
+// `signPow()` returns `aValue` raised to `aExp` keeping its sign.
+//
+//	`aValue` The base value.
+//	`aExp` The exponent.
+func signPow(aValue, aExp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(aValue), aExp), aValue)
+} // signPow()
+
+// `sRGBToLinear()` returns the linear colour value of the sRGB
+// value `aValue`.
+//
+//	`aValue` The sRGB value (`0` to `255`).
+func sRGBToLinear(aValue uint32) float64 {
+	value := float64(aValue) / 255
+	if 0.04045 >= value {
+		return value / 12.92
+	}
+
+	return math.Pow((value+0.055)/1.055, 2.4)
+} // sRGBToLinear()
+
+/* _EoF_ */
@@ -0,0 +1,105 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// `prepImage()` returns an image of `aWidth`×`aHeight` pixels filled
+// with `aColor`.
+func prepImage(aWidth, aHeight int, aColor color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, aWidth, aHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(aColor), image.Point{}, draw.Src)
+
+	return img
+} // prepImage()
+
+func Test_blurHash(t *testing.T) {
+	noise := image.NewRGBA(image.Rect(0, 0, 32, 48))
+	rnd := rand.New(rand.NewSource(1)) // #nosec G404
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 32; x++ {
+			noise.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 5), uint8(rnd.Intn(256)), 0xFF})
+		}
+	}
+	red := prepImage(8, 8, color.RGBA{0xFF, 0, 0, 0xFF})
+
+	tests := []struct {
+		name   string
+		aImage image.Image
+		aX, aY int
+		want   string
+	}{
+		// TODO: Add test cases.
+		{" 1", noise, 3, 4, `TxH2E22twwl}ahjrgKfifPnma{ju`},
+		{" 2", red, 3, 4, `TfTI:j|cfQ|csUfQfQfQfQ|csUfQ`},
+		{" 3", red, 1, 1, `00TI:j`},
+		{" 4", image.NewRGBA(image.Rect(0, 0, 0, 0)), 3, 4, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blurHash(tt.aImage, tt.aX, tt.aY); got != tt.want {
+				t.Errorf("blurHash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_blurHash()
+
+func Test_dominantColor(t *testing.T) {
+	mixed := prepImage(10, 10, color.RGBA{0x20, 0x40, 0x60, 0xFF})
+	draw.Draw(mixed, image.Rect(0, 0, 10, 4), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name   string
+		aImage image.Image
+		want   string
+	}{
+		// TODO: Add test cases.
+		{" 1", prepImage(4, 4, color.RGBA{0xFF, 0, 0, 0xFF}), `#ff0000`},
+		{" 2", mixed, `#204060`},
+		{" 3", image.NewRGBA(image.Rect(0, 0, 0, 0)), ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dominantColor(tt.aImage); got != tt.want {
+				t.Errorf("dominantColor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_dominantColor()
+
+func Test_encode83(t *testing.T) {
+	tests := []struct {
+		name    string
+		aValue  int
+		aLength int
+		want    string
+	}{
+		// TODO: Add test cases.
+		{" 1", 0, 1, `0`},
+		{" 2", 82, 1, `~`},
+		{" 3", 83, 2, `10`},
+		{" 4", 29, 1, `T`},
+		{" 5", 0xFF0000, 4, `TI:j`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encode83(tt.aValue, tt.aLength); got != tt.want {
+				t.Errorf("encode83() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_encode83()
+
+/* _EoF_ */
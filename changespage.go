@@ -0,0 +1,219 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the "recently changed" page, the marker for
+ * books changed since the user's last visit, and the Atom feed of
+ * the library's additions.
+ */
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mwat56/kaliber/db"
+	"github.com/mwat56/sessions"
+)
+
+const (
+	// Period of changes shown on the `/changes` page.
+	changesPeriod = 30 * 24 * time.Hour
+
+	// Max. number of entries in the Atom feed.
+	changesFeedSize = 50
+
+	// Session key of the time the user last looked at the changes.
+	changesSeenKey = `changesSeen`
+)
+
+type (
+	// `tAtomFeed` is an Atom (RFC 4287) feed.
+	tAtomFeed struct {
+		XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string       `xml:"id"`
+		Title   string       `xml:"title"`
+		Updated string       `xml:"updated"`
+		Author  tAtomPerson  `xml:"author"`
+		Links   []tAtomLink  `xml:"link"`
+		Entries []tAtomEntry `xml:"entry"`
+	}
+
+	// `tAtomEntry` is a single entry of an Atom feed.
+	tAtomEntry struct {
+		ID      string       `xml:"id"`
+		Title   string       `xml:"title"`
+		Updated string       `xml:"updated"`
+		Author  *tAtomPerson `xml:"author,omitempty"`
+		Link    tAtomLink    `xml:"link"`
+		Summary string       `xml:"summary,omitempty"`
+	}
+
+	// `tAtomLink` is a link of an Atom feed or entry.
+	tAtomLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+		Type string `xml:"type,attr,omitempty"`
+	}
+
+	// `tAtomPerson` is an author of an Atom feed or entry.
+	tAtomPerson struct {
+		Name string `xml:"name"`
+	}
+)
+
+// `absoluteURL()` returns `aPath` as an absolute URL of the server
+// that received `aRequest`.
+//
+//	`aRequest` The HTTP request received by the server.
+//	`aPath` The URL path to use.
+func absoluteURL(aRequest *http.Request, aPath string) string {
+	scheme := `http`
+	if nil != aRequest.TLS {
+		scheme = `https`
+	}
+
+	return scheme + `://` + aRequest.Host + aPath
+} // absoluteURL()
+
+// `changesAtom()` returns the Atom feed of the library's additions.
+//
+//	`aRequest` The HTTP request received by the server.
+//	`aLibrary` The library the additions were made to.
+//	`aList` The additions to include (newest first).
+func changesAtom(aRequest *http.Request, aLibrary *db.TLibrary, aList db.TChangeList) *tAtomFeed {
+	host := aRequest.Host
+	if h, _, ok := strings.Cut(host, `:`); ok {
+		host = h
+	}
+	updated := time.Unix(0, 0)
+	if 0 < len(aList) {
+		updated = aList[0].Time
+	}
+	result := &tAtomFeed{
+		ID:      absoluteURL(aRequest, aLibrary.URL()+`/changes/atom`),
+		Title:   aLibrary.Title(),
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  tAtomPerson{Name: requestSite(aRequest).Realm},
+		Links: []tAtomLink{
+			{Href: absoluteURL(aRequest, aLibrary.URL()+`/changes/atom`), Rel: `self`, Type: `application/atom+xml`},
+			{Href: absoluteURL(aRequest, aLibrary.URL()+`/changes`), Rel: `alternate`, Type: `text/html`},
+		},
+	}
+	for _, ch := range aList {
+		entry := tAtomEntry{
+			// The same book might be added several times (after
+			// being removed), hence the time is part of the ID:
+			ID: fmt.Sprintf("tag:%s,%s:book/%d/%d", host,
+				ch.Time.UTC().Format(`2006-01-02`), ch.ID, ch.Time.Unix()),
+			Title:   ch.Title,
+			Updated: ch.Time.UTC().Format(time.RFC3339),
+			Link:    tAtomLink{Href: absoluteURL(aRequest, ch.DocLink()), Rel: `alternate`},
+		}
+		if 0 < len(ch.Authors) {
+			entry.Author = &tAtomPerson{Name: ch.Authors}
+		}
+		if 0 < len(ch.Formats) {
+			entry.Summary = strings.Join(ch.FormatList(), `, `)
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+
+	return result
+} // changesAtom()
+
+// `changesSeen()` returns the time the user of `aSession` last looked
+// at the changes of `aLibrary`.
+//
+// For a new session that's the session's start so that books changed
+// while browsing get marked.
+//
+//	`aSession` The current user session.
+//	`aLibrary` The library whose changes to consider.
+func changesSeen(aSession *sessions.TSession, aLibrary *db.TLibrary) time.Time {
+	key := librarySessionKey(changesSeenKey, aLibrary)
+	if seen, ok := aSession.GetTime(key); ok {
+		return seen
+	}
+	now := time.Now()
+	aSession.Set(key, now)
+
+	return now
+} // changesSeen()
+
+// `changedIDs()` returns the IDs of the books of `aLibrary` added or
+// modified since `aSince`.
+//
+//	`aLibrary` The library whose changes to consider.
+//	`aSince` The time of the oldest change to consider.
+func changedIDs(aLibrary *db.TLibrary, aSince time.Time) map[db.TID]bool {
+	result := make(map[db.TID]bool)
+	for _, ch := range aLibrary.RecentChanges(aSince, db.ChangeAdded, db.ChangeModified) {
+		if ch.Time.After(aSince) {
+			result[ch.ID] = true
+		}
+	}
+
+	return result
+} // changedIDs()
+
+// `handleChanges()` serves the `/changes` page listing the library's
+// recent changes.
+//
+// Viewing the page resets the user's "new since last visit" marker.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aOptions` The current query options to use.
+//	`aSession` The current user session.
+func (ph *TPageHandler) handleChanges(aWriter http.ResponseWriter, aRequest *http.Request, aOptions *db.TQueryOptions, aSession *sessions.TSession) {
+	lib := db.ContextLibrary(aRequest.Context())
+	seen := changesSeen(aSession, lib)
+	aSession.Set(librarySessionKey(changesSeenKey, lib), time.Now())
+
+	pageData := ph.basicTemplateData(aRequest, aOptions).
+		Set("Changes", lib.RecentChanges(time.Now().Add(-changesPeriod))).
+		Set("SID", aSession.ID()).
+		Set("SIDNAME", sessions.SIDname()).
+		Set("Seen", seen).
+		Set("ShowForm", false)
+	aWriter.Header().Set(`Cache-Control`, `no-store`)
+	ph.handleReply(`changes`, aWriter, aRequest, aOptions, aSession, pageData)
+} // handleChanges()
+
+// `handleChangesFeed()` serves the Atom feed of the library's
+// additions.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+func (ph *TPageHandler) handleChangesFeed(aWriter http.ResponseWriter, aRequest *http.Request) {
+	lib := db.ContextLibrary(aRequest.Context())
+	list := lib.RecentChanges(time.Now().Add(-changesPeriod), db.ChangeAdded)
+	if changesFeedSize < len(list) {
+		list = list[:changesFeedSize]
+	}
+	feed := changesAtom(aRequest, lib, list)
+
+	aWriter.Header().Set(`Content-Type`, `application/atom+xml; charset=utf-8`)
+	if 0 < len(list) {
+		aWriter.Header().Set(`Last-Modified`, list[0].Time.UTC().Format(http.TimeFormat))
+	}
+	if `HEAD` == aRequest.Method {
+		return
+	}
+	_, _ = aWriter.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(aWriter)
+	enc.Indent(``, "\t")
+	_ = enc.Encode(feed)
+} // handleChangesFeed()
+
+/* _EoF_ */
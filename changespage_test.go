@@ -0,0 +1,63 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mwat56/kaliber/db"
+)
+
+func Test_changesAtom(t *testing.T) {
+	when := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	list := db.TChangeList{
+		{Authors: `Jane Doe`, Formats: `EPUB,PDF`, ID: 3, Kind: db.ChangeAdded, Time: when, Title: `three`},
+		{ID: 1, Kind: db.ChangeAdded, Time: when.Add(-time.Hour), Title: `one`},
+	}
+	req := httptest.NewRequest(`GET`, `/changes/atom`, nil)
+	req.Host = `books.example.com:8383`
+
+	data, err := xml.Marshal(changesAtom(req, db.DefaultLibrary(), list))
+	if nil != err {
+		t.Fatalf("xml.Marshal() error = %v", err)
+	}
+	var got tAtomFeed
+	if err = xml.Unmarshal(data, &got); nil != err {
+		t.Fatalf("xml.Unmarshal() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		// TODO: Add test cases.
+		{" 1", got.XMLName.Space, `http://www.w3.org/2005/Atom`},
+		{" 2", got.Updated, `2024-02-01T10:00:00Z`},
+		{" 3", got.Links[0].Href, `http://books.example.com:8383/changes/atom`},
+		{" 4", got.Entries[0].ID, `tag:books.example.com,2024-02-01:book/3/1706781600`},
+		{" 5", got.Entries[0].Link.Href, `http://books.example.com:8383/doc/3/doc.html`},
+		{" 6", got.Entries[0].Author.Name, `Jane Doe`},
+		{" 7", got.Entries[0].Summary, `EPUB, PDF`},
+		{" 8", got.Entries[1].Title, `one`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("changesAtom() = %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+	if nil != got.Entries[1].Author {
+		t.Errorf("changesAtom() author = %v, want nil", got.Entries[1].Author)
+	}
+} // Test_changesAtom()
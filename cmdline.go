@@ -13,6 +13,12 @@ package kaliber
  */
 
 import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/mwat56/passlist"
 )
 
@@ -26,6 +32,95 @@ func ListUsers(aFilename string) {
 	passlist.ListUsers(aFilename)
 } // ListUsers()
 
+// TokenAdd creates a new API token and prints it to `StdOut`;
+// `aSpec` is expected in the form `user:scope,scope[:days]` with
+// the optional number of days the token remains valid.
+//
+// NOTE: This function does not return but terminates the program
+// with error code `0` (zero) if successful, or `1` (one) otherwise.
+//
+//	`aSpec` the token's user, scopes, and validity.
+//	`aFilename` name of the API token file to use.
+func TokenAdd(aSpec, aFilename string) {
+	var (
+		expires time.Time
+		scopes  []string
+		token   string
+	)
+	parts := strings.SplitN(aSpec, `:`, 3)
+	ts, err := NewTokenStore(aFilename)
+	if (nil == err) && (2 > len(parts)) {
+		err = fmt.Errorf("missing scopes in '%s'", aSpec)
+	}
+	if nil == err {
+		scopes, err = parseScopes(parts[1])
+	}
+	if (nil == err) && (3 == len(parts)) {
+		var days int
+		if days, err = strconv.Atoi(parts[2]); (nil == err) && (0 < days) {
+			expires = time.Now().AddDate(0, 0, days)
+		}
+	}
+	if nil == err {
+		token, err = ts.Add(parts[0], `commandline`, scopes, expires)
+	}
+	if nil != err {
+		fmt.Fprintf(os.Stderr, "\n\tcan't create API token: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n\tadded API token for '%s':\n\t%s\n\n", parts[0], token)
+
+	os.Exit(0)
+} // TokenAdd()
+
+// TokenDelete revokes the API token `aID`.
+//
+// NOTE: This function does not return but terminates the program
+// with error code `0` (zero) if successful, or `1` (one) otherwise.
+//
+//	`aID` the ID of the token to revoke.
+//	`aFilename` name of the API token file to use.
+func TokenDelete(aID, aFilename string) {
+	ts, err := NewTokenStore(aFilename)
+	if nil == err {
+		err = ts.Remove(aID, ``)
+	}
+	if nil != err {
+		fmt.Fprintf(os.Stderr, "\n\tcan't revoke API token: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n\trevoked API token '%s'\n\n", aID)
+
+	os.Exit(0)
+} // TokenDelete()
+
+// TokenList prints all API tokens stored in `aFilename`.
+//
+// NOTE: This function does not return but terminates the program
+// with error code `0` (zero) if successful, or `1` (one) otherwise.
+//
+//	`aFilename` name of the API token file to use.
+func TokenList(aFilename string) {
+	ts, err := NewTokenStore(aFilename)
+	if nil != err {
+		fmt.Fprintf(os.Stderr, "\n\tcan't read API tokens: %v\n", err)
+		os.Exit(1)
+	}
+	format := func(aTime time.Time) string {
+		if aTime.IsZero() {
+			return `-`
+		}
+		return aTime.Format(`2006-01-02T15:04`)
+	}
+	for _, ti := range ts.List(``) {
+		fmt.Printf("%s\t%s\t%s\tcreated %s\texpires %s\tused %s\t%s\n",
+			ti.ID, ti.User, strings.Join(ti.Scopes, `,`), format(ti.Created),
+			format(ti.Expires), format(ti.LastUsed), ti.Name)
+	}
+
+	os.Exit(0)
+} // TokenList()
+
 // UserAdd reads a password for `aUser` from the commandline
 // and adds it to `aFilename`.
 //
@@ -51,7 +146,7 @@ func UserCheck(aUser, aFilename string) {
 } // UserCheck()
 
 // UserDelete removes the entry for `aUser` from the password
-// list `aFilename`.
+// list `aFilename` and revokes the user's API tokens and share links.
 //
 // NOTE: This function does not return but terminates the program
 // with error code `0` (zero) if successful, or `1` (one) otherwise.
@@ -59,9 +154,64 @@ func UserCheck(aUser, aFilename string) {
 //	`aUser` the username to remove from the password file.
 //	`aFilename` name of the password file to use.
 func UserDelete(aUser, aFilename string) {
+	// A removed user's API tokens and share links mustn't remain valid:
+	if ts, err := NewTokenStore(aFilename + `.tokens`); nil == err {
+		_, _ = ts.RemoveUser(aUser)
+	}
+	if ss, err := NewShareStore(aFilename + `.shares`); nil == err {
+		_, _ = ss.RemoveUser(aUser)
+	}
 	passlist.DeleteUser(aUser, aFilename)
 } // UserDelete()
 
+// UserRoles sets the roles of a user in the TOTP data file
+// `aFilename`; `aSpec` is expected in the form `user:role,role`
+// (an empty role list removes all roles).
+//
+// NOTE: This function does not return but terminates the program
+// with error code `0` (zero) if successful, or `1` (one) otherwise.
+//
+//	`aSpec` the username and roles to set.
+//	`aFilename` name of the TOTP data file to use.
+func UserRoles(aSpec, aFilename string) {
+	user, roles, _ := strings.Cut(aSpec, `:`)
+	ts, err := NewTOTPstore(aFilename)
+	if nil == err {
+		err = ts.SetRoles(user, splitList(roles))
+	}
+	if nil != err {
+		fmt.Fprintf(os.Stderr, "\n\tcan't set roles: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n\tset roles of '%s' to '%s'\n\n", user,
+		strings.Join(ts.Roles(user), `,`))
+
+	os.Exit(0)
+} // UserRoles()
+
+// UserTOTP removes the two-factor authentication data of `aUser`
+// from the TOTP data file `aFilename` (e.g. if the user lost the
+// device generating the codes).
+//
+// NOTE: This function does not return but terminates the program
+// with error code `0` (zero) if successful, or `1` (one) otherwise.
+//
+//	`aUser` the username to reset.
+//	`aFilename` name of the TOTP data file to use.
+func UserTOTP(aUser, aFilename string) {
+	ts, err := NewTOTPstore(aFilename)
+	if nil == err {
+		err = ts.Disable(aUser)
+	}
+	if nil != err {
+		fmt.Fprintf(os.Stderr, "\n\tcan't reset two-factor authentication: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n\treset two-factor authentication of '%s'\n\n", aUser)
+
+	os.Exit(0)
+} // UserTOTP()
+
 // UserUpdate reads a password for `aUser` from the commandline
 // and updates the entry in the password list `aFilename`.
 //
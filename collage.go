@@ -0,0 +1,292 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the collages of authors, series, and tags, i.e.
+ * mosaics composed of the thumbnails of an entity's first books.
+ *
+ * The collages are cached below the library's cache path; their
+ * names contain a hash of the books shown so that a collage is
+ * replaced as soon as the entity's books change.
+ */
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mwat56/kaliber/db"
+	"github.com/nfnt/resize"
+)
+
+const (
+	// Name of the cache subdirectory holding the collages.
+	clDirectory = `collages`
+
+	// Max. number of books shown by a collage.
+	clMaxBooks = 4
+
+	// Size of a collage (in pixels).
+	clWidth, clHeight = 480, 720
+
+	// Space between the collage's tiles (in pixels).
+	clGap = 4
+)
+
+var (
+	// The background colour shown between the collage's tiles.
+	clBackground = color.RGBA{0x33, 0x33, 0x33, 0xFF}
+)
+
+// `collageEntity()` returns whether there are collages of `aEntity`.
+//
+//	`aEntity` The entity's kind.
+func collageEntity(aEntity string) bool {
+	switch aEntity {
+	case `authors`, `series`, `tags`:
+		return true
+	}
+
+	return false
+} // collageEntity()
+
+// `collageLayout()` returns the tiles of a collage showing `aCount`
+// books.
+//
+//	`aCount` The number of books (`1` to `clMaxBooks`).
+func collageLayout(aCount int) []image.Rectangle {
+	const halfW, halfH = clWidth / 2, clHeight / 2
+	const g = clGap / 2
+	switch aCount {
+	case 0:
+		return nil
+	case 1:
+		return []image.Rectangle{image.Rect(0, 0, clWidth, clHeight)}
+	case 2:
+		return []image.Rectangle{
+			image.Rect(0, 0, halfW-g, clHeight),
+			image.Rect(halfW+g, 0, clWidth, clHeight),
+		}
+	case 3:
+		return []image.Rectangle{
+			image.Rect(0, 0, halfW-g, clHeight),
+			image.Rect(halfW+g, 0, clWidth, halfH-g),
+			image.Rect(halfW+g, halfH+g, clWidth, clHeight),
+		}
+	}
+
+	return []image.Rectangle{
+		image.Rect(0, 0, halfW-g, halfH-g),
+		image.Rect(halfW+g, 0, clWidth, halfH-g),
+		image.Rect(0, halfH+g, halfW-g, clHeight),
+		image.Rect(halfW+g, halfH+g, clWidth, clHeight),
+	}
+} // collageLayout()
+
+// `collageName()` returns the name of the collage file of `aEntity`
+// `aID` showing the documents `aDocs`.
+//
+//	`aLibrary` The library of the entity.
+//	`aEntity` The entity's kind.
+//	`aID` The entity's ID.
+//	`aDocs` The documents shown by the collage.
+func collageName(aLibrary *db.TLibrary, aEntity string, aID db.TID, aDocs []*db.TDocument) string {
+	hash := fnv.New32a()
+	for _, doc := range aDocs {
+		fmt.Fprintf(hash, "%d,", doc.ID)
+	}
+	name := fmt.Sprintf("%06d", aID)
+
+	return filepath.Join(aLibrary.CachePath(), clDirectory, aEntity, name[:4],
+		fmt.Sprintf("%s-%08x.jpg", name, hash.Sum32()))
+} // collageName()
+
+// `drawTile()` draws `aImage` into the area `aTile` of `aCollage`
+// cropping it to fill the whole area.
+//
+//	`aCollage` The image to draw into.
+//	`aTile` The area to fill.
+//	`aImage` The image to draw.
+func drawTile(aCollage draw.Image, aTile image.Rectangle, aImage image.Image) {
+	iW, iH := aImage.Bounds().Dx(), aImage.Bounds().Dy()
+	tW, tH := aTile.Dx(), aTile.Dy()
+	if (0 >= iW) || (0 >= iH) {
+		return
+	}
+	// scale to cover the tile and crop the rest:
+	width, height := tW, (iH*tW+iW-1)/iW
+	if iW*tH > iH*tW {
+		// the image is wider than the tile
+		width, height = (iW*tH+iH-1)/iH, tH
+	}
+	scaled := resize.Resize(uint(width), uint(height), aImage, resize.Bilinear)
+	offset := image.Pt((scaled.Bounds().Dx()-tW)/2, (scaled.Bounds().Dy()-tH)/2)
+	draw.Draw(aCollage, aTile, scaled, scaled.Bounds().Min.Add(offset), draw.Src)
+} // drawTile()
+
+// `makeCollage()` composes the thumbnails `aThumbs` to a collage and
+// stores it in `aDstName`.
+//
+//	`aThumbs` The filenames of the thumbnails to use.
+//	`aDstName` The name of the JPEG file to write.
+func makeCollage(aThumbs []string, aDstName string) error {
+	img := image.NewRGBA(image.Rect(0, 0, clWidth, clHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(clBackground), image.Point{}, draw.Src)
+
+	for idx, tile := range collageLayout(len(aThumbs)) {
+		file, err := os.Open(aThumbs[idx]) // #nosec G304
+		if nil != err {
+			return err
+		}
+		tImg, _, err := image.Decode(file)
+		_ = file.Close()
+		if nil != err {
+			return err
+		}
+		drawTile(img, tile, tImg)
+	}
+	if err := os.MkdirAll(filepath.Dir(aDstName), os.ModeDir|0775); nil != err {
+		return err
+	}
+
+	return saveJPEG(img, aDstName, rdQuality)
+} // makeCollage()
+
+// Collage returns the name of the collage file of the author, series,
+// or tag `aID`, generating it if necessary.
+//
+// The collage is generated again if the entity's first books changed
+// (e.g. after the library was synchronised) or if one of their
+// thumbnails is younger than the collage.
+//
+//	`aContext` The current request's context.
+//	`aDB` The DB handle to access the library's database.
+//	`aEntity` The entity's kind (`authors`, `series`, or `tags`).
+//	`aID` The entity's ID.
+func Collage(aContext context.Context, aDB *db.TDataBase, aEntity string, aID db.TID) (string, error) {
+	if !collageEntity(aEntity) {
+		return "", fmt.Errorf("Collage(): unsupported entity '%s'", aEntity)
+	}
+	list, err := aDB.QueryEntityIDs(aContext, aEntity, aID, clMaxBooks)
+	if nil != err {
+		return "", err
+	}
+	if 0 == len(*list) {
+		return "", fmt.Errorf("Collage(): no books of %s %d", aEntity, aID)
+	}
+	docs := make([]*db.TDocument, 0, len(*list))
+	for idx := range *list {
+		docs = append(docs, &(*list)[idx])
+	}
+	lib := docs[0].Library()
+
+	// The thumbnails are provided by the thumbnail workers:
+	thumbs := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		if tName, err := ThumbnailRequest(aContext, doc); nil == err {
+			thumbs = append(thumbs, tName)
+		}
+	}
+	if 0 == len(thumbs) {
+		return "", fmt.Errorf("Collage(): no thumbnails of %s %d", aEntity, aID)
+	}
+
+	dName := collageName(lib, aEntity, aID, docs)
+	if dFI, err := os.Stat(dName); nil == err {
+		current := true
+		for _, tName := range thumbs {
+			if tFI, err := os.Stat(tName); (nil != err) || tFI.ModTime().After(dFI.ModTime()) {
+				current = false
+				break
+			}
+		}
+		if current {
+			return dName, nil
+		}
+	}
+
+	// Remove the collages showing other books:
+	base := strings.TrimSuffix(dName, filepath.Ext(dName))
+	if idx := strings.LastIndex(base, `-`); 0 < idx {
+		previous, _ := filepath.Glob(base[:idx] + `-*.jpg`)
+		for _, pName := range previous {
+			if pName == dName {
+				continue // replaced by the new collage
+			}
+			imgCache.forget(pName)
+			_ = os.Remove(pName)
+		}
+	}
+	if err = makeCollage(thumbs, dName); nil != err {
+		return "", err
+	}
+	imgCache.add(dName)
+
+	return dName, nil
+} // Collage()
+
+// `collageURL()` returns the URL of the collage of `aEntity` `aID`
+// or an empty string if there's none.
+//
+//	`aLibrary` The library of the entity.
+//	`aEntity` The entity's kind.
+//	`aID` The entity's ID.
+func collageURL(aLibrary *db.TLibrary, aEntity string, aID db.TID) string {
+	if (0 >= aID) || !collageEntity(aEntity) {
+		return ``
+	}
+
+	return fmt.Sprintf("%s/collage/%s/%d/collage.jpg", aLibrary.URL(), aEntity, aID)
+} // collageURL()
+
+// `serveCollage()` sends the collage of an author, series, or tag
+// given by `aTail` (`ENTITY/ID/...`).
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aDB` The DB handle to access the library's database.
+//	`aTail` The URL path's tail naming the entity.
+func (ph *TPageHandler) serveCollage(aWriter http.ResponseWriter, aRequest *http.Request, aDB *db.TDataBase, aTail string) {
+	var id db.TID
+	parts := strings.SplitN(aTail, `/`, 3)
+	if (2 > len(parts)) || !collageEntity(parts[0]) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	if _, err := fmt.Sscanf(parts[1], "%d", &id); (nil != err) || (0 >= id) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	cName, err := Collage(aRequest.Context(), aDB, parts[0], id)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	imgCache.access(cName)
+
+	lib := db.ContextLibrary(aRequest.Context())
+	file, err := filepath.Rel(lib.CachePath(), cName)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	aWriter.Header().Set(`Cache-Control`, `private, max-age=3600`)
+	aRequest.URL.Path = file
+	ph.cacheServer(lib).ServeHTTP(aWriter, aRequest)
+} // serveCollage()
+
+/* _EoF_ */
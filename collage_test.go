@@ -0,0 +1,164 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwat56/kaliber/db"
+)
+
+func Test_collageLayout(t *testing.T) {
+	bounds := image.Rect(0, 0, clWidth, clHeight)
+	for count := 0; count <= clMaxBooks+1; count++ {
+		tiles := collageLayout(count)
+		want := count
+		if clMaxBooks < want {
+			want = clMaxBooks
+		}
+		if len(tiles) != want {
+			t.Errorf("collageLayout(%d) = %d tiles, want %d", count, len(tiles), want)
+		}
+		area := 0
+		for idx, tile := range tiles {
+			if !tile.In(bounds) || tile.Empty() {
+				t.Errorf("collageLayout(%d) tile %v outside %v", count, tile, bounds)
+			}
+			for _, other := range tiles[idx+1:] {
+				if tile.Overlaps(other) {
+					t.Errorf("collageLayout(%d) tiles %v and %v overlap", count, tile, other)
+				}
+			}
+			area += tile.Dx() * tile.Dy()
+		}
+		// the gaps between the tiles take less than 2 percent:
+		if (0 < count) && (area < clWidth*clHeight*98/100) {
+			t.Errorf("collageLayout(%d) covers %d pixels only", count, area)
+		}
+	}
+} // Test_collageLayout()
+
+func Test_collageName(t *testing.T) {
+	lib := db.DefaultLibrary()
+	docs := []*db.TDocument{{ID: 3}, {ID: 6}}
+	name := collageName(lib, `series`, 42, docs)
+	if want := filepath.Join(clDirectory, `series`, `0000`, `000042-`); !strings.Contains(name, want) {
+		t.Errorf("collageName() = %q, want it to contain %q", name, want)
+	}
+	if again := collageName(lib, `series`, 42, []*db.TDocument{{ID: 3}, {ID: 6}}); name != again {
+		t.Errorf("collageName() = %q, then %q", name, again)
+	}
+	if changed := collageName(lib, `series`, 42, []*db.TDocument{{ID: 6}, {ID: 3}}); name == changed {
+		t.Errorf("collageName() = %q for changed books", changed)
+	}
+} // Test_collageName()
+
+func Test_collageURL(t *testing.T) {
+	lib := db.DefaultLibrary()
+
+	tests := []struct {
+		name    string
+		aEntity string
+		aID     db.TID
+		want    string
+	}{
+		// TODO: Add test cases.
+		{" 1", `authors`, 7, lib.URL() + `/collage/authors/7/collage.jpg`},
+		{" 2", `series`, 1, lib.URL() + `/collage/series/1/collage.jpg`},
+		{" 3", `tags`, 0, ``},
+		{" 4", `publisher`, 3, ``},
+		{" 5", ``, 0, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collageURL(lib, tt.aEntity, tt.aID); got != tt.want {
+				t.Errorf("collageURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_collageURL()
+
+func Test_drawTile(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	tests := []struct {
+		name   string
+		aImage image.Image
+	}{
+		// TODO: Add test cases.
+		{" 1", prepImage(320, 480, red)},  // same aspect ratio
+		{" 2", prepImage(320, 200, red)},  // wider than the tile
+		{" 3", prepImage(100, 900, red)},  // taller than the tile
+		{" 4", prepImage(20, 30, red)},    // smaller than the tile
+		{" 5", prepImage(1000, 999, red)}, // larger than the tile
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := prepImage(clWidth, clHeight, clBackground)
+			tile := image.Rect(10, 20, 210, 320)
+			drawTile(img, tile, tt.aImage)
+			for _, pt := range []image.Point{tile.Min, tile.Max.Sub(image.Pt(1, 1)),
+				image.Pt(tile.Min.X, tile.Max.Y-1), image.Pt(tile.Max.X-1, tile.Min.Y)} {
+				if r, _, _, _ := img.At(pt.X, pt.Y).RGBA(); 0xF000 > r {
+					t.Errorf("drawTile() left pixel %v empty", pt)
+				}
+			}
+			if got := img.RGBAAt(tile.Max.X, tile.Max.Y); got != clBackground {
+				t.Errorf("drawTile() drew outside the tile: %v", got)
+			}
+		})
+	}
+} // Test_drawTile()
+
+func Test_makeCollage(t *testing.T) {
+	dir := t.TempDir()
+	var thumbs []string
+	for idx, c := range []color.RGBA{{0xFF, 0, 0, 0xFF}, {0, 0xFF, 0, 0xFF}, {0, 0, 0xFF, 0xFF}} {
+		name := filepath.Join(dir, string(rune('a'+idx))+`.jpg`)
+		if err := saveJPEG(prepImage(320, 480, c), name, 100); nil != err {
+			t.Fatal(err)
+		}
+		thumbs = append(thumbs, name)
+	}
+	dst := filepath.Join(dir, clDirectory, `series`, `0000`, `000001-0.jpg`)
+	if err := makeCollage(thumbs, dst); nil != err {
+		t.Fatalf("makeCollage() error = %v", err)
+	}
+	file, err := os.Open(dst)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := jpeg.Decode(file)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if (clWidth != img.Bounds().Dx()) || (clHeight != img.Bounds().Dy()) {
+		t.Errorf("makeCollage() size = %v", img.Bounds())
+	}
+	// the three books' colours in their tiles:
+	for idx, pt := range []image.Point{{clWidth / 4, clHeight / 2}, {clWidth * 3 / 4, clHeight / 4}, {clWidth * 3 / 4, clHeight * 3 / 4}} {
+		r, g, b, _ := img.At(pt.X, pt.Y).RGBA()
+		channels := []uint32{r, g, b}
+		if 0xE000 > channels[idx] {
+			t.Errorf("makeCollage() pixel %v = %v, want book %d", pt, channels, idx)
+		}
+	}
+
+	if err = makeCollage(append(thumbs, filepath.Join(dir, `missing.jpg`)), dst); nil == err {
+		t.Error("makeCollage() expected an error for a missing thumbnail")
+	}
+} // Test_makeCollage()
+
+/* _EoF_ */
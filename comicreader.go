@@ -0,0 +1,393 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the in-browser reader of comic books, i.e. of
+ * the CBZ, CBR, and CB7 files.
+ *
+ * The archives' pages are served either unchanged or scaled to the
+ * cover renditions' widths; the scaled pages are cached below the
+ * library's cache path.
+ */
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mwat56/kaliber/db"
+	"github.com/mwat56/sessions"
+)
+
+const (
+	// Name of the cache subdirectory holding the scaled pages.
+	crDirectory = `comics`
+
+	// The session keys of the reader's settings.
+	crRTLKey    = `comicRTL`
+	crSpreadKey = `comicSpread`
+)
+
+var (
+	// The formats of comic book archives (in order of preference).
+	crFormats = []string{`CBZ`, `CBR`, `CB7`}
+)
+
+type (
+	// `tComicPage` is a page shown by the comic reader.
+	tComicPage struct {
+		Number int             // the page's number (starting at `1`)
+		Src    string          // the URL of the original image
+		Srcset template.Srcset // the URLs of the scaled images
+	}
+)
+
+// `comicFile()` returns the format and the path/filename of the comic
+// book archive of `aDoc`, or empty strings if there's none.
+//
+//	`aDoc` The document to check.
+func comicFile(aDoc *db.TDocument) (rFormat, rFilename string) {
+	if nil == aDoc {
+		return
+	}
+	for _, format := range crFormats {
+		if file := aDoc.Filename(format); 0 < len(file) {
+			return format, filepath.Join(aDoc.Library().Path(), file)
+		}
+	}
+
+	return
+} // comicFile()
+
+// `comicPage()` returns the page `aNumber` of the comic reader.
+//
+//	`aBaseURL` The reader's URL of the book (without trailing slash).
+//	`aNumber` The page's number (starting at `1`).
+func comicPage(aBaseURL string, aNumber int) tComicPage {
+	src := fmt.Sprintf("%s/img/%d", aBaseURL, aNumber)
+	list := make([]string, 0, len(rdWidths))
+	for _, width := range rdWidths {
+		list = append(list, fmt.Sprintf("%s/w/%d %dw", src, width, width))
+	}
+
+	return tComicPage{
+		Number: aNumber,
+		Src:    src,
+		Srcset: template.Srcset(strings.Join(list, `, `)), // #nosec G203
+	}
+} // comicPage()
+
+// `comicPageName()` returns the name of the cached page `aNumber` of
+// `aDoc` with a width of `aWidth` pixels.
+//
+//	`aDoc` The document whose page to use.
+//	`aNumber` The page's number (starting at `1`).
+//	`aWidth` The width of the scaled page.
+func comicPageName(aDoc *db.TDocument, aNumber int, aWidth uint) string {
+	name := fmt.Sprintf("%06d", aDoc.ID)
+
+	return filepath.Join(aDoc.Library().CachePath(), crDirectory, name[:4], name,
+		fmt.Sprintf("w%dq%d", aWidth, rdQuality), fmt.Sprintf("%04d.jpg", aNumber))
+} // comicPageName()
+
+// `comicSpread()` returns the first page and the number of pages
+// shown together with page `aPage` of a book with `aCount` pages.
+//
+// In spread mode the first page (i.e. the cover) is shown alone
+// while the following pages are shown in pairs (2+3, 4+5, …).
+//
+//	`aPage` The page's number (starting at `1`).
+//	`aCount` The number of the book's pages.
+//	`aSpread` Flag whether to show two pages side by side.
+func comicSpread(aPage, aCount int, aSpread bool) (rFirst, rNum int) {
+	if !aSpread || (1 == aPage) {
+		return aPage, 1
+	}
+	rFirst = aPage - aPage%2 // the spread's even page
+	if rFirst == aCount {
+		return rFirst, 1
+	}
+
+	return rFirst, 2
+} // comicSpread()
+
+// `comicURL()` returns the URL of the comic reader of `aDoc` or an
+// empty string if there's no comic book archive of the document.
+//
+//	`aDoc` The document to read.
+func comicURL(aDoc *db.TDocument) string {
+	if format, _ := comicFile(aDoc); 0 == len(format) {
+		return ``
+	}
+
+	return fmt.Sprintf("%s/comic/%d/", aDoc.Library().URL(), aDoc.ID)
+} // comicURL()
+
+// ComicPage returns the name of the page file `aNumber` of `aDoc`
+// scaled to `aWidth` pixels, generating it if necessary.
+//
+// The page is generated again if the archive is younger than the
+// cached page.
+//
+//	`aDoc` The document whose page to use.
+//	`aNumber` The page's number (starting at `1`).
+//	`aWidth` The width of the scaled page.
+func ComicPage(aDoc *db.TDocument, aNumber int, aWidth uint) (string, error) {
+	_, sName := comicFile(aDoc)
+	if 0 == len(sName) {
+		return "", fmt.Errorf("ComicPage(): no comic book archive of %d", aDoc.ID)
+	}
+	sFI, err := os.Stat(sName)
+	if nil != err {
+		return "", err
+	}
+	dName := comicPageName(aDoc, aNumber, aWidth)
+	if dFI, err := os.Stat(dName); (nil == err) && dFI.ModTime().After(sFI.ModTime()) {
+		// the page is younger than the archive
+		return dName, nil
+	}
+
+	comic, err := db.OpenComic(sName)
+	if nil != err {
+		return "", err
+	}
+	defer comic.Close()
+
+	data, err := comic.Page(aNumber - 1)
+	if nil != err {
+		return "", err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if nil != err {
+		return "", err
+	}
+	if err = os.MkdirAll(filepath.Dir(dName), os.ModeDir|0775); nil != err {
+		return "", err
+	}
+	if err = saveJPEG(scaleImage(img, aWidth), dName, rdQuality); nil != err {
+		return "", err
+	}
+	imgCache.add(dName)
+
+	return dName, nil
+} // ComicPage()
+
+// ComicPageRequest returns the name of the page file `aNumber` of
+// `aDoc` scaled to `aWidth` pixels, generating it by the thumbnail
+// workers with a high priority if necessary.
+//
+// Concurrent requests for the same page share a single job.
+//
+//	`aContext` The context of the page request.
+//	`aDoc` The document whose page to use.
+//	`aNumber` The page's number (starting at `1`).
+//	`aWidth` The width of the scaled page.
+func ComicPageRequest(aContext context.Context, aDoc *db.TDocument, aNumber int, aWidth uint) (string, error) {
+	job := thPool.submitJob(aDoc, comicPageName(aDoc, aNumber, aWidth), thPrioRequest, nil,
+		func() (string, error) {
+			return ComicPage(aDoc, aNumber, aWidth)
+		})
+
+	return thPool.wait(aContext, job)
+} // ComicPageRequest()
+
+// `readerSetting()` returns the reader's boolean setting `aKey`.
+//
+// A value given by the request's field `aField` (`1` or `0`) is
+// stored in the user's session for the following pages.
+//
+//	`aRequest` The HTTP request received by the server.
+//	`aSession` The current user session.
+//	`aField` The name of the request's form field.
+//	`aKey` The session key of the setting.
+func readerSetting(aRequest *http.Request, aSession *sessions.TSession, aField, aKey string) bool {
+	switch aRequest.FormValue(aField) {
+	case `1`:
+		aSession.Set(aKey, true)
+		return true
+	case `0`:
+		aSession.Set(aKey, false)
+		return false
+	}
+	result, _ := aSession.GetBool(aKey)
+
+	return result
+} // readerSetting()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `handleComic()` serves the reader page or a page image of a comic
+// book given by `aTail` (`ID/`, `ID/N`, `ID/img/N`, or `ID/img/N/w/W`).
+//
+// The page last shown of every book is remembered in the user's
+// session and opened again by `ID/`.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aOptions` The current query options to use.
+//	`aSession` The current user session.
+//	`aDB` The DB handle to access the library's database.
+//	`aTail` The URL path's tail naming the document and page.
+func (ph *TPageHandler) handleComic(aWriter http.ResponseWriter, aRequest *http.Request, aOptions *db.TQueryOptions, aSession *sessions.TSession, aDB *db.TDataBase, aTail string) {
+	parts := strings.Split(aTail, `/`)
+	id, err := strconv.Atoi(parts[0])
+	if (nil != err) || (0 >= id) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	doc := aDB.QueryDocMini(aRequest.Context(), id)
+	if nil == doc {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	if (3 <= len(parts)) && (`img` == parts[1]) {
+		ph.serveComicImage(aWriter, aRequest, doc, parts[2:])
+		return
+	}
+
+	_, file := comicFile(doc)
+	if 0 == len(file) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	comic, err := db.OpenComic(file)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	count := len(comic.Pages)
+	_ = comic.Close()
+
+	lib := db.ContextLibrary(aRequest.Context())
+	pageKey := librarySessionKey(fmt.Sprintf("comic%d", doc.ID), lib)
+	page := 1
+	if (2 <= len(parts)) && (0 < len(parts[1])) {
+		if page, err = strconv.Atoi(parts[1]); (nil != err) || (1 > page) || (count < page) {
+			http.NotFound(aWriter, aRequest)
+			return
+		}
+	} else if last, ok := aSession.GetInt(pageKey); ok && (1 <= last) && (int64(count) >= last) {
+		page = int(last)
+	}
+
+	rtl := readerSetting(aRequest, aSession, `rtl`, crRTLKey)
+	spread := readerSetting(aRequest, aSession, `spread`, crSpreadKey)
+	first, num := comicSpread(page, count, spread)
+	aSession.Set(pageKey, first)
+
+	baseURL := fmt.Sprintf("%s/comic/%d", doc.Library().URL(), doc.ID)
+	pages := make([]tComicPage, 0, num)
+	for number := first; number < first+num; number++ {
+		pages = append(pages, comicPage(baseURL, number))
+	}
+	sizes := `100vw`
+	if 1 < num {
+		sizes = `50vw`
+	}
+	pageData := ph.basicTemplateData(aRequest, aOptions).
+		Set("Count", count).
+		Set("Document", doc).
+		Set("Page", first).
+		Set("Pages", pages).
+		Set("ReaderURL", baseURL).
+		Set("RTL", rtl).
+		Set("Sizes", sizes).
+		Set("Spread", spread).
+		Set("Title", doc.Title)
+	if 1 < first {
+		prev, _ := comicSpread(first-1, count, spread)
+		pageData.Set("PrevURL", fmt.Sprintf("%s/%d", baseURL, prev))
+	}
+	if next := first + num; count >= next {
+		// The next pages are loaded in advance:
+		_, nextNum := comicSpread(next, count, spread)
+		prefetch := make([]tComicPage, 0, nextNum)
+		for number := next; number < next+nextNum; number++ {
+			prefetch = append(prefetch, comicPage(baseURL, number))
+		}
+		pageData.Set("NextURL", fmt.Sprintf("%s/%d", baseURL, next)).
+			Set("Prefetch", prefetch)
+	}
+	aWriter.Header().Set(`Cache-Control`, `private, no-cache`)
+	ph.handleReply(`comic`, aWriter, aRequest, aOptions, aSession, pageData)
+} // handleComic()
+
+// `serveComicImage()` sends a page image of `aDoc` given by `aParts`
+// (`N` for the original image or `N/w/W` for the scaled one).
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aDoc` The document whose page to send.
+//	`aParts` The URL path's parts naming the page.
+func (ph *TPageHandler) serveComicImage(aWriter http.ResponseWriter, aRequest *http.Request, aDoc *db.TDocument, aParts []string) {
+	number, err := strconv.Atoi(aParts[0])
+	if (nil != err) || (1 > number) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	_, file := comicFile(aDoc)
+	if 0 == len(file) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	aWriter.Header().Set(`Cache-Control`, `private, max-age=864000`) // 10 days
+	aWriter.Header().Set(`Last-Modified`, aDoc.LastModified())
+
+	if (3 <= len(aParts)) && (`w` == aParts[1]) {
+		width, err := strconv.ParseUint(aParts[2], 10, 32)
+		if (nil != err) || !renditionWidth(uint(width)) {
+			http.NotFound(aWriter, aRequest)
+			return
+		}
+		pName, err := ComicPageRequest(aRequest.Context(), aDoc, number, uint(width))
+		if nil != err {
+			http.NotFound(aWriter, aRequest)
+			return
+		}
+		imgCache.access(pName)
+		pFile, err := os.Open(pName) // #nosec G304
+		if nil != err {
+			http.NotFound(aWriter, aRequest)
+			return
+		}
+		defer pFile.Close()
+
+		aWriter.Header().Set(`Content-Type`, `image/jpeg`)
+		if fi, err := pFile.Stat(); nil == err {
+			http.ServeContent(aWriter, aRequest, pName, fi.ModTime(), pFile)
+		}
+		return
+	}
+
+	comic, err := db.OpenComic(file)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	defer comic.Close()
+
+	data, err := comic.Page(number - 1)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	aWriter.Header().Set(`Content-Type`, http.DetectContentType(data))
+	aWriter.Header().Set(`X-Content-Type-Options`, `nosniff`)
+	_, _ = aWriter.Write(data)
+} // serveComicImage()
+
+/* _EoF_ */
@@ -0,0 +1,57 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_comicPage(t *testing.T) {
+	got := comicPage(`/comic/7`, 3)
+	if 3 != got.Number {
+		t.Errorf("comicPage() number = %d, want 3", got.Number)
+	}
+	if want := `/comic/7/img/3`; got.Src != want {
+		t.Errorf("comicPage() src = %q, want %q", got.Src, want)
+	}
+	if want := `/comic/7/img/3/w/640 640w`; !strings.Contains(string(got.Srcset), want) {
+		t.Errorf("comicPage() srcset = %q, want %q", got.Srcset, want)
+	}
+} // Test_comicPage()
+
+func Test_comicSpread(t *testing.T) {
+	tests := []struct {
+		name      string
+		aPage     int
+		aCount    int
+		aSpread   bool
+		wantFirst int
+		wantNum   int
+	}{
+		// TODO: Add test cases.
+		{" 1", 3, 10, false, 3, 1},
+		{" 2", 1, 10, true, 1, 1},
+		{" 3", 2, 10, true, 2, 2},
+		{" 4", 3, 10, true, 2, 2},
+		{" 5", 9, 10, true, 8, 2},
+		{" 6", 10, 10, true, 10, 1},
+		{" 7", 9, 9, true, 8, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, num := comicSpread(tt.aPage, tt.aCount, tt.aSpread)
+			if (first != tt.wantFirst) || (num != tt.wantNum) {
+				t.Errorf("comicSpread() = %d, %d, want %d, %d", first, num, tt.wantFirst, tt.wantNum)
+			}
+		})
+	}
+} // Test_comicSpread()
+
+/* _EoF_ */
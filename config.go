@@ -29,34 +29,61 @@ type (
 	TAppArgs struct {
 		AccessLog     string // (optional) name of page access logfile
 		Addr          string // listen address ("1.2.3.4:5678")
+		AdminRoles    string // roles allowed to see the admin page
 		AuthAll       bool   // authenticate user for all pages and documents
+		AuthBackend   string // `passlist`, `htpasswd`, or `ldap`
+		AuthProxyHdr  string // header identifying the user by a reverse proxy
+		AuthProxyIPs  string // trusted reverse proxy addresses
 		BooksPerPage  int    // number of documents shown per web-page
+		CachePrune    bool   // remove least recently used cached images
+		CacheRebuild  bool   // remove all cached images and rebuild thumbnails
 		CertKey       string // TLS certificate key
 		CertPem       string // private TLS certificate
+		CoverQuality  int    // JPEG quality of the cover renditions
+		CoverWidths   string // widths of the cover renditions
 		DataDir       string // base directory of application's data
 		delWhitespace bool   // remove whitespace from generated pages
 		dump          bool   // Debug: dump this structure to `StdOut`
 		ErrorLog      string // (optional) name of page error logfile
 		GZip          bool   // send compressed data to remote browser
+		HtpasswdFile  string // (optional) name of a static htpasswd file
+		ImageCache    int    // max. megabytes of cached images (`0`: unlimited)
 		// Intl       string // path/filename of the localisation file
 		Lang          string // default GUI language
+		Libraries     string // additional libraries (`name:path[:title], …`)
 		LibName       string // the library's name
+		LibraryRoles  string // roles allowed per library (`name:roles; …`)
 		libPath       string // path to `Calibre` library
 		listen        string // IP of host to listen at
 		LogStack      bool   // log stack trace in case of errors
 		PassFile      string // (optional) name of page access logfile
 		port          int    // port to listen to
+		QueryTimeout  int    // max. seconds a SQL query may take
 		Realm         string // host/domain to secure by BasicAuth
 		SessionDir    string // directory for session data
 		sessionTTL    int    // session time to live
 		sidName       string // name of session ID
+		SlowQuery     int    // milliseconds after which a SQL query is logged
 		Theme         string // `dark` or `light` display theme
+		ThumbWorkers  int    // number of workers generating thumbnails
+		TOTProles     string // roles requiring two-factor authentication
+		TokenAdd      string // `user:scopes[:days]` of API token to add
+		TokenDelete   string // ID of API token to revoke
+		TokenList     bool   // print out a list of API tokens
 		UserAdd       string // username to add to password list
 		UserCheck     string // username to check in password list
 		UserDelete    string // username to delete from password list
 		UserList      bool   // print out a list of current users
+		UserRoles     string // `user:roles` to set in the TOTP data
+		UserTOTP      string // username to reset two-factor authentication
 		UserUpdate    string // username to update in password list
+		WebhookBase   string // public base URL used in webhook payloads
+		Webhooks      string // comma separated list of webhook URLs
+		WebhookSecret string // secret to sign the webhook payloads
 		writeSQLTrace string // (optional) name of SQL trace logfile
+
+		// (optional) settings of the `ldap` authentication backend
+		LDAP TLDAPconfig
 	}
 
 	// List structure for the INI values.
@@ -103,6 +130,52 @@ func absolute(aBaseDir, aDir string) string {
 	return filepath.Join(aBaseDir, aDir)
 } // absolute()
 
+// `cacheDir()` returns the directory to use for the database copy of
+// the `Calibre` library in `aLibPath`.
+//
+//	`aLibPath` The base directory of the `Calibre` library.
+func cacheDir(aLibPath string) string {
+	// To allow for use of multiple libraries we add the MD5
+	// of the libraryPath to our cache path.
+	s := fmt.Sprintf("%x", md5.Sum([]byte(aLibPath))) // #nosec G401
+	ucd, err := os.UserCacheDir()
+	if (nil != err) || (0 == len(ucd)) {
+		return filepath.Join(AppArgs.DataDir, `img`, s)
+	}
+
+	return filepath.Join(ucd, `kaliber`, s)
+} // cacheDir()
+
+// `setupLibraries()` adds the libraries configured by `aList` to the
+// libraries served.
+//
+//	`aList` Comma separated list of `name:path[:title]` entries.
+func setupLibraries(aList string) error {
+	for _, entry := range strings.Split(aList, `,`) {
+		if entry = strings.TrimSpace(entry); 0 == len(entry) {
+			continue
+		}
+		parts := strings.SplitN(entry, `:`, 3)
+		if 2 > len(parts) {
+			return fmt.Errorf("invalid library '%s' (want `name:path[:title]`)", entry)
+		}
+		title := ``
+		if 3 == len(parts) {
+			title = strings.TrimSpace(parts[2])
+		}
+		libPath, _ := filepath.Abs(strings.TrimSpace(parts[1]))
+		lib, err := db.NewLibrary(strings.TrimSpace(parts[0]), title, libPath, cacheDir(libPath))
+		if nil != err {
+			return err
+		}
+		if err = db.AddLibrary(lib); nil != err {
+			return err
+		}
+	}
+
+	return nil
+} // setupLibraries()
+
 // String implements the `Stringer` interface returning a (pretty printed)
 // string representation of the current `TAppArgs` instance.
 //
@@ -228,21 +301,16 @@ func readFlags() {
 		log.Fatalf("Error: `libPath` not a directory `%s`", AppArgs.libPath)
 	}
 
-	// To allow for use of multiple libraries we add the MD5
-	// of the libraryPath to our cache path.
-	s := fmt.Sprintf("%x", md5.Sum([]byte(AppArgs.libPath))) // #nosec G401
-	if ucd, err := os.UserCacheDir(); (nil != err) || (0 == len(ucd)) {
-		if err = db.SetCalibreCachePath(filepath.Join(AppArgs.DataDir, `img`, s)); nil != err {
-			log.Fatalf("Error: %v", err)
-		}
-	} else {
-		if err = db.SetCalibreCachePath(filepath.Join(ucd, `kaliber`, s)); nil != err {
-			log.Fatalf("Error: %v", err)
-		}
+	if err := db.SetCalibreCachePath(cacheDir(AppArgs.libPath)); nil != err {
+		log.Fatalf("Error: %v", err)
 	}
 	if err := db.SetCalibreLibraryPath(AppArgs.libPath); nil != err {
 		log.Fatalf("Error: %v", err)
 	}
+	db.DefaultLibrary().SetTitle(AppArgs.LibName)
+	if err := setupLibraries(AppArgs.Libraries); nil != err {
+		log.Fatalf("Error: `libraries` %v", err)
+	}
 
 	if `0` == AppArgs.listen {
 		AppArgs.listen = ``
@@ -274,6 +342,23 @@ func readFlags() {
 	}
 	db.SetSQLtraceFile(AppArgs.writeSQLTrace)
 
+	if 0 > AppArgs.QueryTimeout {
+		AppArgs.QueryTimeout = 0
+	}
+	db.SetQueryTimeout(time.Duration(AppArgs.QueryTimeout) * time.Second)
+	if 0 > AppArgs.SlowQuery {
+		AppArgs.SlowQuery = 0
+	}
+	db.SetSlowQueryThreshold(time.Duration(AppArgs.SlowQuery) * time.Millisecond)
+
+	AppArgs.CoverQuality = SetCoverQuality(AppArgs.CoverQuality)
+	AppArgs.ThumbWorkers = SetThumbWorkers(AppArgs.ThumbWorkers)
+	if 0 > AppArgs.ImageCache {
+		AppArgs.ImageCache = 0
+	}
+	SetImageCacheSize(AppArgs.ImageCache)
+	SetCoverWidths(AppArgs.CoverWidths)
+
 	if 0 < len(AppArgs.Theme) {
 		AppArgs.Theme = strings.ToLower(AppArgs.Theme)
 	}
@@ -288,6 +373,22 @@ func readFlags() {
 		AppArgs.PassFile = absolute(AppArgs.DataDir, AppArgs.PassFile)
 	}
 
+	// An unknown backend is kept to be rejected by `newAuthenticator()`
+	// instead of silently falling back to another one.
+	if AppArgs.AuthBackend = strings.ToLower(strings.TrimSpace(AppArgs.AuthBackend)); 0 == len(AppArgs.AuthBackend) {
+		AppArgs.AuthBackend = AuthBackendPasslist
+	}
+
+	if 0 < len(AppArgs.HtpasswdFile) {
+		AppArgs.HtpasswdFile = absolute(AppArgs.DataDir, AppArgs.HtpasswdFile)
+	}
+
+	// The virtual hosts use the values above as their defaults:
+	var err error
+	if vhList, err = readVirtualHosts(iniHostList()); nil != err {
+		log.Fatalf("Error: %v", err)
+	}
+
 	if AppArgs.dump {
 		// Print out the arguments and terminate:
 		log.Fatalf("runtime arguments:\n%s", AppArgs.String())
@@ -312,18 +413,52 @@ func setFlags() {
 		ok bool
 		s  string // temp. value
 	)
+	if AppArgs.AdminRoles, ok = iniValues.AsString(`adminRoles`); !ok {
+		AppArgs.AdminRoles = `admin`
+	}
+	flag.CommandLine.StringVar(&AppArgs.AdminRoles, `adminRoles`, AppArgs.AdminRoles,
+		"<roleList> comma separated roles allowed to see the admin page\n")
+
 	if AppArgs.AuthAll, ok = iniValues.AsBool(`authAll`); !ok {
 		AppArgs.AuthAll = true
 	}
 	flag.CommandLine.BoolVar(&AppArgs.AuthAll, `authAll`, AppArgs.AuthAll,
 		"<boolean> whether to require authentication for all pages ")
 
+	if AppArgs.AuthBackend, ok = iniValues.AsString(`authBackend`); (!ok) || (0 == len(AppArgs.AuthBackend)) {
+		AppArgs.AuthBackend = AuthBackendPasslist
+	}
+	flag.CommandLine.StringVar(&AppArgs.AuthBackend, `authBackend`, AppArgs.AuthBackend,
+		"<name> authentication backend to use ('passlist', 'htpasswd', or 'ldap')\n")
+
+	AppArgs.AuthProxyHdr, _ = iniValues.AsString(`authProxyHeader`)
+	flag.CommandLine.StringVar(&AppArgs.AuthProxyHdr, `authProxyHeader`, AppArgs.AuthProxyHdr,
+		"<name> header identifying the user authenticated by a reverse proxy\n")
+
+	if AppArgs.AuthProxyIPs, ok = iniValues.AsString(`authProxyTrusted`); (!ok) || (0 == len(AppArgs.AuthProxyIPs)) {
+		AppArgs.AuthProxyIPs = `127.0.0.1, ::1`
+	}
+	flag.CommandLine.StringVar(&AppArgs.AuthProxyIPs, `authProxyTrusted`, AppArgs.AuthProxyIPs,
+		"<IPlist> comma separated addresses of trusted reverse proxies\n")
+
 	if AppArgs.BooksPerPage, ok = iniValues.AsInt(`booksPerPage`); (!ok) || (0 >= AppArgs.BooksPerPage) {
 		AppArgs.BooksPerPage = 24
 	}
 	flag.CommandLine.IntVar(&AppArgs.BooksPerPage, `booksPerPage`, AppArgs.BooksPerPage,
 		"<number> the default number of books shown per page ")
 
+	if AppArgs.CoverQuality, ok = iniValues.AsInt(`coverQuality`); (!ok) || (0 >= AppArgs.CoverQuality) {
+		AppArgs.CoverQuality = 85
+	}
+	flag.CommandLine.IntVar(&AppArgs.CoverQuality, `coverQuality`, AppArgs.CoverQuality,
+		"<number> JPEG quality (1-100) of the cover renditions\n")
+
+	if AppArgs.CoverWidths, ok = iniValues.AsString(`coverWidths`); (!ok) || (0 == len(AppArgs.CoverWidths)) {
+		AppArgs.CoverWidths = `160, 320, 640, 1024`
+	}
+	flag.CommandLine.StringVar(&AppArgs.CoverWidths, `coverWidths`, AppArgs.CoverWidths,
+		"<list> comma separated widths (in pixels) of the cover renditions\n")
+
 	if s, ok = iniValues.AsString("dataDir"); (ok) && (0 < len(s)) {
 		AppArgs.DataDir, _ = filepath.Abs(s)
 	} else {
@@ -370,6 +505,18 @@ func setFlags() {
 	flag.CommandLine.BoolVar(&AppArgs.GZip, "gzip", AppArgs.GZip,
 		"<boolean> use gzip compression for server responses")
 
+	if s, ok = iniValues.AsString(`htpasswdFile`); ok && (0 < len(s)) {
+		AppArgs.HtpasswdFile = absolute(AppArgs.DataDir, s)
+	}
+	flag.CommandLine.StringVar(&AppArgs.HtpasswdFile, `htpasswdFile`, AppArgs.HtpasswdFile,
+		"<fileName> static htpasswd file used by the 'htpasswd' backend\n")
+
+	if AppArgs.ImageCache, ok = iniValues.AsInt(`imageCache`); !ok {
+		AppArgs.ImageCache = 0
+	}
+	flag.CommandLine.IntVar(&AppArgs.ImageCache, `imageCache`, AppArgs.ImageCache,
+		"<number> max. megabytes of cached thumbnails and cover images (0: unlimited)\n")
+
 	/* * /
 	if s, ok = appArguments.AsString("intl"); (ok) && (0 < len(s)) {
 		AppArgs.Intl = absolute(AppArgs.DataDir, s)
@@ -388,6 +535,44 @@ func setFlags() {
 	flag.CommandLine.StringVar(&AppArgs.Lang, "lang", AppArgs.Lang,
 		"the default language to use ")
 
+	AppArgs.LDAP.URL, _ = iniValues.AsString(`ldapURL`)
+	flag.CommandLine.StringVar(&AppArgs.LDAP.URL, `ldapURL`, AppArgs.LDAP.URL,
+		"<URL> LDAP server used by the 'ldap' backend (e.g. 'ldaps://host')\n")
+
+	AppArgs.LDAP.Insecure, _ = iniValues.AsBool(`ldapInsecure`)
+	flag.CommandLine.BoolVar(&AppArgs.LDAP.Insecure, `ldapInsecure`, AppArgs.LDAP.Insecure,
+		"<boolean> send 'ldap://' passwords in cleartext instead of using StartTLS\n")
+
+	AppArgs.LDAP.BindDN, _ = iniValues.AsString(`ldapBindDN`)
+	flag.CommandLine.StringVar(&AppArgs.LDAP.BindDN, `ldapBindDN`, AppArgs.LDAP.BindDN,
+		"<DN> pattern of the users' DN ('%s' is replaced by the username)\n")
+
+	AppArgs.LDAP.GroupBase, _ = iniValues.AsString(`ldapGroupBase`)
+	flag.CommandLine.StringVar(&AppArgs.LDAP.GroupBase, `ldapGroupBase`, AppArgs.LDAP.GroupBase,
+		"<DN> base DN to search for the users' groups\n")
+
+	AppArgs.LDAP.GroupFilter, _ = iniValues.AsString(`ldapGroupFilter`)
+	flag.CommandLine.StringVar(&AppArgs.LDAP.GroupFilter, `ldapGroupFilter`, AppArgs.LDAP.GroupFilter,
+		"<filter> group search filter ('%s' is replaced by the user's DN)\n")
+
+	if AppArgs.LDAP.GroupAttr, ok = iniValues.AsString(`ldapGroupAttr`); (!ok) || (0 == len(AppArgs.LDAP.GroupAttr)) {
+		AppArgs.LDAP.GroupAttr = `cn`
+	}
+	flag.CommandLine.StringVar(&AppArgs.LDAP.GroupAttr, `ldapGroupAttr`, AppArgs.LDAP.GroupAttr,
+		"<name> attribute holding a group's name\n")
+
+	AppArgs.LDAP.RequireGroup, _ = iniValues.AsString(`ldapRequireGroup`)
+	flag.CommandLine.StringVar(&AppArgs.LDAP.RequireGroup, `ldapRequireGroup`, AppArgs.LDAP.RequireGroup,
+		"<name> (optional) group users must be member of\n")
+
+	AppArgs.Libraries, _ = iniValues.AsString(`libraries`)
+	flag.CommandLine.StringVar(&AppArgs.Libraries, `libraries`, AppArgs.Libraries,
+		"<name:path[:title],...> additional Calibre libraries served at '/lib/<name>/'\n")
+
+	AppArgs.LibraryRoles, _ = iniValues.AsString(`libraryRoles`)
+	flag.CommandLine.StringVar(&AppArgs.LibraryRoles, `libraryRoles`, AppArgs.LibraryRoles,
+		"<name:roles;...> roles allowed to access a library (default: everybody)\n")
+
 	AppArgs.LibName, _ = iniValues.AsString("libraryName")
 	flag.CommandLine.StringVar(&AppArgs.LibName, "libraryName", AppArgs.LibName,
 		"Name of this Library (shown on every page)\n")
@@ -416,6 +601,12 @@ func setFlags() {
 	flag.CommandLine.IntVar(&AppArgs.port, "port", AppArgs.port,
 		"<portNumber> The IP port to listen to ")
 
+	if AppArgs.QueryTimeout, ok = iniValues.AsInt(`queryTimeout`); !ok {
+		AppArgs.QueryTimeout = 10
+	}
+	flag.CommandLine.IntVar(&AppArgs.QueryTimeout, `queryTimeout`, AppArgs.QueryTimeout,
+		"<seconds> max. duration of a single SQL query (0 = unlimited) ")
+
 	if AppArgs.Realm, ok = iniValues.AsString("realm"); (!ok) || (0 == len(AppArgs.Realm)) {
 		AppArgs.Realm = `eBooks Host`
 	}
@@ -434,6 +625,12 @@ func setFlags() {
 	flag.CommandLine.StringVar(&AppArgs.sidName, "sidName", AppArgs.sidName,
 		"<name> The name of the session ID to use\n")
 
+	if AppArgs.SlowQuery, ok = iniValues.AsInt(`slowQuery`); !ok {
+		AppArgs.SlowQuery = 250
+	}
+	flag.CommandLine.IntVar(&AppArgs.SlowQuery, `slowQuery`, AppArgs.SlowQuery,
+		"<milliseconds> duration after which a SQL query is logged with its query plan (0 = never) ")
+
 	if s, ok = iniValues.AsString("sqlTrace"); ok && (0 < len(s)) {
 		AppArgs.writeSQLTrace = absolute(AppArgs.DataDir, s)
 	}
@@ -451,6 +648,31 @@ func setFlags() {
 	flag.CommandLine.StringVar(&AppArgs.Theme, "theme", AppArgs.Theme,
 		"<name> The display theme to use ('light' or 'dark')\n")
 
+	if AppArgs.ThumbWorkers, ok = iniValues.AsInt(`thumbWorkers`); (!ok) || (0 >= AppArgs.ThumbWorkers) {
+		AppArgs.ThumbWorkers = 2
+	}
+	flag.CommandLine.IntVar(&AppArgs.ThumbWorkers, `thumbWorkers`, AppArgs.ThumbWorkers,
+		"<number> number of workers generating thumbnails and renditions\n")
+
+	AppArgs.TOTProles, _ = iniValues.AsString(`totpRoles`)
+	flag.CommandLine.StringVar(&AppArgs.TOTProles, `totpRoles`, AppArgs.TOTProles,
+		"<roleList> comma separated roles requiring two-factor authentication ('*' for all users)\n")
+
+	flag.CommandLine.BoolVar(&AppArgs.CachePrune, "cp", AppArgs.CachePrune,
+		"<boolean> Cache prune: remove the least recently used images exceeding 'imageCache'")
+
+	flag.CommandLine.BoolVar(&AppArgs.CacheRebuild, "cr", AppArgs.CacheRebuild,
+		"<boolean> Cache rebuild: remove all cached images and generate the thumbnails anew")
+
+	flag.CommandLine.StringVar(&AppArgs.TokenAdd, "ta", AppArgs.TokenAdd,
+		"<userName:scopeList[:days]> Token add: create an API token ('read', 'download')")
+
+	flag.CommandLine.StringVar(&AppArgs.TokenDelete, "td", AppArgs.TokenDelete,
+		"<tokenID> Token delete: revoke an API token")
+
+	flag.CommandLine.BoolVar(&AppArgs.TokenList, "tl", AppArgs.TokenList,
+		"<boolean> Token list: show all API tokens")
+
 	flag.CommandLine.StringVar(&AppArgs.UserAdd, "ua", AppArgs.UserAdd,
 		"<userName> User add: add a username to the password file")
 
@@ -469,8 +691,26 @@ func setFlags() {
 	flag.CommandLine.BoolVar(&AppArgs.UserList, "ul", AppArgs.UserList,
 		"<boolean> User list: show all users in the password file")
 
+	flag.CommandLine.StringVar(&AppArgs.UserRoles, "ur", AppArgs.UserRoles,
+		"<userName:roleList> User roles: set the (comma separated) roles of a user")
+
+	flag.CommandLine.StringVar(&AppArgs.UserTOTP, "ut", AppArgs.UserTOTP,
+		"<userName> User TOTP: reset the two-factor authentication of a user")
+
 	flag.CommandLine.StringVar(&AppArgs.UserUpdate, "uu", AppArgs.UserUpdate,
 		"<userName> User update: update a username in the password file")
+
+	AppArgs.WebhookBase, _ = iniValues.AsString(`webhookBaseURL`)
+	flag.CommandLine.StringVar(&AppArgs.WebhookBase, `webhookBaseURL`, AppArgs.WebhookBase,
+		"<URL> public base URL of the server used in webhook payloads\n")
+
+	AppArgs.Webhooks, _ = iniValues.AsString(`webhooks`)
+	flag.CommandLine.StringVar(&AppArgs.Webhooks, `webhooks`, AppArgs.Webhooks,
+		"<URLlist> comma separated URLs to notify about library changes\n")
+
+	AppArgs.WebhookSecret, _ = iniValues.AsString(`webhookSecret`)
+	flag.CommandLine.StringVar(&AppArgs.WebhookSecret, `webhookSecret`, AppArgs.WebhookSecret,
+		"<string> secret to sign the webhook payloads with\n")
 } // setFlags()
 
 // ShowHelp lists the commandline options to `Stderr`.
@@ -144,6 +144,8 @@ func Test_readIniFiles(t *testing.T) {
 func Test_setFlagsDebug(t *testing.T) {
 	expected := &TAppArgs{
 		AuthAll:       true,
+		AuthBackend:   `passlist`,
+		AuthProxyIPs:  `127.0.0.1, ::1`,
 		BooksPerPage:  24,
 		DataDir:       `/home/matthias/devel/Go/src/github.com/mwat56/kaliber`,
 		delWhitespace: true,
@@ -156,6 +158,7 @@ func Test_setFlagsDebug(t *testing.T) {
 		sessionTTL:    1200,
 		sidName:       `sid`,
 		Theme:         `dark`,
+		LDAP:          TLDAPconfig{GroupAttr: `cn`},
 	}
 	tests := []struct {
 		name string
@@ -0,0 +1,92 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the protection of the forms changing the server's
+ * state (two-factor authentication, API tokens, share links) against
+ * cross-site requests.
+ */
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mwat56/sessions"
+)
+
+const (
+	// Name of the form field (and session key) holding the token.
+	csrfName = `csrf`
+)
+
+var (
+	// The error reported for a form not sent by the server's own page.
+	errCSRF = errors.New(`the form has expired, please try again`)
+)
+
+// `csrfPassed()` reports whether `aRequest` was sent by one of the
+// server's own pages: its `Origin` (or `Referer`) header must name
+// the requested host and its form must hold the session's `aToken`.
+//
+//	`aRequest` The HTTP (POST) request received by the server.
+//	`aToken` The session's CSRF token.
+func csrfPassed(aRequest *http.Request, aToken string) bool {
+	if (0 == len(aToken)) || !sameOrigin(aRequest) {
+		return false
+	}
+
+	return 1 == subtle.ConstantTimeCompare([]byte(aToken), []byte(aRequest.FormValue(csrfName)))
+} // csrfPassed()
+
+// `csrfToken()` returns the token to send with the forms of
+// `aSession`, creating it if necessary.
+//
+//	`aSession` The current user session.
+func csrfToken(aSession *sessions.TSession) string {
+	if token, ok := aSession.GetString(csrfName); ok && (0 < len(token)) {
+		return token
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); nil != err {
+		return ``
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	aSession.Set(csrfName, token)
+
+	return token
+} // csrfToken()
+
+// `sameOrigin()` reports whether the `Origin` header of `aRequest`
+// (or its `Referer` if there's no `Origin`) names the requested host.
+//
+// A request without both headers is accepted.
+//
+//	`aRequest` The HTTP request received by the server.
+func sameOrigin(aRequest *http.Request) bool {
+	origin := aRequest.Header.Get(`Origin`)
+	if 0 == len(origin) {
+		if origin = aRequest.Header.Get(`Referer`); 0 == len(origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if (nil != err) || (0 == len(u.Host)) {
+		return false // e.g. "null"
+	}
+
+	return strings.EqualFold(u.Host, aRequest.Host)
+} // sameOrigin()
+
+/* _EoF_ */
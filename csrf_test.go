@@ -0,0 +1,55 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_csrfPassed(t *testing.T) {
+	const token = `0123456789abcdef`
+
+	tests := []struct {
+		name    string
+		aToken  string
+		aForm   string
+		aHeader string
+		aValue  string
+		want    bool
+	}{
+		// TODO: Add test cases.
+		{" 1", token, token, ``, ``, true},
+		{" 2", token, token, `Origin`, `http://kaliber.example`, true},
+		{" 3", token, token, `Referer`, `https://kaliber.example/totp`, true},
+		{" 4", token, token, `Origin`, `https://evil.example`, false},
+		{" 5", token, token, `Referer`, `https://evil.example/form`, false},
+		{" 6", token, token, `Origin`, `null`, false},
+		{" 7", token, `fedcba9876543210`, ``, ``, false},
+		{" 8", token, ``, ``, ``, false},
+		{" 9", ``, ``, ``, ``, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{csrfName: {tt.aForm}}
+			req := httptest.NewRequest(`POST`, `http://kaliber.example/totp`, strings.NewReader(form.Encode()))
+			req.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
+			if 0 < len(tt.aHeader) {
+				req.Header.Set(tt.aHeader, tt.aValue)
+			}
+			if got := csrfPassed(req, tt.aToken); got != tt.want {
+				t.Errorf("csrfPassed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // Test_csrfPassed()
+
+/* _EoF_ */
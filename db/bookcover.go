@@ -0,0 +1,447 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the extraction of the covers of books without
+ * a cover image of their own from the books' EPUB, CBZ, or FB2 files.
+ *
+ * The extracted covers are stored below the library's cache path,
+ * the Calibre library is never written to.
+ */
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// CoversDirectory is the cache subdirectory holding the covers
+	// extracted from the book files.
+	CoversDirectory = `covers`
+
+	// Suffix of the file marking a failed cover extraction.
+	bcFailedSuffix = `-failed`
+
+	// Max. size of an extracted cover image.
+	bcMaxImageSize = 32 << 20
+)
+
+type (
+	// `tBookCoverFunc` returns the cover image stored in a book file.
+	tBookCoverFunc func(aFilename string) ([]byte, error)
+
+	// `tEpubContainer` is the `META-INF/container.xml` of an EPUB.
+	tEpubContainer struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+
+	// `tEpubPackage` is the (relevant part of the) OPF file of an EPUB.
+	tEpubPackage struct {
+		Metas []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"metadata>meta"`
+		Items []struct {
+			ID         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"manifest>item"`
+		Spine struct {
+			Toc      string `xml:"toc,attr"`
+			ItemRefs []struct {
+				IDRef string `xml:"idref,attr"`
+			} `xml:"itemref"`
+		} `xml:"spine"`
+	}
+)
+
+var (
+	// The book formats to extract covers from (in order of preference).
+	bcFormats = []struct {
+		ext     string
+		extract tBookCoverFunc
+	}{
+		{`epub`, epubCover},
+		{`cbz`, cbzCover},
+		{`fb2`, fb2Cover},
+	}
+)
+
+// `cbzCover()` returns the first image (in natural order) of the comic
+// book archive `aFilename`.
+//
+//	`aFilename` The name of the CBZ file.
+func cbzCover(aFilename string) ([]byte, error) {
+	zr, err := zip.OpenReader(aFilename)
+	if nil != err {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var images []*zip.File
+	for _, file := range zr.File {
+		if isComicImage(file.Name) {
+			images = append(images, file)
+		}
+	}
+	if 0 == len(images) {
+		return nil, errors.New(`cbzCover(): no image found in ` + aFilename)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return naturalLess(images[i].Name, images[j].Name)
+	})
+
+	return zipFileData(images[0])
+} // cbzCover()
+
+// `coverImageExt()` returns the filename extension matching the
+// image data in `aData`, or an empty string if it's neither a JPEG,
+// a PNG, nor a GIF image.
+//
+//	`aData` The image data to check.
+func coverImageExt(aData []byte) string {
+	switch {
+	case bytes.HasPrefix(aData, []byte("\xFF\xD8\xFF")):
+		return `.jpg`
+	case bytes.HasPrefix(aData, []byte("\x89PNG\r\n\x1A\n")):
+		return `.png`
+	case bytes.HasPrefix(aData, []byte("GIF87a")), bytes.HasPrefix(aData, []byte("GIF89a")):
+		return `.gif`
+	}
+
+	return ``
+} // coverImageExt()
+
+// `epubCover()` returns the cover image of the EPUB `aFilename`.
+//
+// The image is the manifest item marked as `cover-image` (EPUB 3),
+// the one named by the `cover` meta data (EPUB 2), or the first image
+// of the manifest.
+//
+//	`aFilename` The name of the EPUB file.
+func epubCover(aFilename string) ([]byte, error) {
+	zr, err := zip.OpenReader(aFilename)
+	if nil != err {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, file := range zr.File {
+		files[file.Name] = file
+	}
+	var container tEpubContainer
+	if err = zipFileXML(files[`META-INF/container.xml`], &container); nil != err {
+		return nil, err
+	}
+	if 0 == len(container.Rootfiles) {
+		return nil, errors.New(`epubCover(): no OPF file in ` + aFilename)
+	}
+	opfName := container.Rootfiles[0].FullPath
+	var pkg tEpubPackage
+	if err = zipFileXML(files[opfName], &pkg); nil != err {
+		return nil, err
+	}
+
+	var coverID string
+	for _, meta := range pkg.Metas {
+		if `cover` == meta.Name {
+			coverID = meta.Content
+			break
+		}
+	}
+	href := ``
+	for _, item := range pkg.Items {
+		if !strings.HasPrefix(item.MediaType, `image/`) {
+			continue
+		}
+		if 0 <= strings.Index(` `+item.Properties+` `, ` cover-image `) {
+			href = item.Href
+			break
+		}
+		if (0 < len(coverID)) && (coverID == item.ID) {
+			href = item.Href
+			// an EPUB 3 `cover-image` item takes precedence
+			continue
+		}
+		if 0 == len(href) {
+			href = item.Href // the first image
+		}
+	}
+	if 0 == len(href) {
+		return nil, errors.New(`epubCover(): no image found in ` + aFilename)
+	}
+	if h, err := url.PathUnescape(href); nil == err {
+		href = h
+	}
+
+	return zipFileData(files[path.Join(path.Dir(opfName), href)])
+} // epubCover()
+
+// `fb2Cover()` returns the cover image of the FictionBook file
+// `aFilename`.
+//
+// The image is the one named by the `coverpage` or – if there's
+// none – the first embedded JPEG image.
+//
+//	`aFilename` The name of the FB2 file.
+func fb2Cover(aFilename string) ([]byte, error) {
+	file, err := os.Open(aFilename) // #nosec G304
+	if nil != err {
+		return nil, err
+	}
+	defer file.Close()
+
+	dec := xml.NewDecoder(io.LimitReader(file, 4*bcMaxImageSize))
+	// The binaries and their IDs are plain ASCII, hence the text's
+	// (often legacy) encoding doesn't matter here:
+	dec.CharsetReader = func(_ string, aInput io.Reader) (io.Reader, error) {
+		return aInput, nil
+	}
+	dec.Strict = false
+
+	var (
+		coverID    string
+		inCover    bool
+		firstImage []byte
+	)
+	for {
+		token, err := dec.Token()
+		if nil != err {
+			break
+		}
+		switch elem := token.(type) {
+		case xml.StartElement:
+			switch elem.Name.Local {
+			case `coverpage`:
+				inCover = true
+			case `image`:
+				if !inCover || (0 < len(coverID)) {
+					continue
+				}
+				for _, attr := range elem.Attr {
+					if `href` == attr.Name.Local {
+						coverID = strings.TrimPrefix(attr.Value, `#`)
+					}
+				}
+			case `binary`:
+				var binary struct {
+					ID          string `xml:"id,attr"`
+					ContentType string `xml:"content-type,attr"`
+					Data        string `xml:",chardata"`
+				}
+				if err = dec.DecodeElement(&binary, &elem); nil != err {
+					continue
+				}
+				isCover := (0 < len(coverID)) && (coverID == binary.ID)
+				if !isCover && ((0 < len(firstImage)) || (`image/jpeg` != binary.ContentType)) {
+					continue
+				}
+				data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(binary.Data), ``))
+				if nil != err {
+					continue
+				}
+				if isCover {
+					return data, nil
+				}
+				firstImage = data
+			}
+		case xml.EndElement:
+			if `coverpage` == elem.Name.Local {
+				inCover = false
+			}
+		}
+	}
+	if 0 < len(firstImage) {
+		return firstImage, nil
+	}
+
+	return nil, errors.New(`fb2Cover(): no image found in ` + aFilename)
+} // fb2Cover()
+
+// `isComicImage()` returns whether `aName` is an image page of a
+// comic book archive.
+//
+//	`aName` The name of the archive's file.
+func isComicImage(aName string) bool {
+	base := path.Base(aName)
+	if strings.HasPrefix(aName, `__MACOSX/`) || strings.HasPrefix(base, `.`) ||
+		strings.HasSuffix(aName, `/`) {
+		return false
+	}
+	switch strings.ToLower(path.Ext(base)) {
+	case `.gif`, `.jpeg`, `.jpg`, `.png`:
+		return true
+	}
+
+	return false
+} // isComicImage()
+
+// `zipFileData()` returns the (uncompressed) contents of `aFile`.
+//
+//	`aFile` The archive's file to read.
+func zipFileData(aFile *zip.File) ([]byte, error) {
+	if nil == aFile {
+		return nil, os.ErrNotExist
+	}
+	if bcMaxImageSize < aFile.UncompressedSize64 {
+		return nil, fmt.Errorf("zipFileData(): %s too large", aFile.Name)
+	}
+	rc, err := aFile.Open()
+	if nil != err {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(io.LimitReader(rc, bcMaxImageSize))
+} // zipFileData()
+
+// `zipFileXML()` decodes the XML file `aFile` into `aData`.
+//
+//	`aFile` The archive's file to read.
+//	`aData` The structure to fill.
+func zipFileXML(aFile *zip.File, aData any) error {
+	data, err := zipFileData(aFile)
+	if nil != err {
+		return err
+	}
+
+	return xml.Unmarshal(data, aData)
+} // zipFileXML()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `coverExtracted()` returns the path/filename of the cover image
+// extracted from one of the document's book files.
+//
+// The image is extracted again if the book file is younger than
+// the previously extracted image. A failed extraction is recorded
+// by a marker file so that it's tried again only after the book's
+// directory or files changed.
+func (doc *TDocument) coverExtracted() (string, error) {
+	dir := filepath.Join(doc.Library().Path(), doc.path)
+	pattern := dir
+	if 0 <= strings.Index(pattern, `[`) {
+		// make sure to escape the meta-character
+		pattern = strings.Replace(pattern, `[`, `\[`, -1)
+	}
+	name := fmt.Sprintf("%06d", doc.ID)
+	base := filepath.Join(doc.Library().CachePath(), CoversDirectory, name[:4], name)
+	failed := base + bcFailedSuffix
+	previous, _ := filepath.Glob(base + `.*`)
+
+	var newest time.Time // the latest change of the book's files
+	if dFI, err := os.Stat(dir); nil == err {
+		newest = dFI.ModTime()
+	}
+	type tBook struct {
+		name    string
+		modTime time.Time
+		extract tBookCoverFunc
+	}
+	books := make([]tBook, 0, len(bcFormats))
+	for _, format := range bcFormats {
+		bNames, err := filepath.Glob(pattern + `/*.` + format.ext)
+		if (nil != err) || (0 == len(bNames)) {
+			continue
+		}
+		bFI, err := os.Stat(bNames[0])
+		if nil != err {
+			continue
+		}
+		books = append(books, tBook{bNames[0], bFI.ModTime(), format.extract})
+		if bFI.ModTime().After(newest) {
+			newest = bFI.ModTime()
+		}
+	}
+	if fFI, err := os.Stat(failed); (nil == err) && fFI.ModTime().After(newest) {
+		// nothing changed since the last failed extraction
+		return ``, errors.New(`TDocument.coverExtracted(): no cover found in ` + dir)
+	}
+
+	for _, book := range books {
+		for _, cName := range previous {
+			if cFI, err := os.Stat(cName); (nil == err) && cFI.ModTime().After(book.modTime) {
+				return cName, nil
+			}
+		}
+
+		data, err := book.extract(book.name)
+		if nil != err {
+			continue
+		}
+		ext := coverImageExt(data)
+		if 0 == len(ext) {
+			continue
+		}
+		for _, cName := range previous {
+			_ = os.Remove(cName)
+		}
+		if err = writeCoverFile(base+ext, data); nil != err {
+			return ``, err
+		}
+		_ = os.Remove(failed)
+		return base + ext, nil
+	}
+	_ = writeCoverFile(failed, nil)
+
+	return ``, errors.New(`TDocument.coverExtracted(): no cover found in ` + dir)
+} // coverExtracted()
+
+// `writeCoverFile()` stores `aData` in `aFilename`.
+//
+// The data is written to a temporary file first so that concurrent
+// requests never see an incomplete image.
+//
+//	`aFilename` The name of the file to write.
+//	`aData` The image data to store.
+func writeCoverFile(aFilename string, aData []byte) error {
+	dir := filepath.Dir(aFilename)
+	if err := os.MkdirAll(dir, os.ModeDir|0775); nil != err {
+		return err
+	}
+	file, err := os.CreateTemp(dir, `.cover-*`)
+	if nil != err {
+		return err
+	}
+	tName := file.Name()
+	_, err = file.Write(aData)
+	if cErr := file.Close(); nil == err {
+		err = cErr
+	}
+	if nil == err {
+		err = os.Chmod(tName, 0640)
+	}
+	if nil == err {
+		err = os.Rename(tName, aFilename)
+	}
+	if nil != err {
+		_ = os.Remove(tName)
+	}
+
+	return err
+} // writeCoverFile()
+
+/* _EoF_ */
@@ -0,0 +1,301 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	// Minimal image headers used as test data.
+	bcJPEG = []byte("\xFF\xD8\xFF\xE0 jpeg")
+	bcPNG  = []byte("\x89PNG\r\n\x1A\n png")
+	bcGIF  = []byte("GIF89a gif")
+)
+
+// `prepZipFile()` writes an archive with `aFiles` (name/data pairs)
+// to `aFilename`.
+func prepZipFile(t *testing.T, aFilename string, aFiles ...string) {
+	if err := os.MkdirAll(filepath.Dir(aFilename), 0700); nil != err {
+		t.Fatal(err)
+	}
+	file, err := os.Create(aFilename)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	for idx := 0; idx < len(aFiles)-1; idx += 2 {
+		w, err := zw.Create(aFiles[idx])
+		if nil != err {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(aFiles[idx+1])); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); nil != err {
+		t.Fatal(err)
+	}
+} // prepZipFile()
+
+const (
+	bcContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+	bcOPF2 = `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Test</dc:title><meta name="cover" content="img2"/></metadata>
+<manifest>
+<item id="text" href="text.html" media-type="application/xhtml+xml"/>
+<item id="img1" href="images/first.png" media-type="image/png"/>
+<item id="img2" href="images/my%20cover.jpg" media-type="image/jpeg"/>
+</manifest>
+</package>`
+
+	bcOPF3 = `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+<metadata/>
+<manifest>
+<item id="img1" href="first.gif" media-type="image/gif"/>
+<item id="img2" href="cover.png" media-type="image/png" properties="cover-image"/>
+</manifest>
+</package>`
+
+	bcOPFfirst = `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+<manifest>
+<item id="text" href="text.html" media-type="application/xhtml+xml"/>
+<item id="img1" href="first.gif" media-type="image/gif"/>
+<item id="img2" href="second.png" media-type="image/png"/>
+</manifest>
+</package>`
+)
+
+func Test_epubCover(t *testing.T) {
+	dir := t.TempDir()
+	epub2 := filepath.Join(dir, `2.epub`)
+	prepZipFile(t, epub2, `mimetype`, `application/epub+zip`,
+		`META-INF/container.xml`, bcContainer, `OEBPS/content.opf`, bcOPF2,
+		`OEBPS/images/first.png`, string(bcPNG), `OEBPS/images/my cover.jpg`, string(bcJPEG))
+	epub3 := filepath.Join(dir, `3.epub`)
+	prepZipFile(t, epub3, `META-INF/container.xml`, bcContainer,
+		`OEBPS/content.opf`, bcOPF3, `OEBPS/first.gif`, string(bcGIF), `OEBPS/cover.png`, string(bcPNG))
+	epub1 := filepath.Join(dir, `1.epub`)
+	prepZipFile(t, epub1, `META-INF/container.xml`, bcContainer,
+		`OEBPS/content.opf`, bcOPFfirst, `OEBPS/first.gif`, string(bcGIF), `OEBPS/second.png`, string(bcPNG))
+	epub0 := filepath.Join(dir, `0.epub`)
+	prepZipFile(t, epub0, `mimetype`, `application/epub+zip`)
+
+	tests := []struct {
+		name      string
+		aFilename string
+		want      []byte
+		wantErr   bool
+	}{
+		// TODO: Add test cases.
+		{" 1", epub2, bcJPEG, false},
+		{" 2", epub3, bcPNG, false},
+		{" 3", epub1, bcGIF, false},
+		{" 4", epub0, nil, true},
+		{" 5", filepath.Join(dir, `missing.epub`), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := epubCover(tt.aFilename)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("epubCover() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("epubCover() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_epubCover()
+
+func Test_cbzCover(t *testing.T) {
+	dir := t.TempDir()
+	cbz1 := filepath.Join(dir, `1.cbz`)
+	prepZipFile(t, cbz1, `ComicInfo.xml`, `<ComicInfo/>`,
+		`__MACOSX/._001.jpg`, `junk`, `Comic/002.png`, string(bcPNG), `Comic/001.JPG`, string(bcJPEG))
+	cbz0 := filepath.Join(dir, `0.cbz`)
+	prepZipFile(t, cbz0, `ComicInfo.xml`, `<ComicInfo/>`)
+
+	tests := []struct {
+		name      string
+		aFilename string
+		want      []byte
+		wantErr   bool
+	}{
+		// TODO: Add test cases.
+		{" 1", cbz1, bcJPEG, false},
+		{" 2", cbz0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cbzCover(tt.aFilename)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cbzCover() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("cbzCover() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_cbzCover()
+
+func Test_fb2Cover(t *testing.T) {
+	dir := t.TempDir()
+	write := func(aName, aText string) string {
+		fName := filepath.Join(dir, aName)
+		if err := os.WriteFile(fName, []byte(aText), 0600); nil != err {
+			t.Fatal(err)
+		}
+		return fName
+	}
+	b64 := func(aData []byte) string {
+		return base64.StdEncoding.EncodeToString(aData)
+	}
+	fb1 := write(`1.fb2`, `<?xml version="1.0" encoding="windows-1251"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description><title-info><coverpage><image l:href="#cover.png"/></coverpage></title-info></description>
+<body><section><p>Text</p><image l:href="#pic.jpg"/></section></body>
+<binary id="pic.jpg" content-type="image/jpeg">`+b64(bcJPEG)+`</binary>
+<binary id="cover.png" content-type="image/png">
+`+b64(bcPNG)+`
+</binary>
+</FictionBook>`)
+	fb2 := write(`2.fb2`, `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0">
+<description><title-info/></description>
+<binary id="pic.png" content-type="image/png">`+b64(bcPNG)+`</binary>
+<binary id="pic.jpg" content-type="image/jpeg">`+b64(bcJPEG)+`</binary>
+</FictionBook>`)
+	fb0 := write(`0.fb2`, `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><body/></FictionBook>`)
+
+	tests := []struct {
+		name      string
+		aFilename string
+		want      []byte
+		wantErr   bool
+	}{
+		// TODO: Add test cases.
+		{" 1", fb1, bcPNG, false},
+		{" 2", fb2, bcJPEG, false},
+		{" 3", fb0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fb2Cover(tt.aFilename)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fb2Cover() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("fb2Cover() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_fb2Cover()
+
+func TestTDocument_coverExtracted(t *testing.T) {
+	lib := prepLibraryForTesting(t)
+	doc := lib.newDocument()
+	doc.ID = 42
+	doc.path = `Author/Title (42)`
+	bookDir := filepath.Join(lib.Path(), doc.path)
+
+	if _, err := doc.CoverFile(); nil == err {
+		t.Fatal("CoverFile() expected an error without book files")
+	}
+
+	cbz := filepath.Join(bookDir, `Title - Author.cbz`)
+	prepZipFile(t, cbz, `01.png`, string(bcPNG))
+	got, err := doc.CoverFile()
+	if nil != err {
+		t.Fatalf("CoverFile() error = %v", err)
+	}
+	want := filepath.Join(lib.CachePath(), CoversDirectory, `0000`, `000042.png`)
+	if got != want {
+		t.Errorf("CoverFile() = %q, want %q", got, want)
+	}
+	if strings.HasPrefix(got, lib.Path()) {
+		t.Errorf("CoverFile() = %q is inside the Calibre library", got)
+	}
+
+	// A changed book file replaces the extracted cover:
+	past := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(got, past, past); nil != err {
+		t.Fatal(err)
+	}
+	prepZipFile(t, cbz, `01.jpg`, string(bcJPEG))
+	if got, err = doc.CoverFile(); (nil != err) || !strings.HasSuffix(got, `000042.jpg`) {
+		t.Errorf("CoverFile() = %q, %v, want a JPEG file", got, err)
+	}
+	if _, err = os.Stat(want); !os.IsNotExist(err) {
+		t.Errorf("outdated cover %q not removed", want)
+	}
+
+	// Calibre's own cover takes precedence:
+	cover := filepath.Join(bookDir, `cover.jpg`)
+	if err = os.WriteFile(cover, bcJPEG, 0600); nil != err {
+		t.Fatal(err)
+	}
+	if got, err = doc.CoverFile(); (nil != err) || (cover != got) {
+		t.Errorf("CoverFile() = %q, %v, want %q", got, err, cover)
+	}
+
+	// A failed extraction isn't tried again until the book changes:
+	doc.ID = 43
+	doc.path = `Author/Other (43)`
+	bookDir = filepath.Join(lib.Path(), doc.path)
+	cbz = filepath.Join(bookDir, `Other - Author.cbz`)
+	prepZipFile(t, cbz, `01.txt`, `no image`)
+	if _, err = doc.CoverFile(); nil == err {
+		t.Fatal("CoverFile() expected an error without images")
+	}
+	failed := filepath.Join(lib.CachePath(), CoversDirectory, `0000`, `000043`+bcFailedSuffix)
+	if _, err = os.Stat(failed); nil != err {
+		t.Fatalf("failed extraction not recorded: %v", err)
+	}
+	prepZipFile(t, cbz, `01.png`, string(bcPNG))
+	for _, name := range []string{cbz, bookDir} {
+		if err = os.Chtimes(name, past, past); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if _, err = doc.CoverFile(); nil == err {
+		t.Errorf("CoverFile() extracted again although the book didn't change")
+	}
+	now := time.Now().Add(time.Second)
+	if err = os.Chtimes(cbz, now, now); nil != err {
+		t.Fatal(err)
+	}
+	if got, err = doc.CoverFile(); (nil != err) || !strings.HasSuffix(got, `000043.png`) {
+		t.Errorf("CoverFile() = %q, %v, want a PNG file", got, err)
+	}
+	if _, err = os.Stat(failed); !os.IsNotExist(err) {
+		t.Errorf("failure marker %q not removed", failed)
+	}
+} // TestTDocument_coverExtracted()
+
+/* _EoF_ */
@@ -0,0 +1,367 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the detection of library changes by comparing
+ * consecutive database copies, and a small history of those changes.
+ */
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mwat56/apachelogger"
+)
+
+// Kinds of library changes.
+const (
+	// ChangeAdded marks a book added to the library.
+	ChangeAdded = `added`
+
+	// ChangeModified marks a book whose metadata was changed.
+	ChangeModified = `modified`
+
+	// ChangeRemoved marks a book removed from the library.
+	ChangeRemoved = `removed`
+)
+
+const (
+	// Name of the history file in the cache directory.
+	changesFilename = `kaliber_changes.log`
+
+	// Max. age of the changes kept in the history.
+	changesMaxAge = 90 * 24 * time.Hour
+
+	// Max. number of changes kept in the history.
+	changesMaxCount = 2048
+
+	// Query for a book's state used to detect changes.
+	changesQuery = `SELECT b.id, b.title, b.last_modified, b.timestamp,
+IFNULL((SELECT group_concat(a.name, ', ') FROM authors a JOIN books_authors_link l ON(l.author = a.id) WHERE (l.book = b.id)), ''),
+IFNULL((SELECT group_concat(d.format, ',') FROM data d WHERE (d.book = b.id)), '')
+FROM books b`
+)
+
+type (
+	// TChange describes a single change of the library.
+	TChange struct {
+		Authors string    // the book's authors
+		Formats string    // comma separated list of file formats
+		ID      TID       // the book's ID
+		Kind    string    // `ChangeAdded`, `ChangeModified`, or `ChangeRemoved`
+		lib     *TLibrary // the library changed
+		Time    time.Time // time the change was detected
+		Title   string    // the book's title
+	}
+
+	// TChangeList is a list of library changes.
+	TChangeList []TChange
+
+	// `tBookState` is the part of a book's data used to detect changes.
+	tBookState struct {
+		authors, formats, stamp, title string
+	}
+
+	// `tChanges` is a library's history of changes.
+	tChanges struct {
+		history TChangeList         // the library's changes (newest last)
+		hooks   []func(TChangeList) // functions to call on changes
+		loaded  bool                // flag whether `history` was read from disk
+		mtx     *sync.Mutex         // guard for `history` and `hooks`
+	}
+)
+
+// `newChanges()` returns an empty history of changes.
+func newChanges() tChanges {
+	return tChanges{
+		mtx: new(sync.Mutex),
+	}
+} // newChanges()
+
+// DocLink returns the URL of the changed document's page.
+func (ch TChange) DocLink() string {
+	return fmt.Sprintf("%s/doc/%d/doc.html", ch.Library().URL(), ch.ID)
+} // DocLink()
+
+// FormatList returns the changed document's file formats.
+func (ch TChange) FormatList() []string {
+	if 0 == len(ch.Formats) {
+		return nil
+	}
+
+	return strings.Split(ch.Formats, `,`)
+} // FormatList()
+
+// Library returns the library the change was detected in.
+func (ch TChange) Library() *TLibrary {
+	if nil == ch.lib {
+		return DefaultLibrary()
+	}
+
+	return ch.lib
+} // Library()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `changesDiff()` compares two library states, returning the list of
+// added, modified, and removed books (sorted by kind and ID).
+//
+//	`aOld` The library's previous state.
+//	`aNew` The library's current state.
+//	`aTime` The time to use for the changes.
+func changesDiff(aOld, aNew map[TID]tBookState, aTime time.Time) TChangeList {
+	var result TChangeList
+	change := func(aKind string, aID TID, aState tBookState) TChange {
+		return TChange{
+			Authors: aState.authors,
+			Formats: aState.formats,
+			ID:      aID,
+			Kind:    aKind,
+			Time:    aTime,
+			Title:   aState.title,
+		}
+	}
+
+	for id, state := range aNew {
+		if old, ok := aOld[id]; !ok {
+			result = append(result, change(ChangeAdded, id, state))
+		} else if old != state {
+			result = append(result, change(ChangeModified, id, state))
+		}
+	}
+	for id, state := range aOld {
+		if _, ok := aNew[id]; !ok {
+			result = append(result, change(ChangeRemoved, id, state))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+} // changesDiff()
+
+// `changesFile()` returns the path-/filename of the history file.
+func (l *TLibrary) changesFile() string {
+	return filepath.Join(l.cachePath, changesFilename)
+} // changesFile()
+
+// `changesLoad()` reads the history file (if not done already).
+//
+// NOTE: The caller is expected to hold the lock.
+func (l *TLibrary) changesLoad() {
+	if l.changes.loaded {
+		return
+	}
+	l.changes.loaded = true
+
+	file, err := os.Open(l.changesFile()) // #nosec G304
+	if nil != err {
+		if !os.IsNotExist(err) {
+			apachelogger.Err("changesLoad()", fmt.Sprintf("%v", err))
+		}
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// time, kind, id, title, authors, formats
+		fields := strings.Split(scanner.Text(), "\t")
+		if 6 != len(fields) {
+			continue
+		}
+		sec, err := strconv.ParseInt(fields[0], 10, 64)
+		if nil != err {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if nil != err {
+			continue
+		}
+		l.changes.history = append(l.changes.history, TChange{
+			Authors: fields[4],
+			Formats: fields[5],
+			ID:      id,
+			Kind:    fields[1],
+			lib:     l,
+			Time:    time.Unix(sec, 0),
+			Title:   fields[3],
+		})
+	}
+} // changesLoad()
+
+// `changesPublish()` adds `aList` to the history and calls all
+// functions registered with `OnChange()`.
+//
+//	`aList` The changes detected.
+func (l *TLibrary) changesPublish(aList TChangeList) {
+	for idx := range aList {
+		aList[idx].lib = l
+	}
+	l.changes.mtx.Lock()
+	l.changesLoad()
+	l.changes.history = append(l.changes.history, aList...)
+	err := l.changesSave()
+	hooks := l.changes.hooks
+	l.changes.mtx.Unlock()
+
+	if nil != err {
+		apachelogger.Err("changesPublish()", fmt.Sprintf("%v", err))
+	}
+	for _, hook := range hooks {
+		hook(aList)
+	}
+} // changesPublish()
+
+// `changesReadState()` returns the state of all books in the
+// database `aName`.
+//
+//	`aName` The path-/filename of the database to read.
+func changesReadState(aName string) (map[TID]tBookState, error) {
+	conn, err := sql.Open(`sqlite3`, `file:`+aName+`?mode=ro`)
+	if nil != err {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query(changesQuery)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[TID]tBookState, 1024)
+	for rows.Next() {
+		var (
+			id                                  TID
+			authors, formats, modified, created string
+			title                               string
+		)
+		if err = rows.Scan(&id, &title, &modified, &created, &authors, &formats); nil != err {
+			return nil, err
+		}
+		result[id] = tBookState{
+			authors: authors,
+			formats: formats,
+			stamp:   modified + `|` + created,
+			title:   title,
+		}
+	}
+
+	return result, rows.Err()
+} // changesReadState()
+
+// `changesSave()` writes the history file, dropping old entries.
+//
+// NOTE: The caller is expected to hold the lock.
+func (l *TLibrary) changesSave() error {
+	history := l.changes.history
+	limit := time.Now().Add(-changesMaxAge)
+	start := 0
+	if changesMaxCount < len(history) {
+		start = len(history) - changesMaxCount
+	}
+	for (start < len(history)) && history[start].Time.Before(limit) {
+		start++
+	}
+	history = append(TChangeList(nil), history[start:]...)
+	l.changes.history = history
+
+	clean := strings.NewReplacer("\t", ` `, "\n", ` `, "\r", ` `)
+	var sb strings.Builder
+	for _, ch := range history {
+		fmt.Fprintf(&sb, "%d\t%s\t%d\t%s\t%s\t%s\n", ch.Time.Unix(), ch.Kind,
+			ch.ID, clean.Replace(ch.Title), clean.Replace(ch.Authors), ch.Formats)
+	}
+
+	tmpName := l.changesFile() + `~`
+	if err := os.WriteFile(tmpName, []byte(sb.String()), 0640); nil != err {
+		return err
+	}
+
+	return os.Rename(tmpName, l.changesFile())
+} // changesSave()
+
+// `syncDiff()` compares the database copies `aOldName` and `aNewName`
+// returning the changes found.
+//
+//	`aOldName` The path-/filename of the previous database copy.
+//	`aNewName` The path-/filename of the current database copy.
+func syncDiff(aOldName, aNewName string) (TChangeList, error) {
+	oldState, err := changesReadState(aOldName)
+	if nil != err {
+		return nil, err
+	}
+	newState, err := changesReadState(aNewName)
+	if nil != err {
+		return nil, err
+	}
+
+	return changesDiff(oldState, newState, time.Now().Truncate(time.Second)), nil
+} // syncDiff()
+
+// OnChange registers `aHook` to be called (in background) whenever
+// changes of the library were detected.
+//
+//	`aHook` The function to call with the list of changes.
+func (l *TLibrary) OnChange(aHook func(TChangeList)) {
+	l.changes.mtx.Lock()
+	defer l.changes.mtx.Unlock()
+
+	l.changes.hooks = append(l.changes.hooks, aHook)
+} // OnChange()
+
+// RecentChanges returns the changes detected since `aSince` (newest
+// first), optionally limited to the given `aKinds`.
+//
+//	`aSince` The time of the oldest change to return.
+//	`aKinds` The kinds of changes to return (all if empty).
+func (l *TLibrary) RecentChanges(aSince time.Time, aKinds ...string) TChangeList {
+	l.changes.mtx.Lock()
+	defer l.changes.mtx.Unlock()
+
+	l.changesLoad()
+	var result TChangeList
+	for idx := len(l.changes.history) - 1; 0 <= idx; idx-- {
+		ch := l.changes.history[idx]
+		if ch.Time.Before(aSince) {
+			break
+		}
+		if 0 < len(aKinds) {
+			found := false
+			for _, kind := range aKinds {
+				if kind == ch.Kind {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		result = append(result, ch)
+	}
+
+	return result
+} // RecentChanges()
+
+/* _EoF_ */
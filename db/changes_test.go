@@ -0,0 +1,133 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_changesDiff(t *testing.T) {
+	now := time.Now()
+	s1 := tBookState{title: `one`, stamp: `1`}
+	s2 := tBookState{title: `two`, stamp: `1`}
+	s2m := tBookState{title: `two`, stamp: `2`}
+	tests := []struct {
+		name string
+		aOld map[TID]tBookState
+		aNew map[TID]tBookState
+		want TChangeList
+	}{
+		// TODO: Add test cases.
+		{" 1", map[TID]tBookState{1: s1}, map[TID]tBookState{1: s1}, nil},
+		{" 2", map[TID]tBookState{1: s1}, map[TID]tBookState{1: s1, 2: s2}, TChangeList{
+			{ID: 2, Kind: ChangeAdded, Time: now, Title: `two`},
+		}},
+		{" 3", map[TID]tBookState{1: s1, 2: s2}, map[TID]tBookState{2: s2m, 3: s1}, TChangeList{
+			{ID: 3, Kind: ChangeAdded, Time: now, Title: `one`},
+			{ID: 2, Kind: ChangeModified, Time: now, Title: `two`},
+			{ID: 1, Kind: ChangeRemoved, Time: now, Title: `one`},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changesDiff(tt.aOld, tt.aNew, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changesDiff() = %v,\nwant %v", got, tt.want)
+			}
+		})
+	}
+} // Test_changesDiff()
+
+// `prepCalibreDB()` creates a minimal Calibre-like database `aName`.
+func prepCalibreDB(t *testing.T, aName string, aStmts ...string) {
+	conn, err := sql.Open(`sqlite3`, `file:`+aName)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, stmt := range append([]string{
+		`CREATE TABLE IF NOT EXISTS books (id INTEGER PRIMARY KEY, title TEXT, timestamp TIMESTAMP, last_modified TIMESTAMP)`,
+		`CREATE TABLE IF NOT EXISTS authors (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE IF NOT EXISTS books_authors_link (id INTEGER PRIMARY KEY, book INTEGER, author INTEGER)`,
+		`CREATE TABLE IF NOT EXISTS data (id INTEGER PRIMARY KEY, book INTEGER, format TEXT)`,
+	}, aStmts...) {
+		if _, err = conn.Exec(stmt); nil != err {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+} // prepCalibreDB()
+
+func TestRecentChanges(t *testing.T) {
+	lib := prepLibraryForTesting(t)
+	srcName := filepath.Join(lib.path, dbCalibreDatabaseFilename)
+
+	prepCalibreDB(t, srcName,
+		`INSERT INTO books VALUES (1, 'one', '2024-01-01 10:00:00+00:00', '2024-01-01 10:00:00+00:00')`,
+		`INSERT INTO books VALUES (2, 'two', '2024-01-01 10:00:00+00:00', '2024-01-01 10:00:00+00:00')`)
+	if _, err := lib.syncDatabaseFile(); nil != err {
+		t.Fatalf("syncDatabaseFile() error = %v", err)
+	}
+
+	hooked := make(chan TChangeList, 1)
+	lib.OnChange(func(aList TChangeList) { hooked <- aList })
+
+	prepCalibreDB(t, srcName,
+		`INSERT INTO authors VALUES (1, 'Jane Doe')`,
+		`INSERT INTO books VALUES (3, 'three', '2024-02-01 10:00:00+00:00', '2024-02-01 10:00:00+00:00')`,
+		`INSERT INTO books_authors_link VALUES (1, 3, 1)`,
+		`INSERT INTO data VALUES (1, 3, 'EPUB')`,
+		`INSERT INTO data VALUES (2, 3, 'PDF')`,
+		`UPDATE books SET last_modified = '2024-02-01 11:00:00+00:00' WHERE id = 2`,
+		`DELETE FROM books WHERE id = 1`)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(srcName, future, future)
+	if copied, err := lib.syncDatabaseFile(); (!copied) || (nil != err) {
+		t.Fatalf("syncDatabaseFile() = %v, %v", copied, err)
+	}
+
+	var list TChangeList
+	select {
+	case list = <-hooked:
+	case <-time.After(time.Second):
+		t.Fatal("OnChange() hook wasn't called")
+	}
+	if 3 != len(list) {
+		t.Fatalf("OnChange() list = %v", list)
+	}
+	if added := list[0]; (ChangeAdded != added.Kind) || (3 != added.ID) ||
+		(`Jane Doe` != added.Authors) || !reflect.DeepEqual(added.FormatList(), []string{`EPUB`, `PDF`}) {
+		t.Errorf("OnChange() added = %v", added)
+	}
+
+	// The history must survive a restart:
+	lib.changes.history, lib.changes.loaded = nil, false
+	tests := []struct {
+		name   string
+		aSince time.Time
+		aKinds []string
+		want   int
+	}{
+		// TODO: Add test cases.
+		{" 1", time.Now().Add(-time.Hour), nil, 3},
+		{" 2", time.Now().Add(-time.Hour), []string{ChangeAdded}, 1},
+		{" 3", time.Now().Add(-time.Hour), []string{ChangeAdded, ChangeRemoved}, 2},
+		{" 4", time.Now().Add(time.Hour), nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lib.RecentChanges(tt.aSince, tt.aKinds...); len(got) != tt.want {
+				t.Errorf("RecentChanges() = %v, want %d entries", got, tt.want)
+			}
+		})
+	}
+} // TestRecentChanges()
@@ -0,0 +1,537 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides read access to the pages of comic book archives,
+ * i.e. the images stored in CBZ (ZIP), CBR (RAR), or CB7 (7-Zip)
+ * files.
+ *
+ * The opened archives are kept for the next requests (until the
+ * archive file changes) so that the pages of a comic read one after
+ * the other don't list – or, for RAR, decompress – the archive again.
+ */
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/nwaples/rardecode"
+)
+
+type (
+	// `tComicArchive` is the archive of a comic book.
+	tComicArchive interface {
+		// `Close()` closes the archive.
+		Close() error
+
+		// `names()` returns the names of the archive's files.
+		names() []string
+
+		// `read()` returns the contents of the archive's file `aName`.
+		read(aName string) ([]byte, error)
+	}
+
+	// `tComic7z` is a 7-Zip archive.
+	tComic7z struct {
+		files map[string]*sevenzip.File
+		zr    *sevenzip.ReadCloser
+	}
+
+	// `tComicRar` is a RAR archive.
+	//
+	// RAR archives can only be read sequentially, so all the pages
+	// are extracted to a temporary directory by the first read.
+	tComicRar struct {
+		filename string
+		list     []string
+		dir      string            // the directory of the extracted pages
+		files    map[string]string // the extracted pages by name
+	}
+
+	// `tComicZip` is a ZIP archive.
+	tComicZip struct {
+		files map[string]*zip.File
+		zr    *zip.ReadCloser
+	}
+
+	// TComic is an opened comic book archive.
+	TComic struct {
+		Pages    []string // the archive's images in reading order
+		archive  tComicArchive
+		filename string     // the archive's filename
+		modTime  time.Time  // the archive's modification time
+		size     int64      // the archive's size
+		lastUse  time.Time  // the last time the comic was opened
+		users    int        // number of callers not having closed it
+		evicted  bool       // whether the comic left the cache
+		mtx      sync.Mutex // serialises the archive's reads
+	}
+)
+
+const (
+	// Max. number of comics kept open.
+	coCacheSize = 8
+)
+
+var (
+	// The comics kept open by their filenames.
+	coCache = make(map[string]*TComic, coCacheSize)
+
+	// Guard for the comics' cache and users.
+	coMtx sync.Mutex
+)
+
+// `isDigit()` returns whether `aByte` is an ASCII digit.
+//
+//	`aByte` The byte to check.
+func isDigit(aByte byte) bool {
+	return ('0' <= aByte) && ('9' >= aByte)
+} // isDigit()
+
+// `naturalLess()` returns whether `aA` sorts before `aB` in natural
+// order, i.e. ignoring case and comparing numbers by their value
+// (so that `page2` comes before `page10`).
+//
+//	`aA` The first name to compare.
+//	`aB` The second name to compare.
+func naturalLess(aA, aB string) bool {
+	a, b := strings.ToLower(aA), strings.ToLower(aB)
+	for (0 < len(a)) && (0 < len(b)) {
+		if !isDigit(a[0]) || !isDigit(b[0]) {
+			if a[0] != b[0] {
+				return a[0] < b[0]
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		// compare the numbers' values, i.e. their significant digits:
+		i, j := 0, 0
+		for (i < len(a)) && isDigit(a[i]) {
+			i++
+		}
+		for (j < len(b)) && isDigit(b[j]) {
+			j++
+		}
+		numA, numB := strings.TrimLeft(a[:i], `0`), strings.TrimLeft(b[:j], `0`)
+		if len(numA) != len(numB) {
+			return len(numA) < len(numB)
+		}
+		if numA != numB {
+			return numA < numB
+		}
+		if i != j {
+			return i < j // fewer leading zeros first
+		}
+		a, b = a[i:], b[j:]
+	}
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+
+	return aA < aB
+} // naturalLess()
+
+// `readLimited()` returns the contents of `aReader` if it's not
+// larger than the max. image size.
+//
+//	`aName` The name of the file read.
+//	`aReader` The reader to use.
+func readLimited(aName string, aReader io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(aReader, bcMaxImageSize+1))
+	if nil != err {
+		return nil, err
+	}
+	if bcMaxImageSize < len(data) {
+		return nil, fmt.Errorf("readLimited(): %s too large", aName)
+	}
+
+	return data, nil
+} // readLimited()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `open7z()` opens the 7-Zip archive `aFilename`.
+//
+//	`aFilename` The name of the archive file.
+func open7z(aFilename string) (tComicArchive, error) {
+	zr, err := sevenzip.OpenReader(aFilename)
+	if nil != err {
+		return nil, err
+	}
+	result := &tComic7z{
+		files: make(map[string]*sevenzip.File, len(zr.File)),
+		zr:    zr,
+	}
+	for _, file := range zr.File {
+		result.files[file.Name] = file
+	}
+
+	return result, nil
+} // open7z()
+
+// Close closes the archive.
+func (ca *tComic7z) Close() error {
+	return ca.zr.Close()
+} // Close()
+
+// `names()` returns the names of the archive's files.
+func (ca *tComic7z) names() []string {
+	result := make([]string, 0, len(ca.files))
+	for name := range ca.files {
+		result = append(result, name)
+	}
+
+	return result
+} // names()
+
+// `read()` returns the contents of the archive's file `aName`.
+//
+//	`aName` The name of the file to read.
+func (ca *tComic7z) read(aName string) ([]byte, error) {
+	file, ok := ca.files[aName]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	rc, err := file.Open()
+	if nil != err {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return readLimited(aName, rc)
+} // read()
+
+// `openRar()` opens the RAR archive `aFilename`.
+//
+//	`aFilename` The name of the archive file.
+func openRar(aFilename string) (tComicArchive, error) {
+	rr, err := rardecode.OpenReader(aFilename, ``)
+	if nil != err {
+		return nil, err
+	}
+	defer rr.Close()
+
+	result := &tComicRar{filename: aFilename}
+	for {
+		header, err := rr.Next()
+		if io.EOF == err {
+			break
+		}
+		if nil != err {
+			return nil, err
+		}
+		if !header.IsDir {
+			result.list = append(result.list, header.Name)
+		}
+	}
+
+	return result, nil
+} // openRar()
+
+// Close closes the archive.
+func (ca *tComicRar) Close() error {
+	if 0 == len(ca.dir) {
+		return nil // the archive is opened by `extract()`
+	}
+	err := os.RemoveAll(ca.dir)
+	ca.dir, ca.files = ``, nil
+
+	return err
+} // Close()
+
+// `extract()` stores the archive's images in a temporary directory.
+func (ca *tComicRar) extract() error {
+	rr, err := rardecode.OpenReader(ca.filename, ``)
+	if nil != err {
+		return err
+	}
+	defer rr.Close()
+
+	dir, err := os.MkdirTemp(``, `kaliber-cbr-*`)
+	if nil != err {
+		return err
+	}
+	files := make(map[string]string, len(ca.list))
+	for {
+		header, err := rr.Next()
+		if io.EOF == err {
+			break
+		}
+		if nil != err {
+			_ = os.RemoveAll(dir)
+			return err
+		}
+		if header.IsDir || !isComicImage(header.Name) {
+			continue
+		}
+		data, err := readLimited(header.Name, rr)
+		if nil != err {
+			continue // the page will be missing
+		}
+		fName := filepath.Join(dir, fmt.Sprintf("%06d", len(files)))
+		if err = os.WriteFile(fName, data, 0600); nil != err {
+			_ = os.RemoveAll(dir)
+			return err
+		}
+		files[header.Name] = fName
+	}
+	ca.dir, ca.files = dir, files
+
+	return nil
+} // extract()
+
+// `names()` returns the names of the archive's files.
+func (ca *tComicRar) names() []string {
+	return ca.list
+} // names()
+
+// `read()` returns the contents of the archive's file `aName`.
+//
+//	`aName` The name of the file to read.
+func (ca *tComicRar) read(aName string) ([]byte, error) {
+	if 0 == len(ca.dir) {
+		if err := ca.extract(); nil != err {
+			return nil, err
+		}
+	}
+	fName, ok := ca.files[aName]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return os.ReadFile(fName) // #nosec G304
+} // read()
+
+// `openZip()` opens the ZIP archive `aFilename`.
+//
+//	`aFilename` The name of the archive file.
+func openZip(aFilename string) (tComicArchive, error) {
+	zr, err := zip.OpenReader(aFilename)
+	if nil != err {
+		return nil, err
+	}
+	result := &tComicZip{
+		files: make(map[string]*zip.File, len(zr.File)),
+		zr:    zr,
+	}
+	for _, file := range zr.File {
+		result.files[file.Name] = file
+	}
+
+	return result, nil
+} // openZip()
+
+// Close closes the archive.
+func (ca *tComicZip) Close() error {
+	return ca.zr.Close()
+} // Close()
+
+// `names()` returns the names of the archive's files.
+func (ca *tComicZip) names() []string {
+	result := make([]string, 0, len(ca.files))
+	for name := range ca.files {
+		result = append(result, name)
+	}
+
+	return result
+} // names()
+
+// `read()` returns the contents of the archive's file `aName`.
+//
+//	`aName` The name of the file to read.
+func (ca *tComicZip) read(aName string) ([]byte, error) {
+	return zipFileData(ca.files[aName])
+} // read()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// CloseComics closes all the comic book archives kept open, removing
+// the pages extracted from RAR archives.
+//
+// It's meant to be called when the program terminates.
+func CloseComics() {
+	coMtx.Lock()
+	defer coMtx.Unlock()
+
+	for _, co := range coCache {
+		co.users = 0
+		uncache(co)
+	}
+} // CloseComics()
+
+// `openComic()` opens the comic book archive `aFilename` and lists
+// its pages.
+//
+//	`aFilename` The name of the CBZ, CBR, or CB7 file.
+func openComic(aFilename string) (*TComic, error) {
+	file, err := os.Open(aFilename) // #nosec G304
+	if nil != err {
+		return nil, err
+	}
+	magic := make([]byte, 6)
+	_, err = io.ReadFull(file, magic)
+	_ = file.Close()
+	if nil != err {
+		return nil, err
+	}
+
+	var archive tComicArchive
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK")):
+		archive, err = openZip(aFilename)
+	case bytes.HasPrefix(magic, []byte("Rar!")):
+		archive, err = openRar(aFilename)
+	case bytes.HasPrefix(magic, []byte("7z\xBC\xAF\x27\x1C")):
+		archive, err = open7z(aFilename)
+	default:
+		return nil, errors.New(`OpenComic(): unknown archive type of ` + aFilename)
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	result := &TComic{archive: archive, filename: aFilename}
+	for _, name := range archive.names() {
+		if isComicImage(name) {
+			result.Pages = append(result.Pages, name)
+		}
+	}
+	if 0 == len(result.Pages) {
+		_ = archive.Close()
+		return nil, errors.New(`OpenComic(): no image found in ` + aFilename)
+	}
+	sort.Slice(result.Pages, func(i, j int) bool {
+		return naturalLess(result.Pages[i], result.Pages[j])
+	})
+
+	return result, nil
+} // openComic()
+
+// `uncache()` removes `aComic` from the cache closing its archive
+// if there are no more users.
+//
+// The caller must hold `coMtx`.
+//
+//	`aComic` The comic to remove.
+func uncache(aComic *TComic) {
+	if coCache[aComic.filename] == aComic {
+		delete(coCache, aComic.filename)
+	}
+	aComic.evicted = true
+	if 0 >= aComic.users {
+		aComic.mtx.Lock()
+		_ = aComic.archive.Close()
+		aComic.mtx.Unlock()
+	}
+} // uncache()
+
+// OpenComic opens the comic book archive `aFilename` and lists its
+// pages, i.e. the images it contains in natural sort order.
+//
+// The archive's type is determined by its contents rather than the
+// filename's extension since many CBZ files are in fact RAR archives
+// and vice versa.
+//
+// The opened archive is shared by all callers until the archive file
+// changes; each caller is responsible for calling `Close()` (once)
+// when done.
+//
+//	`aFilename` The name of the CBZ, CBR, or CB7 file.
+func OpenComic(aFilename string) (*TComic, error) {
+	fi, err := os.Stat(aFilename)
+	if nil != err {
+		return nil, err
+	}
+
+	coMtx.Lock()
+	if co, ok := coCache[aFilename]; ok {
+		if co.modTime.Equal(fi.ModTime()) && (co.size == fi.Size()) {
+			co.users++
+			co.lastUse = time.Now()
+			coMtx.Unlock()
+			return co, nil
+		}
+		uncache(co) // the archive changed
+	}
+	coMtx.Unlock()
+
+	result, err := openComic(aFilename)
+	if nil != err {
+		return nil, err
+	}
+	result.modTime, result.size = fi.ModTime(), fi.Size()
+	result.users, result.lastUse = 1, time.Now()
+
+	coMtx.Lock()
+	defer coMtx.Unlock()
+	if co, ok := coCache[aFilename]; ok {
+		// opened by a concurrent request in the meantime
+		uncache(co)
+	}
+	coCache[aFilename] = result
+	for coCacheSize < len(coCache) {
+		var oldest *TComic
+		for _, co := range coCache {
+			if (nil == oldest) || co.lastUse.Before(oldest.lastUse) {
+				oldest = co
+			}
+		}
+		uncache(oldest)
+	}
+
+	return result, nil
+} // OpenComic()
+
+// Close releases the comic book archive.
+//
+// The archive is closed when it's no longer cached and all its
+// users called `Close()`.
+func (co *TComic) Close() error {
+	coMtx.Lock()
+	defer coMtx.Unlock()
+
+	if 0 >= co.users {
+		return nil
+	}
+	co.users--
+	if !co.evicted || (0 < co.users) {
+		return nil
+	}
+	co.mtx.Lock()
+	defer co.mtx.Unlock()
+
+	return co.archive.Close()
+} // Close()
+
+// Page returns the image data of the page `aIndex`.
+//
+//	`aIndex` The page's index (starting at `0`).
+func (co *TComic) Page(aIndex int) ([]byte, error) {
+	if (0 > aIndex) || (len(co.Pages) <= aIndex) {
+		return nil, fmt.Errorf("TComic.Page(): no page %d", aIndex)
+	}
+	co.mtx.Lock()
+	defer co.mtx.Unlock()
+
+	return co.archive.read(co.Pages[aIndex])
+} // Page()
+
+/* _EoF_ */
@@ -0,0 +1,231 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	// A 7-Zip archive (stored) with the files `p10.png` ("page ten"),
+	// `p2.png` ("page two"), and `info.txt`.
+	co7zArchive = `N3q8ryccAAOAcqXDEQAAAAAAAADIAAAAAAAAADVklcBwYWdlIHRlbnBhZ2UgdHdveAEEBgADCQgI
+AQAHCwMAAQEAAQEAAQEADAgIAQAICgF4ctrhPTIp7oMW3IwAAAUDETEAcAAxADAALgBwAG4AZwAA
+AHAAMgAuAHAAbgBnAAAAaQBuAGYAbwAuAHQAeAB0AAAAFBoBAFy7xxqnX90BXLvHGqdf3QFcu8ca
+p1/dARIaAQBcu8cap1/dAVy7xxqnX90BXLvHGqdf3QETGgEAhh7HGqdf3QFcu8cap1/dAVy7xxqn
+X90BFQ4BACCApIEggKSBIICkgQAA`
+)
+
+// `prepRarFile()` writes a RAR (v1.5) archive storing `aFiles`
+// (name/data pairs) to `aFilename`.
+func prepRarFile(t *testing.T, aFilename string, aFiles ...string) {
+	var buf bytes.Buffer
+	block := func(aHeader, aData []byte) {
+		// the header's CRC are the low 16 bits of its CRC32
+		_ = binary.Write(&buf, binary.LittleEndian, uint16(crc32.ChecksumIEEE(aHeader)))
+		buf.Write(aHeader)
+		buf.Write(aData)
+	}
+	buf.WriteString("Rar!\x1A\x07\x00")
+	block([]byte{0x73, 0, 0, 13, 0, 0, 0, 0, 0, 0, 0}, nil) // main header
+
+	for idx := 0; idx < len(aFiles)-1; idx += 2 {
+		name, data := aFiles[idx], []byte(aFiles[idx+1])
+		var header bytes.Buffer
+		header.WriteByte(0x74) // file header
+		for _, value := range []any{
+			uint16(0x8000),         // flags: data follows the header
+			uint16(32 + len(name)), // header size
+			uint32(len(data)),      // packed size
+			uint32(len(data)),      // unpacked size
+			uint8(3),               // host OS: Unix
+			crc32.ChecksumIEEE(data),
+			uint32(0x21 << 16), // DOS time
+			uint8(20),          // RAR version needed
+			uint8(0x30),        // method: store
+			uint16(len(name)),
+			uint32(0x81A4), // attributes
+		} {
+			_ = binary.Write(&header, binary.LittleEndian, value)
+		}
+		header.WriteString(name)
+		block(header.Bytes(), data)
+	}
+	if err := os.WriteFile(aFilename, buf.Bytes(), 0600); nil != err {
+		t.Fatal(err)
+	}
+} // prepRarFile()
+
+func Test_naturalLess(t *testing.T) {
+	tests := []struct {
+		name string
+		aA   string
+		aB   string
+		want bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `page2.jpg`, `page10.jpg`, true},
+		{" 2", `page10.jpg`, `page2.jpg`, false},
+		{" 3", `Page2.jpg`, `page3.jpg`, true},
+		{" 4", `ch1/p9.jpg`, `ch2/p1.jpg`, true},
+		{" 5", `p007.jpg`, `p7.jpg`, false},
+		{" 6", `p7.jpg`, `p007.jpg`, true},
+		{" 7", `p1.jpg`, `p1a.jpg`, true},
+		{" 8", `a.jpg`, `a.jpg`, false},
+		{" 9", `001.png`, `a.png`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := naturalLess(tt.aA, tt.aB); got != tt.want {
+				t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.aA, tt.aB, got, tt.want)
+			}
+		})
+	}
+} // Test_naturalLess()
+
+func TestOpenComic(t *testing.T) {
+	defer CloseComics()
+	dir := t.TempDir()
+	cbz := filepath.Join(dir, `test.cbz`)
+	prepZipFile(t, cbz,
+		`Comic/page10.jpg`, `ten`,
+		`Comic/page2.jpg`, `two`,
+		`Comic/Page1.PNG`, `one`,
+		`Comic/ComicInfo.xml`, `<ComicInfo/>`,
+		`__MACOSX/Comic/._page1.png`, `resource fork`)
+	cbr := filepath.Join(dir, `test.cbr`)
+	prepRarFile(t, cbr, `p10.png`, `page ten`, `p2.png`, `page two`, `info.txt`, `x`)
+	cb7 := filepath.Join(dir, `test.cb7`)
+	data, err := base64.StdEncoding.DecodeString(co7zArchive)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(cb7, data, 0600); nil != err {
+		t.Fatal(err)
+	}
+	misnamed := filepath.Join(dir, `rar.cbz`) // a RAR named CBZ
+	prepRarFile(t, misnamed, `only.gif`, `gif`)
+	noImages := filepath.Join(dir, `text.cbz`)
+	prepZipFile(t, noImages, `readme.txt`, `no images`)
+	unknown := filepath.Join(dir, `unknown.cbz`)
+	if err = os.WriteFile(unknown, []byte(`plain text`), 0600); nil != err {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		aFilename string
+		wantPages []string
+		wantFirst string
+		wantErr   bool
+	}{
+		// TODO: Add test cases.
+		{" 1", cbz, []string{`Comic/Page1.PNG`, `Comic/page2.jpg`, `Comic/page10.jpg`}, `one`, false},
+		{" 2", cbr, []string{`p2.png`, `p10.png`}, `page two`, false},
+		{" 3", cb7, []string{`p2.png`, `p10.png`}, `page two`, false},
+		{" 4", misnamed, []string{`only.gif`}, `gif`, false},
+		{" 5", noImages, nil, ``, true},
+		{" 6", unknown, nil, ``, true},
+		{" 7", filepath.Join(dir, `missing.cbz`), nil, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OpenComic(tt.aFilename)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("OpenComic() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if nil == got {
+				return
+			}
+			defer got.Close()
+
+			if !reflect.DeepEqual(got.Pages, tt.wantPages) {
+				t.Errorf("OpenComic() pages = %q, want %q", got.Pages, tt.wantPages)
+			}
+			first, err := got.Page(0)
+			if nil != err {
+				t.Errorf("TComic.Page(0) error = %v", err)
+			} else if string(first) != tt.wantFirst {
+				t.Errorf("TComic.Page(0) = %q, want %q", first, tt.wantFirst)
+			}
+			last, err := got.Page(len(got.Pages) - 1)
+			if (nil != err) || (0 == len(last)) {
+				t.Errorf("TComic.Page(%d) = %q, %v", len(got.Pages)-1, last, err)
+			}
+			if _, err = got.Page(len(got.Pages)); nil == err {
+				t.Error("TComic.Page() returned a page beyond the last one")
+			}
+		})
+	}
+} // TestOpenComic()
+
+func TestOpenComic_cache(t *testing.T) {
+	defer CloseComics()
+	dir := t.TempDir()
+	cbr := filepath.Join(dir, `test.cbr`)
+	prepRarFile(t, cbr, `p1.png`, `page one`, `p2.png`, `page two`)
+
+	first, err := OpenComic(cbr)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = first.Page(1); nil != err {
+		t.Fatal(err)
+	}
+	rar := first.archive.(*tComicRar)
+	extracted := rar.dir
+	if 0 == len(extracted) {
+		t.Fatal("TComic.Page() didn't extract the RAR archive")
+	}
+
+	second, err := OpenComic(cbr)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("OpenComic() didn't reuse the opened archive")
+	}
+	if page, err := second.Page(0); (nil != err) || (`page one` != string(page)) || (extracted != rar.dir) {
+		t.Errorf("TComic.Page(0) = %q, %v (extracted again: %v)", page, err, extracted != rar.dir)
+	}
+	_ = second.Close()
+
+	// A changed archive is opened again:
+	prepRarFile(t, cbr, `p1.png`, `new page one`)
+	third, err := OpenComic(cbr)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if third == first {
+		t.Fatal("OpenComic() reused the archive after it changed")
+	}
+	if page, err := third.Page(0); (nil != err) || (`new page one` != string(page)) {
+		t.Errorf("TComic.Page(0) = %q, %v, want %q", page, err, `new page one`)
+	}
+
+	// The outdated archive is closed by its last user:
+	if _, err = os.Stat(extracted); nil != err {
+		t.Errorf("extracted pages removed while still in use: %v", err)
+	}
+	_ = first.Close()
+	if _, err = os.Stat(extracted); !os.IsNotExist(err) {
+		t.Errorf("extracted pages %q not removed", extracted)
+	}
+} // TestOpenComic_cache()
+
+/* _EoF_ */
@@ -79,6 +79,7 @@ type (
 		languages    *tLanguageList
 		lccn         string
 		lastModified time.Time // SQL: timestamp
+		lib          *TLibrary // the library holding the document
 		Pages        int
 		path         string
 		pubdate      time.Time // SQL: timestamp
@@ -122,7 +123,7 @@ func (doc *TDocument) Authors() *TEntityList {
 		ent := TEntity{
 			ID:   author.ID,
 			Name: author.Name,
-			URL:  fmt.Sprintf("/authors/%d/%s", author.ID, url.PathEscape(author.Name)),
+			URL:  fmt.Sprintf("%s/authors/%d/%s", doc.Library().URL(), author.ID, url.PathEscape(author.Name)),
 		}
 		result = append(result, ent)
 	}
@@ -137,19 +138,19 @@ func (doc *TDocument) Comment() template.HTML {
 
 // Cover returns the URL path/filename for the document's cover image.
 func (doc *TDocument) Cover() string {
-	return fmt.Sprintf("/cover/%d/cover.gif", doc.ID)
+	return fmt.Sprintf("%s/cover/%d/cover.gif", doc.Library().URL(), doc.ID)
 } // Cover()
 
 // CoverAbs returns the path/filename of the document's cover image.
 //
 // If `aRelative` is `true` the function result is the path/filename
-// relative to `CalibreLibraryPath()`, otherwise it's the document
+// relative to the library's path, otherwise it's the document
 // cover's complete path/filename.
 //
 //	`aRelative` Flag indicating a complete or relative path/filename
 // of the document's cover.
 func (doc *TDocument) CoverAbs(aRelative bool) (string, error) {
-	dir := filepath.Join(CalibreLibraryPath(), doc.path)
+	dir := filepath.Join(doc.Library().Path(), doc.path)
 	if 0 <= strings.Index(dir, `[`) {
 		// make sure to escape the meta-character
 		dir = strings.Replace(dir, `[`, `\[`, -1)
@@ -164,7 +165,7 @@ func (doc *TDocument) CoverAbs(aRelative bool) (string, error) {
 	if !aRelative {
 		return filenames[0], nil
 	}
-	if dir, err = filepath.Rel(CalibreLibraryPath(), filenames[0]); nil != err {
+	if dir, err = filepath.Rel(doc.Library().Path(), filenames[0]); nil != err {
 		return ``, err
 	}
 
@@ -172,20 +173,39 @@ func (doc *TDocument) CoverAbs(aRelative bool) (string, error) {
 } // CoverAbs()
 
 // CoverFile returns the complete path/filename of the document's cover file.
+//
+// If the `Calibre` library holds no cover image of the document the
+// cover extracted from one of the document's book files is used.
 func (doc *TDocument) CoverFile() (string, error) {
-	return doc.CoverAbs(false)
+	result, err := doc.CoverAbs(false)
+	if nil == err {
+		return result, nil
+	}
+	if name, xErr := doc.coverExtracted(); nil == xErr {
+		return name, nil
+	}
+
+	return ``, err
 } // CoverFile()
 
+// CoverWidth returns the URL of the document's cover scaled to
+// `aWidth` pixels.
+//
+//	`aWidth` The width of the cover image to use.
+func (doc *TDocument) CoverWidth(aWidth uint) string {
+	return fmt.Sprintf("%s/cover/%d/w/%d", doc.Library().URL(), doc.ID, aWidth)
+} // CoverWidth()
+
 // DocLink returns a link to this document's page.
 func (doc *TDocument) DocLink() string {
-	return fmt.Sprintf("/doc/%d/doc.html", doc.ID)
+	return fmt.Sprintf("%s/doc/%d/doc.html", doc.Library().URL(), doc.ID)
 } // DocLink()
 
 // Filename returns the path-/filename of the document's `aFormat`.
 func (doc *TDocument) Filename(aFormat string) string {
 	list := *doc.filenames()
 	if pName, ok := list[strings.ToUpper(aFormat)]; ok {
-		if fName, err := filepath.Rel(CalibreLibraryPath(), pName); nil == err {
+		if fName, err := filepath.Rel(doc.Library().Path(), pName); nil == err {
 			return fName
 		}
 	}
@@ -196,7 +216,7 @@ func (doc *TDocument) Filename(aFormat string) string {
 // `filenames()` returns a list of path-/filenames for this document.
 func (doc *TDocument) filenames() *tPathMap {
 	result := make(tPathMap, len(*doc.formats))
-	dir := filepath.Join(CalibreLibraryPath(), doc.path)
+	dir := filepath.Join(doc.Library().Path(), doc.path)
 	for _, format := range *doc.formats {
 		if "ORIGINAL_EPUB" == format.Name {
 			continue // we ignore this internal file type
@@ -233,7 +253,7 @@ func (doc *TDocument) Files() *TEntityList {
 		ent := TEntity{
 			ID:   format.ID,
 			Name: format.Name,
-			URL:  fmt.Sprintf("/file/%d/%s/%s", doc.ID, format.Name, fName),
+			URL:  fmt.Sprintf("%s/file/%d/%s/%s", doc.Library().URL(), doc.ID, format.Name, fName),
 		}
 		result = append(result, ent)
 	}
@@ -258,7 +278,7 @@ func (doc *TDocument) Formats() *TEntityList {
 		ent := TEntity{
 			ID:   format.ID,
 			Name: format.Name,
-			URL:  fmt.Sprintf("/format/%d/%s", format.ID, format.Name),
+			URL:  fmt.Sprintf("%s/format/%d/%s", doc.Library().URL(), format.ID, format.Name),
 		}
 		result = append(result, ent)
 	}
@@ -324,7 +344,7 @@ func (doc *TDocument) Languages() *TEntityList {
 		ent := TEntity{
 			ID:   language.ID,
 			Name: language.Name,
-			URL:  fmt.Sprintf("/languages/%d/%s", language.ID, language.Name),
+			URL:  fmt.Sprintf("%s/languages/%d/%s", doc.Library().URL(), language.ID, language.Name),
 		}
 		result = append(result, ent)
 	}
@@ -337,6 +357,15 @@ func (doc *TDocument) LastModified() string {
 	return doc.lastModified.Format(time.RFC1123)
 } // LastModified()
 
+// Library returns the library holding the document.
+func (doc *TDocument) Library() *TLibrary {
+	if nil == doc.lib {
+		return DefaultLibrary()
+	}
+
+	return doc.lib
+} // Library()
+
 // PubDate returns the document's formatted publication date.
 func (doc *TDocument) PubDate() string {
 	y, m, _ := doc.pubdate.Date()
@@ -356,7 +385,7 @@ func (doc *TDocument) Publisher() *TEntity {
 	result := TEntity{
 		ID:   doc.publisher.ID,
 		Name: doc.publisher.Name,
-		URL:  fmt.Sprintf("/publisher/%d/%s", doc.publisher.ID, url.PathEscape(doc.publisher.Name)),
+		URL:  fmt.Sprintf("%s/publisher/%d/%s", doc.Library().URL(), doc.publisher.ID, url.PathEscape(doc.publisher.Name)),
 	}
 
 	return &result
@@ -371,7 +400,7 @@ func (doc *TDocument) Series() *TEntity {
 	result := TEntity{
 		ID:   doc.series.ID,
 		Name: doc.series.Name,
-		URL:  fmt.Sprintf("/series/%d/%s", doc.series.ID, url.PathEscape(doc.series.Name)),
+		URL:  fmt.Sprintf("%s/series/%d/%s", doc.Library().URL(), doc.series.ID, url.PathEscape(doc.series.Name)),
 	}
 
 	return &result
@@ -406,7 +435,7 @@ func (doc *TDocument) Tags() *TEntityList {
 		ent := TEntity{
 			ID:   tag.ID,
 			Name: tag.Name,
-			URL:  fmt.Sprintf("/tags/%d/%s", tag.ID, url.PathEscape(tag.Name)),
+			URL:  fmt.Sprintf("%s/tags/%d/%s", doc.Library().URL(), tag.ID, url.PathEscape(tag.Name)),
 		}
 		result = append(result, ent)
 	}
@@ -419,7 +448,7 @@ func (doc *TDocument) Tags() *TEntityList {
 
 // Thumb returns the path-filename of the document's thumbnail image.
 func (doc *TDocument) Thumb() string {
-	return fmt.Sprintf("/thumb/%d/cover.jpg", doc.ID)
+	return fmt.Sprintf("%s/thumb/%d/cover.jpg", doc.Library().URL(), doc.ID)
 } // Thumb()
 
 // Timestamp returns the formatted `acquisition` property.
@@ -427,6 +456,12 @@ func (doc *TDocument) Timestamp() string {
 	return doc.acquisition.Format("2006-01-02 15:04:05")
 } // Timestamp()
 
+// UUID returns the document's unique identifier (as assigned by
+// `Calibre`).
+func (doc *TDocument) UUID() string {
+	return doc.uuid
+} // UUID()
+
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
 // NewDocument returns a new `TDocument` instance.
@@ -436,6 +471,14 @@ func NewDocument() *TDocument {
 	return result
 } // NewDocument()
 
+// `newDocument()` returns a new `TDocument` instance of the library.
+func (l *TLibrary) newDocument() *TDocument {
+	result := NewDocument()
+	result.lib = l
+
+	return result
+} // newDocument()
+
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
 type (
@@ -64,8 +64,8 @@ func TestTDocument_coverAbs(t *testing.T) {
 		path: "John Scalzi/Zoe's Tale (6730)",
 	}
 	w2 := d2.path + "/cover.jpg"
-	w3 := filepath.Join(dbCalibreLibraryPath, w1)
-	w4 := filepath.Join(dbCalibreLibraryPath, w2)
+	w3 := filepath.Join(CalibreLibraryPath(), w1)
+	w4 := filepath.Join(CalibreLibraryPath(), w2)
 	d5 := TDocument{
 		ID:   4793,
 		path: "Gail Carriger/Soulless [1] (4793)",
@@ -74,7 +74,7 @@ func TestTDocument_coverAbs(t *testing.T) {
 	type args struct {
 		aRelative bool
 	}
-	w6 := filepath.Join(dbCalibreLibraryPath, w5)
+	w6 := filepath.Join(CalibreLibraryPath(), w5)
 	tests := []struct {
 		name    string
 		fields  TDocument
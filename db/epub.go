@@ -0,0 +1,384 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides read access to the contents of EPUB files,
+ * i.e. their manifest, spine, and table of contents as well as the
+ * files stored in the archive.
+ */
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// The media type of an EPUB's package (OPF) file.
+	epubOPFType = `application/oebps-package+xml`
+
+	// The media type of an EPUB 2 table of contents.
+	epubNCXType = `application/x-dtbncx+xml`
+)
+
+type (
+	// TEpubItem is a manifest item of an EPUB.
+	TEpubItem struct {
+		ID         string // the item's manifest ID
+		MediaType  string // the item's MIME type
+		Name       string // the item's path/filename within the archive
+		Properties string // the item's (EPUB 3) properties
+	}
+
+	// TEpubNavPoint is an entry of an EPUB's table of contents.
+	TEpubNavPoint struct {
+		Fragment string // the ID of the target within the file
+		Level    int    // the entry's nesting level (starting at `0`)
+		Name     string // the archive filename of the target
+		Title    string // the entry's text
+	}
+
+	// TEpub is an opened EPUB file.
+	TEpub struct {
+		OPF   string          // the archive filename of the package file
+		Spine []*TEpubItem    // the book's files in reading order
+		TOC   []TEpubNavPoint // the book's table of contents
+		files map[string]*zip.File
+		items map[string]*TEpubItem // manifest items by archive filename
+		zr    *zip.ReadCloser
+	}
+
+	// `tEpubNCXPoint` is a `navPoint` of an EPUB 2 NCX file.
+	tEpubNCXPoint struct {
+		Label   string `xml:"navLabel>text"`
+		Content struct {
+			Src string `xml:"src,attr"`
+		} `xml:"content"`
+		Points []tEpubNCXPoint `xml:"navPoint"`
+	}
+
+	// `tEpubNCX` is the (relevant part of the) NCX file of an EPUB.
+	tEpubNCX struct {
+		Points []tEpubNCXPoint `xml:"navMap>navPoint"`
+	}
+)
+
+// `epubHref()` returns the archive filename and the fragment ID of
+// the link `aHref` found in the archive's file `aBase`.
+//
+//	`aBase` The archive filename of the file containing the link.
+//	`aHref` The (relative) link to resolve.
+func epubHref(aBase, aHref string) (rName, rFragment string) {
+	href, fragment, _ := strings.Cut(aHref, `#`)
+	if h, err := url.PathUnescape(href); nil == err {
+		href = h
+	}
+	if 0 == len(href) {
+		return aBase, fragment
+	}
+
+	return path.Join(path.Dir(aBase), href), fragment
+} // epubHref()
+
+// `epubNavPoints()` returns the entries of `aList` (and their
+// sub-entries) in document order.
+//
+//	`aBase` The archive filename of the NCX file.
+//	`aList` The NCX file's `navPoint` entries.
+//	`aLevel` The nesting level of `aList`.
+func epubNavPoints(aBase string, aList []tEpubNCXPoint, aLevel int) (rList []TEpubNavPoint) {
+	for _, point := range aList {
+		if src := point.Content.Src; 0 < len(src) {
+			name, fragment := epubHref(aBase, src)
+			rList = append(rList, TEpubNavPoint{
+				Fragment: fragment,
+				Level:    aLevel,
+				Name:     name,
+				Title:    strings.Join(strings.Fields(point.Label), ` `),
+			})
+		}
+		rList = append(rList, epubNavPoints(aBase, point.Points, aLevel+1)...)
+	}
+
+	return
+} // epubNavPoints()
+
+// `epubNavDoc()` returns the entries of the EPUB 3 navigation
+// document `aData`.
+//
+// The entries are the links of the `nav` element of the type `toc`
+// (or of the first `nav` element if none is marked as such).
+//
+//	`aBase` The archive filename of the navigation document.
+//	`aData` The contents of the navigation document.
+func epubNavDoc(aBase string, aData []byte) []TEpubNavPoint {
+	var (
+		candidate, result []TEpubNavPoint
+		current           *TEpubNavPoint
+		navDepth, olDepth int
+		isTOC             bool
+		title             strings.Builder
+	)
+	decoder := xml.NewDecoder(bytes.NewReader(aData))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	for {
+		token, err := decoder.Token()
+		if nil != err {
+			break
+		}
+		switch tok := token.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(tok.Name.Local) {
+			case `nav`:
+				navDepth++
+				if 1 < navDepth {
+					break
+				}
+				isTOC, candidate = false, nil
+				for _, attr := range tok.Attr {
+					if (`type` == attr.Name.Local) &&
+						(0 <= strings.Index(` `+attr.Value+` `, ` toc `)) {
+						isTOC = true
+					}
+				}
+
+			case `ol`:
+				if 0 < navDepth {
+					olDepth++
+				}
+
+			case `a`:
+				if 0 == navDepth {
+					break
+				}
+				for _, attr := range tok.Attr {
+					if `href` == attr.Name.Local {
+						name, fragment := epubHref(aBase, attr.Value)
+						current = &TEpubNavPoint{
+							Fragment: fragment,
+							Level:    max(olDepth-1, 0),
+							Name:     name,
+						}
+						title.Reset()
+					}
+				}
+			}
+
+		case xml.CharData:
+			if nil != current {
+				title.Write(tok)
+			}
+
+		case xml.EndElement:
+			switch strings.ToLower(tok.Name.Local) {
+			case `nav`:
+				if 0 == navDepth {
+					break
+				}
+				navDepth--
+				if 0 < navDepth {
+					break
+				}
+				if isTOC {
+					return candidate
+				}
+				if nil == result {
+					result = candidate
+				}
+
+			case `ol`:
+				if 0 < olDepth {
+					olDepth--
+				}
+
+			case `a`:
+				if nil != current {
+					current.Title = strings.Join(strings.Fields(title.String()), ` `)
+					candidate = append(candidate, *current)
+					current = nil
+				}
+			}
+		}
+	}
+
+	return result
+} // epubNavDoc()
+
+// OpenEpub opens the EPUB file `aFilename` and reads its package
+// and navigation files.
+//
+// The caller is responsible for calling `Close()` when done.
+//
+//	`aFilename` The name of the EPUB file.
+func OpenEpub(aFilename string) (*TEpub, error) {
+	zr, err := zip.OpenReader(aFilename)
+	if nil != err {
+		return nil, err
+	}
+	result := &TEpub{
+		files: make(map[string]*zip.File, len(zr.File)),
+		zr:    zr,
+	}
+	for _, file := range zr.File {
+		result.files[file.Name] = file
+	}
+	if err = result.read(); nil != err {
+		_ = zr.Close()
+		return nil, err
+	}
+
+	return result, nil
+} // OpenEpub()
+
+// Close closes the EPUB file.
+func (ep *TEpub) Close() error {
+	if nil == ep.zr {
+		return nil
+	}
+	err := ep.zr.Close()
+	ep.zr = nil
+
+	return err
+} // Close()
+
+// File returns the contents and media type of the archive's file
+// `aName`.
+//
+// Only the package file and the files listed by the package's
+// manifest are returned.
+//
+//	`aName` The archive filename to read.
+func (ep *TEpub) File(aName string) ([]byte, string, error) {
+	mediaType := ``
+	if item, ok := ep.items[aName]; ok {
+		mediaType = item.MediaType
+	} else if aName == ep.OPF {
+		mediaType = epubOPFType
+	} else {
+		return nil, ``, os.ErrNotExist
+	}
+	if 0 == len(mediaType) {
+		mediaType = mime.TypeByExtension(path.Ext(aName))
+	}
+	if 0 == len(mediaType) {
+		mediaType = `application/octet-stream`
+	}
+	data, err := zipFileData(ep.files[aName])
+	if nil != err {
+		return nil, ``, err
+	}
+
+	return data, mediaType, nil
+} // File()
+
+// Item returns the manifest item of the archive's file `aName`.
+//
+//	`aName` The archive filename to look up.
+func (ep *TEpub) Item(aName string) (*TEpubItem, bool) {
+	item, ok := ep.items[aName]
+
+	return item, ok
+} // Item()
+
+// `read()` reads the EPUB's package file and its table of contents.
+func (ep *TEpub) read() error {
+	var container tEpubContainer
+	if err := zipFileXML(ep.files[`META-INF/container.xml`], &container); nil != err {
+		return err
+	}
+	if 0 == len(container.Rootfiles) {
+		return errors.New(`TEpub.read(): no OPF file found`)
+	}
+	ep.OPF = container.Rootfiles[0].FullPath
+	var pkg tEpubPackage
+	if err := zipFileXML(ep.files[ep.OPF], &pkg); nil != err {
+		return err
+	}
+
+	ids := make(map[string]*TEpubItem, len(pkg.Items))
+	list := make([]*TEpubItem, 0, len(pkg.Items)) // in manifest order
+	ep.items = make(map[string]*TEpubItem, len(pkg.Items))
+	for _, pItem := range pkg.Items {
+		name, _ := epubHref(ep.OPF, pItem.Href)
+		item := &TEpubItem{
+			ID:         pItem.ID,
+			MediaType:  pItem.MediaType,
+			Name:       name,
+			Properties: pItem.Properties,
+		}
+		ids[item.ID] = item
+		ep.items[name] = item
+		list = append(list, item)
+	}
+	for _, ref := range pkg.Spine.ItemRefs {
+		if item, ok := ids[ref.IDRef]; ok {
+			ep.Spine = append(ep.Spine, item)
+		}
+	}
+	if 0 == len(ep.Spine) {
+		return errors.New(`TEpub.read(): empty spine`)
+	}
+
+	// The EPUB 3 navigation document takes precedence:
+	for _, item := range list {
+		if 0 > strings.Index(` `+item.Properties+` `, ` nav `) {
+			continue
+		}
+		if data, err := zipFileData(ep.files[item.Name]); nil == err {
+			ep.TOC = epubNavDoc(item.Name, data)
+		}
+		if 0 < len(ep.TOC) {
+			return nil
+		}
+	}
+
+	ncx, ok := ids[pkg.Spine.Toc]
+	if !ok {
+		for _, item := range list {
+			if epubNCXType == item.MediaType {
+				ncx = item
+				break
+			}
+		}
+	}
+	if nil != ncx {
+		var toc tEpubNCX
+		if err := zipFileXML(ep.files[ncx.Name], &toc); nil == err {
+			ep.TOC = epubNavPoints(ncx.Name, toc.Points, 0)
+		}
+	}
+
+	return nil
+} // read()
+
+// SpineIndex returns the index of the archive's file `aName` in the
+// EPUB's spine, or `-1` if it's not part of the spine.
+//
+//	`aName` The archive filename to look up.
+func (ep *TEpub) SpineIndex(aName string) int {
+	for idx, item := range ep.Spine {
+		if aName == item.Name {
+			return idx
+		}
+	}
+
+	return -1
+} // SpineIndex()
+
+/* _EoF_ */
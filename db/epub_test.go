@@ -0,0 +1,199 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	epOPF2 = `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+<metadata/>
+<manifest>
+<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+<item id="ch1" href="text/chapter%201.xhtml" media-type="application/xhtml+xml"/>
+<item id="ch2" href="text/chapter2.xhtml" media-type="application/xhtml+xml"/>
+<item id="img" href="images/map.png" media-type="image/png"/>
+<item id="css" href="style.css" media-type=""/>
+</manifest>
+<spine toc="ncx"><itemref idref="ch1"/><itemref idref="missing"/><itemref idref="ch2"/></spine>
+</package>`
+
+	epNCX = `<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<navMap>
+<navPoint id="p1"><navLabel><text>First
+	Chapter</text></navLabel><content src="text/chapter%201.xhtml"/>
+<navPoint id="p2"><navLabel><text>Section</text></navLabel><content src="text/chapter%201.xhtml#sec"/></navPoint>
+</navPoint>
+<navPoint id="p3"><navLabel><text>Second Chapter</text></navLabel><content src="text/chapter2.xhtml"/></navPoint>
+</navMap>
+</ncx>`
+
+	epOPF3 = `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+<metadata/>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="ch1" href="one.xhtml" media-type="application/xhtml+xml"/>
+<item id="ch2" href="two.xhtml" media-type="application/xhtml+xml"/>
+</manifest>
+<spine><itemref idref="ch1"/><itemref idref="ch2"/></spine>
+</package>`
+
+	epNav = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<body>
+<nav epub:type="landmarks"><ol><li><a href="two.xhtml">Landmark</a></li></ol></nav>
+<nav epub:type="toc"><h1>Contents</h1>
+<ol>
+<li><a href="one.xhtml">One&nbsp;<em>Two</em></a>
+	<ol><li><a href="one.xhtml#part">Part</a></li></ol></li>
+<li><span>Heading</span></li>
+<li><a href="two.xhtml">Two</a></li>
+</ol>
+</nav>
+</body>
+</html>`
+)
+
+func TestOpenEpub(t *testing.T) {
+	dir := t.TempDir()
+	epub2 := filepath.Join(dir, `v2.epub`)
+	prepZipFile(t, epub2,
+		`META-INF/container.xml`, bcContainer,
+		`OEBPS/content.opf`, epOPF2,
+		`OEBPS/toc.ncx`, epNCX,
+		`OEBPS/text/chapter 1.xhtml`, `<html><body><p>one</p></body></html>`,
+		`OEBPS/text/chapter2.xhtml`, `<html><body><p>two</p></body></html>`,
+		`OEBPS/images/map.png`, string(bcPNG),
+		`OEBPS/style.css`, `p {}`,
+		`OEBPS/secret.txt`, `not in the manifest`)
+	epub3 := filepath.Join(dir, `v3.epub`)
+	prepZipFile(t, epub3,
+		`META-INF/container.xml`, bcContainer,
+		`OEBPS/content.opf`, epOPF3,
+		`OEBPS/nav.xhtml`, epNav,
+		`OEBPS/one.xhtml`, `<html><body><p>one</p></body></html>`,
+		`OEBPS/two.xhtml`, `<html><body><p>two</p></body></html>`)
+	empty := filepath.Join(dir, `empty.epub`)
+	prepZipFile(t, empty,
+		`META-INF/container.xml`, bcContainer,
+		`OEBPS/content.opf`, bcOPF3)
+
+	tests := []struct {
+		name      string
+		aFilename string
+		wantSpine []string
+		wantTOC   []TEpubNavPoint
+		wantErr   bool
+	}{
+		// TODO: Add test cases.
+		{" 1", epub2,
+			[]string{`OEBPS/text/chapter 1.xhtml`, `OEBPS/text/chapter2.xhtml`},
+			[]TEpubNavPoint{
+				{Level: 0, Name: `OEBPS/text/chapter 1.xhtml`, Title: `First Chapter`},
+				{Fragment: `sec`, Level: 1, Name: `OEBPS/text/chapter 1.xhtml`, Title: `Section`},
+				{Level: 0, Name: `OEBPS/text/chapter2.xhtml`, Title: `Second Chapter`},
+			}, false},
+		{" 2", epub3,
+			[]string{`OEBPS/one.xhtml`, `OEBPS/two.xhtml`},
+			[]TEpubNavPoint{
+				{Level: 0, Name: `OEBPS/one.xhtml`, Title: `One Two`},
+				{Fragment: `part`, Level: 1, Name: `OEBPS/one.xhtml`, Title: `Part`},
+				{Level: 0, Name: `OEBPS/two.xhtml`, Title: `Two`},
+			}, false},
+		{" 3", empty, nil, nil, true},
+		{" 4", filepath.Join(dir, `missing.epub`), nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OpenEpub(tt.aFilename)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("OpenEpub() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if nil == got {
+				return
+			}
+			defer got.Close()
+
+			var spine []string
+			for _, item := range got.Spine {
+				spine = append(spine, item.Name)
+			}
+			if !reflect.DeepEqual(spine, tt.wantSpine) {
+				t.Errorf("OpenEpub() spine = %q, want %q", spine, tt.wantSpine)
+			}
+			if !reflect.DeepEqual(got.TOC, tt.wantTOC) {
+				t.Errorf("OpenEpub() TOC = %q, want %q", got.TOC, tt.wantTOC)
+			}
+		})
+	}
+} // TestOpenEpub()
+
+func TestTEpub_File(t *testing.T) {
+	epub := filepath.Join(t.TempDir(), `test.epub`)
+	prepZipFile(t, epub,
+		`META-INF/container.xml`, bcContainer,
+		`OEBPS/content.opf`, epOPF2,
+		`OEBPS/toc.ncx`, epNCX,
+		`OEBPS/text/chapter 1.xhtml`, `<html><body><p>one</p></body></html>`,
+		`OEBPS/text/chapter2.xhtml`, `<html><body><p>two</p></body></html>`,
+		`OEBPS/images/map.png`, string(bcPNG),
+		`OEBPS/style.css`, `p {}`,
+		`OEBPS/secret.txt`, `not in the manifest`)
+	ep, err := OpenEpub(epub)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ep.Close()
+
+	tests := []struct {
+		name     string
+		aName    string
+		wantData string
+		wantType string
+		wantErr  bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `OEBPS/images/map.png`, string(bcPNG), `image/png`, false},
+		{" 2", `OEBPS/toc.ncx`, epNCX, `application/x-dtbncx+xml`, false},
+		{" 3", `OEBPS/content.opf`, epOPF2, `application/oebps-package+xml`, false},
+		{" 4", `OEBPS/style.css`, `p {}`, `text/css; charset=utf-8`, false},
+		{" 5", `OEBPS/secret.txt`, ``, ``, true},
+		{" 6", `META-INF/container.xml`, ``, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, mediaType, err := ep.File(tt.aName)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("TEpub.File() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("TEpub.File() data = %q, want %q", data, tt.wantData)
+			}
+			if mediaType != tt.wantType {
+				t.Errorf("TEpub.File() type = %q, want %q", mediaType, tt.wantType)
+			}
+		})
+	}
+	if idx := ep.SpineIndex(`OEBPS/text/chapter2.xhtml`); 1 != idx {
+		t.Errorf("TEpub.SpineIndex() = %d, want 1", idx)
+	}
+	if idx := ep.SpineIndex(`OEBPS/images/map.png`); -1 != idx {
+		t.Errorf("TEpub.SpineIndex() = %d, want -1", idx)
+	}
+} // TestTEpub_File()
+
+/* _EoF_ */
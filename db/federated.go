@@ -0,0 +1,283 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the search across several libraries.
+ *
+ * The search expression is run against each library's own database
+ * (using that library's custom columns) concurrently; the results are
+ * merged, the same book found in several libraries is reported once,
+ * and the remaining documents are sorted as requested.
+ */
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Max. number of documents read from each library by
+	// `FederatedSearch()`, i.e. the deepest position a page
+	// of the merged results may reach.
+	fedMaxHits = 500
+)
+
+type (
+	// TFederatedHit is a document found by `FederatedSearch()`.
+	TFederatedHit struct {
+		*TDocument              // the document found first
+		Copies     []*TDocument // the same book found in other libraries
+	}
+
+	// TFederatedList is a list of documents found by `FederatedSearch()`.
+	TFederatedList []TFederatedHit
+)
+
+// `fedCompare()` compares `aDoc1` and `aDoc2` by `aSortBy` returning
+// a negative number, zero, or a positive number.
+//
+//	`aDoc1` The first document to compare.
+//	`aDoc2` The second document to compare.
+//	`aSortBy` The sort order to use (`qoSortByXXX`).
+func fedCompare(aDoc1, aDoc2 *TDocument, aSortBy TSortType) int {
+	first := func(aList *TEntityList) string {
+		if (nil == aList) || (0 == len(*aList)) {
+			return ``
+		}
+		return (*aList)[0].Name
+	}
+	name := func(aEntity *TEntity) string {
+		if nil == aEntity {
+			return ``
+		}
+		return aEntity.Name
+	}
+
+	switch aSortBy { // constants defined in `queryoptions.go`
+	case qoSortByAcquisition:
+		return aDoc1.acquisition.Compare(aDoc2.acquisition)
+	case qoSortByAuthor:
+		return strings.Compare(aDoc1.authorSort, aDoc2.authorSort)
+	case qoSortByLanguage:
+		return strings.Compare(first(aDoc1.languages), first(aDoc2.languages))
+	case qoSortByPublisher:
+		return strings.Compare(name(aDoc1.publisher), name(aDoc2.publisher))
+	case qoSortByRating:
+		return aDoc1.Rating - aDoc2.Rating
+	case qoSortBySeries:
+		if result := strings.Compare(name(aDoc1.series), name(aDoc2.series)); 0 != result {
+			return result
+		}
+		if aDoc1.seriesindex < aDoc2.seriesindex {
+			return -1
+		}
+		if aDoc1.seriesindex > aDoc2.seriesindex {
+			return 1
+		}
+	case qoSortBySize:
+		if aDoc1.Size < aDoc2.Size {
+			return -1
+		}
+		if aDoc1.Size > aDoc2.Size {
+			return 1
+		}
+	case qoSortByTags:
+		return strings.Compare(first(aDoc1.tags), first(aDoc2.tags))
+	case qoSortByTime:
+		return aDoc1.pubdate.Compare(aDoc2.pubdate)
+	case qoSortByTitle:
+		return strings.Compare(aDoc1.titleSort, aDoc2.titleSort)
+	}
+
+	return 0
+} // fedCompare()
+
+// `fedISBN()` returns `aISBN` without any separators.
+//
+//	`aISBN` The ISBN to normalise.
+func fedISBN(aISBN string) string {
+	var sb strings.Builder
+	for _, r := range aISBN {
+		switch {
+		case ('0' <= r) && ('9' >= r):
+			sb.WriteRune(r)
+		case ('x' == r) || ('X' == r):
+			sb.WriteRune('X')
+		}
+	}
+
+	return sb.String()
+} // fedISBN()
+
+// `fedKeys()` returns the keys identifying the book `aDoc` across
+// libraries, i.e. its ISBN and other identifiers as well as its title
+// and authors.
+//
+//	`aDoc` The document to identify.
+func fedKeys(aDoc *TDocument) []string {
+	var result []string
+	if isbn := fedISBN(aDoc.ISBN); 0 < len(isbn) {
+		result = append(result, `isbn:`+isbn)
+	}
+	if nil != aDoc.identifiers {
+		for _, ident := range *aDoc.identifiers {
+			value := strings.ToLower(strings.TrimSpace(ident.URL))
+			if `isbn` == ident.Name {
+				value = fedISBN(value)
+			}
+			if 0 < len(value) {
+				result = append(result, ident.Name+`:`+value)
+			}
+		}
+	}
+	if title := strings.ToLower(strings.TrimSpace(aDoc.Title)); 0 < len(title) {
+		result = append(result, "title:"+title+"\x00"+strings.ToLower(aDoc.AuthorList()))
+	}
+
+	return result
+} // fedKeys()
+
+// `fedMerge()` returns the documents of `aLists` with all copies of
+// the same book merged into a single hit.
+//
+// The first document found of a book is the one reported, so the
+// order of `aLists` decides which library's document is preferred.
+//
+//	`aLists` The documents found in each library.
+func fedMerge(aLists []*TDocList) TFederatedList {
+	result := make(TFederatedList, 0, 64)
+	seen := make(map[string]int, 256) // key -> index in `result`
+
+	for _, list := range aLists {
+		if nil == list {
+			continue
+		}
+		for idx := range *list {
+			doc := &(*list)[idx]
+			keys := fedKeys(doc)
+			hit := -1
+			for _, key := range keys {
+				if pos, ok := seen[key]; ok {
+					hit = pos
+					break
+				}
+			}
+			if 0 > hit {
+				hit = len(result)
+				result = append(result, TFederatedHit{TDocument: doc})
+			} else {
+				result[hit].Copies = append(result[hit].Copies, doc)
+			}
+			for _, key := range keys {
+				if _, ok := seen[key]; !ok {
+					seen[key] = hit
+				}
+			}
+		}
+	}
+
+	return result
+} // fedMerge()
+
+// FederatedSearch runs the search `aOptions.Matching` against all
+// `aLibraries` concurrently.
+//
+// The method returns in `rCount` the number of (distinct) documents
+// found, in `rList` the documents selected by `aOptions.LimitStart`
+// and `aOptions.LimitLength` sorted by `aOptions.SortBy`, in
+// `rTruncated` whether a library found more documents than were
+// read (in which case `rCount` is limited to the documents which
+// can be paged through), in `rErr` either `nil` or the first error
+// of a library's search (the other libraries' documents are
+// returned nevertheless).
+//
+// It's the caller's responsibility to pass only libraries the
+// current user may access.
+//
+//	`aContext` The current web request's context.
+//	`aLibraries` The libraries to search.
+//	`aOptions` The options to configure the query.
+func FederatedSearch(aContext context.Context, aLibraries []*TLibrary, aOptions *TQueryOptions) (rCount int, rList TFederatedList, rTruncated bool, rErr error) {
+	// Each library returns its first documents by the requested
+	// order; paging is done after merging the results:
+	qo := aOptions.clone()
+	qo.Layout = QoLayoutList
+	qo.LimitStart, qo.LimitLength = 0, fedMaxHits
+
+	counts := make([]int, len(aLibraries))
+	lists := make([]*TDocList, len(aLibraries))
+	errs := make([]error, len(aLibraries))
+	var wg sync.WaitGroup
+	for idx, lib := range aLibraries {
+		wg.Add(1)
+		go func(aIdx int, aLibrary *TLibrary) {
+			defer wg.Done()
+			dbh, err := aLibrary.Open(aContext)
+			if nil != err {
+				errs[aIdx] = err
+				return
+			}
+			defer dbh.Close()
+			counts[aIdx], lists[aIdx], errs[aIdx] = dbh.QuerySearch(aContext, qo)
+		}(idx, lib)
+	}
+	wg.Wait()
+	for idx, err := range errs {
+		if nil != err {
+			rErr = fmt.Errorf("FederatedSearch(%s): %w", aLibraries[idx].Name(), err)
+			break
+		}
+	}
+
+	hits := fedMerge(lists)
+	sort.SliceStable(hits, func(i, j int) bool {
+		result := fedCompare(hits[i].TDocument, hits[j].TDocument, aOptions.SortBy)
+		if 0 == result {
+			result = strings.Compare(hits[i].titleSort, hits[j].titleSort)
+			if aOptions.Descending {
+				result = -result // keep the title ascending
+			}
+		}
+		if aOptions.Descending {
+			return 0 < result
+		}
+		return 0 > result
+	})
+
+	rCount = len(hits)
+	for idx, list := range lists {
+		if (nil != list) && (len(*list) < counts[idx]) {
+			// The documents following the ones read might precede
+			// some of the other libraries' documents, so only the
+			// first `fedMaxHits` positions are certain:
+			rTruncated = true
+			if fedMaxHits < rCount {
+				rCount = fedMaxHits
+			}
+			break
+		}
+	}
+	start := int(aOptions.LimitStart)
+	if start > rCount {
+		start = rCount
+	}
+	end := rCount
+	if (0 < aOptions.LimitLength) && (start+int(aOptions.LimitLength) < end) {
+		end = start + int(aOptions.LimitLength)
+	}
+	rList = hits[start:end]
+
+	return
+} // FederatedSearch()
+
+/* _EoF_ */
@@ -0,0 +1,115 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func Test_fedISBN(t *testing.T) {
+	tests := []struct {
+		name  string
+		aISBN string
+		want  string
+	}{
+		{" 1", "", ""},
+		{" 2", "978-3-16-148410-0", "9783161484100"},
+		{" 3", "3 499 13599 x", "349913599X"},
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fedISBN(tt.aISBN); got != tt.want {
+				t.Errorf("fedISBN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_fedISBN()
+
+func TestFederatedSearch(t *testing.T) {
+	ctx := context.Background()
+	lib1, lib2 := prepLibraryForTesting(t), prepLibraryForTesting(t)
+	// Books #1 and #2 are in both libraries (same ISBN identifier):
+	prepCalibreLibrary(t, filepath.Join(lib1.Path(), dbCalibreDatabaseFilename), 2)
+	prepCalibreLibrary(t, filepath.Join(lib2.Path(), dbCalibreDatabaseFilename), 4)
+	libs := []*TLibrary{lib1, lib2}
+
+	qo := func(aMatching string, aStart, aLength uint, aDescending bool) *TQueryOptions {
+		result := NewQueryOptions(0)
+		result.Matching = aMatching
+		result.SortBy = qoSortByTitle
+		result.Descending = aDescending
+		result.LimitStart, result.LimitLength = aStart, aLength
+		return result
+	}
+	tests := []struct {
+		name      string
+		aOptions  *TQueryOptions
+		wantCount int
+		wantIDs   []TID
+		wantLibs  []*TLibrary
+	}{
+		{" 1", qo(`title:"Title"`, 0, 10, false), 4, []TID{1, 2, 3, 4}, []*TLibrary{lib1, lib1, lib2, lib2}},
+		{" 2", qo(`title:"Title"`, 0, 10, true), 4, []TID{4, 3, 2, 1}, []*TLibrary{lib2, lib2, lib1, lib1}},
+		{" 3", qo(`title:"Title"`, 1, 2, false), 4, []TID{2, 3}, []*TLibrary{lib1, lib2}},
+		{" 4", qo(`title:"=Title 3"`, 0, 10, false), 1, []TID{3}, []*TLibrary{lib2}},
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCount, gotList, gotTruncated, err := FederatedSearch(ctx, libs, tt.aOptions)
+			if nil != err {
+				t.Fatalf("FederatedSearch() error = %v", err)
+			}
+			if gotTruncated {
+				t.Error("FederatedSearch() truncated = true, want false")
+			}
+			if gotCount != tt.wantCount {
+				t.Errorf("FederatedSearch() count = %d, want %d", gotCount, tt.wantCount)
+			}
+			if len(gotList) != len(tt.wantIDs) {
+				t.Fatalf("FederatedSearch() len = %d, want %d", len(gotList), len(tt.wantIDs))
+			}
+			for idx, hit := range gotList {
+				if (hit.ID != tt.wantIDs[idx]) || (hit.Library() != tt.wantLibs[idx]) {
+					t.Errorf("FederatedSearch()[%d] = #%d/%s, want #%d/%s",
+						idx, hit.ID, hit.Library().Name(), tt.wantIDs[idx], tt.wantLibs[idx].Name())
+				}
+				wantCopies := 0
+				if lib1 == hit.Library() {
+					wantCopies = 1 // the same book in `lib2`
+				}
+				if len(hit.Copies) != wantCopies {
+					t.Errorf("FederatedSearch()[%d] copies = %d, want %d", idx, len(hit.Copies), wantCopies)
+				}
+			}
+		})
+	}
+
+	// A library with more documents than are read:
+	lib3 := prepLibraryForTesting(t)
+	prepCalibreLibrary(t, filepath.Join(lib3.Path(), dbCalibreDatabaseFilename), fedMaxHits+20)
+	for _, page := range []uint{0, fedMaxHits - 10, fedMaxHits} {
+		count, list, truncated, err := FederatedSearch(ctx,
+			[]*TLibrary{lib2, lib3}, qo(`title:"Title"`, page, 10, false))
+		if nil != err {
+			t.Fatalf("FederatedSearch() error = %v", err)
+		}
+		if (fedMaxHits != count) || !truncated {
+			t.Errorf("FederatedSearch(%d) = %d, %v, want %d, true", page, count, truncated, fedMaxHits)
+		}
+		if want := min(10, fedMaxHits-int(page)); len(list) != want {
+			t.Errorf("FederatedSearch(%d) len = %d, want %d", page, len(list), want)
+		}
+	}
+} // TestFederatedSearch()
+
+/* _EoF_ */
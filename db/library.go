@@ -0,0 +1,350 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the `Calibre` libraries served by `Kaliber`.
+ *
+ * Each library has its own database copy and handle, cache directory,
+ * metadata preferences, and history of changes.
+ * The first library added is the default one which is used whenever
+ * a request doesn't ask for a certain library.
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+const (
+	// LibraryDefaultName is the name of the default library unless
+	// another one was added first.
+	LibraryDefaultName = `main`
+)
+
+type (
+	// TLibrary is a single `Calibre` library.
+	TLibrary struct {
+		cachePath string      // directory of the database copy
+		changes   tChanges    // the library's history of changes
+		initOnce  *sync.Once  // guard for `Init()`
+		md        tMetadata   // the library's metadata preferences
+		name      string      // the library's name used in URLs
+		path      string      // base directory of the library
+		pool      *tDBpool    // handle of the current database copy
+		rmFailed  bool        // flag whether building the current copy's read model failed
+		syncMtx   *sync.Mutex // guard against parallel database copies
+		title     string      // the library's display name
+	}
+
+	// `tLibraryKey` is the context key of a request's library.
+	tLibraryKey struct{}
+)
+
+var (
+	// The libraries served (the first one being the default).
+	libList []*TLibrary
+
+	// Guard for `libList`.
+	libMtx = new(sync.RWMutex)
+
+	// RegEx to check a library's name.
+	libNameRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+// `newLibrary()` returns a library without any directories.
+//
+//	`aName` The library's name used in URLs.
+//	`aTitle` The library's display name.
+func newLibrary(aName, aTitle string) *TLibrary {
+	result := &TLibrary{
+		changes:  newChanges(),
+		initOnce: new(sync.Once),
+		md:       newMetadata(),
+		name:     aName,
+		syncMtx:  new(sync.Mutex),
+		title:    aTitle,
+	}
+	result.pool = &tDBpool{
+		cOnce: new(sync.Once),
+		lib:   result,
+		pMtx:  new(sync.Mutex),
+	}
+
+	return result
+} // newLibrary()
+
+// NewLibrary returns a new library using `aLibraryPath` and `aCachePath`.
+//
+// The library is served only after it was given to `AddLibrary()`.
+//
+//	`aName` The library's name used in URLs (lowercase letters, digits, `-` and `_`).
+//	`aTitle` The library's display name (`aName` if empty).
+//	`aLibraryPath` The base directory of the `Calibre` library.
+//	`aCachePath` The directory to use for the database copy.
+func NewLibrary(aName, aTitle, aLibraryPath, aCachePath string) (*TLibrary, error) {
+	if !libNameRE.MatchString(aName) {
+		return nil, fmt.Errorf("NewLibrary: invalid library name '%s'", aName)
+	}
+	if 0 == len(aTitle) {
+		aTitle = aName
+	}
+	result := newLibrary(aName, aTitle)
+	if err := result.setPath(aLibraryPath); nil != err {
+		return nil, err
+	}
+	if err := result.setCachePath(aCachePath); nil != err {
+		return nil, err
+	}
+
+	return result, nil
+} // NewLibrary()
+
+// AddLibrary adds `aLibrary` to the libraries served.
+//
+// The first library added is the default library.
+//
+//	`aLibrary` The library to add.
+func AddLibrary(aLibrary *TLibrary) error {
+	if nil == aLibrary {
+		return errors.New(`AddLibrary: missing library`)
+	}
+	libMtx.Lock()
+	defer libMtx.Unlock()
+
+	for _, lib := range libList {
+		if lib.name == aLibrary.name {
+			return fmt.Errorf("AddLibrary: duplicate library name '%s'", aLibrary.name)
+		}
+		if lib.cachePath == aLibrary.cachePath {
+			return fmt.Errorf("AddLibrary: libraries '%s' and '%s' share the cache '%s'",
+				lib.name, aLibrary.name, aLibrary.cachePath)
+		}
+	}
+	libList = append(libList, aLibrary)
+
+	return nil
+} // AddLibrary()
+
+// ContextLibrary returns the library of `aContext` or – if none was
+// set by `NewLibraryContext()` – the default library.
+//
+//	`aContext` The current request's context.
+func ContextLibrary(aContext context.Context) *TLibrary {
+	if nil != aContext {
+		if lib, ok := aContext.Value(tLibraryKey{}).(*TLibrary); ok && (nil != lib) {
+			return lib
+		}
+	}
+
+	return DefaultLibrary()
+} // ContextLibrary()
+
+// DefaultLibrary returns the library used when a request doesn't ask
+// for a certain library.
+//
+// If no library was added yet an empty one is created which can be
+// set up by `SetCalibreLibraryPath()` and `SetCalibreCachePath()`.
+func DefaultLibrary() *TLibrary {
+	libMtx.RLock()
+	if 0 < len(libList) {
+		defer libMtx.RUnlock()
+		return libList[0]
+	}
+	libMtx.RUnlock()
+
+	libMtx.Lock()
+	defer libMtx.Unlock()
+
+	if 0 == len(libList) {
+		libList = append(libList, newLibrary(LibraryDefaultName, LibraryDefaultName))
+	}
+
+	return libList[0]
+} // DefaultLibrary()
+
+// Libraries returns all libraries served (the default one first).
+func Libraries() []*TLibrary {
+	libMtx.RLock()
+	defer libMtx.RUnlock()
+
+	return append([]*TLibrary(nil), libList...)
+} // Libraries()
+
+// Library returns the library named `aName` or `nil` if there's no
+// such library.
+//
+//	`aName` The name of the library to return.
+func Library(aName string) *TLibrary {
+	libMtx.RLock()
+	defer libMtx.RUnlock()
+
+	for _, lib := range libList {
+		if lib.name == aName {
+			return lib
+		}
+	}
+
+	return nil
+} // Library()
+
+// NewLibraryContext returns a copy of `aContext` using `aLibrary`
+// for all `OpenDatabase()` calls.
+//
+//	`aContext` The current request's context.
+//	`aLibrary` The library to use.
+func NewLibraryContext(aContext context.Context, aLibrary *TLibrary) context.Context {
+	return context.WithValue(aContext, tLibraryKey{}, aLibrary)
+} // NewLibraryContext()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// CachePath returns the directory of the library's database copy.
+func (l *TLibrary) CachePath() string {
+	return l.cachePath
+} // CachePath()
+
+// Init prepares the library's database copy and starts monitoring
+// the original database file.
+//
+// Calling this method more than once doesn't do any harm.
+func (l *TLibrary) Init() {
+	l.initOnce.Do(func() {
+		// Prepare the local database copy:
+		if copied, _ := l.syncDatabaseFile(); copied {
+			_ = l.pool.renew()
+		}
+
+		// Start monitoring the original database file:
+		go l.goSyncFile()
+	})
+} // Init()
+
+// IsDefault returns whether the library is the default library.
+func (l *TLibrary) IsDefault() bool {
+	return l == DefaultLibrary()
+} // IsDefault()
+
+// Name returns the library's name used in URLs.
+func (l *TLibrary) Name() string {
+	return l.name
+} // Name()
+
+// Open returns a connection to the library's database.
+//
+// The caller is expected to call the returned instance's `Close()`
+// method when done.
+//
+//	`aContext` The current web request's context.
+func (l *TLibrary) Open(aContext context.Context) (*TDataBase, error) {
+	l.Init()
+	snap, err := l.pool.acquire(aContext)
+	if nil != err {
+		return nil, err
+	}
+
+	return &TDataBase{lib: l, snap: snap}, nil
+} // Open()
+
+// Path returns the base directory of the `Calibre` library.
+func (l *TLibrary) Path() string {
+	return l.path
+} // Path()
+
+// PreferencesFile returns the complete path-/filename of the
+// library's preferences file.
+func (l *TLibrary) PreferencesFile() string {
+	return filepath.Join(l.path, dbCalibrePreferencesFile)
+} // PreferencesFile()
+
+// `setCachePath()` sets the directory of the library's database copy.
+//
+// If `aPath` is an empty string or is a directory that can't be used
+// or created the method returns an appropriate error.
+//
+//	`aPath` The directory to use for the database copy.
+func (l *TLibrary) setCachePath(aPath string) error {
+	if 0 == len(aPath) {
+		return errors.New(`SetCalibreCachePath can't use empty directory/path`)
+	}
+	if path, err := filepath.Abs(aPath); nil == err {
+		aPath = path
+	}
+	if fi, err := os.Stat(aPath); (nil == err) && fi.IsDir() {
+		l.cachePath = aPath
+	} else if err := os.MkdirAll(aPath, os.ModeDir|0750); nil == err {
+		l.cachePath = aPath
+	} else {
+		l.cachePath = ``
+		return fmt.Errorf("SetCalibreCachePath can't find directory: %v", err)
+	}
+
+	return nil
+} // setCachePath()
+
+// `setPath()` sets the base directory of the `Calibre` library.
+//
+// If `aPath` is an empty string or is not a directory the method
+// returns an appropriate error.
+//
+//	`aPath` The base directory of the `Calibre` library.
+func (l *TLibrary) setPath(aPath string) error {
+	if 0 == len(aPath) {
+		return errors.New(`SetCalibreLibraryPath can't use empty directory/path`)
+	}
+	if path, err := filepath.Abs(aPath); nil == err {
+		aPath = path
+	}
+	if fi, err := os.Stat(aPath); (nil == err) && fi.IsDir() {
+		l.path = aPath
+	} else {
+		l.path = ``
+		return fmt.Errorf("SetCalibreLibraryPath can't find directory: %v", err)
+	}
+
+	return nil
+} // setPath()
+
+// SetTitle sets the library's display name.
+//
+// If `aTitle` is empty the library's name is used.
+//
+//	`aTitle` The library's new display name.
+func (l *TLibrary) SetTitle(aTitle string) *TLibrary {
+	if 0 == len(aTitle) {
+		aTitle = l.name
+	}
+	l.title = aTitle
+
+	return l
+} // SetTitle()
+
+// Title returns the library's display name.
+func (l *TLibrary) Title() string {
+	return l.title
+} // Title()
+
+// URL returns the prefix of the library's URLs.
+//
+// The default library is served without a prefix, all others use
+// `/lib/<name>`.
+func (l *TLibrary) URL() string {
+	if (nil == l) || l.IsDefault() {
+		return ``
+	}
+
+	return `/lib/` + l.name
+} // URL()
+
+/* _EoF_ */
@@ -0,0 +1,159 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// `prepLibraryForTesting()` returns a library (not added to the
+// libraries served) using temporary directories.
+func prepLibraryForTesting(t testing.TB) *TLibrary {
+	lib, err := NewLibrary(`test`, `Test`, t.TempDir(), t.TempDir())
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	return lib
+} // prepLibraryForTesting()
+
+// `prepLibrariesForTesting()` replaces the libraries served by an
+// empty list, restoring the previous list when the test is done.
+func prepLibrariesForTesting(t testing.TB) {
+	libMtx.Lock()
+	saved := libList
+	libList = nil
+	libMtx.Unlock()
+
+	t.Cleanup(func() {
+		libMtx.Lock()
+		libList = saved
+		libMtx.Unlock()
+	})
+} // prepLibrariesForTesting()
+
+func TestNewLibrary(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name         string
+		aName        string
+		aTitle       string
+		aLibraryPath string
+		aCachePath   string
+		wantTitle    string
+		wantErr      bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `fiction`, `Fiction`, dir, filepath.Join(dir, `c1`), `Fiction`, false},
+		{" 2", `comics_2`, ``, dir, filepath.Join(dir, `c2`), `comics_2`, false},
+		{" 3", `Fiction`, ``, dir, filepath.Join(dir, `c3`), ``, true},
+		{" 4", `fic/tion`, ``, dir, filepath.Join(dir, `c4`), ``, true},
+		{" 5", ``, ``, dir, filepath.Join(dir, `c5`), ``, true},
+		{" 6", `fiction`, ``, filepath.Join(dir, `missing`), filepath.Join(dir, `c6`), ``, true},
+		{" 7", `fiction`, ``, dir, ``, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewLibrary(tt.aName, tt.aTitle, tt.aLibraryPath, tt.aCachePath)
+			if (nil != err) != tt.wantErr {
+				t.Fatalf("NewLibrary() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (tt.aName != got.Name()) || (tt.wantTitle != got.Title()) ||
+				(tt.aLibraryPath != got.Path()) || (tt.aCachePath != got.CachePath()) {
+				t.Errorf("NewLibrary() = %q, %q, %q, %q", got.Name(), got.Title(), got.Path(), got.CachePath())
+			}
+		})
+	}
+} // TestNewLibrary()
+
+func TestAddLibrary(t *testing.T) {
+	prepLibrariesForTesting(t)
+	dir := t.TempDir()
+	main, _ := NewLibrary(`main`, ``, dir, filepath.Join(dir, `main`))
+	fiction, _ := NewLibrary(`fiction`, ``, dir, filepath.Join(dir, `fiction`))
+	again, _ := NewLibrary(`fiction`, ``, dir, filepath.Join(dir, `again`))
+	shared, _ := NewLibrary(`shared`, ``, dir, filepath.Join(dir, `fiction`))
+
+	tests := []struct {
+		name     string
+		aLibrary *TLibrary
+		wantErr  bool
+	}{
+		// TODO: Add test cases.
+		{" 1", main, false},
+		{" 2", fiction, false},
+		{" 3", again, true},
+		{" 4", shared, true},
+		{" 5", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := AddLibrary(tt.aLibrary); (nil != err) != tt.wantErr {
+				t.Errorf("AddLibrary() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if got := Libraries(); (2 != len(got)) || (main != got[0]) || (fiction != got[1]) {
+		t.Errorf("Libraries() = %v", got)
+	}
+	if got := DefaultLibrary(); main != got {
+		t.Errorf("DefaultLibrary() = %v, want %v", got, main)
+	}
+	if got := Library(`fiction`); fiction != got {
+		t.Errorf("Library() = %v, want %v", got, fiction)
+	}
+	if got := Library(`unknown`); nil != got {
+		t.Errorf("Library() = %v, want nil", got)
+	}
+	if got := main.URL(); `` != got {
+		t.Errorf("TLibrary.URL() = %q, want %q", got, ``)
+	}
+	if got := fiction.URL(); `/lib/fiction` != got {
+		t.Errorf("TLibrary.URL() = %q, want %q", got, `/lib/fiction`)
+	}
+
+	ctx := context.Background()
+	if got := ContextLibrary(ctx); main != got {
+		t.Errorf("ContextLibrary() = %v, want %v", got, main)
+	}
+	if got := ContextLibrary(NewLibraryContext(ctx, fiction)); fiction != got {
+		t.Errorf("ContextLibrary() = %v, want %v", got, fiction)
+	}
+} // TestAddLibrary()
+
+func TestTLibrary_Open(t *testing.T) {
+	ctx := context.Background()
+	lib1, lib2 := prepLibraryForTesting(t), prepLibraryForTesting(t)
+	for idx, lib := range []*TLibrary{lib1, lib2} {
+		prepCalibreLibrary(t, filepath.Join(lib.Path(), dbCalibreDatabaseFilename), idx+1)
+	}
+
+	for idx, lib := range []*TLibrary{lib1, lib2} {
+		dbh, err := lib.Open(ctx)
+		if nil != err {
+			t.Fatalf("TLibrary.Open() error = %v", err)
+		}
+		list, err := dbh.QueryIDs(ctx)
+		dbh.Close()
+		if (nil != err) || (idx+1 != len(*list)) {
+			t.Fatalf("QueryIDs() = %v, %v, want %d documents", list, err, idx+1)
+		}
+		if doc := (*list)[0]; lib != doc.Library() {
+			t.Errorf("TDocument.Library() = %v, want %v", doc.Library(), lib)
+		}
+	}
+} // TestTLibrary_Open()
+
+/* _EoF_ */
@@ -48,48 +48,61 @@ type (
 	// TVirtLibList is the `virtual_libraries` JSON metadata section
 	// indexed by virt.library name.
 	TVirtLibList map[string]string
-)
 
-var (
-	// cache of "book_display_fields" list
-	mdBookDisplayFieldsList    tBookDisplayFieldsList
-	mdBookDisplayFieldsListMtx = new(sync.RWMutex)
+	// `tMetadata` caches a library's metadata preferences.
+	tMetadata struct {
+		// cache of "book_display_fields" list
+		bookDisplayFieldsList    tBookDisplayFieldsList
+		bookDisplayFieldsListMtx *sync.RWMutex
 
-	// cache of "field_metadata" list
-	mdFieldsMetadataList    *tInterfaceList // map[string]interface{}
-	mdFieldsMetadataListMtx = new(sync.RWMutex)
+		// cache of "field_metadata" list
+		fieldsMetadataList    *tInterfaceList // map[string]interface{}
+		fieldsMetadataListMtx *sync.RWMutex
 
-	// list of virtual libraries to hide
-	mdHiddenVirtLibs    *tInterfaceList // map[string]interface{}
-	mdHiddenVirtLibsMtx = new(sync.RWMutex)
+		// list of virtual libraries to hide
+		hiddenVirtLibs    *tInterfaceList // map[string]interface{}
+		hiddenVirtLibsMtx *sync.RWMutex
 
-	// cache of all DB metadata preferences
-	mdMetadataDbPrefs    *tInterfaceList // map[string]interface{}
-	mdMetadataDbPrefsMtx = new(sync.RWMutex)
+		// cache of all DB metadata preferences
+		metadataDbPrefs    *tInterfaceList // map[string]interface{}
+		metadataDbPrefsMtx *sync.RWMutex
 
-	// virtual libraries list
-	mdVirtLibList    TVirtLibList
-	mdVirtLibListMtx = new(sync.RWMutex)
+		// virtual libraries list
+		virtLibList    TVirtLibList
+		virtLibListMtx *sync.RWMutex
 
-	// raw virtual libraries list
-	mdVirtLibsRaw    *tInterfaceList // map[string]interface{}
-	mdVirtLibsRawMtx = new(sync.RWMutex)
+		// raw virtual libraries list
+		virtLibsRaw    *tInterfaceList // map[string]interface{}
+		virtLibsRawMtx *sync.RWMutex
+	}
 )
 
+// `newMetadata()` returns an empty metadata cache.
+func newMetadata() tMetadata {
+	return tMetadata{
+		bookDisplayFieldsListMtx: new(sync.RWMutex),
+		fieldsMetadataListMtx:    new(sync.RWMutex),
+		hiddenVirtLibsMtx:        new(sync.RWMutex),
+		metadataDbPrefsMtx:       new(sync.RWMutex),
+		virtLibListMtx:           new(sync.RWMutex),
+		virtLibsRawMtx:           new(sync.RWMutex),
+	}
+} // newMetadata()
+
 // `mdGetFieldData()` returns a list of field definitions for `aField`.
-func mdGetFieldData(aField string) (rList tInterfaceList /* map[string]interface{} */, rErr error) {
+func (l *TLibrary) mdGetFieldData(aField string) (rList tInterfaceList /* map[string]interface{} */, rErr error) {
 	if 0 == len(aField) {
 		return
 	}
-	if rErr = mdReadFieldMetadata(); nil != rErr {
+	if rErr = l.mdReadFieldMetadata(); nil != rErr {
 		msg := fmt.Sprintf("mdReadFieldMetadata(): %v", rErr)
 		rErr = errors.New(msg)
 		return
 	}
-	mdFieldsMetadataListMtx.RLock()
-	defer mdFieldsMetadataListMtx.RUnlock()
+	l.md.fieldsMetadataListMtx.RLock()
+	defer l.md.fieldsMetadataListMtx.RUnlock()
 
-	fmd := *mdFieldsMetadataList
+	fmd := *l.md.fieldsMetadataList
 	fd, ok := fmd[aField]
 	if !ok {
 		return nil, errors.New("no such JSON section: " + aField)
@@ -101,81 +114,81 @@ func mdGetFieldData(aField string) (rList tInterfaceList /* map[string]interface
 } // mdGetFieldData()
 
 // `mdReadBookDisplayFields()`
-func mdReadBookDisplayFields() error {
-	if err := mdReadMetadataFile(); nil != err {
+func (l *TLibrary) mdReadBookDisplayFields() error {
+	if err := l.mdReadMetadataFile(); nil != err {
 		msg := fmt.Sprintf("mdReadMetadataFile(): %v", err)
 		return errors.New(msg)
 	}
 
-	section, ok := mdGetMetadataDbPref(mdBookDisplayFields)
+	section, ok := l.mdGetMetadataDbPref(mdBookDisplayFields)
 	if !ok {
 		return errors.New("no such JSON section: " + mdBookDisplayFields)
 	}
 
-	mdBookDisplayFieldsListMtx.Lock()
-	defer mdBookDisplayFieldsListMtx.Unlock()
+	l.md.bookDisplayFieldsListMtx.Lock()
+	defer l.md.bookDisplayFieldsListMtx.Unlock()
 
-	if nil != mdBookDisplayFieldsList {
+	if nil != l.md.bookDisplayFieldsList {
 		return nil // field metadata already read
 	}
 
 	data := section.([]interface{})
-	mdBookDisplayFieldsList = make(tBookDisplayFieldsList, len(data))
+	l.md.bookDisplayFieldsList = make(tBookDisplayFieldsList, len(data))
 	for _, raw := range data {
 		entry := raw.([]interface{})
 		field := entry[0].(string)
 		display := entry[1].(bool)
-		mdBookDisplayFieldsList[field] = display
+		l.md.bookDisplayFieldsList[field] = display
 	}
 
 	return nil
 } // mdReadBookDisplayFields()
 
 // `mdReadFieldMetadata()`
-func mdReadFieldMetadata() error {
-	if err := mdReadMetadataFile(); nil != err {
+func (l *TLibrary) mdReadFieldMetadata() error {
+	if err := l.mdReadMetadataFile(); nil != err {
 		msg := fmt.Sprintf("mdReadMetadataFile(): %v", err)
 		return errors.New(msg)
 	}
 
-	section, ok := mdGetMetadataDbPref(mdFieldMetadata)
+	section, ok := l.mdGetMetadataDbPref(mdFieldMetadata)
 	if !ok {
 		return errors.New("no such JSON section: " + mdFieldMetadata)
 	}
 
-	mdFieldsMetadataListMtx.Lock()
-	defer mdFieldsMetadataListMtx.Unlock()
+	l.md.fieldsMetadataListMtx.Lock()
+	defer l.md.fieldsMetadataListMtx.Unlock()
 
-	if nil != mdFieldsMetadataList {
+	if nil != l.md.fieldsMetadataList {
 		return nil // field metadata already read
 	}
 
 	fmd := section.(map[string]interface{})
 	msi := tInterfaceList(fmd)
-	mdFieldsMetadataList = &msi
+	l.md.fieldsMetadataList = &msi
 
 	return nil
 } // mdReadFieldMetadata()
 
 // `mdReadHiddenVirtualLibraries()` reads the list ob hidden libraries to hide.
-func mdReadHiddenVirtualLibraries() error {
-	if err := mdReadMetadataFile(); nil != err {
+func (l *TLibrary) mdReadHiddenVirtualLibraries() error {
+	if err := l.mdReadMetadataFile(); nil != err {
 		msg := fmt.Sprintf("mdReadMetadataFile(): %v", err)
 		apachelogger.Err("mdReadHiddenVirtualLibraries", msg)
 		return errors.New(msg)
 	}
 
-	section, ok := mdGetMetadataDbPref(mdHiddenVirtualLibraries)
+	section, ok := l.mdGetMetadataDbPref(mdHiddenVirtualLibraries)
 	if !ok {
 		msg := "no such JSON section: " + mdHiddenVirtualLibraries
 		apachelogger.Err("mdReadHiddenVirtualLibraries", msg)
 		return errors.New(msg)
 	}
 
-	mdHiddenVirtLibsMtx.Lock()
-	defer mdHiddenVirtLibsMtx.Unlock()
+	l.md.hiddenVirtLibsMtx.Lock()
+	defer l.md.hiddenVirtLibsMtx.Unlock()
 
-	if nil != mdHiddenVirtLibs {
+	if nil != l.md.hiddenVirtLibs {
 		return nil
 	}
 
@@ -188,31 +201,31 @@ func mdReadHiddenVirtualLibraries() error {
 		lib := val.(string)
 		result[lib] = struct{}{}
 	}
-	mdHiddenVirtLibs = &result
+	l.md.hiddenVirtLibs = &result
 
 	return nil
 } // mdReadHiddenVirtualLibraries()
 
 // `mdGetMetadataDbPref()` returns the preferences section indexed by `aKey`.
-func mdGetMetadataDbPref(aKey string) (rSection interface{}, rOK bool) {
-	mdMetadataDbPrefsMtx.RLock()
-	defer mdMetadataDbPrefsMtx.RUnlock()
+func (l *TLibrary) mdGetMetadataDbPref(aKey string) (rSection interface{}, rOK bool) {
+	l.md.metadataDbPrefsMtx.RLock()
+	defer l.md.metadataDbPrefsMtx.RUnlock()
 
-	rSection, rOK = (*mdMetadataDbPrefs)[aKey]
+	rSection, rOK = (*l.md.metadataDbPrefs)[aKey]
 
 	return
 } // mdGetMetadataDbPref()
 
 // `mdReadMetadataFile()` returns a map of the JSON data read.
-func mdReadMetadataFile() error {
-	mdMetadataDbPrefsMtx.Lock()
-	defer mdMetadataDbPrefsMtx.Unlock()
+func (l *TLibrary) mdReadMetadataFile() error {
+	l.md.metadataDbPrefsMtx.Lock()
+	defer l.md.metadataDbPrefsMtx.Unlock()
 
-	if nil != mdMetadataDbPrefs {
+	if nil != l.md.metadataDbPrefs {
 		return nil // metadata already read
 	}
 
-	fName := CalibrePreferencesFile()
+	fName := l.PreferencesFile()
 	srcFile, err := os.OpenFile(fName, os.O_RDONLY, 0)
 	if nil != err {
 		msg := fmt.Sprintf("os.OpenFile(%s): %v", fName, err)
@@ -237,62 +250,62 @@ func mdReadMetadataFile() error {
 	delete(jsData, `saved_searches`)
 	delete(jsData, `update_all_last_mod_dates_on_start`)
 	delete(jsData, `user_categories`)
-	mdMetadataDbPrefs = &jsData
+	l.md.metadataDbPrefs = &jsData
 
 	return nil
 } // mdReadMetadataFile()
 
 // `mdReadVirtualLibraries()` reads the raw virt.library definitions.
-func mdReadVirtualLibraries() error {
-	if err := mdReadMetadataFile(); nil != err {
+func (l *TLibrary) mdReadVirtualLibraries() error {
+	if err := l.mdReadMetadataFile(); nil != err {
 		msg := fmt.Sprintf("mdReadMetadataFile(): %v", err)
 		apachelogger.Err("mdReadVirtualLibraries()", msg)
 		return errors.New(msg)
 	}
 
-	section, ok := mdGetMetadataDbPref(mdVirtualLibraries)
+	section, ok := l.mdGetMetadataDbPref(mdVirtualLibraries)
 	if !ok {
 		msg := "no such JSON section: " + mdVirtualLibraries
 		apachelogger.Err("mdReadVirtualLibraries()", msg)
 		return errors.New(msg)
 	}
 
-	mdVirtLibsRawMtx.Lock()
-	defer mdVirtLibsRawMtx.Unlock()
+	l.md.virtLibsRawMtx.Lock()
+	defer l.md.virtLibsRawMtx.Unlock()
 
-	if nil != mdVirtLibsRaw {
+	if nil != l.md.virtLibsRaw {
 		return nil
 	}
 
 	vlr := section.(map[string]interface{})
 	msi := tInterfaceList(vlr)
-	mdVirtLibsRaw = &msi
+	l.md.virtLibsRaw = &msi
 
 	return nil
 } // mdReadVirtualLibraries()
 
 // `mdVirtLibDefinitions()` returns a map of virtual library definitions.
-func mdVirtLibDefinitions() (*TVirtLibList, error) {
-	if err := mdReadVirtualLibraries(); nil != err {
+func (l *TLibrary) mdVirtLibDefinitions() (*TVirtLibList, error) {
+	if err := l.mdReadVirtualLibraries(); nil != err {
 		msg := fmt.Sprintf("mdReadVirtualLibraries(): %v", err)
 		apachelogger.Err("mdVirtualLibDefinitions()", msg)
 		return nil, errors.New(msg)
 	}
-	if err := mdReadHiddenVirtualLibraries(); nil != err {
+	if err := l.mdReadHiddenVirtualLibraries(); nil != err {
 		msg := fmt.Sprintf("mdReadHiddenVirtualLibraries(): %v", err)
 		apachelogger.Err("mdVirtualLibDefinitions()", msg)
 		return nil, errors.New(msg)
 	}
 
-	mdVirtLibsRawMtx.RLock()
-	defer mdVirtLibsRawMtx.RUnlock()
+	l.md.virtLibsRawMtx.RLock()
+	defer l.md.virtLibsRawMtx.RUnlock()
 
-	m := *mdVirtLibsRaw
+	m := *l.md.virtLibsRaw
 	result := make(TVirtLibList, len(m))
 	for key, value := range m {
 		//FIXME MUTEX
-		if nil != mdHiddenVirtLibs {
-			if _, ok := (*mdHiddenVirtLibs)[key]; ok {
+		if nil != l.md.hiddenVirtLibs {
+			if _, ok := (*l.md.hiddenVirtLibs)[key]; ok {
 				continue
 			}
 		}
@@ -315,16 +328,16 @@ func mdVirtLibDefinitions() (*TVirtLibList, error) {
 // otherwise the (boolean) `visible` value and `nil`.
 //
 //	`aFieldname` The name of the field/column to check.
-func BookFieldVisible(aFieldname string) (bool, error) {
-	if err := mdReadBookDisplayFields(); nil != err {
+func (l *TLibrary) BookFieldVisible(aFieldname string) (bool, error) {
+	if err := l.mdReadBookDisplayFields(); nil != err {
 		msg := fmt.Sprintf("mdReadBookDisplayFields(): %v", err)
 		apachelogger.Err("md.BookFieldVisible()", msg)
 		return true, errors.New(msg)
 	}
-	mdBookDisplayFieldsListMtx.RLock()
-	defer mdBookDisplayFieldsListMtx.RUnlock()
+	l.md.bookDisplayFieldsListMtx.RLock()
+	defer l.md.bookDisplayFieldsListMtx.RUnlock()
 
-	if result, ok := mdBookDisplayFieldsList[aFieldname]; ok {
+	if result, ok := l.md.bookDisplayFieldsList[aFieldname]; ok {
 		return result, nil
 	}
 
@@ -338,14 +351,14 @@ func BookFieldVisible(aFieldname string) (bool, error) {
 //
 //	`aSection` Name of the field's metadata section.
 //	`aField` Name of the data field within `aSection`.
-func MetaFieldValue(aSection, aField string) (interface{}, error) {
+func (l *TLibrary) MetaFieldValue(aSection, aField string) (interface{}, error) {
 	if (0 == len(aSection)) || (0 == len(aField)) {
 		msg := fmt.Sprintf(`md.MetaFieldValue(): empty arguments ("%s". "%s")`, aSection, aField)
 		apachelogger.Err("md.MetaFieldValue", msg)
 		return nil, errors.New(msg)
 	}
 
-	fmd, err := mdGetFieldData(aSection)
+	fmd, err := l.mdGetFieldData(aSection)
 	if nil != err {
 		msg := fmt.Sprintf("mdGetFieldData(): %v", err)
 		apachelogger.Err("md.MetaFieldValue", msg)
@@ -365,24 +378,24 @@ func MetaFieldValue(aSection, aField string) (interface{}, error) {
 // VirtLibOptions returns the SELECT/OPTIONs of the virtual libraries.
 //
 //	`aSelected` Name of the currently selected library.
-func VirtLibOptions(aSelected string) string {
-	_, err := VirtualLibraryList()
+func (l *TLibrary) VirtLibOptions(aSelected string) string {
+	_, err := l.VirtualLibraryList()
 	if nil != err {
 		msg := fmt.Sprintf("md.VirtualLibraryList(): %v", err)
 		apachelogger.Err("md.VirtLibOptions", msg)
 		return ""
 	}
-	mdVirtLibListMtx.RLock()
-	defer mdVirtLibListMtx.RUnlock()
+	l.md.virtLibListMtx.RLock()
+	defer l.md.virtLibListMtx.RUnlock()
 
-	list := make([]string, 0, len(mdVirtLibList)+1)
+	list := make([]string, 0, len(l.md.virtLibList)+1)
 	if (0 == len(aSelected)) || ("-" == aSelected) {
 		list = append(list, `<option value="-" SELECTED> – </option>`)
 		aSelected = ""
 	} else {
 		list = append(list, `<option value="-"> – </option>`)
 	}
-	for key := range mdVirtLibList {
+	for key := range l.md.virtLibList {
 		option := `<option value="` + key + `"`
 		if key == aSelected {
 			option += ` SELECTED`
@@ -405,30 +418,30 @@ var (
 
 // VirtualLibraryList returns a list of virtual library definitions
 // and SQL code to access them.
-func VirtualLibraryList() (TVirtLibList, error) {
-	mdVirtLibListMtx.Lock()
-	defer mdVirtLibListMtx.Unlock()
+func (l *TLibrary) VirtualLibraryList() (TVirtLibList, error) {
+	l.md.virtLibListMtx.Lock()
+	defer l.md.virtLibListMtx.Unlock()
 
-	if nil != mdVirtLibList {
-		return mdVirtLibList, nil
+	if nil != l.md.virtLibList {
+		return l.md.virtLibList, nil
 	}
 
-	jsList, err := mdVirtLibDefinitions()
+	jsList, err := l.mdVirtLibDefinitions()
 	if nil != err {
 		msg := fmt.Sprintf("mdVirtLibDefinitions(): %v", err)
 		apachelogger.Err("md.VirtualLibraryList()", msg)
 		return nil, err
 	}
 
-	mdVirtLibList = make(TVirtLibList, len(*jsList))
+	l.md.virtLibList = make(TVirtLibList, len(*jsList))
 	for key, value := range *jsList {
 
 		//TODO check for libraries to hide
 
-		mdVirtLibList[key] = mdDotStarRE.ReplaceAllLiteralString(value, "%")
+		l.md.virtLibList[key] = mdDotStarRE.ReplaceAllLiteralString(value, "%")
 	}
 
-	return mdVirtLibList, nil
+	return l.md.virtLibList, nil
 } // VirtualLibraryList()
 
 /* _EoF_ */
@@ -31,7 +31,7 @@ func Test_BookFieldVisible(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := BookFieldVisible(tt.args.aFieldname)
+			got, err := DefaultLibrary().BookFieldVisible(tt.args.aFieldname)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("BookFieldVisible() error = '%v', wantErr '%v'", err, tt.wantErr)
 				return
@@ -61,7 +61,7 @@ func Test_mdGetFieldData(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := mdGetFieldData(tt.args.aKey)
+			got, err := DefaultLibrary().mdGetFieldData(tt.args.aKey)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("mdGetFieldData() error = '%v', wantErr '%v'", err, tt.wantErr)
 				return
@@ -87,10 +87,10 @@ func Test_mdReadBookDisplayFields(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := mdReadBookDisplayFields(); (err != nil) != tt.wantErr {
+			if err := DefaultLibrary().mdReadBookDisplayFields(); (err != nil) != tt.wantErr {
 				t.Errorf("mdReadBookDisplayFields() error = '%v', wantErr '%v'", err, tt.wantErr)
 			}
-			if nil == mdBookDisplayFieldsList {
+			if nil == DefaultLibrary().md.bookDisplayFieldsList {
 				t.Errorf("mdReadBookDisplayFields() error = '%v', want '%s'", nil, "!nil")
 			}
 		})
@@ -108,11 +108,11 @@ func Test_mdReadFieldMetadata(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := mdReadFieldMetadata(); (err != nil) != tt.wantErr {
+			if err := DefaultLibrary().mdReadFieldMetadata(); (err != nil) != tt.wantErr {
 				t.Errorf("mdReadFieldMetadata() error = '%v', wantErr '%v'", err, tt.wantErr)
 			}
-			if 0 == len(*mdFieldsMetadataList) {
-				t.Errorf("GetVirtLibList() = '%v', want '%v'", len(*mdFieldsMetadataList), "> 0")
+			if 0 == len(*DefaultLibrary().md.fieldsMetadataList) {
+				t.Errorf("GetVirtLibList() = '%v', want '%v'", len(*DefaultLibrary().md.fieldsMetadataList), "> 0")
 			}
 		})
 	}
@@ -129,7 +129,7 @@ func Test_mdReadHiddenVirtualLibraries(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := mdReadHiddenVirtualLibraries(); (err != nil) != tt.wantErr {
+			if err := DefaultLibrary().mdReadHiddenVirtualLibraries(); (err != nil) != tt.wantErr {
 				t.Errorf("mdReadHiddenVirtualLibraries() error = '%v', wantErr '%v'", err, tt.wantErr)
 			}
 		})
@@ -149,13 +149,13 @@ func Test_mdReadMetadataFile(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := mdReadMetadataFile()
+			err := DefaultLibrary().mdReadMetadataFile()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("mdReadMetadataFile() error = '%v', wantErr '%v'", err, tt.wantErr)
 				return
 			}
-			if 0 == len(*mdMetadataDbPrefs) {
-				t.Errorf("mdReadMetadataFile() = '%v', want '%v'", len(*mdMetadataDbPrefs), "> 0")
+			if 0 == len(*DefaultLibrary().md.metadataDbPrefs) {
+				t.Errorf("mdReadMetadataFile() = '%v', want '%v'", len(*DefaultLibrary().md.metadataDbPrefs), "> 0")
 			}
 		})
 	}
@@ -172,11 +172,11 @@ func Test_mdReadVirtualLibraries(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := mdReadVirtualLibraries(); (err != nil) != tt.wantErr {
+			if err := DefaultLibrary().mdReadVirtualLibraries(); (err != nil) != tt.wantErr {
 				t.Errorf("mdReadVirtualLibraries() error = '%v', wantErr '%v'", err, tt.wantErr)
 			}
-			if 0 == len(*mdVirtLibsRaw) {
-				t.Errorf("mdReadVirtualLibraries() = '%v', want '%v'", len(*mdVirtLibsRaw), "> 0")
+			if 0 == len(*DefaultLibrary().md.virtLibsRaw) {
+				t.Errorf("mdReadVirtualLibraries() = '%v', want '%v'", len(*DefaultLibrary().md.virtLibsRaw), "> 0")
 			}
 		})
 	}
@@ -194,7 +194,7 @@ func Test_mdVirtualLibDefinitions(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := mdVirtLibDefinitions()
+			got, err := DefaultLibrary().mdVirtLibDefinitions()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("mdVirtualLibDefinitions() error = '%v', wantErr '%v'", err, tt.wantErr)
 				return
@@ -227,7 +227,7 @@ func Test_MetaFieldValue(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := MetaFieldValue(tt.args.aField, tt.args.aKey)
+			got, err := DefaultLibrary().MetaFieldValue(tt.args.aField, tt.args.aKey)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetMetaFieldValue() error = '%v', wantErr '%v'", err, tt.wantErr)
 				return
@@ -252,7 +252,7 @@ func Test_VirtualLibraryList(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := VirtualLibraryList()
+			got, err := DefaultLibrary().VirtualLibraryList()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("VirtualLibraryList() error = '%v',\nwantErr '%v'", err, tt.wantErr)
 				return
@@ -279,7 +279,7 @@ func Test_VirtLibOptions(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := VirtLibOptions(tt.args.aSelected); 0 == len(got) {
+			if got := DefaultLibrary().VirtLibOptions(tt.args.aSelected); 0 == len(got) {
 				t.Errorf("GetVirtLibOptions() = '%v',\nwant '%v'", got, "> 0")
 			}
 		})
@@ -1,5 +1,5 @@
 /*
-   Copyright © 2020 M.Watermann, 10247 Berlin, Germany
+   Copyright © 2020, 2024 M.Watermann, 10247 Berlin, Germany
                   All rights reserved
                EMail : <support@mwat.de>
 */
@@ -9,8 +9,17 @@ package db
 //lint:file-ignore ST1017 - I prefer Yoda conditions
 
 /*
- * This file implements a simple connection pool to recycle
- * used SQLite connections.
+ * This file manages the database handle of each library.
+ *
+ * Each copy of `Calibre's` database (a "snapshot") is opened once as
+ * an immutable R/O `sql.DB` whose connections are pooled by the
+ * `database/sql` package.
+ * Since a new copy replaces the previous one under the same name each
+ * snapshot uses a hard link of its own (`metadata.db@<generation>`)
+ * which is removed when the snapshot gets closed.
+ * Whenever a new copy lands the pool switches to a new handle while
+ * the queries still running on the previous one may finish before
+ * that handle gets closed.
  */
 
 import (
@@ -18,182 +27,274 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/mwat56/apachelogger"
+)
+
+const (
+	// Time after which an idle connection gets closed.
+	poolMaxIdleTime = time.Minute << 2 // four minutes
 )
 
 type (
-	// A List of database connections.
-	tDBlist []*sql.DB
+	// `tSnapshot` is the database handle of a single database copy.
+	tSnapshot struct {
+		cache   *tQueryCache         // the copy's query results
+		conn    *sql.DB              // the copy's connection pool
+		file    string               // the copy's own link (if any)
+		model   bool                 // flag whether the copy has a read model
+		refs    int                  // number of `TDataBase` instances using it
+		retired bool                 // flag whether a newer copy replaced it
+		sMtx    *sync.Mutex          // guard for `stmts`
+		stmts   map[string]*sql.Stmt // prepared statements by query
+	}
 
-	// tDBpool The list of database connections.
+	// `tDBpool` provides the handle of a library's current database copy.
+	//
+	// To use the current copy's handle call the `acquire()` method
+	// and hand it back by `release()`.
 	tDBpool struct {
-		pList tDBlist     // The actual list of available connections
-		pMtx  *sync.Mutex // A guard against concurrent write accesses
+		cOnce   *sync.Once  // guard to remove old links only once
+		current *tSnapshot  // the current copy's handle
+		gen     uint64      // generation of the latest link
+		lib     *TLibrary   // the library whose database is used
+		pMtx    *sync.Mutex // guard for `current` and the `refs`
 	}
 )
 
-var (
-	// The list of database connections.
-	pConnPool *tDBpool
+// `poolMaxConns()` returns the max. number of concurrent connections
+// of a database handle.
+func poolMaxConns() int {
+	if result := runtime.GOMAXPROCS(0) << 1; 4 < result {
+		return result
+	}
 
-	// Guard for repetitive calls to `NewPool()`.
-	pInitPoolOnce sync.Once
-)
+	return 4
+} // poolMaxConns()
 
-// `goMonitorPool()` checks the size of the connection pool.
-func goMonitorPool() {
-	var pLen int
-	chkInterval := time.Minute << 2 // four minutes
-	chkTimer := time.NewTimer(chkInterval)
-	defer chkTimer.Stop()
-
-	//lint:ignore S1000 - We won't use `range` here
-	for {
-		select {
-		case <-chkTimer.C:
-			pConnPool.pMtx.Lock()
-			pLen = len(pConnPool.pList)
-			pConnPool.pMtx.Unlock()
-
-			if 63 < pLen {
-				pConnPool.clear()
-			}
-			chkTimer.Reset(chkInterval)
-		}
+// `poolOpen()` returns a new handle of the database copy `aName`.
+//
+// If `aLinked` is `true` the file `aName` is the snapshot's own link
+// which is removed when the handle gets closed.
+//
+//	`aContext` The current request's context.
+//	`aName` The path-/filename of the database copy.
+//	`aLinked` Flag whether `aName` is used by this handle only.
+func poolOpen(aContext context.Context, aName string, aLinked bool) (*tSnapshot, error) {
+	// `immutable=1` tells SQLite the file won't change (the
+	// snapshot's own link is never written to or replaced) so it
+	// can skip all locking; a shared name needs the locking to
+	// notice a new copy.
+	// `mode=ro` is self-explanatory since we don't change the DB
+	// in any way.
+	dsn := `file:` + aName + `?mode=ro&_query_only=1`
+	if aLinked {
+		dsn = `file:` + aName + `?immutable=1&mode=ro&_query_only=1`
 	}
-} // goMonitorPool()
 
-// `newPool()` returns a list of database connections.
-//
-// To retrieve or store a certain connection use the return value's
-// `get()` and `put()` methods respectively.
-func newPool() *tDBpool {
-	pInitPoolOnce.Do(func() {
-		pConnPool = &tDBpool{
-			pList: make(tDBlist, 0, 127),
-			pMtx:  new(sync.Mutex),
+	conn, err := sql.Open(`sqlite3`, dsn)
+	if nil != err {
+		if aLinked {
+			_ = os.Remove(aName)
 		}
-		go goMonitorPool()
-	})
+		return nil, err
+	}
+	conn.SetMaxOpenConns(poolMaxConns())
+	conn.SetMaxIdleConns(poolMaxConns())
+	conn.SetConnMaxIdleTime(poolMaxIdleTime)
+	if err = conn.PingContext(aContext); nil != err {
+		_ = conn.Close()
+		if aLinked {
+			_ = os.Remove(aName)
+		}
+		return nil, err
+	}
+	go goSQLtrace(`-- opened DB snapshot`, time.Now())
+
+	result := &tSnapshot{
+		cache: newQueryCache(),
+		conn:  conn,
+		model: rmReadyConn(aContext, conn),
+		sMtx:  new(sync.Mutex),
+		stmts: make(map[string]*sql.Stmt, 63),
+	}
+	if aLinked {
+		result.file = aName
+	}
 
-	return pConnPool
-} // newPool()
+	return result, nil
+} // poolOpen()
 
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
-// `clear()` empties the list.
+// `acquire()` returns the handle of the current database copy,
+// opening it if necessary.
 //
-// All connections are closed.
-func (p *tDBpool) clear() *tDBpool {
+// Each handle returned must be given back by calling `release()`.
+//
+//	`aContext` The current request's context.
+func (p *tDBpool) acquire(aContext context.Context) (*tSnapshot, error) {
 	if nil == p {
-		return p
+		return nil, errors.New(`'tDBpool' object uninitialised`)
+	}
+	if err := aContext.Err(); nil != err {
+		return nil, err
 	}
 	p.pMtx.Lock()
 	defer p.pMtx.Unlock()
 
-	for idx, conn := range p.pList {
-		if nil != conn {
-			_ = conn.Close()
+	if nil == p.current {
+		snap, err := p.open(aContext)
+		if nil != err {
+			return nil, err
 		}
-		p.pList[idx] = nil // clear reference
+		p.current = snap
 	}
-	p.pList = p.pList[:0] // empty the list
+	p.current.refs++
+
+	return p.current, nil
+} // acquire()
 
-	return p
-} // clear()
+// `filename()` returns the path-/filename of the library's database copy.
+func (p *tDBpool) filename() string {
+	return filepath.Join(p.lib.cachePath, dbCalibreDatabaseFilename)
+} // filename()
 
-// `get()` selects a single database connection from the list, removes it
-// from the Pool, and returns it to the caller.
+// `link()` returns the name of a new hard link of the library's
+// database copy.
 //
-// Callers should not assume any relation between values passed to `Put()`
-// and the values returned by `get()`.
+// The links left by a previous run are removed first.
+func (p *tDBpool) link() (string, error) {
+	name := p.filename()
+	p.cOnce.Do(func() {
+		if list, err := filepath.Glob(name + `@*`); nil == err {
+			for _, fName := range list {
+				_ = os.Remove(fName)
+			}
+		}
+	})
+	result := fmt.Sprintf("%s@%d", name, atomic.AddUint64(&p.gen, 1))
+	_ = os.Remove(result)
+
+	return result, os.Link(name, result)
+} // link()
+
+// `open()` returns a new handle of the library's database copy
+// using a link of its own.
+//
+// If the link can't be created the copy is opened by its (shared)
+// name without the `immutable` flag.
 //
 //	`aContext` The current request's context.
-func (p *tDBpool) get(aContext context.Context) (rConn *sql.DB, rErr error) {
-	if nil == p {
-		rErr = errors.New(`'tDBpool' object uninitialised`)
+func (p *tDBpool) open(aContext context.Context) (*tSnapshot, error) {
+	if _, err := os.Stat(p.filename()); nil != err {
+		return nil, err
+	}
+	name, err := p.link()
+	if nil != err {
+		apachelogger.Err("tDBpool.open()", fmt.Sprintf("%v", err))
+		return poolOpen(aContext, p.filename(), false)
+	}
+
+	return poolOpen(aContext, name, true)
+} // open()
+
+// `release()` hands back `aSnapshot`, closing it if it was replaced
+// by a newer copy and isn't used anymore.
+//
+//	`aSnapshot` The handle returned by `acquire()`.
+func (p *tDBpool) release(aSnapshot *tSnapshot) {
+	if (nil == p) || (nil == aSnapshot) {
 		return
 	}
 	p.pMtx.Lock()
 	defer p.pMtx.Unlock()
 
-	// There are three cases to consider:
-	//
-	// (1) the list is empty,
-	// (2) the list has one entry,
-	// (3) the list has more than one entry.
-	//
-	// We unroll these cases here to handle each most efficiently.
-
-	sLen := len(p.pList)
-	if 0 == sLen { // case (1)
-
-		//XXX Are there custom functions to inject?
-
-		// `cache=shared` is essential to avoid running out of file
-		// handles since each query seems to hold its own file handle.
-		// `loc=auto` gets time.Time with current locale.
-		// `mode=ro` is self-explanatory since we don't change the DB
-		// in any way.
-		dsn := `file:` +
-			filepath.Join(dbCalibreCachePath, dbCalibreDatabaseFilename) +
-			`?cache=shared&case_sensitive_like=1&immutable=0&loc=auto&mode=ro&query_only=1`
-
-		select {
-		case <-aContext.Done():
-			rErr = aContext.Err()
-
-		default:
-			if rConn, rErr = sql.Open(`sqlite3`, dsn); nil == rErr {
-				// rConn.Exec("PRAGMA xxx=yyy")
-				go goSQLtrace(`-- opened DB connection`, time.Now()) //REMOVE
-				rErr = rConn.PingContext(aContext)
-			}
-		}
-
-		return
+	aSnapshot.refs--
+	if aSnapshot.retired && (0 >= aSnapshot.refs) {
+		p.retire(aSnapshot)
 	}
+} // release()
 
-	select {
-	case <-aContext.Done():
-		rErr = aContext.Err()
+// `renew()` switches to a handle of the (new) current database copy.
+//
+// The previous handle is closed as soon as all queries using it
+// are finished.
+// If the new copy can't be opened the previous handle is kept.
+func (p *tDBpool) renew() error {
+	if nil == p {
+		return errors.New(`'tDBpool' object uninitialised`)
+	}
+	snap, err := p.open(context.Background())
+	if nil != err {
+		apachelogger.Err("tDBpool.renew()", fmt.Sprintf("%v", err))
+		return err
+	}
 
-	default:
-		rConn = p.pList[0]
-		p.pList[0] = nil // remove reference
+	p.pMtx.Lock()
+	defer p.pMtx.Unlock()
 
-		if 1 == sLen { // case (2)
-			p.pList = p.pList[:0] // empty the list
-		} else { // case (3)
-			p.pList = p.pList[1:] // remove first item from list
+	if old := p.current; nil != old {
+		old.retired = true
+		if 0 >= old.refs {
+			p.retire(old)
 		}
-		go goSQLtrace(`-- reusing DB connection`, time.Now()) //REMOVE
 	}
+	p.current = snap
 
-	return
-} // get()
+	return nil
+} // renew()
 
-// `put()` adds `aConnection` to the list.
+// `retire()` closes `aSnapshot` in background.
 //
-//	`aConnection` The database connection to add to the pool.
-func (p *tDBpool) put(aConnection *sql.DB) *tDBpool {
-	if nil == p {
-		return p
+// NOTE: The caller is expected to hold the lock.
+//
+//	`aSnapshot` The handle to close.
+func (p *tDBpool) retire(aSnapshot *tSnapshot) {
+	if nil == aSnapshot.conn {
+		return
 	}
-	p.pMtx.Lock()
-	defer p.pMtx.Unlock()
+	conn, file, stmts := aSnapshot.conn, aSnapshot.file, aSnapshot.stmts
+	aSnapshot.cache, aSnapshot.conn, aSnapshot.stmts = nil, nil, nil
+	go func() {
+		for _, stmt := range stmts {
+			_ = stmt.Close()
+		}
+		_ = conn.Close()
+		if 0 < len(file) {
+			_ = os.Remove(file)
+		}
+		goSQLtrace(`-- closed DB snapshot`, time.Now())
+	}()
+} // retire()
 
-	if nil != aConnection {
-		p.pList = append(p.pList, aConnection)
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
-		go goSQLtrace(fmt.Sprintf(
-			"-- recycling DB connection %d", len(p.pList)),
-			time.Now()) //FIXME REMOVE
+// `prepare()` returns the prepared statement of `aQuery`, preparing
+// it on first use.
+//
+//	`aContext` The current request's context.
+//	`aQuery` The SQL query to prepare.
+func (s *tSnapshot) prepare(aContext context.Context, aQuery string) (*sql.Stmt, error) {
+	s.sMtx.Lock()
+	defer s.sMtx.Unlock()
+
+	if stmt, ok := s.stmts[aQuery]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.conn.PrepareContext(aContext, aQuery)
+	if nil != err {
+		return nil, err
 	}
+	s.stmts[aQuery] = stmt
 
-	return p
-} // put()
+	return stmt, nil
+} // prepare()
 
 /* _EoF_ */
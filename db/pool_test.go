@@ -1,21 +1,22 @@
 /*
-   Copyright © 2020 M.Watermann, 10247 Berlin, Germany
+   Copyright © 2020, 2024 M.Watermann, 10247 Berlin, Germany
                   All rights reserved
                EMail : <support@mwat.de>
 */
 
 package db
 
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
 import (
 	"context"
 	"crypto/md5"
-	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
-	"reflect"
 	"sync"
 	"testing"
+	"time"
 )
 
 func prepDBforTesting(aContext context.Context) {
@@ -28,102 +29,155 @@ func prepDBforTesting(aContext context.Context) {
 	_, _ = OpenDatabase(aContext)
 } // prepDBforTesting()
 
-func Test_tDBpool_clear(t *testing.T) {
-	ctx := context.Background()
-	prepDBforTesting(ctx)
+// `prepPoolForTesting()` returns a new pool using a database copy
+// with `aBooks` entries in a temporary cache directory.
+func prepPoolForTesting(t *testing.T, aBooks int) *tDBpool {
+	pool := prepLibraryForTesting(t).pool
+	setPoolBooks(t, pool, aBooks)
 
-	type fields struct {
-		pList tDBlist
-		pMtx  *sync.Mutex
-	}
-	tests := []struct {
-		name   string
-		fields *tDBpool
-		want   *tDBpool
-	}{
-		// TODO: Add test cases.
-		{" 0", nil, nil},
-		{" 1", pConnPool, pConnPool},
-		{" 2", pConnPool, pConnPool},
+	return pool
+} // prepPoolForTesting()
+
+// `setPoolBooks()` replaces the database copy of `aPool` by one with
+// `aBooks` entries (the same way `syncDatabaseFile()` does).
+func setPoolBooks(t *testing.T, aPool *tDBpool, aBooks int) {
+	name := aPool.filename()
+	_ = os.Remove(name + `~`)
+	prepCalibreDB(t, name+`~`)
+	for id := 1; id <= aBooks; id++ {
+		prepCalibreDB(t, name+`~`, fmt.Sprintf("INSERT INTO books (id, title) VALUES (%d, 'book')", id))
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := tt.fields
-			if got := p.clear(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("tDBpool.clear() = %v, want %v", got, tt.want)
-			}
-		})
+	if err := os.Rename(name+`~`, name); nil != err {
+		t.Fatal(err)
 	}
-} // Test_tDBpool_clear()
+} // setPoolBooks()
 
-func Test_tDBpool_get(t *testing.T) {
+func Test_tDBpool_acquire(t *testing.T) {
 	ctx := context.Background()
-	prepDBforTesting(ctx)
+	pool := prepPoolForTesting(t, 1)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
 
-	type args struct {
-		aContext context.Context
-	}
 	tests := []struct {
 		name     string
-		fields   *tDBpool
-		args     args
-		wantRnil bool
+		pool     *tDBpool
+		aContext context.Context
 		wantErr  bool
 	}{
 		// TODO: Add test cases.
-		{" 0", nil, args{ctx}, true, true},
-		{" 1", pConnPool, args{ctx}, false, false},
-		{" 2", pConnPool, args{ctx}, false, false},
+		{" 0", nil, ctx, true},
+		{" 1", pool, ctx, false},
+		{" 2", pool, ctx, false},
+		{" 3", pool, canceled, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := tt.fields
-			gotRConn, err := p.get(tt.args.aContext)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("tDBpool.get() error = %v, wantErr %v",
-					err, tt.wantErr)
+			got, err := tt.pool.acquire(tt.aContext)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("tDBpool.acquire() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if nil != gotRConn {
-				if tt.wantRnil {
-					t.Errorf("tDBpool.get() = %v, want NIL", gotRConn)
-				}
-			} else {
-				if !tt.wantRnil {
-					t.Errorf("tDBpool.get() = %v, want !NIL", nil)
-				}
+			if (nil != got) && (got != pool.current) {
+				t.Errorf("tDBpool.acquire() = %p, want %p", got, pool.current)
 			}
 		})
 	}
-} // Test_tDBpool_get()
+	if 2 != pool.current.refs {
+		t.Errorf("tDBpool.acquire() refs = %d, want 2", pool.current.refs)
+	}
+} // Test_tDBpool_acquire()
 
-func Test_tDBpool_put(t *testing.T) {
+func Test_tDBpool_renew(t *testing.T) {
 	ctx := context.Background()
-	prepDBforTesting(ctx)
+	pool := prepPoolForTesting(t, 1)
+	countBooks := func(aSnap *tSnapshot) (rCount int, rErr error) {
+		rErr = aSnap.conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM books`).Scan(&rCount)
+		return
+	}
 
-	var conn1 *sql.DB
-	conn2, _ := pConnPool.get(ctx)
+	old, err := pool.acquire(ctx)
+	if nil != err {
+		t.Fatalf("tDBpool.acquire() error = %v", err)
+	}
+	setPoolBooks(t, pool, 3)
+	if err = pool.renew(); nil != err {
+		t.Fatalf("tDBpool.renew() error = %v", err)
+	}
 
-	type args struct {
-		aConnection *sql.DB
+	// The old handle keeps working until it's released (even
+	// with newly opened connections) …
+	old.conn.SetMaxIdleConns(0)
+	if count, err := countBooks(old); (1 != count) || (nil != err) {
+		t.Errorf("old snapshot = %d, %v, want 1, nil", count, err)
 	}
-	tests := []struct {
-		name   string
-		fields *tDBpool
-		args   args
-		want   *tDBpool
-	}{
-		// TODO: Add test cases.
-		{" 0", nil, args{conn1}, nil},
-		{" 1", pConnPool, args{conn1}, pConnPool},
-		{" 2", pConnPool, args{conn2}, pConnPool},
+	conn, file := old.conn, old.file
+	if _, err = os.Stat(file); (0 == len(file)) || (nil != err) {
+		t.Errorf("old snapshot file = %q, %v, want existing link", file, err)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := tt.fields
-			if got := p.put(tt.args.aConnection); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("tDBpool.put() = %v, want %v", got, tt.want)
+	// … while new users get the new copy:
+	snap, _ := pool.acquire(ctx)
+	if count, err := countBooks(snap); (3 != count) || (nil != err) {
+		t.Errorf("new snapshot = %d, %v, want 3, nil", count, err)
+	}
+	pool.release(snap)
+
+	pool.release(old)
+	if nil != old.conn {
+		t.Errorf("tDBpool.release() didn't retire the old snapshot")
+	}
+	for i := 0; i < 50; i++ {
+		if err = conn.PingContext(ctx); nil != err {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if nil == err {
+		t.Errorf("tDBpool.release() didn't close the old snapshot")
+	}
+	for i := 0; i < 50; i++ {
+		if _, err = os.Stat(file); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !os.IsNotExist(err) {
+		t.Errorf("tDBpool.release() didn't remove %q", file)
+	}
+	if (nil == pool.current.conn) || (0 != pool.current.refs) {
+		t.Errorf("tDBpool.current = %v, want open and unused", pool.current)
+	}
+} // Test_tDBpool_renew()
+
+func Test_tDBpool_concurrent(t *testing.T) {
+	ctx := context.Background()
+	pool := prepPoolForTesting(t, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(aNum int) {
+			defer wg.Done()
+			snap, err := pool.acquire(ctx)
+			if nil != err {
+				t.Errorf("tDBpool.acquire() error = %v", err)
+				return
 			}
-		})
+			defer pool.release(snap)
+			var count int
+			if err = snap.conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM books`).Scan(&count); nil != err {
+				t.Errorf("query error = %v", err)
+			}
+			if 0 == aNum%16 {
+				_ = pool.renew()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if 0 != pool.current.refs {
+		t.Errorf("tDBpool refs = %d, want 0", pool.current.refs)
+	}
+	if stats := pool.current.conn.Stats(); stats.InUse != 0 {
+		t.Errorf("tDBpool connections in use = %d, want 0", stats.InUse)
 	}
-}
+} // Test_tDBpool_concurrent()
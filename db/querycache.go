@@ -0,0 +1,249 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides a size limited cache of query results.
+ *
+ * Each database copy has its own cache so activating a new copy
+ * invalidates all cached results at once.
+ */
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// TQueryCacheStats holds the usage counters of the query cache.
+	TQueryCacheStats struct {
+		Entries int    // number of cached results
+		Hits    uint64 // number of results served from the cache
+		MaxSize int64  // max. (estimated) memory size of the cache
+		Misses  uint64 // number of results read from the database
+		Size    int64  // (estimated) memory size of the cached results
+	}
+
+	// `tQueryCache` is a LRU cache of query results.
+	tQueryCache struct {
+		entries map[string]*list.Element // elements by key
+		lru     *list.List               // least recently used last
+		mtx     *sync.Mutex              // guard for the cache
+		size    int64                    // (estimated) memory size
+	}
+
+	// `tQueryCacheEntry` is a single result cached.
+	tQueryCacheEntry struct {
+		count int      // number of matching documents
+		key   string   // the result's key
+		list  TDocList // the page of documents
+		size  int64    // (estimated) memory size
+	}
+)
+
+var (
+	// Number of results served from the cache.
+	qcHits uint64
+
+	// Number of results read from the database.
+	qcMisses uint64
+
+	// Max. (estimated) memory size of a query cache.
+	qcMaxSize int64 = 32 << 20 // 32 MB
+)
+
+// `newQueryCache()` returns a new, empty query cache.
+func newQueryCache() *tQueryCache {
+	return &tQueryCache{
+		entries: make(map[string]*list.Element, 127),
+		lru:     list.New(),
+		mtx:     new(sync.Mutex),
+	}
+} // newQueryCache()
+
+// `qcDocSize()` returns the estimated memory size of `aDoc`.
+//
+//	`aDoc` The document to check.
+func qcDocSize(aDoc *TDocument) int64 {
+	entities := func(aList *TEntityList) (rSize int) {
+		if nil != aList {
+			for _, ent := range *aList {
+				rSize += 48 + len(ent.Name) + len(ent.URL)
+			}
+		}
+		return
+	}
+	result := 320 + len(aDoc.authorSort) + len(aDoc.comments) +
+		len(aDoc.ISBN) + len(aDoc.lccn) + len(aDoc.path) +
+		len(aDoc.Title) + len(aDoc.titleSort) + len(aDoc.uuid) +
+		entities(aDoc.authors) + entities(aDoc.formats) +
+		entities(aDoc.identifiers) + entities(aDoc.languages) +
+		entities(aDoc.tags)
+	if nil != aDoc.publisher {
+		result += 48 + len(aDoc.publisher.Name)
+	}
+	if nil != aDoc.series {
+		result += 48 + len(aDoc.series.Name)
+	}
+
+	return int64(result)
+} // qcDocSize()
+
+// `qcKey()` returns the cache key of a query.
+//
+//	`aOptions` The options of the query.
+//	`aWhere` The query's condition.
+//	`aArgs` The arguments of `aWhere`.
+func qcKey(aOptions *TQueryOptions, aWhere string, aArgs []interface{}) string {
+	return fmt.Sprintf("%d|%t|%d|%d|%d|%v|%s", aOptions.Layout,
+		aOptions.Descending, aOptions.SortBy, aOptions.LimitStart,
+		aOptions.LimitLength, aArgs, aWhere)
+} // qcKey()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `get()` returns the result cached for `aKey`.
+//
+// The list returned is a copy of the cached one.
+//
+//	`aKey` The key of the query.
+func (qc *tQueryCache) get(aKey string) (int, *TDocList, bool) {
+	if nil == qc {
+		return 0, nil, false
+	}
+	qc.mtx.Lock()
+	defer qc.mtx.Unlock()
+
+	elem, ok := qc.entries[aKey]
+	if !ok {
+		atomic.AddUint64(&qcMisses, 1)
+		return 0, nil, false
+	}
+	atomic.AddUint64(&qcHits, 1)
+	qc.lru.MoveToFront(elem)
+	entry := elem.Value.(*tQueryCacheEntry)
+	if nil == entry.list {
+		return entry.count, nil, true
+	}
+	result := make(TDocList, len(entry.list))
+	copy(result, entry.list)
+
+	return entry.count, &result, true
+} // get()
+
+// `len()` returns the number of cached results and their size.
+func (qc *tQueryCache) len() (int, int64) {
+	if nil == qc {
+		return 0, 0
+	}
+	qc.mtx.Lock()
+	defer qc.mtx.Unlock()
+
+	return len(qc.entries), qc.size
+} // len()
+
+// `put()` adds a query's result to the cache, removing the least
+// recently used results if the cache gets too big.
+//
+//	`aKey` The key of the query.
+//	`aCount` The number of matching documents.
+//	`aList` The page of documents to cache.
+func (qc *tQueryCache) put(aKey string, aCount int, aList *TDocList) {
+	if nil == qc {
+		return
+	}
+	entry := &tQueryCacheEntry{
+		count: aCount,
+		key:   aKey,
+		size:  int64(128 + len(aKey)),
+	}
+	if nil != aList {
+		entry.list = make(TDocList, len(*aList))
+		copy(entry.list, *aList)
+		for idx := range entry.list {
+			entry.size += qcDocSize(&entry.list[idx])
+		}
+	}
+	limit := atomic.LoadInt64(&qcMaxSize)
+	if entry.size > (limit >> 3) {
+		return // don't let a single result flush the cache
+	}
+
+	qc.mtx.Lock()
+	defer qc.mtx.Unlock()
+
+	if elem, ok := qc.entries[aKey]; ok {
+		qc.remove(elem)
+	}
+	qc.entries[aKey] = qc.lru.PushFront(entry)
+	qc.size += entry.size
+	for (qc.size > limit) && (0 < qc.lru.Len()) {
+		qc.remove(qc.lru.Back())
+	}
+} // put()
+
+// `remove()` deletes `aElement` from the cache.
+//
+// NOTE: The caller is expected to hold the lock.
+//
+//	`aElement` The cache element to remove.
+func (qc *tQueryCache) remove(aElement *list.Element) {
+	entry := qc.lru.Remove(aElement).(*tQueryCacheEntry)
+	delete(qc.entries, entry.key)
+	qc.size -= entry.size
+} // remove()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// QueryCacheStats returns the usage counters of the query cache.
+//
+// The number of entries and their size are summed up for all
+// libraries while `MaxSize` is the limit of each library's cache.
+func QueryCacheStats() TQueryCacheStats {
+	result := TQueryCacheStats{
+		Hits:    atomic.LoadUint64(&qcHits),
+		MaxSize: atomic.LoadInt64(&qcMaxSize),
+		Misses:  atomic.LoadUint64(&qcMisses),
+	}
+	for _, lib := range Libraries() {
+		lib.pool.pMtx.Lock()
+		if nil != lib.pool.current {
+			entries, size := lib.pool.current.cache.len()
+			result.Entries += entries
+			result.Size += size
+		}
+		lib.pool.pMtx.Unlock()
+	}
+
+	return result
+} // QueryCacheStats()
+
+// HitRate returns the percentage of results served from the cache.
+func (qs TQueryCacheStats) HitRate() float64 {
+	if total := qs.Hits + qs.Misses; 0 < total {
+		return float64(qs.Hits) * 100 / float64(total)
+	}
+
+	return 0
+} // HitRate()
+
+// SetQueryCacheSize sets the max. (estimated) memory size of the
+// query cache; a value of zero disables caching.
+//
+//	`aSize` The max. cache size in bytes.
+func SetQueryCacheSize(aSize int64) {
+	if 0 > aSize {
+		aSize = 0
+	}
+	atomic.StoreInt64(&qcMaxSize, aSize)
+} // SetQueryCacheSize()
+
+/* _EoF_ */
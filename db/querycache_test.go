@@ -0,0 +1,144 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_having(t *testing.T) {
+	tests := []struct {
+		name     string
+		aEntity  string
+		aID      TID
+		wantArgs []interface{}
+	}{
+		// TODO: Add test cases.
+		{" 1", ``, 1, nil},
+		{" 2", `all`, 1, nil},
+		{" 3", `authors`, 0, nil},
+		{" 4", `unknown`, 1, nil},
+		{" 5", `authors`, 7, []interface{}{7}},
+		{" 6", `tags`, 3, []interface{}{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotArgs := having(tt.aEntity, tt.aID)
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("having() args = %v, want %v", gotArgs, tt.wantArgs)
+			}
+			if (nil == tt.wantArgs) != (0 == len(got)) {
+				t.Errorf("having() = %q", got)
+			}
+		})
+	}
+} // Test_having()
+
+func Test_tQueryCache(t *testing.T) {
+	maxSize := atomic.LoadInt64(&qcMaxSize)
+	defer SetQueryCacheSize(maxSize)
+
+	doc := NewDocument()
+	doc.ID, doc.Title = 1, `one`
+	page := &TDocList{*doc}
+	docSize := 128 + qcDocSize(doc)
+	SetQueryCacheSize((docSize + 16) * 8 * 2) // room for a few small entries
+
+	qc := newQueryCache()
+	hits, misses := atomic.LoadUint64(&qcHits), atomic.LoadUint64(&qcMisses)
+	if _, _, ok := qc.get(`k1`); ok {
+		t.Fatalf("tQueryCache.get() on empty cache = true")
+	}
+	qc.put(`k1`, 10, page)
+	(*page)[0].Title = `changed` // the cache must keep its own copy
+
+	count, list, ok := qc.get(`k1`)
+	if (!ok) || (10 != count) || (`one` != (*list)[0].Title) {
+		t.Fatalf("tQueryCache.get() = %d, %v, %v", count, list, ok)
+	}
+	(*list)[0].Title = `changed` // as must the caller
+	if _, list, _ = qc.get(`k1`); `one` != (*list)[0].Title {
+		t.Errorf("tQueryCache.get() returned the cached list")
+	}
+	if h, m := atomic.LoadUint64(&qcHits)-hits, atomic.LoadUint64(&qcMisses)-misses; (2 != h) || (1 != m) {
+		t.Errorf("tQueryCache counters = %d hits, %d misses, want 2, 1", h, m)
+	}
+
+	// Adding more entries than fit removes the least recently used:
+	qc.put(`k2`, 0, nil)
+	_, _, _ = qc.get(`k1`)
+	for i := 3; i < 200; i++ {
+		qc.put(fmt.Sprintf("k%d", i), 0, nil)
+		_, _, _ = qc.get(`k1`)
+	}
+	if _, _, ok = qc.get(`k2`); ok {
+		t.Errorf("tQueryCache.put() didn't remove the oldest entry")
+	}
+	if _, _, ok = qc.get(`k1`); !ok {
+		t.Errorf("tQueryCache.put() removed a recently used entry")
+	}
+	if entries, size := qc.len(); (0 == entries) || (size > atomic.LoadInt64(&qcMaxSize)) {
+		t.Errorf("tQueryCache.len() = %d, %d, max. %d", entries, size, qcMaxSize)
+	}
+
+	// Caching can be switched off:
+	SetQueryCacheSize(0)
+	qc.put(`k0`, 1, nil)
+	if _, _, ok = qc.get(`k0`); ok {
+		t.Errorf("tQueryCache.put() with size 0 cached a result")
+	}
+} // Test_tQueryCache()
+
+func Test_tSnapshot_prepare(t *testing.T) {
+	ctx := context.Background()
+	pool := prepPoolForTesting(t, 2)
+	snap, err := pool.acquire(ctx)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer pool.release(snap)
+
+	stmt1, err := snap.prepare(ctx, `SELECT COUNT(*) FROM books WHERE id > ?`)
+	if nil != err {
+		t.Fatalf("tSnapshot.prepare() error = %v", err)
+	}
+	stmt2, _ := snap.prepare(ctx, `SELECT COUNT(*) FROM books WHERE id > ?`)
+	if stmt1 != stmt2 {
+		t.Errorf("tSnapshot.prepare() didn't reuse the statement")
+	}
+	var count int
+	if err = stmt1.QueryRowContext(ctx, 1).Scan(&count); (nil != err) || (1 != count) {
+		t.Errorf("statement = %d, %v, want 1, nil", count, err)
+	}
+	if _, err = snap.prepare(ctx, `SELECT nothing FROM nowhere`); nil == err {
+		t.Errorf("tSnapshot.prepare() error = nil, want error")
+	}
+} // Test_tSnapshot_prepare()
+
+func Test_tDBpool_renewCache(t *testing.T) {
+	ctx := context.Background()
+	pool := prepPoolForTesting(t, 1)
+	snap, _ := pool.acquire(ctx)
+	snap.cache.put(`key`, 1, nil)
+	pool.release(snap)
+
+	setPoolBooks(t, pool, 2)
+	if err := pool.renew(); nil != err {
+		t.Fatal(err)
+	}
+	snap, _ = pool.acquire(ctx)
+	defer pool.release(snap)
+	if _, _, ok := snap.cache.get(`key`); ok {
+		t.Errorf("tDBpool.renew() kept the cached results")
+	}
+} // Test_tDBpool_renewCache()
@@ -265,8 +265,10 @@ func (qo *TQueryOptions) SelectThemeOptions() *TStringMap {
 
 // SelectVirtLibOptions returns a list of SELECT/OPTIONs
 // for the virtual library choice.
-func (qo *TQueryOptions) SelectVirtLibOptions() string {
-	return VirtLibOptions(qo.VirtLib) // see `metadata.go`
+//
+//	`aLibrary` The library whose virtual libraries to list.
+func (qo *TQueryOptions) SelectVirtLibOptions(aLibrary *TLibrary) string {
+	return aLibrary.VirtLibOptions(qo.VirtLib) // see `metadata.go`
 } // SelectVirtLibOptions()
 
 // String returns the options as a `|` delimited string.
@@ -379,7 +381,7 @@ func (qo *TQueryOptions) Update(aRequest *http.Request) *TQueryOptions {
 		// Explicitly given matches have priority over library:
 		if 0 < len(qo.Matching) {
 			qo.VirtLib = ``
-		} else if vlList, err := VirtualLibraryList(); nil == err {
+		} else if vlList, err := ContextLibrary(aRequest.Context()).VirtualLibraryList(); nil == err {
 			if vld, ok := vlList[vl]; ok {
 				qo.Matching = vld
 			}
@@ -0,0 +1,351 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the timing of the SQL queries.
+ *
+ * Each query runs with an (optional) timeout; its duration and the
+ * number of rows read are written to the SQL trace file, and slow
+ * queries are logged together with their query plan.
+ * The timings are aggregated per "query shape" (i.e. the query with
+ * all literal values replaced by `?`) for the whole application as
+ * well as for a single web request.
+ */
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mwat56/apachelogger"
+)
+
+type (
+	// TQueryTiming holds the aggregated timings of a query shape.
+	TQueryTiming struct {
+		Count    uint64        // number of queries run
+		ID       string        // short identifier of the shape
+		Max      time.Duration // longest duration
+		Rows     uint64        // number of rows read
+		Shape    string        // the query with literals replaced
+		Slow     uint64        // number of slow queries
+		Timeouts uint64        // number of queries timed out
+		Total    time.Duration // sum of all durations
+	}
+
+	// `tQueryTimings` aggregates the timings per query shape.
+	tQueryTimings struct {
+		mtx    *sync.Mutex              // guard for `shapes`
+		shapes map[string]*TQueryTiming // timings by shape ID
+	}
+
+	// `tRows` wraps the rows of a query to time it.
+	tRows struct {
+		*sql.Rows
+		args    []interface{}      // the query's arguments
+		cancel  context.CancelFunc // releases the query's timeout
+		conn    *sql.DB            // the connection used by the query
+		ctx     context.Context    // the query's context
+		done    bool               // flag whether the query finished
+		query   string             // the SQL query run
+		request *tQueryTimings     // the current request's timings
+		rows    int                // number of rows read
+		start   time.Time          // the query's start time
+	}
+
+	// `tTimingKey` is the context key of a request's timings.
+	tTimingKey struct{}
+)
+
+var (
+	// The timings of all queries run.
+	qtAll = newQueryTimings()
+
+	// Regular expression matching literal values in a query.
+	qtLiteralRE = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^']|'')*'|\b\d+(?:\.\d+)?\b`)
+
+	// Duration (in nanoseconds) after which a query gets logged.
+	qtSlow int64 = int64(time.Millisecond * 250)
+
+	// Max. duration (in nanoseconds) of a single query.
+	qtTimeout int64 = int64(time.Second * 10)
+
+	// Regular expression matching whitespace.
+	qtWhitespaceRE = regexp.MustCompile(`\s+`)
+)
+
+// `newQueryTimings()` returns a new, empty timings list.
+func newQueryTimings() *tQueryTimings {
+	return &tQueryTimings{
+		mtx:    new(sync.Mutex),
+		shapes: make(map[string]*TQueryTiming, 31),
+	}
+} // newQueryTimings()
+
+// `qtShape()` returns the shape of `aQuery` and its ID.
+//
+//	`aQuery` The SQL query to normalise.
+func qtShape(aQuery string) (rShape, rID string) {
+	rShape = qtWhitespaceRE.ReplaceAllString(
+		qtLiteralRE.ReplaceAllString(aQuery, `?`), ` `)
+	rShape = strings.TrimSpace(rShape)
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(rShape))
+	rID = fmt.Sprintf("sql-%08x", hash.Sum32())
+
+	return
+} // qtShape()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `add()` adds a query's timing to the list.
+//
+//	`aShape` The query's shape.
+//	`aID` The shape's ID.
+//	`aDuration` The query's duration.
+//	`aRows` The number of rows read.
+//	`aSlow` Flag whether the query was slow.
+//	`aTimeout` Flag whether the query timed out.
+func (qt *tQueryTimings) add(aShape, aID string, aDuration time.Duration, aRows int, aSlow, aTimeout bool) {
+	if nil == qt {
+		return
+	}
+	qt.mtx.Lock()
+	defer qt.mtx.Unlock()
+
+	timing, ok := qt.shapes[aID]
+	if !ok {
+		timing = &TQueryTiming{ID: aID, Shape: aShape}
+		qt.shapes[aID] = timing
+	}
+	timing.Count++
+	timing.Rows += uint64(aRows) // #nosec G115
+	timing.Total += aDuration
+	if aDuration > timing.Max {
+		timing.Max = aDuration
+	}
+	if aSlow {
+		timing.Slow++
+	}
+	if aTimeout {
+		timing.Timeouts++
+	}
+} // add()
+
+// `list()` returns the timings sorted by total duration (longest
+// first).
+func (qt *tQueryTimings) list() []TQueryTiming {
+	if nil == qt {
+		return nil
+	}
+	qt.mtx.Lock()
+	result := make([]TQueryTiming, 0, len(qt.shapes))
+	for _, timing := range qt.shapes {
+		result = append(result, *timing)
+	}
+	qt.mtx.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total == result[j].Total {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].Total > result[j].Total
+	})
+
+	return result
+} // list()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// Average returns the average duration of the shape's queries.
+func (qt TQueryTiming) Average() time.Duration {
+	if 0 == qt.Count {
+		return 0
+	}
+
+	return qt.Total / time.Duration(qt.Count) // #nosec G115
+} // Average()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `newRows()` starts timing a query returning the context to run it.
+//
+// The returned context is limited by the current query timeout;
+// the returned rows' `finish()` method must be called once the
+// query is run.
+//
+//	`aContext` The current request's context.
+//	`aConn` The connection to use.
+//	`aQuery` The SQL query to run.
+//	`aArgs` The arguments of the query's placeholders.
+func newRows(aContext context.Context, aConn *sql.DB, aQuery string, aArgs []interface{}) (*tRows, context.Context) {
+	result := &tRows{
+		args:   aArgs,
+		cancel: func() {},
+		conn:   aConn,
+		ctx:    aContext,
+		query:  aQuery,
+		start:  time.Now(),
+	}
+	if request, ok := aContext.Value(tTimingKey{}).(*tQueryTimings); ok {
+		result.request = request
+	}
+	if timeout := time.Duration(atomic.LoadInt64(&qtTimeout)); 0 < timeout {
+		result.ctx, result.cancel = context.WithTimeout(aContext, timeout)
+	}
+
+	return result, result.ctx
+} // newRows()
+
+// Close closes the rows and records the query's timing.
+func (r *tRows) Close() error {
+	if r.done {
+		return nil
+	}
+	err := r.Rows.Close()
+	r.finish(err)
+
+	return err
+} // Close()
+
+// `explain()` returns the query plan of the query (one line per
+// step, separated by semicolons).
+func (r *tRows) explain() string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second<<2)
+	defer cancel()
+
+	rows, err := r.conn.QueryContext(ctx, `EXPLAIN QUERY PLAN `+r.query, r.args...)
+	if nil != err {
+		return fmt.Sprintf("no query plan: %v", err)
+	}
+	defer rows.Close()
+
+	var plan strings.Builder
+	for rows.Next() {
+		var (
+			id, parent, notused int
+			detail              string
+		)
+		if err = rows.Scan(&id, &parent, &notused, &detail); nil == err {
+			if 0 < plan.Len() {
+				plan.WriteString(`; `)
+			}
+			fmt.Fprintf(&plan, "%d|%d|%s", id, parent, detail)
+		}
+	}
+
+	return plan.String()
+} // explain()
+
+// `finish()` records the timing of the query.
+//
+//	`aErr` The query's error (if any).
+func (r *tRows) finish(aErr error) {
+	if r.done {
+		return
+	}
+	r.done = true
+	duration := time.Since(r.start)
+	timedOut := errors.Is(r.ctx.Err(), context.DeadlineExceeded)
+	r.cancel()
+
+	slowest := time.Duration(atomic.LoadInt64(&qtSlow))
+	slow := (0 < slowest) && (duration >= slowest)
+	shape, id := qtShape(r.query)
+	qtAll.add(shape, id, duration, r.rows, slow, timedOut)
+	r.request.add(shape, id, duration, r.rows, slow, timedOut)
+
+	go goSQLtrace(fmt.Sprintf("%s -- %v, %d rows", r.query, duration, r.rows), r.start)
+	if timedOut {
+		apachelogger.Err("db.tRows.finish()",
+			fmt.Sprintf("query %s timed out after %v", id, duration))
+	} else if slow {
+		go func() {
+			plan := r.explain()
+			apachelogger.Err("db.tRows.finish()",
+				fmt.Sprintf("slow query %s (%v, %d rows): %s -- plan: %s", id, duration, r.rows, shape, plan))
+			goSQLtrace(`-- slow query `+id+`: `+plan, time.Now())
+		}()
+	} else if nil != aErr {
+		apachelogger.Err("db.tRows.finish()",
+			fmt.Sprintf("query %s: %v", id, aErr))
+	}
+} // finish()
+
+// Next prepares the next result row, counting the rows read.
+func (r *tRows) Next() bool {
+	if r.Rows.Next() {
+		r.rows++
+		return true
+	}
+
+	return false
+} // Next()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// NewTimingContext returns a copy of `aContext` which collects the
+// timings of all queries run with it.
+//
+//	`aContext` The current request's context.
+func NewTimingContext(aContext context.Context) context.Context {
+	return context.WithValue(aContext, tTimingKey{}, newQueryTimings())
+} // NewTimingContext()
+
+// ContextTimings returns the timings of the queries run with
+// `aContext`, sorted by total duration (longest first).
+//
+//	`aContext` A context returned by `NewTimingContext()`.
+func ContextTimings(aContext context.Context) []TQueryTiming {
+	if request, ok := aContext.Value(tTimingKey{}).(*tQueryTimings); ok {
+		return request.list()
+	}
+
+	return nil
+} // ContextTimings()
+
+// QueryTimings returns the timings of all queries run, sorted by
+// total duration (longest first).
+func QueryTimings() []TQueryTiming {
+	return qtAll.list()
+} // QueryTimings()
+
+// SetQueryTimeout sets the max. duration of a single query; a value
+// of zero disables the timeout.
+//
+//	`aTimeout` The max. duration of a query.
+func SetQueryTimeout(aTimeout time.Duration) {
+	if 0 > aTimeout {
+		aTimeout = 0
+	}
+	atomic.StoreInt64(&qtTimeout, int64(aTimeout))
+} // SetQueryTimeout()
+
+// SetSlowQueryThreshold sets the duration after which a query gets
+// logged together with its query plan; a value of zero disables
+// the logging.
+//
+//	`aThreshold` The duration of a slow query.
+func SetSlowQueryThreshold(aThreshold time.Duration) {
+	if 0 > aThreshold {
+		aThreshold = 0
+	}
+	atomic.StoreInt64(&qtSlow, int64(aThreshold))
+} // SetSlowQueryThreshold()
+
+/* _EoF_ */
@@ -0,0 +1,115 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_qtShape(t *testing.T) {
+	_, searchID := qtShape(`SELECT id FROM books b WHERE (b.title LIKE "%dog%")`)
+	tests := []struct {
+		name   string
+		aQuery string
+		want   string
+		wantID string
+	}{
+		// TODO: Add test cases.
+		{" 1", `SELECT id FROM books`, `SELECT id FROM books`, ``},
+		{" 2", "SELECT id\n\tFROM books  LIMIT ?,?", `SELECT id FROM books LIMIT ?,?`, ``},
+		{" 3", `SELECT id FROM books b WHERE (b.title LIKE "%cat%")`, `SELECT id FROM books b WHERE (b.title LIKE ?)`, searchID},
+		{" 4", `SELECT id FROM books b WHERE (b.title = "say \"hi\"")`, `SELECT id FROM books b WHERE (b.title = ?)`, ``},
+		{" 5", `SELECT id FROM books WHERE id = 12 AND title = 'it''s'`, `SELECT id FROM books WHERE id = ? AND title = ?`, ``},
+		{" 6", `SELECT id FROM books_custom_column_3`, `SELECT id FROM books_custom_column_3`, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotID := qtShape(tt.aQuery)
+			if got != tt.want {
+				t.Errorf("qtShape() = %q, want %q", got, tt.want)
+			}
+			if (0 < len(tt.wantID)) && (gotID != tt.wantID) {
+				t.Errorf("qtShape() ID = %q, want %q", gotID, tt.wantID)
+			}
+		})
+	}
+} // Test_qtShape()
+
+func Test_tRows_finish(t *testing.T) {
+	pool := prepPoolForTesting(t, 3)
+	snap, err := pool.acquire(context.Background())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer pool.release(snap)
+	dbh := &TDataBase{lib: pool.lib, snap: snap}
+
+	ctx := NewTimingContext(context.Background())
+	for id := 0; id < 2; id++ {
+		rows, err := dbh.query(ctx, `SELECT id FROM books WHERE id > ?`, id)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		_ = rows.Close()
+		_ = rows.Close() // counted only once
+	}
+	timings := ContextTimings(ctx)
+	if 1 != len(timings) {
+		t.Fatalf("ContextTimings() = %v, want one shape", timings)
+	}
+	if got := timings[0]; (2 != got.Count) || (5 != got.Rows) || (0 >= got.Total) || (got.Max > got.Total) {
+		t.Errorf("ContextTimings() = %+v, want 2 queries, 5 rows", got)
+	}
+	if nil != ContextTimings(context.Background()) {
+		t.Errorf("ContextTimings() without timing context != nil")
+	}
+
+	found := false
+	for _, timing := range QueryTimings() {
+		if timing.ID == timings[0].ID {
+			found = (timing.Count >= 2)
+		}
+	}
+	if !found {
+		t.Errorf("QueryTimings() misses %q", timings[0].ID)
+	}
+} // Test_tRows_finish()
+
+func Test_SetQueryTimeout(t *testing.T) {
+	timeout := atomic.LoadInt64(&qtTimeout)
+	defer SetQueryTimeout(time.Duration(timeout))
+	pool := prepPoolForTesting(t, 1)
+	snap, err := pool.acquire(context.Background())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer pool.release(snap)
+	dbh := &TDataBase{lib: pool.lib, snap: snap}
+
+	SetQueryTimeout(time.Millisecond * 50)
+	ctx := NewTimingContext(context.Background())
+	start := time.Now()
+	_, err = scanCount(dbh.query(ctx, `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c) SELECT COUNT(*) FROM c`))
+	if nil == err {
+		t.Fatalf("scanCount() error = nil, want timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*5 {
+		t.Errorf("query took %v despite the timeout", elapsed)
+	}
+	if timings := ContextTimings(ctx); (1 != len(timings)) || (1 != timings[0].Timeouts) {
+		t.Errorf("ContextTimings() = %+v, want one timeout", timings)
+	}
+} // Test_SetQueryTimeout()
+
+/* _EoF_ */
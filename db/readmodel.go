@@ -0,0 +1,175 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the "read model": a denormalised table holding
+ * one row per book with all the data a `TDocument` needs.
+ *
+ * The table is built into each new database copy (before it replaces
+ * the previous one) so the book lists can be read and sorted without
+ * running the correlated subqueries of `dbBaseQuery` for every book.
+ */
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// Version of the read model's layout; increasing it forces
+	// a new database copy.
+	rmVersion = 1
+
+	// Statements creating the read model.
+	rmCreate = `DROP TABLE IF EXISTS kaliber_books;
+DROP TABLE IF EXISTS kaliber_model;
+CREATE TABLE kaliber_books (
+	id INTEGER PRIMARY KEY,
+	title TEXT COLLATE NOCASE,
+	authors TEXT,
+	publisher TEXT,
+	rating INTEGER,
+	timestamp TIMESTAMP,
+	size INTEGER,
+	tags TEXT,
+	comments TEXT,
+	series TEXT,
+	series_index REAL,
+	sort TEXT COLLATE NOCASE,
+	author_sort TEXT COLLATE NOCASE,
+	formats TEXT,
+	languages TEXT,
+	isbn TEXT,
+	identifiers TEXT,
+	path TEXT,
+	lccn TEXT,
+	pubdate TIMESTAMP,
+	flags INTEGER,
+	uuid TEXT,
+	has_cover BOOL,
+	last_modified TIMESTAMP
+);
+INSERT INTO kaliber_books ` + dbBaseQuery + `;
+CREATE INDEX kaliber_books_acquisition ON kaliber_books (timestamp, pubdate, author_sort);
+CREATE INDEX kaliber_books_author ON kaliber_books (author_sort, pubdate);
+CREATE INDEX kaliber_books_time ON kaliber_books (pubdate, timestamp, author_sort);
+CREATE INDEX kaliber_books_title ON kaliber_books (sort, author_sort);
+CREATE TABLE kaliber_model (version INTEGER);
+INSERT INTO kaliber_model (version) VALUES (%d);
+ANALYZE kaliber_books;`
+
+	// Query for the version of a database copy's read model.
+	rmVersionQuery = `SELECT version FROM kaliber_model`
+)
+
+const (
+	// `dbModelQuery` reads the same fields as `dbBaseQuery` from
+	// the read model.
+	//
+	// The columns used by `orderBy()` get an explicit alias since
+	// they'd be ambiguous with the JOINs of `having()` otherwise.
+	dbModelQuery = `SELECT b.id,
+b.title,
+b.authors,
+b.publisher AS publisher,
+b.rating AS rating,
+b.timestamp,
+b.size AS size,
+b.tags AS tags,
+b.comments,
+b.series AS series,
+b.series_index,
+b.sort AS title_sort,
+b.author_sort,
+b.formats,
+b.languages AS languages,
+b.isbn,
+b.identifiers,
+b.path,
+b.lccn,
+b.pubdate,
+b.flags,
+b.uuid,
+b.has_cover,
+b.last_modified
+FROM kaliber_books b `
+
+	// `dbModelCountQuery` counts the books of the read model.
+	dbModelCountQuery = `SELECT COUNT(b.id) FROM kaliber_books b `
+
+	// `dbModelGridQuery` reads the same fields as `dbGridQuery`
+	// from the read model.
+	dbModelGridQuery = `SELECT b.id,
+b.title,
+b.authors,
+b.languages AS languages,
+b.publisher AS publisher,
+b.rating AS rating,
+b.series AS series,
+b.size AS size,
+b.tags AS tags,
+b.pubdate,
+b.sort AS title_sort
+FROM kaliber_books b `
+)
+
+// `rmBuild()` creates the read model in the database `aName`.
+//
+//	`aName` The path-/filename of the database copy.
+func rmBuild(aName string) error {
+	conn, err := sql.Open(`sqlite3`, `file:`+aName)
+	if nil != err {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if nil != err {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(rmCreate, rmVersion)); nil != err {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+} // rmBuild()
+
+// `rmReady()` returns whether the database `aName` holds a read model
+// of the current version.
+//
+//	`aName` The path-/filename of the database copy.
+func rmReady(aName string) bool {
+	conn, err := sql.Open(`sqlite3`, `file:`+aName+`?mode=ro`)
+	if nil != err {
+		return false
+	}
+	defer conn.Close()
+
+	return rmReadyConn(context.Background(), conn)
+} // rmReady()
+
+// `rmReadyConn()` returns whether the database opened as `aConn`
+// holds a read model of the current version.
+//
+//	`aContext` The current request's context.
+//	`aConn` The database connection to use.
+func rmReadyConn(aContext context.Context, aConn *sql.DB) bool {
+	var version int
+	if err := aConn.QueryRowContext(aContext, rmVersionQuery).Scan(&version); nil != err {
+		return false
+	}
+
+	return rmVersion == version
+} // rmReadyConn()
+
+/* _EoF_ */
@@ -0,0 +1,301 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// `prepCalibreLibrary()` creates a database `aName` with the tables
+// of `Calibre` used by `dbBaseQuery` holding `aBooks` books.
+func prepCalibreLibrary(t testing.TB, aName string, aBooks int) {
+	conn, err := sql.Open(`sqlite3`, `file:`+aName)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stmts := []string{
+		`CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT COLLATE NOCASE, sort TEXT COLLATE NOCASE, timestamp TIMESTAMP, pubdate TIMESTAMP, series_index REAL NOT NULL DEFAULT 1.0, author_sort TEXT COLLATE NOCASE, isbn TEXT DEFAULT '', lccn TEXT DEFAULT '', path TEXT NOT NULL DEFAULT '', flags INTEGER NOT NULL DEFAULT 1, uuid TEXT, has_cover BOOL DEFAULT 0, last_modified TIMESTAMP NOT NULL DEFAULT '2000-01-01 00:00:00+00:00')`,
+		`CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER, author INTEGER)`,
+		`CREATE TABLE publishers (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE books_publishers_link (id INTEGER PRIMARY KEY, book INTEGER, publisher INTEGER)`,
+		`CREATE TABLE ratings (id INTEGER PRIMARY KEY, rating INTEGER)`,
+		`CREATE TABLE books_ratings_link (id INTEGER PRIMARY KEY, book INTEGER, rating INTEGER)`,
+		`CREATE TABLE data (id INTEGER PRIMARY KEY, book INTEGER, format TEXT, uncompressed_size INTEGER, name TEXT)`,
+		`CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE books_tags_link (id INTEGER PRIMARY KEY, book INTEGER, tag INTEGER)`,
+		`CREATE TABLE comments (id INTEGER PRIMARY KEY, book INTEGER, text TEXT)`,
+		`CREATE TABLE series (id INTEGER PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE books_series_link (id INTEGER PRIMARY KEY, book INTEGER, series INTEGER)`,
+		`CREATE TABLE languages (id INTEGER PRIMARY KEY, lang_code TEXT)`,
+		`CREATE TABLE books_languages_link (id INTEGER PRIMARY KEY, book INTEGER, lang_code INTEGER)`,
+		`CREATE TABLE identifiers (id INTEGER PRIMARY KEY, book INTEGER, type TEXT, val TEXT)`,
+		`CREATE INDEX books_authors_link_bidx ON books_authors_link (book)`,
+		`CREATE INDEX books_publishers_link_bidx ON books_publishers_link (book)`,
+		`CREATE INDEX books_ratings_link_bidx ON books_ratings_link (book)`,
+		`CREATE INDEX books_tags_link_bidx ON books_tags_link (book)`,
+		`CREATE INDEX books_series_link_bidx ON books_series_link (book)`,
+		`CREATE INDEX books_languages_link_bidx ON books_languages_link (book)`,
+		`CREATE INDEX comments_idx ON comments (book)`,
+		`CREATE INDEX data_idx ON data (book)`,
+		`CREATE INDEX identifiers_idx ON identifiers (book)`,
+		`INSERT INTO publishers VALUES (1, 'Publisher')`,
+		`INSERT INTO ratings VALUES (1, 8)`,
+		`INSERT INTO tags VALUES (1, 'fiction'), (2, 'crime')`,
+		`INSERT INTO series VALUES (1, 'Series')`,
+		`INSERT INTO languages VALUES (1, 'eng'), (2, 'deu')`,
+	}
+	for _, stmt := range stmts {
+		if _, err = conn.Exec(stmt); nil != err {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	tx, err := conn.Begin()
+	if nil != err {
+		t.Fatal(err)
+	}
+	inserts := []string{
+		`INSERT INTO books (id, title, sort, timestamp, pubdate, author_sort, path, uuid, has_cover, last_modified) VALUES (?1, 'Title ' || ?1, 'Title ' || ?1, ?2, ?3, ?4, 'path/' || ?1, 'uuid-' || ?1, 1, '2024-03-01 10:00:00+00:00')`,
+		`INSERT OR IGNORE INTO authors VALUES (?5, ?4)`,
+		`INSERT INTO books_authors_link (book, author) VALUES (?1, ?5)`,
+		`INSERT INTO books_tags_link (book, tag) VALUES (?1, 1 + ?1 % 2)`,
+		`INSERT INTO books_languages_link (book, lang_code) VALUES (?1, 1 + ?1 % 2)`,
+		`INSERT INTO data (book, format, uncompressed_size, name) VALUES (?1, 'EPUB', 1000 + ?1, 'book')`,
+		`INSERT INTO comments (book, text) VALUES (?1, 'Comment ' || ?1)`,
+		`INSERT INTO identifiers (book, type, val) VALUES (?1, 'isbn', printf('978%07d', ?1))`,
+		`INSERT INTO books_publishers_link (book, publisher) SELECT ?1, 1 WHERE 0 = ?1 % 3`,
+		`INSERT INTO books_ratings_link (book, rating) SELECT ?1, 1 WHERE 0 = ?1 % 3`,
+		`INSERT INTO books_series_link (book, series) SELECT ?1, 1 WHERE 0 = ?1 % 3`,
+	}
+	prepared := make([]*sql.Stmt, len(inserts))
+	numArgs := make([]int, len(inserts))
+	for idx, insert := range inserts {
+		if prepared[idx], err = tx.Prepare(insert); nil != err {
+			t.Fatalf("%s: %v", insert, err)
+		}
+		for _, match := range regexp.MustCompile(`\?(\d)`).FindAllStringSubmatch(insert, -1) {
+			if n := int(match[1][0] - '0'); n > numArgs[idx] {
+				numArgs[idx] = n
+			}
+		}
+	}
+	for id := 1; id <= aBooks; id++ {
+		authorID := (id*7)%(aBooks/3+1) + 1
+		args := []interface{}{id,
+			fmt.Sprintf("2024-01-%02d 10:00:00+00:00", 1+id%28),
+			fmt.Sprintf("2020-02-%02d 00:00:00+00:00", 1+id%27),
+			fmt.Sprintf("Author %03d", authorID), authorID}
+		for idx, stmt := range prepared {
+			if _, err = stmt.Exec(args[:numArgs[idx]]...); nil != err {
+				t.Fatalf("%s: %v", inserts[idx], err)
+			}
+		}
+	}
+	if err = tx.Commit(); nil != err {
+		t.Fatal(err)
+	}
+} // prepCalibreLibrary()
+
+// `prepModelForTesting()` copies a library of `aBooks` books into
+// a temporary cache directory returning a pool using that copy.
+func prepModelForTesting(t testing.TB, aBooks int) *tDBpool {
+	lib := prepLibraryForTesting(t)
+	prepCalibreLibrary(t, filepath.Join(lib.path, dbCalibreDatabaseFilename), aBooks)
+	if copied, err := lib.syncDatabaseFile(); (!copied) || (nil != err) {
+		t.Fatalf("syncDatabaseFile() = %v, %v", copied, err)
+	}
+
+	return lib.pool
+} // prepModelForTesting()
+
+func Test_rmBuild(t *testing.T) {
+	ctx := context.Background()
+	pool := prepModelForTesting(t, 30)
+	if !rmReady(pool.filename()) {
+		t.Fatalf("rmReady() = false, want true")
+	}
+
+	snap, err := pool.acquire(ctx)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer pool.release(snap)
+	if !snap.model {
+		t.Fatalf("tSnapshot.model = false, want true")
+	}
+	dbh := &TDataBase{lib: pool.lib, snap: snap}
+
+	tests := []struct {
+		name    string
+		aEntity string
+		aID     TID
+		aLayout uint8
+		aSort   TSortType
+		aDesc   bool
+	}{
+		// TODO: Add test cases.
+		{" 1", ``, 0, QoLayoutList, qoSortByAuthor, false},
+		{" 2", ``, 0, QoLayoutGrid, qoSortByAuthor, true},
+		{" 3", `tags`, 2, QoLayoutList, qoSortByTitle, false},
+		{" 4", `series`, 1, QoLayoutGrid, qoSortBySeries, true},
+		{" 5", `authors`, 3, QoLayoutList, qoSortByAcquisition, false},
+		{" 6", ``, 0, QoLayoutList, qoSortByRating, true},
+		{" 7", ``, 0, QoLayoutGrid, qoSortByLanguage, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qo := &TQueryOptions{
+				Descending:  tt.aDesc,
+				Entity:      tt.aEntity,
+				ID:          tt.aID,
+				Layout:      tt.aLayout,
+				LimitLength: 12,
+				LimitStart:  3,
+				SortBy:      tt.aSort,
+			}
+			where, args := having(qo.Entity, qo.ID)
+			snap.model = true
+			gotCount, gotList, err := dbh.queryPage(ctx, qo, where, true, args)
+			if nil != err {
+				t.Fatalf("queryPage() error = %v", err)
+			}
+			snap.model = false
+			wantCount, wantList, _ := dbh.queryPage(ctx, qo, where, true, args)
+			snap.model = true
+
+			if (gotCount != wantCount) || (0 == gotCount) {
+				t.Errorf("queryPage() count = %d, want %d", gotCount, wantCount)
+			}
+			if !reflect.DeepEqual(gotList, wantList) {
+				t.Errorf("queryPage() = %v,\nwant %v", gotList, wantList)
+			}
+		})
+	}
+
+	if doc := dbh.QueryDocument(ctx, 9); (nil == doc) || (`Title 9` != doc.Title) || (`Publisher` != doc.Publisher().Name) {
+		t.Errorf("QueryDocument() = %v", doc)
+	}
+} // Test_rmBuild()
+
+func TestTDataBase_QueryCoverFields(t *testing.T) {
+	ctx := context.Background()
+	pool := prepModelForTesting(t, 12)
+	snap, err := pool.acquire(ctx)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer pool.release(snap)
+	dbh := &TDataBase{lib: pool.lib, snap: snap}
+
+	// The fields needed for the placeholder covers:
+	doc := dbh.QueryDocMini(ctx, 5)
+	if (nil == doc) || (`Title 5` != doc.Title) || (`uuid-5` != doc.UUID()) ||
+		(`Author 001` != doc.AuthorList()) || (`path/5` != doc.path) {
+		t.Errorf("QueryDocMini() = %+v", doc)
+	}
+	list, err := dbh.QueryIDs(ctx)
+	if (nil != err) || (12 != len(*list)) {
+		t.Fatalf("QueryIDs() = %v, %v", list, err)
+	}
+	for _, doc := range *list {
+		if want := dbh.QueryDocMini(ctx, doc.ID); (doc.Title != want.Title) ||
+			(doc.UUID() != want.UUID()) || (doc.AuthorList() != want.AuthorList()) {
+			t.Errorf("QueryIDs() = %+v, want %+v", doc, want)
+		}
+	}
+} // TestTDataBase_QueryCoverFields()
+
+func TestTDataBase_QueryEntityIDs(t *testing.T) {
+	ctx := context.Background()
+	pool := prepModelForTesting(t, 12)
+	snap, err := pool.acquire(ctx)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer pool.release(snap)
+	dbh := &TDataBase{lib: pool.lib, snap: snap}
+
+	tests := []struct {
+		name    string
+		aEntity string
+		aID     TID
+		aLimit  int
+		want    []TID
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `series`, 1, 4, []TID{12, 3, 6, 9}, false},
+		{" 2", `series`, 1, 2, []TID{12, 3}, false},
+		{" 3", `tags`, 1, 3, []TID{10, 12, 2}, false},
+		{" 4", `authors`, 1, 4, []TID{10, 5}, false},
+		{" 5", `series`, 2, 4, nil, false},
+		{" 6", `publisher`, 1, 4, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := dbh.QueryEntityIDs(ctx, tt.aEntity, tt.aID, tt.aLimit)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("QueryEntityIDs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if nil == list {
+				return
+			}
+			var got []TID
+			for _, doc := range *list {
+				if 0 == len(doc.Title) {
+					t.Errorf("QueryEntityIDs() = %+v without title", doc)
+				}
+				got = append(got, doc.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueryEntityIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // TestTDataBase_QueryEntityIDs()
+
+func Test_rmBuildLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large library in short mode")
+	}
+	ctx := context.Background()
+	pool := prepModelForTesting(t, 40000)
+	snap, err := pool.acquire(ctx)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer pool.release(snap)
+	dbh := &TDataBase{lib: pool.lib, snap: snap}
+
+	qo := &TQueryOptions{
+		Descending:  true,
+		Layout:      QoLayoutGrid,
+		LimitLength: 24,
+		LimitStart:  20000,
+		SortBy:      qoSortByAuthor,
+	}
+	start := time.Now()
+	count, list, err := dbh.queryPage(ctx, qo, ``, true, nil)
+	if elapsed := time.Since(start); (nil != err) || (40000 != count) || (24 != len(*list)) {
+		t.Fatalf("queryPage() = %d, %d, %v", count, len(*list), err)
+	} else if elapsed > time.Second {
+		t.Errorf("queryPage() took %v", elapsed)
+	}
+} // Test_rmBuildLarge()
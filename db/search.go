@@ -67,17 +67,19 @@ func escapeQuery(aSource string) string {
 type (
 	// TSearch provides text search capabilities.
 	TSearch struct {
-		raw   string // the raw (unprocessed) search expression
-		where string // used to build the WHERE clause
+		lib   *TLibrary // the library to search
+		raw   string    // the raw (unprocessed) search expression
+		where string    // used to build the WHERE clause
 		next  string
 	}
 
 	tExpression struct {
-		entity  string // the DB field to lookup
-		matcher string // how to lookup
-		not     bool   // flag negating the search result
-		op      string // how to concat with the next expression
-		term    string // what to lookup
+		entity  string    // the DB field to lookup
+		lib     *TLibrary // the library defining custom fields
+		matcher string    // how to lookup
+		not     bool      // flag negating the search result
+		op      string    // how to concat with the next expression
+		term    string    // what to lookup
 	}
 )
 
@@ -143,13 +145,17 @@ func (exp *tExpression) buildSQL() (rWhere string) {
 		if '#' != exp.entity[0] {
 			field = "#" + field
 		}
-		if isCustom, err := MetaFieldValue(field, "is_custom"); (nil != err) || (true != isCustom) {
+		lib := exp.lib
+		if nil == lib {
+			lib = DefaultLibrary()
+		}
+		if isCustom, err := lib.MetaFieldValue(field, "is_custom"); (nil != err) || (true != isCustom) {
 			return // no user-defined field
 		}
-		if isCategory, err := MetaFieldValue(field, "is_category"); (nil != err) || (true != isCategory) {
+		if isCategory, err := lib.MetaFieldValue(field, "is_category"); (nil != err) || (true != isCategory) {
 			return
 		}
-		iTable, err := MetaFieldValue(field, "table")
+		iTable, err := lib.MetaFieldValue(field, "table")
 		if nil != err {
 			return
 		}
@@ -236,6 +242,7 @@ func (so *TSearch) p1() *TSearch {
 		}
 		exp := &tExpression{
 			entity:  strings.ToLower(matches[3]),
+			lib:     so.lib,
 			not:     (`!` == matches[2]),
 			matcher: matches[4],
 			op:      strings.ToUpper(matches[7]),
@@ -253,6 +260,7 @@ func (so *TSearch) p1() *TSearch {
 		matches := soSearchRemainderRE.FindStringSubmatch(w[p:])
 		if 2 < len(matches) {
 			exp := &tExpression{
+				lib:  so.lib,
 				not:  (`!` == matches[1]),
 				term: matches[2],
 			}
@@ -281,7 +289,7 @@ func (so *TSearch) Parse() *TSearch {
 		return so.p1()
 	}
 
-	exp := &tExpression{term: so.raw}
+	exp := &tExpression{lib: so.lib, term: so.raw}
 	so.where, so.raw = exp.allSQL(), ""
 
 	return so
@@ -306,4 +314,9 @@ func NewSearch(aSearchTerm string) *TSearch {
 	return &TSearch{raw: aSearchTerm}
 } // NewSearch()
 
+// `newSearch()` returns a new `TSearch` instance for the library.
+func (l *TLibrary) newSearch(aSearchTerm string) *TSearch {
+	return &TSearch{lib: l, raw: aSearchTerm}
+} // newSearch()
+
 /* _EoF_ */
@@ -10,9 +10,7 @@ package db
 
 import (
 	"context"
-	"database/sql"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -20,7 +18,6 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // anonymous import
@@ -37,97 +34,59 @@ const (
 type (
 	// TDataBase An opaque structure providing the properties and
 	// methods to access the `Calibre` database.
+	//
+	// All queries of an instance use the same database copy; call
+	// `Close()` when done so an outdated copy can be released.
 	TDataBase struct {
-		sqlConns *tDBpool // reference of the connection pool
-		sqlDB    *sql.DB  // the used database connection
+		lib  *TLibrary  // the library whose database is used
+		snap *tSnapshot // handle of the used database copy
 	}
 )
 
-var (
-	// Make sure the database file monitoring is started only once.
-	dbRunFileCheckOnce sync.Once
-
-	// The database instance that handles all connections
-	dbDataBase *TDataBase
-)
-
-// Init instantiates the database object.
+// Init instantiates the database objects of all libraries.
 //
 // This function should be called before using the database.
 func Init() {
-	// Prepare the local database copy:
-	if copied, _ := syncDatabaseFile(); copied {
-		// Signal for `rDB.reOpen()`:
-		syncCopiedChan <- struct{}{}
-	}
-
-	dbRunFileCheckOnce.Do(func() {
-		// Start monitoring the original database file:
-		go goSyncFile()
-	})
-
-	dbDataBase = &TDataBase{
-		sqlConns: newPool(),
+	for _, lib := range Libraries() {
+		lib.Init()
 	}
 } // Init()
 
 // OpenDatabase returns a database connection.
 //
+// The library used is the one set by `NewLibraryContext()` or –
+// if none was set – the default library.
+//
+// The caller is expected to call the returned instance's `Close()`
+// method when done.
+//
 //	`aContext` The current web request's context.
-func OpenDatabase(aContext context.Context) (rDB *TDataBase, rErr error) {
-	/*
-		// Prepare the local database copy:
-		if _, rErr = syncDatabaseFile(); nil != rErr {
-			return
-		}
-		// Signal for `rDB.reOpen()`:
-		syncCopiedChan <- struct{}{}
-
-		dbRunFileCheckOnce.Do(func() {
-			// Start monitoring the original database file:
-			go goSyncFile()
-		})
-
-		rDB = &TDataBase{
-			sqlConns: newPool(),
-		}
-		rErr = rDB.reOpen(aContext)
-		return
-	*/
-
-	if nil == dbDataBase {
-		Init()
-	}
-	return dbDataBase, dbDataBase.reOpen(aContext)
+func OpenDatabase(aContext context.Context) (*TDataBase, error) {
+	return ContextLibrary(aContext).Open(aContext)
 } // OpenDatabase()
 
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
-var (
-	// Pathname to the copied `Calibre` database
-	dbCalibreCachePath = ``
-
-	// Pathname to the original `Calibre` database
-	dbCalibreLibraryPath = ``
-)
-
-// CalibreCachePath returns the directory of the copied `Calibre` database.
+// CalibreCachePath returns the directory of the default library's
+// database copy.
 func CalibreCachePath() string {
-	return dbCalibreCachePath
+	return DefaultLibrary().CachePath()
 } // CalibreCachePath()
 
-// CalibreLibraryPath returns the base directory of the `Calibre` library.
+// CalibreLibraryPath returns the base directory of the default
+// `Calibre` library.
 func CalibreLibraryPath() string {
-	return dbCalibreLibraryPath
+	return DefaultLibrary().Path()
 } // CalibreLibraryPath()
 
 // CalibrePreferencesFile returns the complete path-/filename of the
-// `Calibre` library's preferences file.
+// default `Calibre` library's preferences file.
 func CalibrePreferencesFile() string {
-	return filepath.Join(dbCalibreLibraryPath, dbCalibrePreferencesFile)
+	return DefaultLibrary().PreferencesFile()
 } // CalibrePreferencesFile()
 
-// SetCalibreCachePath sets the directory of the `Calibre` database copy.
+// SetCalibreCachePath sets the directory of the default library's
+// database copy.
 //
 // If `aPath` is an empty string or is a directory that can't be used or
 // created the function returns an appropriate error, otherwise the return
@@ -135,44 +94,18 @@ func CalibrePreferencesFile() string {
 //
 //	`aPath` is the directory path to use for caching the `Calibre` library.
 func SetCalibreCachePath(aPath string) error {
-	if 0 == len(aPath) {
-		return errors.New(`SetCalibreCachePath can't use empty directory/path`)
-	}
-	if path, err := filepath.Abs(aPath); nil == err {
-		aPath = path
-	}
-	if fi, err := os.Stat(aPath); (nil == err) && fi.IsDir() {
-		dbCalibreCachePath = aPath
-	} else if err := os.MkdirAll(aPath, os.ModeDir|0750); nil == err {
-		dbCalibreCachePath = aPath
-	} else {
-		dbCalibreCachePath = ``
-		return fmt.Errorf("SetCalibreCachePath can't find directory: %v", err)
-	}
-
-	return nil
+	return DefaultLibrary().setCachePath(aPath)
 } // SetCalibreCachePath()
 
-// SetCalibreLibraryPath sets the base directory of the `Calibre` library.
+// SetCalibreLibraryPath sets the base directory of the default
+// `Calibre` library.
 //
 // If `aPath` is an empty string or is a directory that can't be used the
 // function returns an appropriate error, otherwise the return value is `nil`.
 //
 //	`aPath` is the directory path where the `Calibre` library resides.
 func SetCalibreLibraryPath(aPath string) error {
-	if 0 == len(aPath) {
-		return errors.New(`SetCalibreLibraryPath can't use empty directory/path`)
-	}
-	if path, err := filepath.Abs(aPath); nil == err {
-		aPath = path
-	}
-	if fi, err := os.Stat(aPath); (nil == err) && fi.IsDir() {
-		dbCalibreLibraryPath = aPath
-	} else {
-		dbCalibreLibraryPath = ``
-		return fmt.Errorf("SetCalibreLibraryPath can't find directory: %v", err)
-	}
-	return nil
+	return DefaultLibrary().setPath(aPath)
 } // SetCalibreLibraryPath()
 
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
@@ -182,29 +115,29 @@ var (
 	// to records matching a certain condition.
 	dbHaving = map[string]string{
 		`all`:       ``,
-		`authors`:   `JOIN books_authors_link a ON(a.book = b.id) WHERE (a.author = %d) `,
-		`format`:    `JOIN data d ON(b.id = d.book) JOIN data dd ON (d.format = dd.format) WHERE (dd.id = %d) `,
-		`languages`: `JOIN books_languages_link l ON(l.book = b.id) WHERE (l.lang_code = %d) `,
-		`publisher`: `JOIN books_publishers_link p ON(p.book = b.id) WHERE (p.publisher = %d) `,
-		`series`:    `JOIN books_series_link s ON(s.book = b.id) WHERE (s.series = %d) `,
-		`tags`:      `JOIN books_tags_link t ON(t.book = b.id) WHERE (t.tag = %d) `,
+		`authors`:   `JOIN books_authors_link a ON(a.book = b.id) WHERE (a.author = ?) `,
+		`format`:    `JOIN data d ON(b.id = d.book) JOIN data dd ON (d.format = dd.format) WHERE (dd.id = ?) `,
+		`languages`: `JOIN books_languages_link l ON(l.book = b.id) WHERE (l.lang_code = ?) `,
+		`publisher`: `JOIN books_publishers_link p ON(p.book = b.id) WHERE (p.publisher = ?) `,
+		`series`:    `JOIN books_series_link s ON(s.book = b.id) WHERE (s.series = ?) `,
+		`tags`:      `JOIN books_tags_link t ON(t.book = b.id) WHERE (t.tag = ?) `,
 	}
 )
 
 // `having()` returns a string limiting the query to the given `aEntity`
-// with `aID`.
-func having(aEntity string, aID TID) string {
-	if (0 == len(aEntity)) || (`all` == aEntity) || (0 == aID) {
-		return ``
+// with `aID` and the argument(s) of the string's placeholder.
+func having(aEntity string, aID TID) (string, []interface{}) {
+	if (0 == aID) || (0 == len(dbHaving[aEntity])) {
+		return ``, nil
 	}
 
-	return fmt.Sprintf(dbHaving[aEntity], aID)
+	return dbHaving[aEntity], []interface{}{aID}
 } // having()
 
-// `limit()` returns a LIMIT clause defined by `aStart` and `aLength`.
-func limit(aStart, aLength uint) string {
-	return `LIMIT ` + strconv.FormatInt(int64(aStart), 10) +
-		`,` + strconv.FormatInt(int64(aLength), 10)
+// `limit()` returns a LIMIT clause and the arguments of its
+// placeholders defined by `aStart` and `aLength`.
+func limit(aStart, aLength uint) (string, []interface{}) {
+	return `LIMIT ?,?`, []interface{}{aStart, aLength}
 } // limit()
 
 // `orderBy()` returns a ORDER_BY clause defined by `aOrder` and `aDesc`.
@@ -379,7 +312,7 @@ var (
 //
 //	`aPath` The relative directory/path of the document's data.
 func prepPages(aPath string) int {
-	fName := filepath.Join(dbCalibreLibraryPath, aPath, `metadata.opf`)
+	fName := filepath.Join(CalibreLibraryPath(), aPath, `metadata.opf`)
 	if fi, err := os.Stat(fName); (nil != err) || (0 >= fi.Size()) {
 		return 0
 	}
@@ -476,36 +409,76 @@ type (
 	tPSVstring = string
 )
 
-// Close terminates the current database connection.
+// Close releases the database copy used by this instance.
 func (db *TDataBase) Close() {
-	if nil != db.sqlDB {
-		db.sqlConns.put(db.sqlDB)
-		db.sqlDB = nil // clear reference
+	if nil != db.snap {
+		db.lib.pool.release(db.snap)
+		db.snap = nil // clear reference
 	}
 } // Close()
 
+// `cached()` returns the result of `aQuery` from the query cache,
+// calling `aQuery` and caching its result if it's not there.
+//
+//	`aContext` The current request's context.
+//	`aKey` The cache key of the query.
+//	`aQuery` The function running the query.
+func (db *TDataBase) cached(aContext context.Context, aKey string, aQuery func() (int, *TDocList, error)) (int, *TDocList, error) {
+	if err := db.reOpen(aContext); nil != err {
+		return 0, nil, err
+	}
+	cache := db.snap.cache
+	if count, list, ok := cache.get(aKey); ok {
+		return count, list, nil
+	}
+
+	count, list, err := aQuery()
+	if nil == err {
+		cache.put(aKey, count, list)
+	}
+
+	return count, list, err
+} // cached()
+
+// `scanCount()` returns the number read from the first row of
+// `aRows`, typically the result of a `SELECT COUNT(…)`.
+//
+//	`aRows` The query result to read.
+//	`aErr` The query's error (if any).
+func scanCount(aRows *tRows, aErr error) (rCount int, rErr error) {
+	if nil != aErr {
+		return 0, aErr
+	}
+	defer aRows.Close()
+
+	if aRows.Next() {
+		rErr = aRows.Scan(&rCount)
+	}
+	if nil == rErr {
+		rErr = aRows.Err()
+	}
+
+	return
+} // scanCount()
+
 // `doQueryAll()` returns a list of documents with all available fields
 // and an `error` in case of problems.
 //
 //	`aContext` The current request's context.
-//	`aQuery` The SQL query to run.
-func (db *TDataBase) doQueryAll(aContext context.Context, aQuery string) (rList *TDocList, rErr error) {
-	var rows *sql.Rows
-	if rows, rErr = db.query(aContext, aQuery); nil != rErr {
-		return
-	}
-	defer rows.Close()
+//	`aRows` The result of a `dbBaseQuery` to read.
+func (db *TDataBase) doQueryAll(aContext context.Context, aRows *tRows) (rList *TDocList, rErr error) {
+	defer aRows.Close()
 
 	rList = NewDocList()
-	for rows.Next() {
+	for aRows.Next() {
 		var (
 			authors, formats, identifiers, languages,
 			publisher, series, tags tPSVstring
 			noTime  time.Time
 			visible bool
 		)
-		doc := NewDocument()
-		if err := rows.Scan(&doc.ID, &doc.Title, &authors,
+		doc := db.lib.newDocument()
+		if err := aRows.Scan(&doc.ID, &doc.Title, &authors,
 			&publisher, &doc.Rating, &doc.acquisition, &doc.Size,
 			&tags, &doc.comments, &series, &doc.seriesindex,
 			&doc.titleSort, &doc.authorSort, &formats, &languages,
@@ -516,61 +489,61 @@ func (db *TDataBase) doQueryAll(aContext context.Context, aQuery string) (rList
 		}
 
 		// check for (in)visible fields:
-		if visible, _ = BookFieldVisible(`authors`); !visible {
-			visible, _ = BookFieldVisible(`author_sort`)
+		if visible, _ = db.lib.BookFieldVisible(`authors`); !visible {
+			visible, _ = db.lib.BookFieldVisible(`author_sort`)
 		}
 		if visible {
 			doc.authors = prepAuthors(authors)
 		}
-		if visible, _ = BookFieldVisible(`comments`); !visible {
+		if visible, _ = db.lib.BookFieldVisible(`comments`); !visible {
 			doc.comments = ``
 		}
-		if visible, _ = BookFieldVisible(`formats`); visible {
+		if visible, _ = db.lib.BookFieldVisible(`formats`); visible {
 			doc.formats = prepFormats(formats)
 		}
-		if visible, _ = BookFieldVisible(`identifiers`); visible {
+		if visible, _ = db.lib.BookFieldVisible(`identifiers`); visible {
 			doc.identifiers = prepIdentifiers(identifiers)
 		}
-		if visible, _ = BookFieldVisible(`languages`); visible {
+		if visible, _ = db.lib.BookFieldVisible(`languages`); visible {
 			doc.languages = prepLanguages(languages)
 		}
-		// if visible, _ = BookFieldVisible(`#pages`); visible {
+		// if visible, _ = db.lib.BookFieldVisible(`#pages`); visible {
 		// 	doc.Pages = prepPages(doc.path)
 		// }
-		if visible, _ = BookFieldVisible(`path`); !visible {
+		if visible, _ = db.lib.BookFieldVisible(`path`); !visible {
 			doc.path = ``
 		}
-		if visible, _ = BookFieldVisible(`pubdate`); !visible {
+		if visible, _ = db.lib.BookFieldVisible(`pubdate`); !visible {
 			doc.pubdate = noTime
 		}
-		if visible, _ = BookFieldVisible(`publisher`); visible {
+		if visible, _ = db.lib.BookFieldVisible(`publisher`); visible {
 			doc.publisher = prepPublisher(publisher)
 		}
-		if visible, _ = BookFieldVisible(`rating`); !visible {
+		if visible, _ = db.lib.BookFieldVisible(`rating`); !visible {
 			doc.Rating = 0
 		}
-		if visible, _ = BookFieldVisible(`series`); visible {
+		if visible, _ = db.lib.BookFieldVisible(`series`); visible {
 			doc.series = prepSeries(series)
 		}
-		if visible, _ = BookFieldVisible(`tags`); visible {
+		if visible, _ = db.lib.BookFieldVisible(`tags`); visible {
 			doc.tags = prepTags(tags)
 		}
-		if visible, _ = BookFieldVisible(`timestamp`); !visible {
+		if visible, _ = db.lib.BookFieldVisible(`timestamp`); !visible {
 			doc.acquisition = noTime
 		}
-		if visible, _ = BookFieldVisible(`title`); !visible {
-			visible, _ = BookFieldVisible(`sort`)
+		if visible, _ = db.lib.BookFieldVisible(`title`); !visible {
+			visible, _ = db.lib.BookFieldVisible(`sort`)
 		}
 		if !visible {
 			doc.Title = ``
 		}
-		if visible, _ = BookFieldVisible(`size`); !visible {
+		if visible, _ = db.lib.BookFieldVisible(`size`); !visible {
 			doc.Size = 0
 		}
-		if visible, _ = BookFieldVisible(`uuid`); !visible {
+		if visible, _ = db.lib.BookFieldVisible(`uuid`); !visible {
 			doc.uuid = ``
 		}
-		if visible, _ = BookFieldVisible(`last_modified`); !visible {
+		if visible, _ = db.lib.BookFieldVisible(`last_modified`); !visible {
 			doc.lastModified = time.Now()
 		}
 
@@ -714,16 +687,12 @@ FROM books b `
 // `doQueryGrid()` selects the data for a `grid` layout.
 //
 //	`aContext` The current web request's context.
-//	`aQuery` The SQL query to run.
-func (db *TDataBase) doQueryGrid(aContext context.Context, aQuery string) (rList *TDocList, rErr error) {
-	var rows *sql.Rows
-	if rows, rErr = db.query(aContext, aQuery); nil != rErr {
-		return
-	}
-	defer rows.Close()
+//	`aRows` The result of a `dbGridQuery` to read.
+func (db *TDataBase) doQueryGrid(aContext context.Context, aRows *tRows) (rList *TDocList, rErr error) {
+	defer aRows.Close()
 
 	rList = NewDocList()
-	for rows.Next() {
+	for aRows.Next() {
 		var (
 			authors, languages, publisher,
 			series, tags, titleSort tPSVstring
@@ -731,16 +700,16 @@ func (db *TDataBase) doQueryGrid(aContext context.Context, aQuery string) (rList
 			pubdate      time.Time
 			visible      bool
 		)
-		doc := NewDocument()
+		doc := db.lib.newDocument()
 
-		if err := rows.Scan(&doc.ID, &doc.Title, &authors, &languages,
+		if err := aRows.Scan(&doc.ID, &doc.Title, &authors, &languages,
 			&publisher, &rating, &series, &size, &tags, &pubdate,
 			&titleSort); nil != err {
 			continue
 		}
 
-		if visible, _ = BookFieldVisible(`authors`); !visible {
-			_, _ = BookFieldVisible(`author_sort`)
+		if visible, _ = db.lib.BookFieldVisible(`authors`); !visible {
+			_, _ = db.lib.BookFieldVisible(`author_sort`)
 		}
 		doc.authors = prepAuthors(authors)
 
@@ -758,28 +727,97 @@ func (db *TDataBase) doQueryGrid(aContext context.Context, aQuery string) (rList
 } // doQueryGrid()
 
 // `query()` executes a query that returns rows, typically a SELECT.
-// The `args` are for any placeholder parameters in the query.
+// The `aArgs` are for any placeholder parameters in the query.
 //
 //	`aContext` The current request's context.
 //	`aQuery` The SQL query to run.
-func (db *TDataBase) query(aContext context.Context, aQuery string) (rRows *sql.Rows, rErr error) {
-	select {
-	case <-aContext.Done():
-		rErr = aContext.Err()
+//	`aArgs` The arguments of the query's placeholders.
+func (db *TDataBase) query(aContext context.Context, aQuery string, aArgs ...interface{}) (*tRows, error) {
+	if err := db.reOpen(aContext); nil != err {
+		return nil, err
+	}
+	result, ctx := newRows(aContext, db.snap.conn, aQuery, aArgs)
+
+	rows, err := db.snap.conn.QueryContext(ctx, aQuery, aArgs...)
+	if nil != err {
+		result.finish(err)
+		return nil, err
+	}
+	result.Rows = rows
+
+	return result, nil
+} // query()
+
+// `queryStmt()` executes a query like `query()` but uses a prepared
+// statement; it's meant for queries of a fixed shape that are run
+// over and over again.
+//
+//	`aContext` The current request's context.
+//	`aQuery` The SQL query to run.
+//	`aArgs` The arguments of the query's placeholders.
+func (db *TDataBase) queryStmt(aContext context.Context, aQuery string, aArgs ...interface{}) (*tRows, error) {
+	if err := db.reOpen(aContext); nil != err {
+		return nil, err
+	}
+	result, ctx := newRows(aContext, db.snap.conn, aQuery, aArgs)
+
+	stmt, err := db.snap.prepare(ctx, aQuery)
+	if nil != err {
+		result.finish(err)
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, aArgs...)
+	if nil != err {
+		result.finish(err)
+		return nil, err
+	}
+	result.Rows = rows
+
+	return result, nil
+} // queryStmt()
+
+// `queryPage()` runs `dbBaseQuery` (for the `list` layout) or
+// `dbGridQuery` (for the `grid` layout) – or their read model
+// counterparts if available – limited by `aWhere` and returns the
+// page of documents selected by `aOptions`.
+//
+//	`aContext` The current request's context.
+//	`aOptions` The options to configure the query.
+//	`aWhere` The query's condition.
+//	`aPrepared` Flag whether `aWhere` is of a fixed shape.
+//	`aArgs` The arguments of the placeholders in `aWhere`.
+func (db *TDataBase) queryPage(aContext context.Context, aOptions *TQueryOptions, aWhere string, aPrepared bool, aArgs []interface{}) (rCount int, rList *TDocList, rErr error) {
+	run := db.query
+	if aPrepared {
+		run = db.queryStmt
+	}
+	if rErr = db.reOpen(aContext); nil != rErr {
+		return
+	}
+	baseQuery, countQuery, gridQuery := dbBaseQuery, dbCountQuery, dbGridQuery
+	if db.snap.model {
+		baseQuery, countQuery, gridQuery = dbModelQuery, dbModelCountQuery, dbModelGridQuery
+	}
+	if rCount, rErr = scanCount(run(aContext, countQuery+aWhere, aArgs...)); (nil != rErr) || (0 == rCount) {
 		return
+	}
 
-	default:
-		if rErr = db.reOpen(aContext); nil != rErr {
-			return
+	lim, limArgs := limit(aOptions.LimitStart, aOptions.LimitLength)
+	args := append(append([]interface{}{}, aArgs...), limArgs...)
+	order := orderBy(aOptions.SortBy, aOptions.Descending)
+	var rows *tRows
+	if QoLayoutList == aOptions.Layout {
+		if rows, rErr = run(aContext, baseQuery+aWhere+order+lim, args...); nil == rErr {
+			rList, rErr = db.doQueryAll(aContext, rows)
+		}
+	} else {
+		if rows, rErr = run(aContext, gridQuery+aWhere+order+lim, args...); nil == rErr {
+			rList, rErr = db.doQueryGrid(aContext, rows)
 		}
 	}
-	go goSQLtrace(aQuery, time.Now())
-
-	rRows, rErr = db.sqlDB.QueryContext(aContext, aQuery)
-	db.Close() // recycle the connection
 
 	return
-} // query()
+} // queryPage()
 
 // QueryBy returns all documents according to `aOptions`.
 //
@@ -787,43 +825,17 @@ func (db *TDataBase) query(aContext context.Context, aQuery string) (rRows *sql.
 // in `rList` either `nil` or a list list of documents,
 // in `rErr` either `nil` or the error occurred during the search.
 //
+// The results are cached until a new database copy gets used.
+//
 //	`aContext` The current web request's context.
 //	`aOptions` The options to configure the query.
 func (db *TDataBase) QueryBy(aContext context.Context, aOptions *TQueryOptions) (rCount int, rList *TDocList, rErr error) {
-	var rows *sql.Rows
-	rows, rErr = db.query(aContext,
-		dbCountQuery+having(aOptions.Entity, aOptions.ID))
-	if nil != rErr {
-		return
-	}
-	defer rows.Close()
+	where, args := having(aOptions.Entity, aOptions.ID)
 
-	if rows.Next() {
-		_ = rows.Scan(&rCount)
-	}
-
-	select {
-	case <-aContext.Done():
-		rErr = aContext.Err()
-	default:
-		if 0 < rCount {
-			if QoLayoutList == aOptions.Layout {
-				rList, rErr = db.doQueryAll(aContext,
-					dbBaseQuery+
-						having(aOptions.Entity, aOptions.ID)+
-						orderBy(aOptions.SortBy, aOptions.Descending)+
-						limit(aOptions.LimitStart, aOptions.LimitLength))
-			} else {
-				rList, rErr = db.doQueryGrid(aContext,
-					dbGridQuery+
-						having(aOptions.Entity, aOptions.ID)+
-						orderBy(aOptions.SortBy, aOptions.Descending)+
-						limit(aOptions.LimitStart, aOptions.LimitLength))
-			}
-		}
-	}
-
-	return
+	return db.cached(aContext, qcKey(aOptions, where, args),
+		func() (int, *TDocList, error) {
+			return db.queryPage(aContext, aOptions, where, true, args)
+		})
 } // QueryBy()
 
 const (
@@ -877,32 +889,43 @@ const (
 IFNULL((SELECT group_concat(d.format, ", ")
 	FROM data d WHERE d.book = b.id), "") formats,
 b.path,
-b.title
+b.title,
+` + dbAuthorsColumn + `,
+IFNULL(b.uuid, "") uuid
 FROM books b
-WHERE b.id = `
+WHERE b.id = ?`
+
+	// The author(s) of a book in the format used by `prepAuthors()`.
+	dbAuthorsColumn = `IFNULL((SELECT group_concat(a.name || "|" || a.id, ", ")
+	FROM authors a
+	JOIN books_authors_link bal ON(bal.author = a.id)
+	WHERE (bal.book = b.id)
+), "") authors`
 )
 
 // QueryDocMini returns the document identified by `aID`.
 //
-// This function fills only the document properties `ID`, `formats`,
-// `path`, and `Title`.
+// This function fills only the document properties `ID`, `authors`,
+// `formats`, `path`, `Title`, and `uuid` (i.e. everything needed to
+// serve the document's files and cover images).
 // If a matching document could not be found the function returns `nil`.
 //
 //	`aContext` The current web request's context.
 //	`aID` The document ID to lookup.
 func (db *TDataBase) QueryDocMini(aContext context.Context, aID TID) (rDoc *TDocument) {
-	rows, err := db.query(aContext,
-		dbDocMiniQuery+strconv.FormatInt(int64(aID), 10))
+	rows, err := db.queryStmt(aContext, dbDocMiniQuery, aID)
 	if nil != err {
 		return
 	}
 	defer rows.Close()
 
 	if rows.Next() {
-		var formats tPSVstring
-		rDoc = NewDocument()
+		var authors, formats tPSVstring
+		rDoc = db.lib.newDocument()
 		rDoc.ID = aID
-		_ = rows.Scan(&rDoc.ID, &formats, &rDoc.path, &rDoc.Title)
+		_ = rows.Scan(&rDoc.ID, &formats, &rDoc.path, &rDoc.Title,
+			&authors, &rDoc.uuid)
+		rDoc.authors = prepAuthors(authors)
 		rDoc.formats = prepFormats(formats)
 	}
 
@@ -917,10 +940,18 @@ func (db *TDataBase) QueryDocMini(aContext context.Context, aID TID) (rDoc *TDoc
 //	`aContext` The current web request's context.
 //	`aID` The document ID to lookup.
 func (db *TDataBase) QueryDocument(aContext context.Context, aID TID) *TDocument {
-	if list, err := db.doQueryAll(aContext, dbBaseQuery+
-		`WHERE b.id=`+
-		strconv.FormatInt(int64(aID), 10)+
-		` LIMIT 1`); (nil == err) && (0 < len(*list)) {
+	if nil != db.reOpen(aContext) {
+		return nil
+	}
+	query := dbBaseQuery
+	if db.snap.model {
+		query = dbModelQuery
+	}
+	rows, err := db.queryStmt(aContext, query+`WHERE b.id = ? LIMIT 1`, aID)
+	if nil != err {
+		return nil
+	}
+	if list, err := db.doQueryAll(aContext, rows); (nil == err) && (0 < len(*list)) {
 		doc := (*list)[0]
 
 		return &doc
@@ -931,38 +962,83 @@ func (db *TDataBase) QueryDocument(aContext context.Context, aID TID) *TDocument
 
 const (
 	// see `QueryIDs()`
-	dbIDQuery = `SELECT id, path FROM books `
+	dbIDQuery = `SELECT b.id,
+b.path,
+b.title,
+` + dbAuthorsColumn + `,
+IFNULL(b.uuid, "") uuid
+FROM books b `
 )
 
-// QueryIDs returns a list of documents with only the `ID` and
-// `path` fields set.
+// `doQueryIDs()` returns the documents of a `dbIDQuery` result.
+//
+//	`aContext` The current web request's context.
+//	`aRows` The result of a `dbIDQuery` to read.
+func (db *TDataBase) doQueryIDs(aContext context.Context, aRows *tRows) (*TDocList, error) {
+	defer aRows.Close()
+
+	result := NewDocList()
+	for aRows.Next() {
+		var authors tPSVstring
+		doc := db.lib.newDocument()
+		_ = aRows.Scan(&doc.ID, &doc.path, &doc.Title, &authors, &doc.uuid)
+		doc.authors = prepAuthors(authors)
+
+		select {
+		case <-aContext.Done():
+			return result, aContext.Err()
+		default:
+			result.Add(doc)
+		}
+	}
+
+	return result, nil
+} // doQueryIDs()
+
+// QueryEntityIDs returns the first `aLimit` documents of the author,
+// series, or tag `aID` with only the fields set by `QueryIDs()`.
+//
+// The documents of a series are sorted by their series index, all
+// others by their title.
+//
+// This method is used by the entities' cover collages.
+//
+//	`aContext` The current web request's context.
+//	`aEntity` The entity's kind (`authors`, `series`, or `tags`).
+//	`aID` The entity's ID.
+//	`aLimit` The max. number of documents to return.
+func (db *TDataBase) QueryEntityIDs(aContext context.Context, aEntity string, aID TID, aLimit int) (*TDocList, error) {
+	var order string
+	switch aEntity {
+	case `authors`, `tags`:
+		order = `ORDER BY b.sort, b.id `
+	case `series`:
+		order = `ORDER BY b.series_index, b.sort, b.id `
+	default:
+		return nil, errors.New(`QueryEntityIDs(): unsupported entity '` + aEntity + `'`)
+	}
+	rows, err := db.queryStmt(aContext, dbIDQuery+dbHaving[aEntity]+order+`LIMIT ?`, aID, aLimit)
+	if nil != err {
+		return nil, err
+	}
+
+	return db.doQueryIDs(aContext, rows)
+} // QueryEntityIDs()
+
+// QueryIDs returns a list of documents with only the `ID`, `authors`,
+// `path`, `Title`, and `uuid` fields set.
 //
 // This method is used by `thumbnails`.
 //
 //	`aContext` The current web request's context.
 func (db *TDataBase) QueryIDs(aContext context.Context) (rList *TDocList, rErr error) {
-	var rows *sql.Rows
+	var rows *tRows
 	rows, rErr = db.query(aContext, dbIDQuery)
 	if nil != rErr {
 		return
 	}
-	defer rows.Close()
 
-	rList = NewDocList()
-	for rows.Next() {
-		doc := NewDocument()
-		_ = rows.Scan(&doc.ID, &doc.path)
-
-		select {
-		case <-aContext.Done():
-			rErr = aContext.Err()
-			return
-		default:
-			rList.Add(doc)
-		}
-	}
-
-	return
+	return db.doQueryIDs(aContext, rows)
 } // QueryIDs()
 
 // QuerySearch returns a list of documents matching the criteria
@@ -972,72 +1048,32 @@ func (db *TDataBase) QueryIDs(aContext context.Context) (rList *TDocList, rErr e
 // in `rList` either `nil` or a list list of documents,
 // in `rErr` either `nil` or an error occurred during the search.
 //
+// The results are cached until a new database copy gets used.
+//
 //	`aContext` The current request's context.
 //	`aOptions` The options to configure the query.
 func (db *TDataBase) QuerySearch(aContext context.Context, aOptions *TQueryOptions) (rCount int, rList *TDocList, rErr error) {
-	var rows *sql.Rows
-	where := NewSearch(aOptions.Matching)
-	if rows, rErr = db.query(aContext, dbCountQuery+where.Clause()); nil != rErr {
-		return
-	}
-	defer rows.Close()
-
-	if rows.Next() {
-		_ = rows.Scan(&rCount)
-	}
-
-	select {
-	case <-aContext.Done():
-		rErr = aContext.Err()
-
-	default:
-		if 0 < rCount {
-			if QoLayoutList == aOptions.Layout {
-				rList, rErr = db.doQueryAll(aContext,
-					dbBaseQuery+
-						where.Clause()+
-						orderBy(aOptions.SortBy, aOptions.Descending)+
-						limit(aOptions.LimitStart, aOptions.LimitLength))
-			} else {
-				rList, rErr = db.doQueryGrid(aContext,
-					dbGridQuery+
-						where.Clause()+
-						orderBy(aOptions.SortBy, aOptions.Descending)+
-						limit(aOptions.LimitStart, aOptions.LimitLength))
-			}
-		}
-	}
+	where := db.lib.newSearch(aOptions.Matching).Clause()
 
-	return
+	return db.cached(aContext, qcKey(aOptions, where, nil),
+		func() (int, *TDocList, error) {
+			// The search terms are part of `where` so there's
+			// no fixed shape to prepare:
+			return db.queryPage(aContext, aOptions, where, false, nil)
+		})
 } // QuerySearch()
 
-// `reOpen()` checks whether the SQLite database file has changed
-// since the last access.
-// If so, the current database connection is closed and a new one
-// is established.
+// `reOpen()` makes sure this instance uses a database copy.
+//
+// After `Close()` the then current copy is used.
 //
 //	`aContext` The current request's context.
 func (db *TDataBase) reOpen(aContext context.Context) (rErr error) {
-	// Make sure we don't interfere with an ongoing copy.
-	syncCopyMtx.Lock()
-	defer syncCopyMtx.Unlock()
-
-	select {
-	case <-syncCopiedChan:
-		if nil != db.sqlDB {
-			_ = db.sqlDB.Close()
-			db.sqlDB = nil // clear reference
-		}
-		db.sqlConns.clear()
-
-		go goSQLtrace(`-- closed all DB connections`, time.Now()) //FIXME REMOVE
-
-		db.sqlDB, rErr = db.sqlConns.get(aContext)
-
-	default:
-		if nil == db.sqlDB {
-			db.sqlDB, rErr = db.sqlConns.get(aContext)
-		}
+	if err := aContext.Err(); nil != err {
+		return err
+	}
+	if nil == db.snap {
+		db.snap, rErr = db.lib.pool.acquire(aContext)
 	}
 
 	return
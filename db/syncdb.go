@@ -9,14 +9,18 @@ package db
 //lint:file-ignore ST1017 - I prefer Yoda conditions
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
-	// sqlite "github.com/mattn/go-sqlite3"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/mwat56/apachelogger"
 )
 
 /*
@@ -25,8 +29,8 @@ import (
  *
  * To avoid any LOCKing problems (which happened quite frequently)
  * when reading the Calibre database while it is edited by the original
- * Calibre installation here we simply copy Calibre's database file
- * into the user's cache directory.
+ * Calibre installation here we copy Calibre's database file (using
+ * SQLite's online backup API) into the user's cache directory.
  * This way we can use R/O access without the fear that the database might
  * be changed under our feet by other processes.
  *
@@ -35,14 +39,6 @@ import (
  */
 
 var (
-	// `syncCopiedChan` Signal channel for a new database copy.
-	// `goSyncFile()` writes to the channel whenever the database
-	// file was copied so others (`TDataBase.reOpen()`) can check.
-	syncCopiedChan = make(chan struct{}, 2)
-
-	// Guard against parallel database copies.
-	syncCopyMtx = new(sync.Mutex)
-
 	// The channel to send SQL to and read trace messages from.
 	syncSQLTraceChannel = make(chan string, 127)
 
@@ -50,28 +46,116 @@ var (
 	syncSQLTraceFile = ``
 )
 
-// `goSyncFile()` checks in background once a minute whether the
-// original database file has changed.
-// If so, that file is copied to the cache directory from where it is
-// read and used by the `db.TDatabase` instance.
-func goSyncFile() {
-	timer := time.NewTimer(time.Minute)
+const (
+	// Time to wait after the last change of the original database
+	// before copying it (Calibre often writes several times in a row).
+	syncDebounce = time.Second << 1 // two seconds
+
+	// Longest time a copy is delayed by ongoing changes.
+	syncMaxDelay = time.Second << 5 // 32 seconds
+
+	// Interval to check the original database's modification time.
+	syncPollInterval = time.Minute
+)
+
+// `goSyncFile()` watches in background the library's original database
+// file, copying it to the cache directory whenever it was changed; then
+// the library's pool switches to the new copy for all further queries.
+//
+// On Linux the database's directory is watched with `inotify` so
+// a new copy is made within seconds of Calibre finishing a write.
+// Additionally the file's modification time is checked once a
+// minute for filesystems not reporting changes (e.g. NFS).
+func (l *TLibrary) goSyncFile() {
+	var (
+		changes <-chan struct{}
+		pending time.Time // time of the first change not copied yet
+	)
+	watcher, err := newSyncWatcher(l.path,
+		dbCalibreDatabaseFilename,
+		dbCalibreDatabaseFilename+`-journal`,
+		dbCalibreDatabaseFilename+`-wal`)
+	if nil == err {
+		defer watcher.Close()
+		changes = watcher.Events()
+	} else {
+		apachelogger.Err("goSyncFile()",
+			fmt.Sprintf("%v – polling '%s' once a minute", err, l.path))
+	}
+
+	pollTimer := time.NewTimer(syncPollInterval)
+	debounceTimer := time.NewTimer(syncDebounce)
+	_ = debounceTimer.Stop()
 	defer func() {
-		_ = timer.Stop()
+		_ = pollTimer.Stop()
+		_ = debounceTimer.Stop()
 	}()
 
-	//lint:ignore S1000 - We won't use `range` here
+	doSync := func() {
+		pending = time.Time{}
+		if copied, err := l.syncDatabaseFile(); copied && (nil == err) {
+			_ = l.pool.renew()
+		}
+	}
+
 	for {
 		select {
-		case <-timer.C:
-			if copied, err := syncDatabaseFile(); copied && (nil == err) {
-				syncCopiedChan <- struct{}{}
+		case _, more := <-changes:
+			if !more { // watcher closed: fall back to polling only
+				changes = nil
+				continue
 			}
-			_ = timer.Reset(time.Minute)
+			if pending.IsZero() {
+				pending = time.Now()
+			}
+			if syncMaxDelay <= time.Since(pending) {
+				stopTimer(debounceTimer)
+				doSync()
+				continue
+			}
+			stopTimer(debounceTimer)
+			_ = debounceTimer.Reset(syncDebounce)
+
+		case <-debounceTimer.C:
+			doSync()
+
+		case <-pollTimer.C:
+			doSync()
+			_ = pollTimer.Reset(syncPollInterval)
 		}
 	}
 } // goSyncFile()
 
+// `stopTimer()` stops `aTimer` and drains its channel so that a tick
+// fired already doesn't trigger a (second) sync after `Reset()`.
+//
+//	`aTimer` The timer to stop.
+func stopTimer(aTimer *time.Timer) {
+	if !aTimer.Stop() {
+		select {
+		case <-aTimer.C:
+		default:
+		}
+	}
+} // stopTimer()
+
+// `syncSourceTime()` returns the modification time of the original
+// database, taking a possible WAL file into account.
+//
+//	`aName` The path-/filename of the original database.
+func syncSourceTime(aName string) (time.Time, error) {
+	fi, err := os.Stat(aName)
+	if nil != err {
+		return time.Time{}, err
+	}
+	result := fi.ModTime()
+	if wfi, err := os.Stat(aName + `-wal`); (nil == err) && wfi.ModTime().After(result) {
+		result = wfi.ModTime()
+	}
+
+	return result, nil
+} // syncSourceTime()
+
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
 // `goSQLtrace()` runs in background to log `aQuery`
@@ -202,111 +286,211 @@ func SQLtraceFile() string {
 	return syncSQLTraceFile
 } // SQLtraceFile()
 
-// `syncDatabaseFile()` copies Calibre's original database file
-// to the configured cache directory.
+const (
+	// Number of attempts to back up a database locked by Calibre.
+	syncBackupTries = 8
+)
+
+// `syncBackup()` copies the database `aSrcName` to `aDstName` using
+// SQLite's online backup API.
+//
+// Other than a plain file copy the backup honours SQLite's locking
+// and includes data still held in a WAL file, so the result is a
+// consistent snapshot even while Calibre is writing.
+//
+//	`aSrcName` The path-/filename of the original database.
+//	`aDstName` The path-/filename of the database copy.
+func syncBackup(aSrcName, aDstName string) error {
+	ctx := context.Background()
+	srcDB, err := sql.Open(`sqlite3`, `file:`+aSrcName+`?mode=ro`)
+	if nil != err {
+		return err
+	}
+	defer srcDB.Close()
+
+	dstDB, err := sql.Open(`sqlite3`, `file:`+aDstName)
+	if nil != err {
+		return err
+	}
+	defer dstDB.Close()
+
+	srcConn, err := srcDB.Conn(ctx)
+	if nil != err {
+		return err
+	}
+	defer srcConn.Close()
+
+	dstConn, err := dstDB.Conn(ctx)
+	if nil != err {
+		return err
+	}
+	defer dstConn.Close()
+
+	err = dstConn.Raw(func(aDst any) error {
+		return srcConn.Raw(func(aSrc any) error {
+			dst, ok := aDst.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New(`syncBackup: unexpected destination driver`)
+			}
+			src, ok := aSrc.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New(`syncBackup: unexpected source driver`)
+			}
+			backup, err := dst.Backup(`main`, src, `main`)
+			if nil != err {
+				return err
+			}
+			for try := 1; ; try++ {
+				done, err := backup.Step(-1)
+				if nil != err {
+					_ = backup.Finish()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				// The source is locked by another process:
+				if syncBackupTries <= try {
+					_ = backup.Finish()
+					return fmt.Errorf("syncBackup: '%s' stays locked", aSrcName)
+				}
+				time.Sleep(time.Duration(try) * 250 * time.Millisecond)
+			}
+		})
+	})
+	if nil != err {
+		return err
+	}
+
+	// The copy gets opened read-only, hence it mustn't depend on
+	// a WAL file (which Calibre's database might use):
+	_, err = dstConn.ExecContext(ctx, `PRAGMA journal_mode=DELETE`)
+
+	return err
+} // syncBackup()
+
+// `syncCheck()` runs SQLite's `quick_check` on the database `aName`,
+// returning an error if the database is damaged.
+//
+//	`aName` The path-/filename of the database to check.
+func syncCheck(aName string) error {
+	conn, err := sql.Open(`sqlite3`, `file:`+aName+`?mode=ro`)
+	if nil != err {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query(`PRAGMA quick_check`)
+	if nil != err {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err = rows.Scan(&msg); nil != err {
+			return err
+		}
+		if `ok` != msg {
+			problems = append(problems, msg)
+		}
+	}
+	if err = rows.Err(); nil != err {
+		return err
+	}
+	if 0 < len(problems) {
+		return fmt.Errorf("quick_check '%s': %s", aName, strings.Join(problems, `; `))
+	}
+
+	return nil
+} // syncCheck()
+
+// `syncDatabaseFile()` copies the library's original database file
+// to its cache directory.
+//
+// The copy is made with SQLite's online backup API into a temporary
+// file which – after passing a `quick_check` and getting the read
+// model added – atomically replaces the previous copy; if anything
+// fails the previous copy remains in use. Each outcome is written to
+// the error log.
 //
 // The `rCopied` return value signals whether the database file
 // was actually copied or not.
 // The `rErr` return value is either `nil` in case of success or
 // the error that occurred.
-func syncDatabaseFile() (rCopied bool, rErr error) {
+func (l *TLibrary) syncDatabaseFile() (rCopied bool, rErr error) {
 	var (
-		srcFile, tmpFile *os.File
-		srcFI, dstFI     os.FileInfo
+		dstFI   os.FileInfo
+		srcTime time.Time
 	)
-	defer func() {
-		if nil != srcFile {
-			_ = srcFile.Close()
-		}
-		if nil != tmpFile {
-			_ = tmpFile.Close()
-		}
-	}()
-	syncCopyMtx.Lock()
-	defer syncCopyMtx.Unlock()
+	l.syncMtx.Lock()
+	defer l.syncMtx.Unlock()
 
-	srcName := filepath.Join(dbCalibreLibraryPath, dbCalibreDatabaseFilename)
-	if srcFI, rErr = os.Stat(srcName); nil != rErr {
+	srcName := filepath.Join(l.path, dbCalibreDatabaseFilename)
+	if srcTime, rErr = syncSourceTime(srcName); nil != rErr {
+		apachelogger.Err("syncDatabaseFile()", fmt.Sprintf("%v", rErr))
 		return
 	}
 
-	dstName := filepath.Join(dbCalibreCachePath, dbCalibreDatabaseFilename)
+	dstName := l.pool.filename()
 	if dstFI, rErr = os.Stat(dstName); nil == rErr {
-		if srcFI.ModTime().Before(dstFI.ModTime()) {
+		// A copy without (an up-to-date) read model gets replaced
+		// unless building it failed already:
+		if srcTime.Before(dstFI.ModTime()) && (l.rmFailed || rmReady(dstName)) {
 			return
 		}
 	}
 
-	if srcFile, rErr = os.OpenFile(srcName, os.O_RDONLY, 0); /* #nosec G304 */ rErr != nil {
-		return
-	}
-
 	tmpName := dstName + `~`
-	if tmpFile, rErr = os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); rErr != nil {
+	defer os.Remove(tmpName) // #nosec G104
+
+	// Remove leftovers of an aborted run:
+	_ = os.Remove(tmpName)
+	_ = os.Remove(tmpName + `-journal`)
+
+	if rErr = syncBackup(srcName, tmpName); nil != rErr {
+		apachelogger.Err("syncDatabaseFile()",
+			fmt.Sprintf("backup of '%s' failed, keeping previous copy: %v", srcName, rErr))
 		return
 	}
-
-	if _, rErr = io.Copy(tmpFile, srcFile); nil != rErr {
+	if rErr = syncCheck(tmpName); nil != rErr {
+		apachelogger.Err("syncDatabaseFile()",
+			fmt.Sprintf("copy of '%s' is damaged, keeping previous copy: %v", srcName, rErr))
 		return
 	}
-	go goSQLtrace(`-- copied `+srcName+` to `+dstName, time.Now())
 
-	return true, os.Rename(tmpName, dstName)
-} // syncDatabaseFile()
-
-/*
-func syncBackupDataBase() (bool, error) {
-	syncCopyMtx.Lock()
-	defer syncCopyMtx.Unlock()
-
-	var (
-		backup           sqlite.SQLiteBackup
-		dstConn, srcConn *sql.DB
-		destConn         *sqlite.SQLiteConn
-		done             bool
-		err              error
-		sFI, dFI         os.FileInfo
-	)
-	sName := filepath.Join(dbCalibreLibraryPath, dbCalibreDatabaseFilename)
-	if sFI, err = os.Stat(sName); nil != err {
-		return false, err
+	// Without the read model the copy is still usable (just slower):
+	if err := rmBuild(tmpName); nil != err {
+		l.rmFailed = true
+		apachelogger.Err("syncDatabaseFile()",
+			fmt.Sprintf("can't build the read model of '%s': %v", tmpName, err))
+	} else {
+		l.rmFailed = false
 	}
 
-	dName := filepath.Join(dbCalibreCachePath, dbCalibreDatabaseFilename)
-	if dFI, err = os.Stat(dName); nil == err {
-		if sFI.ModTime().Before(dFI.ModTime()) {
-			return false, nil
+	// Compare with the previous copy (if any) to detect changes:
+	var changes TChangeList
+	if _, err := os.Stat(dstName); nil == err {
+		if changes, err = syncDiff(dstName, tmpName); nil != err {
+			apachelogger.Err("syncDatabaseFile()",
+				fmt.Sprintf("can't compare database copies: %v", err))
 		}
-	} // ELSE: the dest file doesn't exist yet
-
-	if srcConn, err = sql.Open(`sqlite3`, `file:`+sName+`?cache=shared&mode=ro`); nil != err {
-		return false, err
 	}
-	defer srcConn.Close()
-
-	tName := dName + `~`
-	if dstConn, err = sql.Open(`sqlite3`, `file:`+tName); nil != err {
-		return false, err
-	}
-	defer dstConn.Close()
-
-	if backup, err = dstConn.Backup(`main`, srcConn, `main`); nil != err {
-		return false, err
-	}
-	defer backup.Close()
 
-	if done, err = backup.Step(-1); nil != err {
-		return false, err
+	if rErr = os.Rename(tmpName, dstName); nil != rErr {
+		apachelogger.Err("syncDatabaseFile()",
+			fmt.Sprintf("can't replace '%s', keeping previous copy: %v", dstName, rErr))
+		return
 	}
-
-	if !done {
-		return false, errors.New(`Problem backing up ` + sName + ` to ` + tName)
+	apachelogger.Err("syncDatabaseFile()",
+		fmt.Sprintf("copied '%s' to '%s'", srcName, dstName))
+	go goSQLtrace(`-- copied `+srcName+` to `+dstName, time.Now())
+	if 0 < len(changes) {
+		go l.changesPublish(changes)
 	}
 
-	go goSQLtrace(`-- copied ` + sName + ` to ` + dName)
-
-	return true, os.Rename(tName, dName)
-} // syncBackupDataBase()
-*/
+	return true, nil
+} // syncDatabaseFile()
 
 /* _EoF_ */
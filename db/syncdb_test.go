@@ -0,0 +1,127 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_syncDatabaseFile(t *testing.T) {
+	lib := prepLibraryForTesting(t)
+	srcName := filepath.Join(lib.path, dbCalibreDatabaseFilename)
+	dstName := filepath.Join(lib.cachePath, dbCalibreDatabaseFilename)
+
+	// Keep the data in the WAL file (like a running Calibre might):
+	src, err := sql.Open(`sqlite3`, `file:`+srcName+`?_journal_mode=WAL`)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	src.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		`PRAGMA wal_autocheckpoint=0`,
+		`CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT)`,
+		`INSERT INTO books (title) VALUES ('one'), ('two')`,
+	} {
+		if _, err = src.Exec(stmt); nil != err {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	countBooks := func() (rCount int, rMode string) {
+		dst, err := sql.Open(`sqlite3`, `file:`+dstName+`?mode=ro`)
+		if nil != err {
+			t.Fatal(err)
+		}
+		defer dst.Close()
+		_ = dst.QueryRow(`SELECT COUNT(*) FROM books`).Scan(&rCount)
+		_ = dst.QueryRow(`PRAGMA journal_mode`).Scan(&rMode)
+		return
+	}
+
+	copied, err := lib.syncDatabaseFile()
+	if (!copied) || (nil != err) {
+		t.Fatalf("syncDatabaseFile() = %v, %v, want true, nil", copied, err)
+	}
+	if count, mode := countBooks(); (2 != count) || (`delete` != mode) {
+		t.Errorf("syncDatabaseFile() copy: count = %d, mode = %q", count, mode)
+	}
+
+	// An unchanged original isn't copied again:
+	if copied, err = lib.syncDatabaseFile(); copied || (nil != err) {
+		t.Errorf("syncDatabaseFile() = %v, %v, want false, nil", copied, err)
+	}
+
+	// A damaged original mustn't replace the previous copy:
+	src.Close()
+	_ = os.Remove(srcName + `-wal`)
+	_ = os.Remove(srcName + `-shm`)
+	if err = os.WriteFile(srcName, []byte(`this is not a database`), 0600); nil != err {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(srcName, future, future)
+	if copied, err = lib.syncDatabaseFile(); copied || (nil == err) {
+		t.Errorf("syncDatabaseFile() = %v, %v, want false, error", copied, err)
+	}
+	if count, _ := countBooks(); 2 != count {
+		t.Errorf("syncDatabaseFile() previous copy: count = %d, want 2", count)
+	}
+	if _, err = os.Stat(dstName + `~`); !os.IsNotExist(err) {
+		t.Errorf("syncDatabaseFile() left temporary file: %v", err)
+	}
+} // Test_syncDatabaseFile()
+
+func Test_syncCheck(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, `good.db`)
+	conn, _ := sql.Open(`sqlite3`, `file:`+good)
+	_, _ = conn.Exec(`CREATE TABLE t (x INTEGER)`)
+	conn.Close()
+	bad := filepath.Join(dir, `bad.db`)
+	_ = os.WriteFile(bad, []byte(`SQLite format 3`+string(make([]byte, 200))), 0600)
+
+	tests := []struct {
+		name    string
+		aName   string
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+		{" 1", good, false},
+		{" 2", bad, true},
+		{" 3", filepath.Join(dir, `missing.db`), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := syncCheck(tt.aName); (nil != err) != tt.wantErr {
+				t.Errorf("syncCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+} // Test_syncCheck()
+
+func Test_stopTimer(t *testing.T) {
+	timer := time.NewTimer(time.Millisecond)
+	time.Sleep(time.Millisecond * 20) // let the timer fire unnoticed
+
+	stopTimer(timer)
+	timer.Reset(time.Hour)
+	select {
+	case <-timer.C:
+		t.Error("stopTimer() left a stale tick in the channel")
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	stopTimer(timer) // a running timer
+	stopTimer(timer) // a stopped timer
+} // Test_stopTimer()
@@ -0,0 +1,127 @@
+//go:build linux
+
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the Linux `inotify` based watcher for the
+ * original Calibre database.
+ */
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// Events signalling a (possible) change of a watched file.
+	syncWatchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+		syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_MOVED_TO
+)
+
+type (
+	// `tSyncWatcher` reports changes of certain files in a directory.
+	tSyncWatcher struct {
+		events chan struct{}       // signals a change
+		file   *os.File            // the inotify file descriptor
+		names  map[string]struct{} // the files to watch
+	}
+)
+
+// `newSyncWatcher()` returns a watcher for the files `aNames` in the
+// directory `aDir`.
+//
+// The directory is watched (instead of the files themselves) to
+// notice files created later on (e.g. a database's WAL file) or
+// replaced by a rename.
+//
+//	`aDir` The directory to watch.
+//	`aNames` The files in `aDir` to report changes for.
+func newSyncWatcher(aDir string, aNames ...string) (*tSyncWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if nil != err {
+		return nil, os.NewSyscallError(`inotify_init1`, err)
+	}
+	if _, err = syscall.InotifyAddWatch(fd, aDir, syncWatchMask); nil != err {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError(`inotify_add_watch`, err)
+	}
+
+	result := &tSyncWatcher{
+		events: make(chan struct{}, 1),
+		// A non-blocking descriptor is handled by Go's runtime
+		// poller so that `Close()` interrupts a pending `Read()`.
+		file:  os.NewFile(uintptr(fd), `inotify`),
+		names: make(map[string]struct{}, len(aNames)),
+	}
+	for _, name := range aNames {
+		result.names[name] = struct{}{}
+	}
+	go result.goRead()
+
+	return result, nil
+} // newSyncWatcher()
+
+// `Close()` stops the watcher and closes its `Events()` channel.
+func (sw *tSyncWatcher) Close() error {
+	return sw.file.Close()
+} // Close()
+
+// `Events()` returns the channel signalling a change of the
+// watched files.
+//
+// Several changes happening before the channel is read are
+// reported only once.
+func (sw *tSyncWatcher) Events() <-chan struct{} {
+	return sw.events
+} // Events()
+
+// `goRead()` reads the inotify events in background until the
+// watcher gets closed.
+func (sw *tSyncWatcher) goRead() {
+	defer close(sw.events)
+
+	buf := make([]byte, (syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)<<4)
+	for {
+		n, err := sw.file.Read(buf)
+		if nil != err {
+			return
+		}
+		changed := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset])) // #nosec G103
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+			if (0 == event.Len) || (offset > n) {
+				continue
+			}
+			// The name is padded with NUL bytes:
+			name := string(buf[nameStart:offset])
+			for i := 0; i < len(name); i++ {
+				if 0 == name[i] {
+					name = name[:i]
+					break
+				}
+			}
+			if _, ok := sw.names[name]; ok {
+				changed = true
+			}
+		}
+		if changed {
+			select {
+			case sw.events <- struct{}{}:
+			default: // a signal is pending already
+			}
+		}
+	}
+} // goRead()
+
+/* _EoF_ */
@@ -0,0 +1,69 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_newSyncWatcher(t *testing.T) {
+	dir := t.TempDir()
+	sw, err := newSyncWatcher(dir, `metadata.db`, `metadata.db-wal`)
+	if nil != err {
+		t.Fatalf("newSyncWatcher() error = %v", err)
+	}
+	tests := []struct {
+		name  string
+		aFile string
+		want  bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `other.db`, false},
+		{" 2", `metadata.db`, true},
+		{" 3", `metadata.db-wal`, true},
+		{" 4", `metadata.db-shm`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// drop events left over from the previous write:
+			time.Sleep(50 * time.Millisecond)
+			select {
+			case <-sw.Events():
+			default:
+			}
+			if err := os.WriteFile(filepath.Join(dir, tt.aFile), []byte(`x`), 0600); nil != err {
+				t.Fatal(err)
+			}
+			got := false
+			select {
+			case <-sw.Events():
+				got = true
+			case <-time.After(200 * time.Millisecond):
+			}
+			if got != tt.want {
+				t.Errorf("tSyncWatcher.Events() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err = sw.Close(); nil != err {
+		t.Errorf("tSyncWatcher.Close() error = %v", err)
+	}
+	select {
+	case _, more := <-sw.Events():
+		if more {
+			t.Error("tSyncWatcher.Events() still open after Close()")
+		}
+	case <-time.After(time.Second):
+		t.Error("tSyncWatcher.Close() didn't stop the reader")
+	}
+} // Test_newSyncWatcher()
@@ -0,0 +1,51 @@
+//go:build !linux
+
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package db
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides a dummy watcher for systems without `inotify`;
+ * there the original Calibre database is only polled.
+ */
+
+import (
+	"errors"
+)
+
+type (
+	// `tSyncWatcher` reports changes of certain files in a directory.
+	tSyncWatcher struct {
+		events chan struct{} // signals a change
+	}
+)
+
+// `newSyncWatcher()` always returns an error since file watching
+// is not supported on this system.
+//
+//	`aDir` The directory to watch.
+//	`aNames` The files in `aDir` to report changes for.
+func newSyncWatcher(aDir string, aNames ...string) (*tSyncWatcher, error) {
+	return nil, errors.New(`newSyncWatcher: not supported on this system`)
+} // newSyncWatcher()
+
+// `Close()` stops the watcher and closes its `Events()` channel.
+func (sw *tSyncWatcher) Close() error {
+	close(sw.events)
+
+	return nil
+} // Close()
+
+// `Events()` returns the channel signalling a change of the
+// watched files.
+func (sw *tSyncWatcher) Events() <-chan struct{} {
+	return sw.events
+} // Events()
+
+/* _EoF_ */
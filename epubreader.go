@@ -0,0 +1,539 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the in-browser reader of EPUB books.
+ *
+ * The book's chapters are read from the EPUB archive on the server
+ * side; their HTML is sanitised (i.e. reduced to a list of harmless
+ * elements and attributes) before it's embedded in the reader page.
+ * The book's other files (images, package file, table of contents)
+ * are served unchanged, but with a sandboxing content policy.
+ */
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mwat56/kaliber/db"
+	"github.com/mwat56/sessions"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const (
+	// The font sizes (in percent) selectable by the reader page.
+	erFontMin, erFontMax, erFontStep = 60, 200, 10
+
+	// The session key of the reader's font size.
+	erFontKey = `readerFS`
+
+	// The prefix of the chapters' element IDs; it keeps them from
+	// clashing with the IDs of the reader page.
+	erIDPrefix = `ep-`
+
+	// The content policy of the files served from an EPUB: no
+	// scripts, no plugins, no external resources.
+	erResourcePolicy = `sandbox; default-src 'none'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; font-src 'self'`
+)
+
+type (
+	// `tReaderChapter` is a chapter (or TOC entry) of the reader page.
+	tReaderChapter struct {
+		Current bool   // flag whether it's the current chapter
+		Level   int    // the entry's nesting level
+		Title   string // the entry's text
+		URL     string // the entry's reader URL
+	}
+)
+
+var (
+	// The elements dropped together with their contents.
+	erDropElements = map[string]bool{
+		`applet`: true, `audio`: true, `button`: true, `canvas`: true,
+		`embed`: true, `form`: true, `head`: true, `iframe`: true,
+		`input`: true, `link`: true, `math`: true, `meta`: true,
+		`noscript`: true, `object`: true, `script`: true, `select`: true,
+		`style`: true, `template`: true, `textarea`: true, `title`: true,
+		`video`: true,
+	}
+
+	// The elements kept by the sanitiser and their allowed
+	// attributes (besides the global ones).
+	erElements = map[string][]string{
+		`a`: {`href`}, `abbr`: nil, `article`: nil, `aside`: nil,
+		`b`: nil, `bdi`: nil, `bdo`: nil, `big`: nil,
+		`blockquote`: nil, `br`: nil, `caption`: nil, `center`: nil,
+		`cite`: nil, `code`: nil, `col`: {`span`},
+		`colgroup`: {`span`}, `dd`: nil, `del`: nil, `dfn`: nil,
+		`div`: nil, `dl`: nil, `dt`: nil, `em`: nil,
+		`figcaption`: nil, `figure`: nil, `footer`: nil, `h1`: nil,
+		`h2`: nil, `h3`: nil, `h4`: nil, `h5`: nil, `h6`: nil,
+		`header`: nil, `hr`: nil, `i`: nil,
+		`img`: {`alt`, `height`, `src`, `width`}, `ins`: nil,
+		`kbd`: nil, `li`: {`value`}, `mark`: nil, `nav`: nil,
+		`ol`: {`reversed`, `start`, `type`}, `p`: nil, `pre`: nil,
+		`q`: nil, `rp`: nil, `rt`: nil, `ruby`: nil, `s`: nil,
+		`samp`: nil, `section`: nil, `small`: nil, `span`: nil,
+		`strong`: nil, `sub`: nil, `sup`: nil, `table`: nil,
+		`tbody`: nil, `td`: {`colspan`, `rowspan`}, `tfoot`: nil,
+		`th`: {`colspan`, `rowspan`, `scope`}, `thead`: nil,
+		`time`: nil, `tr`: nil, `tt`: nil, `u`: nil, `ul`: nil,
+		`var`: nil, `wbr`: nil,
+	}
+
+	// The attributes allowed for all elements kept.
+	erGlobalAttributes = []string{`dir`, `id`, `lang`, `title`}
+
+	// The elements without closing tag.
+	erVoidElements = map[string]bool{
+		`br`: true, `col`: true, `hr`: true, `img`: true, `wbr`: true,
+	}
+)
+
+// `readerFontSize()` returns the font size (in percent) to use by
+// the reader page.
+//
+// A font size given by the request's `fs` value is stored in the
+// user's session for the following pages.
+//
+//	`aRequest` The HTTP request received by the server.
+//	`aSession` The current user session.
+func readerFontSize(aRequest *http.Request, aSession *sessions.TSession) int {
+	if fs, err := strconv.Atoi(aRequest.FormValue(`fs`)); nil == err {
+		fs = min(max(fs, erFontMin), erFontMax)
+		aSession.Set(erFontKey, fs)
+		return fs
+	}
+	if fs, ok := aSession.GetInt(erFontKey); ok &&
+		(erFontMin <= fs) && (erFontMax >= fs) {
+		return int(fs)
+	}
+
+	return 100
+} // readerFontSize()
+
+// `readerLink()` returns the reader URL of the link `aRef` found in
+// the chapter `aChapter` of `aEpub`, or an empty string if the link
+// must not be used.
+//
+// Links to the book's chapters are directed to the reader page,
+// links to the book's other files (e.g. images) to the reader's
+// resource URLs; external links are allowed only for `http(s)` and
+// `mailto` URLs but not for images.
+//
+//	`aEpub` The opened EPUB file.
+//	`aBaseURL` The reader's URL of the book (without trailing slash).
+//	`aChapter` The archive filename of the chapter.
+//	`aRef` The link to rewrite.
+//	`aImage` Flag whether the link is an image's source.
+func readerLink(aEpub *db.TEpub, aBaseURL, aChapter, aRef string, aImage bool) string {
+	aRef = strings.TrimSpace(aRef)
+	if strings.HasPrefix(aRef, `#`) {
+		if aImage || (2 > len(aRef)) {
+			return ``
+		}
+		return `#` + erIDPrefix + aRef[1:]
+	}
+	ref, err := url.Parse(aRef)
+	if nil != err {
+		return ``
+	}
+	if 0 < len(ref.Scheme) {
+		switch strings.ToLower(ref.Scheme) {
+		case `http`, `https`, `mailto`:
+			if !aImage {
+				return ref.String()
+			}
+		case `data`:
+			lower := strings.ToLower(aRef)
+			if aImage && strings.HasPrefix(lower, `data:image/`) &&
+				!strings.HasPrefix(lower, `data:image/svg`) {
+				return aRef
+			}
+		}
+		return ``
+	}
+	if (0 < len(ref.Host)) || (0 == len(ref.Path)) {
+		return ``
+	}
+
+	name := path.Join(path.Dir(aChapter), ref.Path)
+	if !aImage {
+		if idx := aEpub.SpineIndex(name); 0 <= idx {
+			result := fmt.Sprintf("%s/%d", aBaseURL, idx+1)
+			if 0 < len(ref.Fragment) {
+				result += `#` + erIDPrefix + url.PathEscape(ref.Fragment)
+			}
+			return result
+		}
+	}
+	if _, ok := aEpub.Item(name); !ok {
+		return ``
+	}
+
+	return readerResURL(aBaseURL, name)
+} // readerLink()
+
+// `readerResURL()` returns the URL of the EPUB's file `aName`.
+//
+//	`aBaseURL` The reader's URL of the book (without trailing slash).
+//	`aName` The archive filename.
+func readerResURL(aBaseURL, aName string) string {
+	parts := strings.Split(aName, `/`)
+	for idx, part := range parts {
+		parts[idx] = url.PathEscape(part)
+	}
+
+	return aBaseURL + `/res/` + strings.Join(parts, `/`)
+} // readerResURL()
+
+// `readerURL()` returns the reader URL of `aDoc` or an empty string
+// if there's no EPUB file of the document.
+//
+//	`aDoc` The document to read.
+func readerURL(aDoc *db.TDocument) string {
+	if (nil == aDoc) || (0 == len(aDoc.Filename(`EPUB`))) {
+		return ``
+	}
+
+	return fmt.Sprintf("%s/read/%d/", aDoc.Library().URL(), aDoc.ID)
+} // readerURL()
+
+// `sanitizeChapter()` returns the contents of the `body` element of
+// the (X)HTML chapter `aData` reduced to harmless elements and
+// attributes.
+//
+// Scripts, styles, forms, and embedded objects are removed, as are
+// all event handlers and `style` attributes; the remaining links are
+// passed through `aRewrite` which returns the URL to use or an empty
+// string to drop the link.
+//
+//	`aData` The chapter's (X)HTML source.
+//	`aRewrite` The function rewriting the chapter's links.
+func sanitizeChapter(aData []byte, aRewrite func(aRef string, aImage bool) string) (template.HTML, error) {
+	var (
+		buf     bytes.Buffer
+		inBody  bool
+		skip    int      // nesting level of dropped elements
+		stack   []string // the elements written (or `""`)
+		sawBody bool
+	)
+	decoder := xml.NewDecoder(bytes.NewReader(aData))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(aLabel string, aInput io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(aLabel)
+		if nil != err {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(aInput), nil
+	}
+
+	var err error
+	for {
+		var token xml.Token
+		if token, err = decoder.Token(); nil != err {
+			break
+		}
+		switch tok := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(tok.Name.Local)
+			if 0 < skip {
+				skip++
+				continue
+			}
+			if `body` == name {
+				inBody, sawBody = true, true
+				continue
+			}
+			if erDropElements[name] {
+				skip = 1
+				continue
+			}
+			if !inBody {
+				continue
+			}
+			if `image` == name {
+				name = `img` // SVG-wrapped images, e.g. cover pages
+			}
+			allowed, ok := erElements[name]
+			if !ok {
+				stack = append(stack, ``) // keep the contents only
+				continue
+			}
+			stack = append(stack, name)
+			buf.WriteString(`<` + name)
+			sanitizeAttributes(&buf, name, tok.Attr, allowed, aRewrite)
+			buf.WriteString(`>`)
+
+		case xml.EndElement:
+			if 0 < skip {
+				skip--
+				continue
+			}
+			name := strings.ToLower(tok.Name.Local)
+			if `body` == name {
+				inBody = false
+				continue
+			}
+			if !inBody || (0 == len(stack)) {
+				continue
+			}
+			last := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if (0 < len(last)) && !erVoidElements[last] {
+				buf.WriteString(`</` + last + `>`)
+			}
+
+		case xml.CharData:
+			if inBody && (0 == skip) {
+				buf.WriteString(template.HTMLEscapeString(string(tok)))
+			}
+		}
+	}
+	for idx := len(stack) - 1; 0 <= idx; idx-- {
+		if (0 < len(stack[idx])) && !erVoidElements[stack[idx]] {
+			buf.WriteString(`</` + stack[idx] + `>`)
+		}
+	}
+	if (io.EOF != err) && (0 == buf.Len()) {
+		return ``, err
+	}
+	if !sawBody && (0 == buf.Len()) {
+		return ``, fmt.Errorf("sanitizeChapter(): no body found")
+	}
+
+	return template.HTML(buf.String()), nil // #nosec G203
+} // sanitizeChapter()
+
+// `sanitizeAttributes()` writes the allowed attributes of `aAttrs`
+// to `aBuffer`.
+//
+//	`aBuffer` The buffer to write to.
+//	`aElement` The (lower case) name of the element.
+//	`aAttrs` The element's attributes.
+//	`aAllowed` The attributes allowed for the element.
+//	`aRewrite` The function rewriting the links.
+func sanitizeAttributes(aBuffer *bytes.Buffer, aElement string, aAttrs []xml.Attr, aAllowed []string, aRewrite func(aRef string, aImage bool) string) {
+	isAllowed := func(aName string) bool {
+		for _, name := range erGlobalAttributes {
+			if name == aName {
+				return true
+			}
+		}
+		for _, name := range aAllowed {
+			if name == aName {
+				return true
+			}
+		}
+		return false
+	} // isAllowed()
+
+	seen := make(map[string]bool, len(aAttrs))
+	for _, attr := range aAttrs {
+		name, value := strings.ToLower(attr.Name.Local), attr.Value
+		if (0 < len(attr.Name.Space)) && (`href` != name) && (`lang` != name) {
+			continue // e.g. `epub:type`
+		}
+		if (`img` == aElement) && (`href` == name) {
+			name = `src` // `<image xlink:href="…">` of SVG cover pages
+		}
+		if seen[name] || !isAllowed(name) {
+			continue
+		}
+		switch name {
+		case `href`, `src`:
+			if value = aRewrite(value, `src` == name); 0 == len(value) {
+				continue
+			}
+		case `id`:
+			value = erIDPrefix + value
+		}
+		seen[name] = true
+		fmt.Fprintf(aBuffer, ` %s="%s"`, name, template.HTMLEscapeString(value))
+		if (`href` == name) && !strings.HasPrefix(value, `/`) &&
+			!strings.HasPrefix(value, `#`) {
+			aBuffer.WriteString(` rel="noopener noreferrer" target="_blank"`)
+		}
+	}
+} // sanitizeAttributes()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `handleReader()` serves the reader page of the chapter or the
+// file of an EPUB given by `aTail` (`ID/`, `ID/N`, or `ID/res/FILE`).
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aOptions` The current query options to use.
+//	`aSession` The current user session.
+//	`aDB` The DB handle to access the library's database.
+//	`aTail` The URL path's tail naming the document and chapter.
+func (ph *TPageHandler) handleReader(aWriter http.ResponseWriter, aRequest *http.Request, aOptions *db.TQueryOptions, aSession *sessions.TSession, aDB *db.TDataBase, aTail string) {
+	parts := strings.SplitN(aTail, `/`, 3)
+	id, err := strconv.Atoi(parts[0])
+	if (nil != err) || (0 >= id) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	doc := aDB.QueryDocMini(aRequest.Context(), id)
+	if nil == doc {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	file := doc.Filename(`EPUB`)
+	if 0 == len(file) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	epub, err := db.OpenEpub(filepath.Join(doc.Library().Path(), file))
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	defer epub.Close()
+
+	baseURL := fmt.Sprintf("%s/read/%d", doc.Library().URL(), doc.ID)
+	if (3 == len(parts)) && (`res` == parts[1]) {
+		ph.serveEpubFile(aWriter, aRequest, epub, baseURL, parts[2])
+		return
+	}
+	chapter := 1
+	if (2 <= len(parts)) && (0 < len(parts[1])) {
+		if chapter, err = strconv.Atoi(parts[1]); (nil != err) ||
+			(1 > chapter) || (len(epub.Spine) < chapter) {
+			http.NotFound(aWriter, aRequest)
+			return
+		}
+	}
+	item := epub.Spine[chapter-1]
+	data, mediaType, err := epub.File(item.Name)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	var content template.HTML
+	if strings.HasPrefix(mediaType, `image/`) {
+		content = template.HTML(`<p><img alt="" src="` + // #nosec G203
+			template.HTMLEscapeString(readerResURL(baseURL, item.Name)) + `"></p>`)
+	} else {
+		content, err = sanitizeChapter(data, func(aRef string, aImage bool) string {
+			return readerLink(epub, baseURL, item.Name, aRef, aImage)
+		})
+		if nil != err {
+			handleInternalError(aWriter, `TPageHandler.handleReader()`,
+				fmt.Sprintf("sanitizeChapter(%s): %v", item.Name, err))
+			return
+		}
+	}
+
+	// The table of contents (or the chapters if there's none):
+	var toc []tReaderChapter
+	for _, point := range epub.TOC {
+		idx := epub.SpineIndex(point.Name)
+		if 0 > idx {
+			continue
+		}
+		entry := tReaderChapter{
+			Current: idx == chapter-1,
+			Level:   point.Level,
+			Title:   point.Title,
+			URL:     fmt.Sprintf("%s/%d", baseURL, idx+1),
+		}
+		if 0 < len(point.Fragment) {
+			entry.URL += `#` + erIDPrefix + url.PathEscape(point.Fragment)
+		}
+		toc = append(toc, entry)
+	}
+	if 0 == len(toc) {
+		for idx := range epub.Spine {
+			toc = append(toc, tReaderChapter{
+				Current: idx == chapter-1,
+				Title:   strconv.Itoa(idx + 1),
+				URL:     fmt.Sprintf("%s/%d", baseURL, idx+1),
+			})
+		}
+	}
+
+	fontSize := readerFontSize(aRequest, aSession)
+	pageData := ph.basicTemplateData(aRequest, aOptions).
+		Set("Chapter", chapter).
+		Set("Chapters", len(epub.Spine)).
+		Set("Content", content).
+		Set("Document", doc).
+		Set("FontLarger", min(fontSize+erFontStep, erFontMax)).
+		Set("FontSize", fontSize).
+		Set("FontSmaller", max(fontSize-erFontStep, erFontMin)).
+		Set("ReaderURL", baseURL).
+		Set("TOC", toc).
+		Set("Title", doc.Title)
+	if 1 < chapter {
+		pageData.Set("PrevURL", fmt.Sprintf("%s/%d", baseURL, chapter-1))
+	}
+	if len(epub.Spine) > chapter {
+		pageData.Set("NextURL", fmt.Sprintf("%s/%d", baseURL, chapter+1))
+	}
+	aWriter.Header().Set(`Cache-Control`, `private, no-cache`)
+	ph.handleReply(`reader`, aWriter, aRequest, aOptions, aSession, pageData)
+} // handleReader()
+
+// `serveEpubFile()` sends the file `aName` of `aEpub`.
+//
+// (X)HTML files are sent sanitised like the reader's chapters,
+// all files are sent with a content policy forbidding scripts.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aEpub` The opened EPUB file.
+//	`aBaseURL` The reader's URL of the book (without trailing slash).
+//	`aName` The (URL escaped) archive filename.
+func (ph *TPageHandler) serveEpubFile(aWriter http.ResponseWriter, aRequest *http.Request, aEpub *db.TEpub, aBaseURL, aName string) {
+	if name, err := url.PathUnescape(aName); nil == err {
+		aName = name
+	}
+	aName = path.Clean(aName)
+	data, mediaType, err := aEpub.File(aName)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	switch mediaType {
+	case `application/xhtml+xml`, `text/html`:
+		content, err := sanitizeChapter(data, func(aRef string, aImage bool) string {
+			return readerLink(aEpub, aBaseURL, aName, aRef, aImage)
+		})
+		if nil != err {
+			http.NotFound(aWriter, aRequest)
+			return
+		}
+		data = []byte(`<!DOCTYPE html><html><head><meta charset="UTF-8"></head><body>` +
+			string(content) + `</body></html>`)
+		mediaType = `text/html; charset=utf-8`
+	}
+
+	header := aWriter.Header()
+	header.Set(`Cache-Control`, `private, max-age=864000`) // 10 days
+	header.Set(`Content-Security-Policy`, erResourcePolicy)
+	header.Set(`Content-Type`, mediaType)
+	header.Set(`X-Content-Type-Options`, `nosniff`)
+	_, _ = aWriter.Write(data)
+} // serveEpubFile()
+
+/* _EoF_ */
@@ -0,0 +1,201 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"archive/zip"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mwat56/kaliber/db"
+)
+
+// `prepEpub()` writes a small EPUB file to `aFilename` and opens it.
+func prepEpub(t *testing.T, aFilename string) *db.TEpub {
+	file, err := os.Create(aFilename)
+	if nil != err {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	for _, entry := range [][2]string{
+		{`META-INF/container.xml`, `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`},
+		{`OEBPS/content.opf`, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+<manifest>
+<item id="ch1" href="text/one.xhtml" media-type="application/xhtml+xml"/>
+<item id="ch2" href="text/two.xhtml" media-type="application/xhtml+xml"/>
+<item id="img" href="images/my map.png" media-type="image/png"/>
+</manifest>
+<spine><itemref idref="ch1"/><itemref idref="ch2"/></spine>
+</package>`},
+		{`OEBPS/text/one.xhtml`, `<html><body><p>One</p><script>alert(1)</script></body></html>`},
+		{`OEBPS/text/two.xhtml`, `<html><body><p>Two</p></body></html>`},
+		{`OEBPS/images/my map.png`, "\x89PNG\r\n\x1A\n"},
+	} {
+		w, err := zw.Create(entry[0])
+		if nil != err {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(entry[1])); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); nil != err {
+		t.Fatal(err)
+	}
+	if err = file.Close(); nil != err {
+		t.Fatal(err)
+	}
+	epub, err := db.OpenEpub(aFilename)
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = epub.Close() })
+
+	return epub
+} // prepEpub()
+
+func Test_readerLink(t *testing.T) {
+	epub := prepEpub(t, filepath.Join(t.TempDir(), `test.epub`))
+	const base = `/read/7`
+	const chapter = `OEBPS/text/one.xhtml`
+
+	tests := []struct {
+		name   string
+		aRef   string
+		aImage bool
+		want   string
+	}{
+		// TODO: Add test cases.
+		{" 1", `two.xhtml`, false, `/read/7/2`},
+		{" 2", `two.xhtml#note-1`, false, `/read/7/2#ep-note-1`},
+		{" 3", `#top`, false, `#ep-top`},
+		{" 4", `../images/my%20map.png`, true, `/read/7/res/OEBPS/images/my%20map.png`},
+		{" 5", `../images/missing.png`, true, ``},
+		{" 6", `https://example.org/`, false, `https://example.org/`},
+		{" 7", `https://example.org/tracker.png`, true, ``},
+		{" 8", `javascript:alert(1)`, false, ``},
+		{" 9", ` JavaScript:alert(1)`, false, ``},
+		{"10", `data:image/png;base64,AAAA`, true, `data:image/png;base64,AAAA`},
+		{"11", `data:image/svg+xml;base64,AAAA`, true, ``},
+		{"12", `data:text/html;base64,AAAA`, false, ``},
+		{"13", `//example.org/x`, false, ``},
+		{"14", `../content.opf`, false, ``},
+		{"15", `../../../etc/passwd`, false, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readerLink(epub, base, chapter, tt.aRef, tt.aImage); got != tt.want {
+				t.Errorf("readerLink() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_readerLink()
+
+func Test_sanitizeChapter(t *testing.T) {
+	rewrite := func(aRef string, aImage bool) string {
+		if strings.HasPrefix(aRef, `javascript:`) {
+			return ``
+		}
+		if aImage {
+			return `/img/` + aRef
+		}
+		return `/doc/` + aRef
+	}
+
+	tests := []struct {
+		name    string
+		aData   string
+		want    string
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml"><head><title>T</title><style>p{}</style></head><body><h1 id="c1">Title</h1><p class="x" style="color:red">Text &amp; more</p></body></html>`,
+			`<h1 id="ep-c1">Title</h1><p>Text &amp; more</p>`, false},
+		{" 2", `<html><body><p onclick="alert(1)">A<script>alert(2)</script>B</p><iframe src="x"><p>C</p></iframe></body></html>`,
+			`<p>AB</p>`, false},
+		{" 3", `<html><body><a href="javascript:alert(1)">x</a><a href="n.xhtml" target="_top">y</a></body></html>`,
+			`<a>x</a><a href="/doc/n.xhtml">y</a>`, false},
+		{" 4", `<html><body><p>A<br>B<img src="i.png" alt="pic" onerror="alert(1)"></p></body></html>`,
+			`<p>A<br>B<img src="/img/i.png" alt="pic"></p>`, false},
+		{" 5", `<html xmlns:xlink="http://www.w3.org/1999/xlink"><body><svg><image width="600" xlink:href="cover.jpg"/></svg></body></html>`,
+			`<img width="600" src="/img/cover.jpg">`, false},
+		{" 6", `<html><body><p epub:type="note" xml:lang="de"><custom>kept</custom> <b>open</p></body></html>`,
+			`<p lang="de">kept <b>open</b></p>`, false},
+		{" 7", `<html><body><p>"quoted" &lt;tag&gt; &nbsp;</p></body></html>`,
+			`<p>&#34;quoted&#34; &lt;tag&gt; ` + "\u00a0" + `</p>`, false},
+		{" 8", `<?xml version="1.0" encoding="ISO-8859-1"?><html><body><p>` + "\xe4" + `</p></body></html>`,
+			`<p>ä</p>`, false},
+		{" 9", `no markup at all`, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeChapter([]byte(tt.aData), rewrite)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("sanitizeChapter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("sanitizeChapter() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_sanitizeChapter()
+
+func TestTPageHandler_serveEpubFile(t *testing.T) {
+	epub := prepEpub(t, filepath.Join(t.TempDir(), `test.epub`))
+	ph := &TPageHandler{}
+
+	tests := []struct {
+		name     string
+		aName    string
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		// TODO: Add test cases.
+		{" 1", `OEBPS/images/my%20map.png`, 200, `image/png`, "\x89PNG"},
+		{" 2", `OEBPS/text/one.xhtml`, 200, `text/html; charset=utf-8`, `<p>One</p></body>`},
+		{" 3", `OEBPS/content.opf`, 200, `application/oebps-package+xml`, `<spine>`},
+		{" 4", `META-INF/container.xml`, 404, ``, ``},
+		{" 5", `OEBPS/../../x`, 404, ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(`GET`, `/read/7/res/`+tt.aName, nil)
+			ph.serveEpubFile(w, r, epub, `/read/7`, tt.aName)
+			if w.Code != tt.wantCode {
+				t.Errorf("serveEpubFile() code = %d, want %d", w.Code, tt.wantCode)
+				return
+			}
+			if 200 != w.Code {
+				return
+			}
+			if got := w.Header().Get(`Content-Type`); got != tt.wantType {
+				t.Errorf("serveEpubFile() type = %q, want %q", got, tt.wantType)
+			}
+			if got := w.Header().Get(`Content-Security-Policy`); !strings.HasPrefix(got, `sandbox`) {
+				t.Errorf("serveEpubFile() policy = %q", got)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tt.wantBody) ||
+				strings.Contains(body, `<script`) {
+				t.Errorf("serveEpubFile() body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+} // TestTPageHandler_serveEpubFile()
+
+/* _EoF_ */
@@ -4,6 +4,7 @@ go 1.21
 
 require (
 	github.com/NYTimes/gziphandler v1.1.1
+	github.com/bodgit/sevenzip v1.6.0
 	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/mwat56/apachelogger v1.6.3
 	github.com/mwat56/cssfs v0.2.7
@@ -14,10 +15,24 @@ require (
 	github.com/mwat56/sessions v0.3.15
 	github.com/mwat56/whitespace v0.2.5
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/nwaples/rardecode v1.1.3
+	golang.org/x/crypto v0.21.0
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.20.0
+	rsc.io/qr v0.2.0
 )
 
 require (
-	golang.org/x/crypto v0.21.0 // indirect
+	github.com/andybalholm/brotli v1.1.1 // indirect
+	github.com/bodgit/plumbing v1.3.0 // indirect
+	github.com/bodgit/windows v1.0.1 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	github.com/ulikunitz/xz v0.5.12 // indirect
+	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/term v0.18.0 // indirect
 )
@@ -0,0 +1,356 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides an authenticator reading a static `htpasswd`
+ * file as generated e.g. by Apache's `htpasswd` utility.
+ *
+ * Supported hash formats are bcrypt (`$2a$`, `$2b$`, `$2y$`),
+ * SHA-256/SHA-512 crypt (`$5$`, `$6$`), and the salted/unsalted
+ * SHA1 variants (`{SSHA}`, `{SHA}`).
+ */
+
+import (
+	"bufio"
+	"crypto/sha1" // #nosec G505
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	// THtpasswd is an authenticator using a static `htpasswd` file.
+	THtpasswd struct {
+		filename string            // name of the htpasswd file
+		modTime  time.Time         // file's modification time when read
+		mtx      *sync.RWMutex     // guard against concurrent accesses
+		users    map[string]string // hashed passwords by username
+	}
+)
+
+// NewHtpasswd returns a new `THtpasswd` instance reading `aFilename`.
+//
+//	`aFilename` The name of the htpasswd file to use.
+func NewHtpasswd(aFilename string) (*THtpasswd, error) {
+	result := &THtpasswd{
+		filename: aFilename,
+		mtx:      new(sync.RWMutex),
+		users:    make(map[string]string, 16),
+	}
+	if err := result.load(); nil != err {
+		return nil, err
+	}
+
+	return result, nil
+} // NewHtpasswd()
+
+// `find()` returns the password hash of `aUser`.
+//
+// If the htpasswd file has changed since it was last read
+// it gets reloaded first.
+//
+//	`aUser` The username to lookup.
+func (hp *THtpasswd) find(aUser string) (rHash string, rOK bool) {
+	if fi, err := os.Stat(hp.filename); nil == err {
+		hp.mtx.RLock()
+		changed := !fi.ModTime().Equal(hp.modTime)
+		hp.mtx.RUnlock()
+		if changed {
+			_ = hp.load()
+		}
+	}
+	hp.mtx.RLock()
+	defer hp.mtx.RUnlock()
+	rHash, rOK = hp.users[aUser]
+
+	return
+} // find()
+
+// IsAuthenticated checks `aRequest` for BasicAuth data,
+// returning `nil` for successful authentication, or an `error` otherwise.
+//
+// On success the username is stored in `aRequest.URL.User`.
+//
+//	`aRequest` The HTTP request received by the server.
+func (hp *THtpasswd) IsAuthenticated(aRequest *http.Request) error {
+	user, pass, ok := aRequest.BasicAuth()
+	if !ok {
+		return errors.New(`IsAuthenticated: missing authentication data`)
+	}
+	if !hp.Matches(user, pass) {
+		return errors.New(`IsAuthenticated: invalid user/password`)
+	}
+
+	// Store the user info so others can check for it
+	aRequest.URL.User = url.User(user)
+
+	return nil
+} // IsAuthenticated()
+
+// `load()` reads the htpasswd file replacing the current list.
+func (hp *THtpasswd) load() error {
+	file, err := os.Open(hp.filename) // #nosec G304
+	if nil != err {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if nil != err {
+		return err
+	}
+	users := make(map[string]string, len(hp.users)+1)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if (0 == len(line)) || ('#' == line[0]) {
+			continue
+		}
+		if parts := strings.SplitN(line, `:`, 2); 2 == len(parts) {
+			users[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	if err = scanner.Err(); nil != err {
+		return err
+	}
+
+	hp.mtx.Lock()
+	hp.users, hp.modTime = users, fi.ModTime()
+	hp.mtx.Unlock()
+
+	return nil
+} // load()
+
+// Matches checks whether `aPassword` of `aUser` matches
+// the stored password hash.
+//
+//	`aUser` The username to lookup.
+//	`aPassword` The (unhashed) password to check.
+func (hp *THtpasswd) Matches(aUser, aPassword string) bool {
+	pwHash, ok := hp.find(aUser)
+	if !ok {
+		return false
+	}
+
+	return htpasswdMatches(pwHash, aPassword)
+} // Matches()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `htpasswdMatches()` checks whether `aPassword` matches `aHash`.
+//
+//	`aHash` The stored password hash.
+//	`aPassword` The (unhashed) password to check.
+func htpasswdMatches(aHash, aPassword string) bool {
+	switch {
+	case strings.HasPrefix(aHash, `$2a$`),
+		strings.HasPrefix(aHash, `$2b$`),
+		strings.HasPrefix(aHash, `$2y$`):
+		return nil == bcrypt.CompareHashAndPassword([]byte(aHash), []byte(aPassword))
+
+	case strings.HasPrefix(aHash, `$5$`):
+		computed, err := shaCrypt(sha256.New, aPassword, aHash)
+		return (nil == err) && (1 == subtle.ConstantTimeCompare([]byte(computed), []byte(aHash)))
+
+	case strings.HasPrefix(aHash, `$6$`):
+		computed, err := shaCrypt(sha512.New, aPassword, aHash)
+		return (nil == err) && (1 == subtle.ConstantTimeCompare([]byte(computed), []byte(aHash)))
+
+	case strings.HasPrefix(aHash, `{SHA}`):
+		sum := sha1.Sum([]byte(aPassword)) // #nosec G401
+		computed := base64.StdEncoding.EncodeToString(sum[:])
+		return 1 == subtle.ConstantTimeCompare([]byte(computed), []byte(aHash[5:]))
+
+	case strings.HasPrefix(aHash, `{SSHA}`):
+		raw, err := base64.StdEncoding.DecodeString(aHash[6:])
+		if (nil != err) || (sha1.Size >= len(raw)) {
+			return false
+		}
+		sum := sha1.Sum(append([]byte(aPassword), raw[sha1.Size:]...)) // #nosec G401
+		return 1 == subtle.ConstantTimeCompare(sum[:], raw[:sha1.Size])
+	}
+
+	// Neither plain text nor the (insecure) MD5/crypt variants
+	// are supported.
+	return false
+} // htpasswdMatches()
+
+const (
+	// Alphabet used by the `crypt` base64 variant.
+	shaCryptAlphabet = `./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz`
+
+	// Default, minimal, and maximal number of rounds of SHA-crypt.
+	shaCryptRoundsDefault = 5000
+	shaCryptRoundsMin     = 1000
+	shaCryptRoundsMax     = 999999999
+)
+
+var (
+	// Byte order of the final SHA-256 digest when encoding.
+	shaCrypt256Order = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+
+	// Byte order of the final SHA-512 digest when encoding.
+	shaCrypt512Order = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// `shaCryptEncode()` appends `aCount` characters encoding the three
+// bytes `aB2`, `aB1`, and `aB0` to `aBuilder`.
+func shaCryptEncode(aBuilder *strings.Builder, aB2, aB1, aB0 byte, aCount int) {
+	w := uint(aB2)<<16 | uint(aB1)<<8 | uint(aB0)
+	for ; 0 < aCount; aCount-- {
+		aBuilder.WriteByte(shaCryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+} // shaCryptEncode()
+
+// `shaCryptRepeat()` returns `aData` repeated up to `aLength` bytes.
+func shaCryptRepeat(aData []byte, aLength int) []byte {
+	result := make([]byte, 0, aLength)
+	for aLength > len(result) {
+		n := aLength - len(result)
+		if n > len(aData) {
+			n = len(aData)
+		}
+		result = append(result, aData[:n]...)
+	}
+
+	return result
+} // shaCryptRepeat()
+
+// `shaCrypt()` computes the SHA-256/SHA-512 based `crypt` hash of
+// `aPassword` using the settings (prefix, rounds, and salt) of
+// `aSetting` as described by U.Drepper's specification.
+//
+//	`aNew` The constructor of the hash function to use.
+//	`aPassword` The password to hash.
+//	`aSetting` A complete hash or just its `$N$[rounds=R$]salt` part.
+func shaCrypt(aNew func() hash.Hash, aPassword, aSetting string) (string, error) {
+	parts := strings.Split(aSetting, `$`)
+	if (3 > len(parts)) || (0 < len(parts[0])) {
+		return ``, fmt.Errorf("shaCrypt: invalid setting '%s'", aSetting)
+	}
+	prefix, parts := `$`+parts[1]+`$`, parts[2:]
+	rounds, customRounds := shaCryptRoundsDefault, false
+	if strings.HasPrefix(parts[0], `rounds=`) {
+		r, err := strconv.Atoi(parts[0][7:])
+		if (nil != err) || (2 > len(parts)) {
+			return ``, fmt.Errorf("shaCrypt: invalid rounds in '%s'", aSetting)
+		}
+		if shaCryptRoundsMin > r {
+			r = shaCryptRoundsMin
+		} else if shaCryptRoundsMax < r {
+			r = shaCryptRoundsMax
+		}
+		rounds, customRounds, parts = r, true, parts[1:]
+	}
+	salt := parts[0]
+	if 16 < len(salt) {
+		salt = salt[:16]
+	}
+	key, sb := []byte(aPassword), []byte(salt)
+
+	h := aNew()
+	h.Write(key)
+	h.Write(sb)
+	h.Write(key)
+	digestB := h.Sum(nil)
+	size := len(digestB)
+
+	h.Reset()
+	h.Write(key)
+	h.Write(sb)
+	h.Write(shaCryptRepeat(digestB, len(key)))
+	for n := len(key); 0 < n; n >>= 1 {
+		if 0 != n&1 {
+			h.Write(digestB)
+		} else {
+			h.Write(key)
+		}
+	}
+	digestA := h.Sum(nil)
+
+	h.Reset()
+	for i := 0; i < len(key); i++ {
+		h.Write(key)
+	}
+	pSeq := shaCryptRepeat(h.Sum(nil), len(key))
+
+	h.Reset()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		h.Write(sb)
+	}
+	sSeq := shaCryptRepeat(h.Sum(nil), len(sb))
+
+	digestC := digestA
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if 0 != i&1 {
+			h.Write(pSeq)
+		} else {
+			h.Write(digestC)
+		}
+		if 0 != i%3 {
+			h.Write(sSeq)
+		}
+		if 0 != i%7 {
+			h.Write(pSeq)
+		}
+		if 0 != i&1 {
+			h.Write(digestC)
+		} else {
+			h.Write(pSeq)
+		}
+		digestC = h.Sum(digestC[:0])
+	}
+
+	var result strings.Builder
+	result.WriteString(prefix)
+	if customRounds {
+		result.WriteString(`rounds=` + strconv.Itoa(rounds) + `$`)
+	}
+	result.WriteString(salt + `$`)
+	if sha256.Size == size {
+		for _, o := range shaCrypt256Order {
+			shaCryptEncode(&result, digestC[o[0]], digestC[o[1]], digestC[o[2]], 4)
+		}
+		shaCryptEncode(&result, 0, digestC[31], digestC[30], 3)
+	} else {
+		for _, o := range shaCrypt512Order {
+			shaCryptEncode(&result, digestC[o[0]], digestC[o[1]], digestC[o[2]], 4)
+		}
+		shaCryptEncode(&result, 0, 0, digestC[63], 2)
+	}
+
+	return result.String(), nil
+} // shaCrypt()
+
+/* _EoF_ */
@@ -0,0 +1,142 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"crypto/sha1" // #nosec G505
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_shaCrypt(t *testing.T) {
+	tests := []struct {
+		name      string
+		sha512    bool
+		aPassword string
+		aSetting  string
+		want      string
+	}{
+		// TODO: Add test cases.
+		{" 1", false, `Hello world!`, `$5$saltstring`,
+			`$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5`},
+		{" 2", true, `Hello world!`, `$6$saltstring`,
+			`$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1`},
+		{" 3", false, `the minimum number is still observed`, `$5$rounds=10$roundstoolow`,
+			`$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC`},
+		{" 4", true, `secret`, `$6$abc$`,
+			`$6$abc$IdWKNKTJEb8LxY7CGg8YBXlvtfZzFw7Mp/r6niK9YB2mdvgY..TKjv1T..8RadRt2qvUHYRLr/TsVArtr91iR1`},
+		{" 5", false, `pw`, `$5$toolongsaltstringXYZ`,
+			`$5$toolongsaltstrin$Y3vz9Yc/Xx.o6DLddrkbpSLCVwMU7/nP4IbKEArcEi9`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hf := sha256.New
+			if tt.sha512 {
+				hf = sha512.New
+			}
+			got, err := shaCrypt(hf, tt.aPassword, tt.aSetting)
+			if nil != err {
+				t.Errorf("shaCrypt() error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("shaCrypt() = %q,\nwant %q", got, tt.want)
+			}
+		})
+	}
+} // Test_shaCrypt()
+
+func Test_htpasswdMatches(t *testing.T) {
+	bc, _ := bcrypt.GenerateFromPassword([]byte(`secret`), bcrypt.MinCost)
+	bcY := `$2y$` + string(bc[4:])
+	salt := []byte(`pepper`)
+	sum := sha1.Sum(append([]byte(`secret`), salt...)) // #nosec G401
+	ssha := `{SSHA}` + base64.StdEncoding.EncodeToString(append(sum[:], salt...))
+
+	tests := []struct {
+		name      string
+		aHash     string
+		aPassword string
+		want      bool
+	}{
+		// TODO: Add test cases.
+		{" 1", string(bc), `secret`, true},
+		{" 2", string(bc), `wrong`, false},
+		{" 3", bcY, `secret`, true},
+		{" 4", `{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=`, `password`, true},
+		{" 5", `{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=`, `Password`, false},
+		{" 6", ssha, `secret`, true},
+		{" 7", ssha, `secreT`, false},
+		{" 8", `$6$abc$IdWKNKTJEb8LxY7CGg8YBXlvtfZzFw7Mp/r6niK9YB2mdvgY..TKjv1T..8RadRt2qvUHYRLr/TsVArtr91iR1`, `secret`, true},
+		{" 9", `$6$abc$IdWKNKTJEb8LxY7CGg8YBXlvtfZzFw7Mp/r6niK9YB2mdvgY..TKjv1T..8RadRt2qvUHYRLr/TsVArtr91iR1`, `secret2`, false},
+		{"10", `$apr1$abcdefgh$0123456789012345678901`, `secret`, false},
+		{"11", `secret`, `secret`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := htpasswdMatches(tt.aHash, tt.aPassword); got != tt.want {
+				t.Errorf("htpasswdMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // Test_htpasswdMatches()
+
+func TestTHtpasswd_IsAuthenticated(t *testing.T) {
+	bc, _ := bcrypt.GenerateFromPassword([]byte(`secret`), bcrypt.MinCost)
+	fName := filepath.Join(t.TempDir(), `htpasswd`)
+	content := strings.Join([]string{
+		`# test users`,
+		`alice:` + string(bc),
+		`bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=`,
+	}, "\n")
+	if err := os.WriteFile(fName, []byte(content), 0600); nil != err {
+		t.Fatal(err)
+	}
+	hp, err := NewHtpasswd(fName)
+	if nil != err {
+		t.Fatalf("NewHtpasswd() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		aUser   string
+		aPass   string
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `alice`, `secret`, false},
+		{" 2", `alice`, `password`, true},
+		{" 3", `bob`, `password`, false},
+		{" 4", `carol`, `password`, true},
+		{" 5", ``, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(`GET`, `/`, nil)
+			if 0 < len(tt.aUser) {
+				req.SetBasicAuth(tt.aUser, tt.aPass)
+			}
+			if err := hp.IsAuthenticated(req); (nil != err) != tt.wantErr {
+				t.Errorf("THtpasswd.IsAuthenticated() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewHtpasswd(fName + `.missing`); nil == err {
+		t.Error("NewHtpasswd() with missing file: error = nil")
+	}
+} // TestTHtpasswd_IsAuthenticated()
@@ -0,0 +1,401 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the image cache manager keeping track of the
+ * size and last access of the cached images (thumbnails and cover
+ * renditions), and removing the least recently used ones if the
+ * configured disk budget is exceeded.
+ */
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mwat56/apachelogger"
+	"github.com/mwat56/kaliber/db"
+)
+
+const (
+	// Min. period between two updates of a file's access time.
+	icTouchInterval = time.Hour
+
+	// Part of the budget to keep free after an eviction (in percent)
+	// to avoid evicting on every new image.
+	icHeadroom = 10
+)
+
+type (
+	// `tCacheEntry` is a single cached image.
+	tCacheEntry struct {
+		size int64     // the image's file size
+		used time.Time // the image's last access
+	}
+
+	// TImageCacheStats is a snapshot of the image cache's state.
+	TImageCacheStats struct {
+		Budget       int64     // max. number of bytes (`0`: unlimited)
+		Size         int64     // number of bytes used
+		Files        int       // number of cached images
+		Accesses     uint64    // number of images served
+		Generated    uint64    // number of images generated
+		Evicted      uint64    // number of images evicted
+		EvictedBytes int64     // number of bytes evicted
+		Scanned      time.Time // time the cache directories were read
+	}
+
+	// `tImageCache` keeps track of the cached images.
+	tImageCache struct {
+		mtx          sync.Mutex
+		accesses     uint64
+		budget       int64
+		entries      map[string]*tCacheEntry // cached images by filename
+		evicted      uint64
+		evictedBytes int64
+		generated    uint64
+		scanned      time.Time
+		size         int64
+	}
+)
+
+var (
+	// The image cache of all libraries.
+	imgCache = newImageCache(0)
+)
+
+// `cacheImages()` returns the cached images below `aRoot` along with
+// their file info.
+//
+// Only JPEG files are considered so that the database copy and the
+// other files in the library's cache directory are never touched.
+//
+//	`aRoot` The cache directory of a library.
+func cacheImages(aRoot string) (map[string]fs.FileInfo, error) {
+	result := make(map[string]fs.FileInfo, 1024)
+	err := filepath.WalkDir(aRoot, func(aName string, aEntry fs.DirEntry, aErr error) error {
+		if nil != aErr {
+			if aName == aRoot {
+				return aErr
+			}
+			return nil // skip unreadable sub-directories
+		}
+		if aEntry.IsDir() {
+			switch aEntry.Name() {
+			case db.CoversDirectory, phDirectory:
+				// the covers extracted from the book files and the
+				// placeholders used instead of missing covers
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !aEntry.Type().IsRegular() {
+			return nil
+		}
+		base := aEntry.Name()
+		if strings.HasPrefix(base, `.`) || !strings.HasSuffix(base, `.jpg`) {
+			return nil // temporary or unrelated file
+		}
+		if fi, err := aEntry.Info(); nil == err {
+			result[aName] = fi
+		}
+		return nil
+	})
+
+	return result, err
+} // cacheImages()
+
+// `cacheRoots()` returns the cache directories of all libraries.
+func cacheRoots() []string {
+	var result []string
+	for _, lib := range db.Libraries() {
+		result = append(result, lib.CachePath())
+	}
+
+	return result
+} // cacheRoots()
+
+// `newImageCache()` returns a new image cache with `aBudget` bytes.
+//
+//	`aBudget` The max. number of bytes to use (`0`: unlimited).
+func newImageCache(aBudget int64) *tImageCache {
+	return &tImageCache{
+		budget:  aBudget,
+		entries: make(map[string]*tCacheEntry, 1024),
+	}
+} // newImageCache()
+
+// `access()` records that the image `aName` was served.
+//
+// The file's access time is updated (at most once per hour) so that
+// the order of use survives a restart of the program.
+//
+//	`aName` The filename of the cached image.
+func (ic *tImageCache) access(aName string) {
+	now := time.Now()
+	ic.mtx.Lock()
+	ic.accesses++
+	entry, ok := ic.entries[aName]
+	if ok && (now.Sub(entry.used) < icTouchInterval) {
+		entry.used = now
+		ic.mtx.Unlock()
+		return
+	}
+	ic.mtx.Unlock()
+
+	fi, err := os.Stat(aName)
+	if nil != err {
+		return
+	}
+	_ = os.Chtimes(aName, now, fi.ModTime())
+
+	ic.mtx.Lock()
+	defer ic.mtx.Unlock()
+	if entry, ok = ic.entries[aName]; ok {
+		entry.used = now
+		return
+	}
+	ic.entries[aName] = &tCacheEntry{size: fi.Size(), used: now}
+	ic.size += fi.Size()
+	ic.enforce()
+} // access()
+
+// `add()` records the (re-)generated image `aName`.
+//
+//	`aName` The filename of the cached image.
+func (ic *tImageCache) add(aName string) {
+	fi, err := os.Stat(aName)
+	if nil != err {
+		return
+	}
+
+	ic.mtx.Lock()
+	defer ic.mtx.Unlock()
+	ic.generated++
+	if entry, ok := ic.entries[aName]; ok {
+		ic.size -= entry.size
+	}
+	ic.entries[aName] = &tCacheEntry{size: fi.Size(), used: time.Now()}
+	ic.size += fi.Size()
+	ic.enforce()
+} // add()
+
+// `clear()` removes all cached images.
+//
+// It returns the number of files and bytes removed.
+func (ic *tImageCache) clear() (int, int64) {
+	ic.mtx.Lock()
+	defer ic.mtx.Unlock()
+
+	var (
+		count int
+		bytes int64
+	)
+	for name, entry := range ic.entries {
+		if err := os.Remove(name); (nil == err) || os.IsNotExist(err) {
+			count++
+			bytes += entry.size
+		}
+		delete(ic.entries, name)
+	}
+	ic.size = 0
+
+	return count, bytes
+} // clear()
+
+// `enforce()` removes the least recently used images if the cache
+// exceeds its budget.
+//
+// NOTE: The caller must hold the cache's lock.
+func (ic *tImageCache) enforce() {
+	if (0 >= ic.budget) || (ic.size <= ic.budget) {
+		return
+	}
+	names := make([]string, 0, len(ic.entries))
+	for name := range ic.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return ic.entries[names[i]].used.Before(ic.entries[names[j]].used)
+	})
+
+	limit := ic.budget - ic.budget*icHeadroom/100
+	for _, name := range names {
+		if ic.size <= limit {
+			break
+		}
+		if err := os.Remove(name); (nil != err) && !os.IsNotExist(err) {
+			msg := fmt.Sprintf("os.Remove(%s): %v", name, err)
+			apachelogger.Err("tImageCache.enforce()", msg)
+			continue
+		}
+		entry := ic.entries[name]
+		delete(ic.entries, name)
+		ic.size -= entry.size
+		ic.evicted++
+		ic.evictedBytes += entry.size
+	}
+} // enforce()
+
+// `forget()` removes `aName` from the cache's list (e.g. after the
+// file was deleted).
+//
+//	`aName` The filename of the cached image.
+func (ic *tImageCache) forget(aName string) {
+	ic.mtx.Lock()
+	defer ic.mtx.Unlock()
+
+	if entry, ok := ic.entries[aName]; ok {
+		delete(ic.entries, aName)
+		ic.size -= entry.size
+	}
+} // forget()
+
+// `scan()` reads the cached images below `aRoots` and removes the
+// least recently used ones if the cache exceeds its budget.
+//
+// The images' last access is taken from their files' access times.
+//
+//	`aRoots` The cache directories of the libraries.
+func (ic *tImageCache) scan(aRoots []string) error {
+	found := make(map[string]fs.FileInfo, 1024)
+	for _, root := range aRoots {
+		images, err := cacheImages(root)
+		if nil != err {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for name, fi := range images {
+			found[name] = fi
+		}
+	}
+
+	ic.mtx.Lock()
+	defer ic.mtx.Unlock()
+	for name, fi := range found {
+		if _, ok := ic.entries[name]; ok {
+			continue // added while scanning
+		}
+		ic.entries[name] = &tCacheEntry{size: fi.Size(), used: fileAccessTime(fi)}
+		ic.size += fi.Size()
+	}
+	ic.scanned = time.Now()
+	ic.enforce()
+
+	return nil
+} // scan()
+
+// `stats()` returns a snapshot of the cache's state.
+func (ic *tImageCache) stats() TImageCacheStats {
+	ic.mtx.Lock()
+	defer ic.mtx.Unlock()
+
+	return TImageCacheStats{
+		Budget:       ic.budget,
+		Size:         ic.size,
+		Files:        len(ic.entries),
+		Accesses:     ic.accesses,
+		Generated:    ic.generated,
+		Evicted:      ic.evicted,
+		EvictedBytes: ic.evictedBytes,
+		Scanned:      ic.scanned,
+	}
+} // stats()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// ImageCacheScan reads the cached images of all libraries (and
+// removes the least recently used ones if the cache exceeds its
+// budget).
+func ImageCacheScan() {
+	if err := imgCache.scan(cacheRoots()); nil != err {
+		msg := fmt.Sprintf("tImageCache.scan(): %v", err)
+		apachelogger.Err("ImageCacheScan()", msg)
+	}
+} // ImageCacheScan()
+
+// ImageCacheStats returns a snapshot of the image cache's state.
+func ImageCacheStats() TImageCacheStats {
+	return imgCache.stats()
+} // ImageCacheStats()
+
+// SetImageCacheSize sets the disk budget of the cached images.
+//
+//	`aMegabytes` The max. size of the cached images (`0`: unlimited).
+func SetImageCacheSize(aMegabytes int) int64 {
+	if 0 > aMegabytes {
+		aMegabytes = 0
+	}
+	imgCache.mtx.Lock()
+	defer imgCache.mtx.Unlock()
+	imgCache.budget = int64(aMegabytes) << 20
+
+	return imgCache.budget
+} // SetImageCacheSize()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// CachePrune removes the least recently used cached images until
+// the cache fits into its budget, and prints the cache's state.
+//
+// NOTE: This function does not return but terminates the program
+// with error code `0` (zero) if successful, or `1` (one) otherwise.
+func CachePrune() {
+	if err := imgCache.scan(cacheRoots()); nil != err {
+		fmt.Fprintf(os.Stderr, "\n\tcan't read the image cache: %v\n", err)
+		os.Exit(1)
+	}
+	st := imgCache.stats()
+	fmt.Printf("\n\tremoved %d images (%d bytes)\n\t%d images left (%d bytes, budget %d bytes)\n\n",
+		st.Evicted, st.EvictedBytes, st.Files, st.Size, st.Budget)
+
+	os.Exit(0)
+} // CachePrune()
+
+// CacheRebuild removes all cached images and generates the
+// thumbnails of all libraries' books anew; the cover renditions
+// are generated again when requested.
+//
+// NOTE: This function does not return but terminates the program
+// with error code `0` (zero) if successful, or `1` (one) otherwise.
+func CacheRebuild() {
+	if err := imgCache.scan(cacheRoots()); nil != err {
+		fmt.Fprintf(os.Stderr, "\n\tcan't read the image cache: %v\n", err)
+		os.Exit(1)
+	}
+	count, bytes := imgCache.clear()
+	fmt.Printf("\n\tremoved %d images (%d bytes)\n", count, bytes)
+
+	ctx := context.Background()
+	for _, lib := range db.Libraries() {
+		dbHandle, err := lib.Open(ctx)
+		if nil != err {
+			fmt.Fprintf(os.Stderr, "\n\tcan't open library '%s': %v\n", lib.Name(), err)
+			os.Exit(1)
+		}
+		thumbnailsUpdate(lib, dbHandle, true)
+		dbHandle.Close()
+	}
+	st := imgCache.stats()
+	fmt.Printf("\tgenerated %d thumbnails (%d images left, %d bytes, budget %d bytes)\n\n",
+		st.Generated, st.Files, st.Size, st.Budget)
+
+	os.Exit(0)
+} // CacheRebuild()
+
+/* _EoF_ */
@@ -0,0 +1,35 @@
+//go:build linux
+
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the Linux specific access time of a file.
+ */
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// `fileAccessTime()` returns the last access time of the file
+// described by `aInfo`.
+//
+//	`aInfo` The file's info.
+func fileAccessTime(aInfo fs.FileInfo) time.Time {
+	if st, ok := aInfo.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)) //nolint:unconvert
+	}
+
+	return aInfo.ModTime()
+} // fileAccessTime()
+
+/* _EoF_ */
@@ -0,0 +1,32 @@
+//go:build !linux
+
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the access time of a file on systems where
+ * it's not available in a portable way; there the modification
+ * time is used instead.
+ */
+
+import (
+	"io/fs"
+	"time"
+)
+
+// `fileAccessTime()` returns the last modification time of the file
+// described by `aInfo`.
+//
+//	`aInfo` The file's info.
+func fileAccessTime(aInfo fs.FileInfo) time.Time {
+	return aInfo.ModTime()
+} // fileAccessTime()
+
+/* _EoF_ */
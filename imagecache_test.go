@@ -0,0 +1,141 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// `prepImageCache()` creates `aCount` images of 100 bytes each in
+// `aDir`, the first one being the least recently used.
+func prepImageCache(t *testing.T, aDir string, aCount int) []string {
+	var result []string
+	mTime := time.Now().Add(-48 * time.Hour)
+	for idx := 0; idx < aCount; idx++ {
+		name := filepath.Join(aDir, `0000`, `00000`+string(rune('0'+idx))+`.jpg`)
+		if err := os.MkdirAll(filepath.Dir(name), 0700); nil != err {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, make([]byte, 100), 0600); nil != err {
+			t.Fatal(err)
+		}
+		aTime := time.Now().Add(time.Duration(idx-aCount) * time.Hour)
+		if err := os.Chtimes(name, aTime, mTime); nil != err {
+			t.Fatal(err)
+		}
+		result = append(result, name)
+	}
+
+	return result
+} // prepImageCache()
+
+func Test_cacheImages(t *testing.T) {
+	dir := t.TempDir()
+	images := prepImageCache(t, dir, 2)
+	for _, name := range []string{`metadata.db`, `kaliber_changes.log`, `0000/.jpeg-123`} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(`x`), 0600); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := cacheImages(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(got) != len(images) {
+		t.Errorf("cacheImages() = %v, want %v", got, images)
+	}
+	for _, name := range images {
+		if _, ok := got[name]; !ok {
+			t.Errorf("cacheImages() misses %q", name)
+		}
+	}
+	if _, err = cacheImages(filepath.Join(dir, `missing`)); nil == err {
+		t.Error("cacheImages() expected an error for a missing directory")
+	}
+} // Test_cacheImages()
+
+func TestTImageCache(t *testing.T) {
+	dir := t.TempDir()
+	images := prepImageCache(t, dir, 5)
+	ic := newImageCache(300)
+	if err := ic.scan([]string{dir}); nil != err {
+		t.Fatal(err)
+	}
+
+	// 500 bytes with a budget of 300 leaves 270 bytes, i.e. the two
+	// most recently used images:
+	st := ic.stats()
+	if (2 != st.Files) || (200 != st.Size) || (3 != st.Evicted) || (300 != st.EvictedBytes) {
+		t.Errorf("stats() = %+v, want 2 files, 200 bytes, 3 evicted", st)
+	}
+	left, _ := cacheImages(dir)
+	var names []string
+	for name := range left {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if (2 != len(names)) || (images[3] != names[0]) || (images[4] != names[1]) {
+		t.Errorf("remaining images = %v, want %v", names, images[3:])
+	}
+
+	// Using the older image keeps it when the next one is added:
+	ic.access(images[3])
+	if err := os.WriteFile(images[0], make([]byte, 150), 0600); nil != err {
+		t.Fatal(err)
+	}
+	ic.add(images[0])
+	if _, err := os.Stat(images[4]); !os.IsNotExist(err) {
+		t.Errorf("image %q not evicted", images[4])
+	}
+	if _, err := os.Stat(images[3]); nil != err {
+		t.Errorf("image %q evicted: %v", images[3], err)
+	}
+	if st = ic.stats(); (250 != st.Size) || (1 != st.Accesses) || (1 != st.Generated) {
+		t.Errorf("stats() = %+v, want 250 bytes, 1 access, 1 generated", st)
+	}
+
+	ic.forget(images[0])
+	if st = ic.stats(); (1 != st.Files) || (100 != st.Size) {
+		t.Errorf("stats() = %+v, want 1 file, 100 bytes", st)
+	}
+	if count, bytes := ic.clear(); (1 != count) || (100 != bytes) {
+		t.Errorf("clear() = %d, %d, want 1, 100", count, bytes)
+	}
+} // TestTImageCache()
+
+func TestSetImageCacheSize(t *testing.T) {
+	saved := imgCache.budget
+	defer func() { imgCache.budget = saved }()
+
+	tests := []struct {
+		name       string
+		aMegabytes int
+		want       int64
+	}{
+		// TODO: Add test cases.
+		{" 1", 0, 0},
+		{" 2", -5, 0},
+		{" 3", 1, 1 << 20},
+		{" 4", 512, 512 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SetImageCacheSize(tt.aMegabytes); got != tt.want {
+				t.Errorf("SetImageCacheSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+} // TestSetImageCacheSize()
+
+/* _EoF_ */
@@ -0,0 +1,138 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+/*
+ * This file draws the BlurHash given by the covers' `data-blurhash`
+ * attribute as their background while the thumbnails are loading
+ * (see `blurhash.go` for the encoder).
+ */
+
+(function () {
+	'use strict';
+
+	// The characters used by the BlurHash's base83 encoding.
+	var digits = '0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~';
+
+	// The size of the drawn preview (it's scaled by the browser).
+	var size = 32;
+
+	function decode83(aText) {
+		var result = 0;
+		for (var i = 0; i < aText.length; i++) {
+			var digit = digits.indexOf(aText.charAt(i));
+			if (0 > digit) {
+				return -1;
+			}
+			result = result * 83 + digit;
+		}
+		return result;
+	} // decode83()
+
+	function sRGBToLinear(aValue) {
+		var value = aValue / 255;
+		return (0.04045 >= value) ? value / 12.92 : Math.pow((value + 0.055) / 1.055, 2.4);
+	} // sRGBToLinear()
+
+	function linearToSRGB(aValue) {
+		var value = Math.max(0, Math.min(1, aValue));
+		if (0.0031308 >= value) {
+			return Math.round(value * 12.92 * 255);
+		}
+		return Math.round((1.055 * Math.pow(value, 1 / 2.4) - 0.055) * 255);
+	} // linearToSRGB()
+
+	function signPow(aValue, aExp) {
+		return ((0 > aValue) ? -1 : 1) * Math.pow(Math.abs(aValue), aExp);
+	} // signPow()
+
+	// `decode()` returns the RGBA pixels of `aHash` (or `null`).
+	function decode(aHash, aWidth, aHeight) {
+		if (6 > aHash.length) {
+			return null;
+		}
+		var sizeFlag = decode83(aHash.charAt(0));
+		var nx = (sizeFlag % 9) + 1, ny = Math.floor(sizeFlag / 9) + 1;
+		if ((0 > sizeFlag) || (aHash.length !== 4 + 2 * nx * ny)) {
+			return null;
+		}
+		var maxValue = (decode83(aHash.charAt(1)) + 1) / 166;
+		var dc = decode83(aHash.substring(2, 6));
+		var colors = [[sRGBToLinear(dc >> 16), sRGBToLinear((dc >> 8) & 255), sRGBToLinear(dc & 255)]];
+		for (var i = 1; i < nx * ny; i++) {
+			var ac = decode83(aHash.substring(4 + i * 2, 6 + i * 2));
+			colors.push([
+				signPow((Math.floor(ac / 361) - 9) / 9, 2) * maxValue,
+				signPow((Math.floor(ac / 19) % 19 - 9) / 9, 2) * maxValue,
+				signPow((ac % 19 - 9) / 9, 2) * maxValue
+			]);
+		}
+
+		var pixels = new Uint8ClampedArray(aWidth * aHeight * 4);
+		for (var y = 0; y < aHeight; y++) {
+			for (var x = 0; x < aWidth; x++) {
+				var r = 0, g = 0, b = 0;
+				for (var j = 0; j < ny; j++) {
+					var cosY = Math.cos(Math.PI * y * j / aHeight);
+					for (var k = 0; k < nx; k++) {
+						var basis = Math.cos(Math.PI * x * k / aWidth) * cosY;
+						var color = colors[k + j * nx];
+						r += color[0] * basis;
+						g += color[1] * basis;
+						b += color[2] * basis;
+					}
+				}
+				var idx = 4 * (x + y * aWidth);
+				pixels[idx] = linearToSRGB(r);
+				pixels[idx + 1] = linearToSRGB(g);
+				pixels[idx + 2] = linearToSRGB(b);
+				pixels[idx + 3] = 255;
+			}
+		}
+		return pixels;
+	} // decode()
+
+	// `preview()` returns the data URL of the image of `aHash` (or '').
+	function preview(aHash) {
+		var pixels = decode(aHash, size, size);
+		if (!pixels) {
+			return '';
+		}
+		var canvas = document.createElement('canvas');
+		canvas.width = canvas.height = size;
+		var ctx = canvas.getContext('2d');
+		if (!ctx) {
+			return '';
+		}
+		var data = ctx.createImageData(size, size);
+		data.data.set(pixels);
+		ctx.putImageData(data, 0, 0);
+		return canvas.toDataURL();
+	} // preview()
+
+	function showPreviews() {
+		var images = document.querySelectorAll('img[data-blurhash]');
+		Array.prototype.forEach.call(images, function (aImage) {
+			if (aImage.complete && (0 < aImage.naturalWidth)) {
+				return; // already loaded
+			}
+			var url = preview(aImage.getAttribute('data-blurhash'));
+			if (!url) {
+				return;
+			}
+			aImage.style.backgroundImage = 'url(' + url + ')';
+			aImage.style.backgroundSize = '100% 100%';
+			aImage.addEventListener('load', function () {
+				aImage.style.backgroundImage = '';
+			}, {once: true});
+		});
+	} // showPreviews()
+
+	if ('loading' === document.readyState) {
+		document.addEventListener('DOMContentLoaded', showPreviews);
+	} else {
+		showPreviews();
+	}
+})();
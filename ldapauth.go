@@ -0,0 +1,488 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides an authenticator using a LDAP directory
+ * (simple bind plus an optional group lookup).
+ */
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LDAP application tags used here (RFC 4511)
+const (
+	ldapBindRequest       = 0
+	ldapBindResponse      = 1
+	ldapUnbindRequest     = 2
+	ldapSearchRequest     = 3
+	ldapSearchResultEntry = 4
+	ldapSearchResultDone  = 5
+	ldapExtendedRequest   = 23
+	ldapExtendedResponse  = 24
+)
+
+const (
+	// LDAP result code for invalid credentials.
+	ldapInvalidCredentials = 49
+
+	// OID of the StartTLS extended operation (RFC 4511, 4.14).
+	ldapStartTLSOID = `1.3.6.1.4.1.1466.20037`
+
+	// Time a successful bind is remembered to spare the directory
+	// server a new bind with every single page request.
+	ldapCacheTTL = time.Minute * 5
+
+	// Timeout for connecting and talking to the directory server.
+	ldapTimeout = time.Second * 10
+)
+
+type (
+	// TLDAPconfig holds the settings of the LDAP authenticator.
+	TLDAPconfig struct {
+		URL          string // `ldap://host:389` or `ldaps://host:636`
+		Insecure     bool   // send `ldap://` binds in cleartext (no StartTLS)
+		BindDN       string // user DN pattern, e.g. `uid=%s,ou=people,dc=example,dc=org`
+		GroupBase    string // base DN for the group lookup
+		GroupFilter  string // group filter pattern, e.g. `(member=%s)`
+		GroupAttr    string // attribute holding the group's name
+		RequireGroup string // (optional) group a user must be member of
+	}
+
+	// `tLDAPcached` is a successful authentication remembered.
+	tLDAPcached struct {
+		expires time.Time // when the entry becomes invalid
+		groups  []string  // the user's groups
+		pwHash  [32]byte  // hash of the password used
+	}
+
+	// TLDAPauth is an authenticator using a LDAP directory.
+	TLDAPauth struct {
+		cache   map[string]tLDAPcached // successful logins by username
+		config  TLDAPconfig            // the directory settings
+		mtx     *sync.Mutex            // guard for `cache`
+		rootCAs *x509.CertPool         // trusted CAs (`nil`: the system's)
+	}
+)
+
+// NewLDAPauth returns a new `TLDAPauth` instance.
+//
+//	`aConfig` The directory settings to use.
+func NewLDAPauth(aConfig TLDAPconfig) (*TLDAPauth, error) {
+	if 0 == len(aConfig.URL) {
+		return nil, errors.New("missing `ldapURL` value")
+	}
+	u, err := url.Parse(aConfig.URL)
+	if nil != err {
+		return nil, fmt.Errorf("invalid `ldapURL` value: %w", err)
+	}
+	if scheme := strings.ToLower(u.Scheme); (`ldap` != scheme) && (`ldaps` != scheme) {
+		return nil, fmt.Errorf("unsupported `ldapURL` scheme '%s'", u.Scheme)
+	}
+	if !strings.Contains(aConfig.BindDN, `%s`) {
+		return nil, errors.New("`ldapBindDN` needs a '%s' placeholder for the username")
+	}
+	if 0 < len(aConfig.GroupFilter) {
+		if _, err := ldapParseFilter(fmt.Sprintf(aConfig.GroupFilter, `x`)); nil != err {
+			return nil, fmt.Errorf("invalid `ldapGroupFilter` value: %w", err)
+		}
+	} else if 0 < len(aConfig.RequireGroup) {
+		return nil, errors.New("`ldapRequireGroup` needs a `ldapGroupFilter` value")
+	}
+	if 0 == len(aConfig.GroupAttr) {
+		aConfig.GroupAttr = `cn`
+	}
+
+	return &TLDAPauth{
+		cache:  make(map[string]tLDAPcached, 16),
+		config: aConfig,
+		mtx:    new(sync.Mutex),
+	}, nil
+} // NewLDAPauth()
+
+// Authenticate checks `aUser` and `aPassword` against the directory
+// returning the user's groups or an error.
+//
+//	`aUser` The username to check.
+//	`aPassword` The user's password.
+func (la *TLDAPauth) Authenticate(aUser, aPassword string) ([]string, error) {
+	if (0 == len(aUser)) || (0 == len(aPassword)) {
+		// An empty password would result in an "unauthenticated"
+		// bind which most servers accept as anonymous access.
+		return nil, errors.New(`Authenticate: missing username/password`)
+	}
+	pwHash := sha256.Sum256([]byte(aUser + "\x00" + aPassword))
+
+	la.mtx.Lock()
+	if cached, ok := la.cache[aUser]; ok {
+		if time.Now().After(cached.expires) {
+			delete(la.cache, aUser)
+		} else if cached.pwHash == pwHash {
+			la.mtx.Unlock()
+			return cached.groups, nil
+		}
+	}
+	la.mtx.Unlock()
+
+	conn, err := ldapDial(la.config.URL, la.rootCAs, !la.config.Insecure)
+	if nil != err {
+		return nil, err
+	}
+	defer conn.close()
+
+	userDN := fmt.Sprintf(la.config.BindDN, ldapEscapeDN(aUser))
+	if err = conn.bind(userDN, aPassword); nil != err {
+		return nil, err
+	}
+
+	var groups []string
+	if 0 < len(la.config.GroupFilter) {
+		filter := fmt.Sprintf(la.config.GroupFilter, ldapEscapeFilter(userDN))
+		if groups, err = conn.searchValues(la.config.GroupBase, filter, la.config.GroupAttr); nil != err {
+			return nil, err
+		}
+		sort.Strings(groups)
+	}
+	if rg := la.config.RequireGroup; 0 < len(rg) {
+		member := false
+		for _, group := range groups {
+			if strings.EqualFold(group, rg) {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return nil, fmt.Errorf("Authenticate: '%s' not member of '%s'", aUser, rg)
+		}
+	}
+
+	la.mtx.Lock()
+	la.cache[aUser] = tLDAPcached{
+		expires: time.Now().Add(ldapCacheTTL),
+		groups:  groups,
+		pwHash:  pwHash,
+	}
+	la.mtx.Unlock()
+
+	return groups, nil
+} // Authenticate()
+
+// Groups returns the groups of `aUser` as found during the last
+// successful authentication.
+//
+//	`aUser` The username to lookup.
+func (la *TLDAPauth) Groups(aUser string) []string {
+	la.mtx.Lock()
+	defer la.mtx.Unlock()
+
+	if cached, ok := la.cache[aUser]; ok {
+		return cached.groups
+	}
+
+	return nil
+} // Groups()
+
+// IsAuthenticated checks `aRequest`'s BasicAuth data against the
+// directory returning `nil` for successful authentication, or an
+// `error` otherwise.
+//
+// On success the username is stored in `aRequest.URL.User`.
+//
+//	`aRequest` The HTTP request received by the server.
+func (la *TLDAPauth) IsAuthenticated(aRequest *http.Request) error {
+	user, pass, ok := aRequest.BasicAuth()
+	if !ok {
+		return errors.New(`IsAuthenticated: missing authentication data`)
+	}
+	if _, err := la.Authenticate(user, pass); nil != err {
+		return fmt.Errorf(`IsAuthenticated: %w`, err)
+	}
+
+	// Store the user info so others can check for it
+	aRequest.URL.User = url.User(user)
+
+	return nil
+} // IsAuthenticated()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `ldapEscapeDN()` escapes `aValue` for use as a DN attribute
+// value (RFC 4514).
+func ldapEscapeDN(aValue string) string {
+	var result strings.Builder
+	for i := 0; i < len(aValue); i++ {
+		c := aValue[i]
+		switch c {
+		case ',', '+', '"', '\\', '<', '>', ';', '=':
+			result.WriteByte('\\')
+		case ' ':
+			if (0 == i) || (len(aValue)-1 == i) {
+				result.WriteByte('\\')
+			}
+		case '#':
+			if 0 == i {
+				result.WriteByte('\\')
+			}
+		case 0:
+			result.WriteString(`\00`)
+			continue
+		}
+		result.WriteByte(c)
+	}
+
+	return result.String()
+} // ldapEscapeDN()
+
+// `ldapEscapeFilter()` escapes `aValue` for use in a search
+// filter (RFC 4515).
+func ldapEscapeFilter(aValue string) string {
+	var result strings.Builder
+	for i := 0; i < len(aValue); i++ {
+		switch c := aValue[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&result, `\%02x`, c)
+		default:
+			result.WriteByte(c)
+		}
+	}
+
+	return result.String()
+} // ldapEscapeFilter()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+type (
+	// `tLDAPconn` is a connection to a LDAP server.
+	tLDAPconn struct {
+		conn  net.Conn // the network connection
+		msgID int64    // ID of the last message sent
+	}
+)
+
+// `ldapDial()` connects to the LDAP server at `aURL`.
+//
+// A `ldap://` connection is secured by StartTLS unless `aStartTLS`
+// is `false` in which case all data (including the passwords) are
+// sent in cleartext.
+//
+//	`aURL` The server's address (`ldap://` or `ldaps://`).
+//	`aRootCAs` The CAs to trust (`nil`: the system's).
+//	`aStartTLS` Whether to use StartTLS for `ldap://` connections.
+func ldapDial(aURL string, aRootCAs *x509.CertPool, aStartTLS bool) (*tLDAPconn, error) {
+	u, err := url.Parse(aURL)
+	if nil != err {
+		return nil, err
+	}
+	var (
+		conn   net.Conn
+		dialer = &net.Dialer{Timeout: ldapTimeout}
+		host   = u.Host
+		tc     = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    aRootCAs,
+			ServerName: u.Hostname(),
+		}
+	)
+	switch strings.ToLower(u.Scheme) {
+	case `ldap`:
+		if 0 == len(u.Port()) {
+			host = net.JoinHostPort(u.Hostname(), `389`)
+		}
+		if conn, err = dialer.Dial(`tcp`, host); nil != err {
+			return nil, err
+		}
+		result := &tLDAPconn{conn: conn}
+		if aStartTLS {
+			if err = result.startTLS(tc); nil != err {
+				_ = conn.Close()
+				return nil, err
+			}
+		}
+		return result, nil
+
+	case `ldaps`:
+		if 0 == len(u.Port()) {
+			host = net.JoinHostPort(u.Hostname(), `636`)
+		}
+		conn, err = tls.DialWithDialer(dialer, `tcp`, host, tc)
+
+	default:
+		return nil, fmt.Errorf("ldapDial: unsupported scheme '%s'", u.Scheme)
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	return &tLDAPconn{conn: conn}, nil
+} // ldapDial()
+
+// `bind()` performs a simple bind as `aDN` with `aPassword`.
+func (lc *tLDAPconn) bind(aDN, aPassword string) error {
+	op := berNewConstructed(berClassApplication, ldapBindRequest,
+		berNewInt(berClassUniversal, berTagInteger, 3),
+		berNewString(berClassUniversal, berTagOctetString, aDN),
+		berNewString(berClassContext, 0, aPassword),
+	)
+	if err := lc.send(op); nil != err {
+		return err
+	}
+	resp, err := lc.receive()
+	if nil != err {
+		return err
+	}
+	if !resp.is(berClassApplication, ldapBindResponse) {
+		return errors.New(`bind: unexpected response`)
+	}
+
+	return ldapResultError(`bind`, resp)
+} // bind()
+
+// `close()` sends an unbind request and closes the connection.
+func (lc *tLDAPconn) close() {
+	_ = lc.send(berNew(berClassApplication, ldapUnbindRequest, nil))
+	_ = lc.conn.Close()
+} // close()
+
+// `receive()` reads the next LDAP message returning its
+// protocol operation.
+func (lc *tLDAPconn) receive() (*tBerPacket, error) {
+	_ = lc.conn.SetReadDeadline(time.Now().Add(ldapTimeout))
+	msg, err := berRead(lc.conn)
+	if nil != err {
+		return nil, err
+	}
+	if (2 > len(msg.children)) || (lc.msgID != msg.child(0).int()) {
+		return nil, errors.New(`receive: invalid LDAP message`)
+	}
+
+	return msg.child(1), nil
+} // receive()
+
+// `searchValues()` runs a subtree search returning all values of
+// `aAttr` of the entries found.
+//
+//	`aBase` The DN to start the search at.
+//	`aFilter` The search filter to apply.
+//	`aAttr` The attribute whose values to return.
+func (lc *tLDAPconn) searchValues(aBase, aFilter, aAttr string) ([]string, error) {
+	filter, err := ldapParseFilter(aFilter)
+	if nil != err {
+		return nil, err
+	}
+	op := berNewConstructed(berClassApplication, ldapSearchRequest,
+		berNewString(berClassUniversal, berTagOctetString, aBase),
+		berNewInt(berClassUniversal, berTagEnumerated, 2), // wholeSubtree
+		berNewInt(berClassUniversal, berTagEnumerated, 0), // neverDerefAliases
+		berNewInt(berClassUniversal, berTagInteger, 0),    // sizeLimit
+		berNewInt(berClassUniversal, berTagInteger, 0),    // timeLimit
+		berNewBool(false), // typesOnly
+		filter,
+		berNewConstructed(berClassUniversal, berTagSequence,
+			berNewString(berClassUniversal, berTagOctetString, aAttr)),
+	)
+	if err = lc.send(op); nil != err {
+		return nil, err
+	}
+
+	var result []string
+	for {
+		resp, err := lc.receive()
+		if nil != err {
+			return nil, err
+		}
+		switch {
+		case resp.is(berClassApplication, ldapSearchResultEntry):
+			for _, attr := range resp.child(1).children {
+				if !strings.EqualFold(aAttr, attr.child(0).string()) {
+					continue
+				}
+				for _, val := range attr.child(1).children {
+					result = append(result, val.string())
+				}
+			}
+
+		case resp.is(berClassApplication, ldapSearchResultDone):
+			return result, ldapResultError(`search`, resp)
+		}
+		// ignore anything else (e.g. search result references)
+	}
+} // searchValues()
+
+// `startTLS()` asks the server to secure the connection and performs
+// the TLS handshake (RFC 4511, 4.14).
+//
+//	`aConfig` The TLS settings to use.
+func (lc *tLDAPconn) startTLS(aConfig *tls.Config) error {
+	op := berNewConstructed(berClassApplication, ldapExtendedRequest,
+		berNewString(berClassContext, 0, ldapStartTLSOID))
+	if err := lc.send(op); nil != err {
+		return err
+	}
+	resp, err := lc.receive()
+	if nil != err {
+		return err
+	}
+	if !resp.is(berClassApplication, ldapExtendedResponse) {
+		return errors.New(`startTLS: unexpected response`)
+	}
+	if err = ldapResultError(`startTLS`, resp); nil != err {
+		return err
+	}
+
+	conn := tls.Client(lc.conn, aConfig)
+	_ = conn.SetDeadline(time.Now().Add(ldapTimeout))
+	if err = conn.Handshake(); nil != err {
+		return fmt.Errorf("startTLS: %w", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	lc.conn = conn
+
+	return nil
+} // startTLS()
+
+// `send()` wraps `aOp` into a LDAP message and sends it.
+func (lc *tLDAPconn) send(aOp *tBerPacket) error {
+	lc.msgID++
+	msg := berNewConstructed(berClassUniversal, berTagSequence,
+		berNewInt(berClassUniversal, berTagInteger, lc.msgID),
+		aOp,
+	)
+	_ = lc.conn.SetWriteDeadline(time.Now().Add(ldapTimeout))
+	_, err := lc.conn.Write(msg.bytes())
+
+	return err
+} // send()
+
+// `ldapResultError()` returns an error if the `LDAPResult` in
+// `aResponse` doesn't signal success.
+func ldapResultError(aOp string, aResponse *tBerPacket) error {
+	code := aResponse.child(0).int()
+	switch code {
+	case 0:
+		return nil
+	case ldapInvalidCredentials:
+		return fmt.Errorf("%s: invalid credentials", aOp)
+	}
+
+	return fmt.Errorf("%s: LDAP result %d (%s)", aOp, code, aResponse.child(2).string())
+} // ldapResultError()
+
+/* _EoF_ */
@@ -0,0 +1,338 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type (
+	// `tLDAPstandIn` is a tiny LDAP server answering StartTLS
+	// requests, simple binds, and `member=DN` group searches.
+	tLDAPstandIn struct {
+		binds    atomic.Int32        // number of bind requests seen
+		cleartxt atomic.Int32        // number of binds without TLS
+		groups   map[string][]string // group names by member DN
+		listener net.Listener        // the server's listener
+		rootCAs  *x509.CertPool      // CAs trusting `tlsConf`
+		tlsConf  *tls.Config         // StartTLS settings (`nil`: none)
+		users    map[string]string   // passwords by user DN
+	}
+)
+
+// `newLDAPstandIn()` starts a LDAP stand-in at a random local port.
+func newLDAPstandIn(t *testing.T) *tLDAPstandIn {
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if nil != err {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	result := &tLDAPstandIn{
+		groups: map[string][]string{
+			`uid=alice,ou=people,dc=example,dc=org`: {`readers`, `admins`},
+			`uid=bob,ou=people,dc=example,dc=org`:   {`guests`},
+		},
+		listener: l,
+		users: map[string]string{
+			`uid=alice,ou=people,dc=example,dc=org`: `secret`,
+			`uid=bob,ou=people,dc=example,dc=org`:   `pass`,
+		},
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	// borrow the test certificate of `httptest`:
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(ts.Close)
+	result.tlsConf = ts.TLS
+	result.rootCAs = x509.NewCertPool()
+	result.rootCAs.AddCert(ts.Certificate())
+
+	go result.serve()
+
+	return result
+} // newLDAPstandIn()
+
+// `url()` returns the stand-in's address.
+func (ls *tLDAPstandIn) url() string {
+	return `ldap://` + ls.listener.Addr().String()
+} // url()
+
+// `serve()` accepts connections until the listener is closed.
+func (ls *tLDAPstandIn) serve() {
+	for {
+		conn, err := ls.listener.Accept()
+		if nil != err {
+			return
+		}
+		go ls.handle(conn)
+	}
+} // serve()
+
+// `handle()` answers the requests of a single connection.
+func (ls *tLDAPstandIn) handle(aConn net.Conn) {
+	defer func() { _ = aConn.Close() }()
+
+	reply := func(aID int64, aOp *tBerPacket) {
+		msg := berNewConstructed(berClassUniversal, berTagSequence,
+			berNewInt(berClassUniversal, berTagInteger, aID), aOp)
+		_, _ = aConn.Write(msg.bytes())
+	}
+	result := func(aTag int, aCode int64) *tBerPacket {
+		return berNewConstructed(berClassApplication, aTag,
+			berNewInt(berClassUniversal, berTagEnumerated, aCode),
+			berNewString(berClassUniversal, berTagOctetString, ``),
+			berNewString(berClassUniversal, berTagOctetString, ``))
+	}
+
+	for {
+		msg, err := berRead(aConn)
+		if nil != err {
+			return
+		}
+		id, op := msg.child(0).int(), msg.child(1)
+		switch {
+		case op.is(berClassApplication, ldapExtendedRequest):
+			if (nil == ls.tlsConf) || (ldapStartTLSOID != op.child(0).string()) {
+				reply(id, result(ldapExtendedResponse, 2)) // protocolError
+				continue
+			}
+			reply(id, result(ldapExtendedResponse, 0))
+			aConn = tls.Server(aConn, ls.tlsConf)
+
+		case op.is(berClassApplication, ldapBindRequest):
+			ls.binds.Add(1)
+			if _, ok := aConn.(*tls.Conn); !ok {
+				ls.cleartxt.Add(1)
+			}
+			dn, pw := op.child(1).string(), op.child(2).string()
+			if want, ok := ls.users[dn]; ok && (want == pw) {
+				reply(id, result(ldapBindResponse, 0))
+			} else {
+				reply(id, result(ldapBindResponse, ldapInvalidCredentials))
+			}
+
+		case op.is(berClassApplication, ldapSearchRequest):
+			for _, member := range ldapStandInMembers(op.child(6)) {
+				for _, group := range ls.groups[member] {
+					reply(id, berNewConstructed(berClassApplication, ldapSearchResultEntry,
+						berNewString(berClassUniversal, berTagOctetString, `cn=`+group+`,ou=groups,dc=example,dc=org`),
+						berNewConstructed(berClassUniversal, berTagSequence,
+							berNewConstructed(berClassUniversal, berTagSequence,
+								berNewString(berClassUniversal, berTagOctetString, `cn`),
+								berNewConstructed(berClassUniversal, berTagSet,
+									berNewString(berClassUniversal, berTagOctetString, group))))))
+				}
+			}
+			reply(id, result(ldapSearchResultDone, 0))
+
+		case op.is(berClassApplication, ldapUnbindRequest):
+			return
+		}
+	}
+} // handle()
+
+// `ldapStandInMembers()` returns the values of all `member=`
+// equality items in `aFilter`.
+func ldapStandInMembers(aFilter *tBerPacket) (rList []string) {
+	if aFilter.is(berClassContext, 3) {
+		if strings.EqualFold(`member`, aFilter.child(0).string()) {
+			rList = append(rList, aFilter.child(1).string())
+		}
+		return
+	}
+	for _, child := range aFilter.children {
+		rList = append(rList, ldapStandInMembers(child)...)
+	}
+
+	return
+} // ldapStandInMembers()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+func Test_ldapEscapeDN(t *testing.T) {
+	tests := []struct {
+		name   string
+		aValue string
+		want   string
+	}{
+		// TODO: Add test cases.
+		{" 1", `alice`, `alice`},
+		{" 2", `doe, john`, `doe\, john`},
+		{" 3", ` lead`, `\ lead`},
+		{" 4", `#hash`, `\#hash`},
+		{" 5", `a=b+c`, `a\=b\+c`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ldapEscapeDN(tt.aValue); got != tt.want {
+				t.Errorf("ldapEscapeDN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_ldapEscapeDN()
+
+func Test_ldapEscapeFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		aValue string
+		want   string
+	}{
+		// TODO: Add test cases.
+		{" 1", `alice`, `alice`},
+		{" 2", `a*(b)\c`, `a\2a\28b\29\5cc`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ldapEscapeFilter(tt.aValue); got != tt.want {
+				t.Errorf("ldapEscapeFilter() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_ldapEscapeFilter()
+
+func TestNewLDAPauth(t *testing.T) {
+	tests := []struct {
+		name    string
+		aConfig TLDAPconfig
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+		{" 1", TLDAPconfig{}, true},
+		{" 2", TLDAPconfig{URL: `ldap://localhost`, BindDN: `uid=x`}, true},
+		{" 3", TLDAPconfig{URL: `ldap://localhost`, BindDN: `uid=%s`}, false},
+		{" 4", TLDAPconfig{URL: `ldap://localhost`, BindDN: `uid=%s`, RequireGroup: `x`}, true},
+		{" 5", TLDAPconfig{URL: `ldap://localhost`, BindDN: `uid=%s`, GroupFilter: `(member=%s`}, true},
+		{" 6", TLDAPconfig{URL: `ldap://localhost`, BindDN: `uid=%s`, GroupFilter: `(&(objectClass=groupOfNames)(member=%s))`, RequireGroup: `x`}, false},
+		{" 7", TLDAPconfig{URL: `http://localhost`, BindDN: `uid=%s`}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewLDAPauth(tt.aConfig); (nil != err) != tt.wantErr {
+				t.Errorf("NewLDAPauth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+} // TestNewLDAPauth()
+
+func TestTLDAPauth_Authenticate(t *testing.T) {
+	ls := newLDAPstandIn(t)
+	la, err := NewLDAPauth(TLDAPconfig{
+		URL:          ls.url(),
+		BindDN:       `uid=%s,ou=people,dc=example,dc=org`,
+		GroupBase:    `ou=groups,dc=example,dc=org`,
+		GroupFilter:  `(&(objectClass=groupOfNames)(member=%s))`,
+		RequireGroup: `Readers`,
+	})
+	if nil != err {
+		t.Fatalf("NewLDAPauth() error = %v", err)
+	}
+	la.rootCAs = ls.rootCAs
+
+	tests := []struct {
+		name       string
+		aUser      string
+		aPassword  string
+		wantGroups []string
+		wantErr    bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `alice`, `secret`, []string{`admins`, `readers`}, false},
+		{" 2", `alice`, `wrong`, nil, true},
+		{" 3", `alice`, ``, nil, true},
+		{" 4", `bob`, `pass`, nil, true}, // not member of "readers"
+		{" 5", `carol`, `secret`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := la.Authenticate(tt.aUser, tt.aPassword)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("TLDAPauth.Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.wantGroups) {
+				t.Errorf("TLDAPauth.Authenticate() = %v, want %v", got, tt.wantGroups)
+			}
+		})
+	}
+
+	// A repeated login is served from the cache:
+	binds := ls.binds.Load()
+	req := httptest.NewRequest(`GET`, `/`, nil)
+	req.SetBasicAuth(`alice`, `secret`)
+	if err = la.IsAuthenticated(req); nil != err {
+		t.Errorf("TLDAPauth.IsAuthenticated() error = %v", err)
+	}
+	if (nil == req.URL.User) || (`alice` != req.URL.User.Username()) {
+		t.Errorf("TLDAPauth.IsAuthenticated() user = %v, want 'alice'", req.URL.User)
+	}
+	if binds != ls.binds.Load() {
+		t.Errorf("TLDAPauth.IsAuthenticated() binds = %d, want %d", ls.binds.Load(), binds)
+	}
+	if got := la.Groups(`alice`); !reflect.DeepEqual(got, []string{`admins`, `readers`}) {
+		t.Errorf("TLDAPauth.Groups() = %v", got)
+	}
+	if 0 != ls.cleartxt.Load() {
+		t.Errorf("TLDAPauth.Authenticate() sent %d cleartext binds", ls.cleartxt.Load())
+	}
+} // TestTLDAPauth_Authenticate()
+
+func TestTLDAPauth_startTLS(t *testing.T) {
+	ls := newLDAPstandIn(t)
+	config := TLDAPconfig{
+		URL:    ls.url(),
+		BindDN: `uid=%s,ou=people,dc=example,dc=org`,
+	}
+
+	tests := []struct {
+		name      string
+		aInsecure bool
+		aTLS      bool // whether the server offers StartTLS
+		aTrusted  bool // whether the server's certificate is trusted
+		wantErr   bool
+		wantClear int32
+	}{
+		// TODO: Add test cases.
+		{" 1", false, true, true, false, 0},
+		{" 2", false, true, false, true, 0},
+		{" 3", false, false, true, true, 0},
+		{" 4", true, false, true, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConf := ls.tlsConf
+			if !tt.aTLS {
+				ls.tlsConf = nil
+			}
+			defer func() { ls.tlsConf = tlsConf }()
+			ls.cleartxt.Store(0)
+
+			config.Insecure = tt.aInsecure
+			la, err := NewLDAPauth(config)
+			if nil != err {
+				t.Fatalf("NewLDAPauth() error = %v", err)
+			}
+			if tt.aTrusted {
+				la.rootCAs = ls.rootCAs
+			}
+			if _, err = la.Authenticate(`alice`, `secret`); (nil != err) != tt.wantErr {
+				t.Errorf("TLDAPauth.Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := ls.cleartxt.Load(); got != tt.wantClear {
+				t.Errorf("TLDAPauth.Authenticate() cleartext binds = %d, want %d", got, tt.wantClear)
+			}
+		})
+	}
+} // TestTLDAPauth_startTLS()
@@ -0,0 +1,375 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides a minimal BER (Basic Encoding Rules) codec
+ * sufficient for the few LDAP operations used by `TLDAPauth`.
+ */
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BER identifier classes
+const (
+	berClassUniversal   = byte(0x00)
+	berClassApplication = byte(0x40)
+	berClassContext     = byte(0x80)
+)
+
+// BER universal tags
+const (
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x10
+	berTagSet         = 0x11
+)
+
+const (
+	// Upper limit of a single BER packet's length we accept.
+	berMaxLength = 1 << 24 // 16 MB
+)
+
+type (
+	// `tBerPacket` is a single BER encoded element.
+	tBerPacket struct {
+		class       byte          // one of the `berClassXxx` values
+		constructed bool          // whether the packet holds children
+		tag         int           // the element's tag (`< 31`)
+		value       []byte        // primitive content
+		children    []*tBerPacket // constructed content
+	}
+)
+
+// `berNew()` returns a new (primitive) BER packet.
+func berNew(aClass byte, aTag int, aValue []byte) *tBerPacket {
+	return &tBerPacket{
+		class: aClass,
+		tag:   aTag,
+		value: aValue,
+	}
+} // berNew()
+
+// `berNewBool()` returns a universal BOOLEAN packet.
+func berNewBool(aValue bool) *tBerPacket {
+	if aValue {
+		return berNew(berClassUniversal, berTagBoolean, []byte{0xff})
+	}
+
+	return berNew(berClassUniversal, berTagBoolean, []byte{0})
+} // berNewBool()
+
+// `berNewConstructed()` returns a new constructed BER packet
+// holding `aChildren`.
+func berNewConstructed(aClass byte, aTag int, aChildren ...*tBerPacket) *tBerPacket {
+	return &tBerPacket{
+		class:       aClass,
+		constructed: true,
+		tag:         aTag,
+		children:    aChildren,
+	}
+} // berNewConstructed()
+
+// `berNewInt()` returns an INTEGER (or ENUMERATED) packet.
+func berNewInt(aClass byte, aTag int, aValue int64) *tBerPacket {
+	buf := make([]byte, 0, 8)
+	for {
+		buf = append([]byte{byte(aValue)}, buf...)
+		aValue >>= 8
+		if ((0 == aValue) && (0 == buf[0]&0x80)) ||
+			((-1 == aValue) && (0 != buf[0]&0x80)) {
+			break
+		}
+	}
+
+	return berNew(aClass, aTag, buf)
+} // berNewInt()
+
+// `berNewString()` returns an OCTET STRING packet.
+func berNewString(aClass byte, aTag int, aValue string) *tBerPacket {
+	return berNew(aClass, aTag, []byte(aValue))
+} // berNewString()
+
+// `add()` appends `aChild` to the packet's children.
+func (bp *tBerPacket) add(aChild ...*tBerPacket) *tBerPacket {
+	bp.children = append(bp.children, aChild...)
+
+	return bp
+} // add()
+
+// `bytes()` returns the BER encoding of the packet.
+func (bp *tBerPacket) bytes() []byte {
+	content := bp.value
+	ident := bp.class | byte(bp.tag&0x1f)
+	if bp.constructed {
+		ident |= 0x20
+		content = nil
+		for _, child := range bp.children {
+			content = append(content, child.bytes()...)
+		}
+	}
+	result := append([]byte{ident}, berLength(len(content))...)
+
+	return append(result, content...)
+} // bytes()
+
+// `child()` returns the packet's child at `aIndex` (or `nil`).
+func (bp *tBerPacket) child(aIndex int) *tBerPacket {
+	if (nil == bp) || (0 > aIndex) || (len(bp.children) <= aIndex) {
+		return nil
+	}
+
+	return bp.children[aIndex]
+} // child()
+
+// `int()` returns the packet's value as an integer.
+func (bp *tBerPacket) int() int64 {
+	if (nil == bp) || (0 == len(bp.value)) {
+		return 0
+	}
+	var result int64
+	if 0 != bp.value[0]&0x80 {
+		result = -1
+	}
+	for _, b := range bp.value {
+		result = (result << 8) | int64(b)
+	}
+
+	return result
+} // int()
+
+// `is()` returns whether the packet has `aClass` and `aTag`.
+func (bp *tBerPacket) is(aClass byte, aTag int) bool {
+	return (nil != bp) && (aClass == bp.class) && (aTag == bp.tag)
+} // is()
+
+// `string()` returns the packet's value as a string.
+func (bp *tBerPacket) string() string {
+	if nil == bp {
+		return ``
+	}
+
+	return string(bp.value)
+} // string()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `berLength()` returns the BER encoding of `aLength`.
+func berLength(aLength int) []byte {
+	if 0x80 > aLength {
+		return []byte{byte(aLength)}
+	}
+	var buf []byte
+	for ; 0 < aLength; aLength >>= 8 {
+		buf = append([]byte{byte(aLength)}, buf...)
+	}
+
+	return append([]byte{0x80 | byte(len(buf))}, buf...)
+} // berLength()
+
+// `berParse()` decodes a single BER packet from `aData` returning
+// the packet and the number of bytes consumed.
+func berParse(aData []byte) (*tBerPacket, int, error) {
+	if 2 > len(aData) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	ident := aData[0]
+	if 0x1f == ident&0x1f {
+		return nil, 0, errors.New(`berParse: multi-byte tags not supported`)
+	}
+	length, pos := int(aData[1]), 2
+	if 0 != length&0x80 {
+		n := length & 0x7f
+		if (0 == n) || (3 < n) || (len(aData) < pos+n) {
+			return nil, 0, errors.New(`berParse: invalid length`)
+		}
+		length = 0
+		for _, b := range aData[pos : pos+n] {
+			length = (length << 8) | int(b)
+		}
+		pos += n
+	}
+	if len(aData) < pos+length {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	result := &tBerPacket{
+		class:       ident & 0xc0,
+		constructed: 0 != ident&0x20,
+		tag:         int(ident & 0x1f),
+	}
+	content := aData[pos : pos+length]
+	if !result.constructed {
+		result.value = content
+		return result, pos + length, nil
+	}
+	for 0 < len(content) {
+		child, n, err := berParse(content)
+		if nil != err {
+			return nil, 0, err
+		}
+		result.children = append(result.children, child)
+		content = content[n:]
+	}
+
+	return result, pos + length, nil
+} // berParse()
+
+// `berRead()` reads a single BER packet from `aReader`.
+func berRead(aReader io.Reader) (*tBerPacket, error) {
+	head := make([]byte, 2, 6)
+	if _, err := io.ReadFull(aReader, head); nil != err {
+		return nil, err
+	}
+	length := int(head[1])
+	if 0 != length&0x80 {
+		n := length & 0x7f
+		if (0 == n) || (3 < n) {
+			return nil, errors.New(`berRead: invalid length`)
+		}
+		lBuf := make([]byte, n)
+		if _, err := io.ReadFull(aReader, lBuf); nil != err {
+			return nil, err
+		}
+		head = append(head, lBuf...)
+		length = 0
+		for _, b := range lBuf {
+			length = (length << 8) | int(b)
+		}
+	}
+	if berMaxLength < length {
+		return nil, fmt.Errorf("berRead: packet too large (%d bytes)", length)
+	}
+	data := make([]byte, len(head)+length)
+	copy(data, head)
+	if _, err := io.ReadFull(aReader, data[len(head):]); nil != err {
+		return nil, err
+	}
+	result, _, err := berParse(data)
+
+	return result, err
+} // berRead()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `ldapParseFilter()` returns the BER encoding of the LDAP search
+// filter `aFilter` (RFC 4515).
+//
+// Supported are the `&`, `|`, and `!` operators as well as equality
+// (`attr=value`) and presence (`attr=*`) items.
+//
+//	`aFilter` The filter string to parse.
+func ldapParseFilter(aFilter string) (*tBerPacket, error) {
+	aFilter = strings.TrimSpace(aFilter)
+	if (0 < len(aFilter)) && ('(' != aFilter[0]) {
+		aFilter = `(` + aFilter + `)`
+	}
+	result, rest, err := ldapParseFilterPrim(aFilter)
+	if nil != err {
+		return nil, err
+	}
+	if 0 < len(rest) {
+		return nil, fmt.Errorf("ldapParseFilter: trailing data '%s'", rest)
+	}
+
+	return result, nil
+} // ldapParseFilter()
+
+// `ldapParseFilterPrim()` parses a single parenthesised filter
+// returning the BER packet and the remaining (unparsed) text.
+func ldapParseFilterPrim(aFilter string) (*tBerPacket, string, error) {
+	if (2 > len(aFilter)) || ('(' != aFilter[0]) {
+		return nil, ``, fmt.Errorf("ldapParseFilter: missing '(' in '%s'", aFilter)
+	}
+	rest := aFilter[1:]
+
+	switch rest[0] {
+	case '&', '|', '!':
+		tag := map[byte]int{'&': 0, '|': 1, '!': 2}[rest[0]]
+		result := berNewConstructed(berClassContext, tag)
+		rest = rest[1:]
+		for (0 < len(rest)) && ('(' == rest[0]) {
+			child, r, err := ldapParseFilterPrim(rest)
+			if nil != err {
+				return nil, ``, err
+			}
+			result.add(child)
+			rest = r
+		}
+		if (0 == len(rest)) || (')' != rest[0]) {
+			return nil, ``, errors.New(`ldapParseFilter: missing ')'`)
+		}
+		if (2 == tag) && (1 != len(result.children)) {
+			return nil, ``, errors.New(`ldapParseFilter: '!' needs exactly one filter`)
+		}
+		return result, rest[1:], nil
+	}
+
+	end := strings.IndexByte(rest, ')')
+	if 0 > end {
+		return nil, ``, errors.New(`ldapParseFilter: missing ')'`)
+	}
+	item := rest[:end]
+	eq := strings.IndexByte(item, '=')
+	if 0 >= eq {
+		return nil, ``, fmt.Errorf("ldapParseFilter: invalid item '%s'", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	if strings.ContainsAny(attr, `<>~:`) {
+		return nil, ``, fmt.Errorf("ldapParseFilter: unsupported item '%s'", item)
+	}
+	if `*` == value {
+		return berNewString(berClassContext, 7, attr), rest[end+1:], nil
+	}
+	if strings.Contains(value, `*`) {
+		return nil, ``, fmt.Errorf("ldapParseFilter: substrings not supported '%s'", item)
+	}
+	v, err := ldapUnescapeFilterValue(value)
+	if nil != err {
+		return nil, ``, err
+	}
+
+	return berNewConstructed(berClassContext, 3,
+		berNewString(berClassUniversal, berTagOctetString, attr),
+		berNewString(berClassUniversal, berTagOctetString, v),
+	), rest[end+1:], nil
+} // ldapParseFilterPrim()
+
+// `ldapUnescapeFilterValue()` replaces the `\XX` escapes in `aValue`.
+func ldapUnescapeFilterValue(aValue string) (string, error) {
+	if !strings.Contains(aValue, `\`) {
+		return aValue, nil
+	}
+	var result strings.Builder
+	for i := 0; i < len(aValue); i++ {
+		if '\\' != aValue[i] {
+			result.WriteByte(aValue[i])
+			continue
+		}
+		if i+2 >= len(aValue) {
+			return ``, fmt.Errorf("ldapParseFilter: invalid escape in '%s'", aValue)
+		}
+		b, err := hex.DecodeString(aValue[i+1 : i+3])
+		if nil != err {
+			return ``, fmt.Errorf("ldapParseFilter: invalid escape in '%s'", aValue)
+		}
+		result.Write(b)
+		i += 2
+	}
+
+	return result.String(), nil
+} // ldapUnescapeFilterValue()
+
+/* _EoF_ */
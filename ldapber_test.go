@@ -0,0 +1,116 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_berNewInt(t *testing.T) {
+	tests := []struct {
+		name   string
+		aValue int64
+		want   []byte
+	}{
+		// TODO: Add test cases.
+		{" 1", 0, []byte{0x02, 0x01, 0x00}},
+		{" 2", 3, []byte{0x02, 0x01, 0x03}},
+		{" 3", 128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{" 4", -1, []byte{0x02, 0x01, 0xff}},
+		{" 5", 65536, []byte{0x02, 0x03, 0x01, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := berNewInt(berClassUniversal, berTagInteger, tt.aValue)
+			if got := p.bytes(); !bytes.Equal(got, tt.want) {
+				t.Errorf("berNewInt() = %x, want %x", got, tt.want)
+			}
+			if got := p.int(); got != tt.aValue {
+				t.Errorf("tBerPacket.int() = %d, want %d", got, tt.aValue)
+			}
+		})
+	}
+} // Test_berNewInt()
+
+func Test_berRead(t *testing.T) {
+	long := strings.Repeat(`x`, 300)
+	msg := berNewConstructed(berClassUniversal, berTagSequence,
+		berNewInt(berClassUniversal, berTagInteger, 7),
+		berNewConstructed(berClassApplication, ldapBindRequest,
+			berNewInt(berClassUniversal, berTagInteger, 3),
+			berNewString(berClassUniversal, berTagOctetString, long),
+			berNewString(berClassContext, 0, `secret`)),
+	)
+	got, err := berRead(bytes.NewReader(msg.bytes()))
+	if nil != err {
+		t.Fatalf("berRead() error = %v", err)
+	}
+	if 7 != got.child(0).int() {
+		t.Errorf("berRead() msgID = %d, want 7", got.child(0).int())
+	}
+	op := got.child(1)
+	if !op.is(berClassApplication, ldapBindRequest) || !op.constructed {
+		t.Errorf("berRead() op = %#v", op)
+	}
+	if long != op.child(1).string() {
+		t.Errorf("berRead() DN length = %d, want %d", len(op.child(1).string()), len(long))
+	}
+	if `secret` != op.child(2).string() {
+		t.Errorf("berRead() password = %q", op.child(2).string())
+	}
+	if _, err = berRead(bytes.NewReader(msg.bytes()[:10])); nil == err {
+		t.Error("berRead() with truncated data: error = nil")
+	}
+} // Test_berRead()
+
+func Test_ldapParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		aFilter string
+		wantTag int
+		wantLen int
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `(cn=test)`, 3, 2, false},
+		{" 2", `cn=test`, 3, 2, false},
+		{" 3", `(objectClass=*)`, 7, 0, false},
+		{" 4", `(&(objectClass=groupOfNames)(member=uid=a\2cb))`, 0, 2, false},
+		{" 5", `(|(a=1)(b=2)(c=3))`, 1, 3, false},
+		{" 6", `(!(a=1))`, 2, 1, false},
+		{" 7", `(!(a=1)(b=2))`, 0, 0, true},
+		{" 8", `(cn=te*st)`, 0, 0, true},
+		{" 9", `(&(a=1)`, 0, 0, true},
+		{"10", `(a>=1)`, 0, 0, true},
+		{"11", `(a=1)(b=2)`, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ldapParseFilter(tt.aFilter)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("ldapParseFilter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.is(berClassContext, tt.wantTag) || (len(got.children) != tt.wantLen) {
+				t.Errorf("ldapParseFilter() = tag %d / %d children, want %d / %d",
+					got.tag, len(got.children), tt.wantTag, tt.wantLen)
+			}
+		})
+	}
+
+	f, _ := ldapParseFilter(`(member=uid=a\2cb)`)
+	if v := f.child(1).string(); `uid=a,b` != v {
+		t.Errorf("ldapParseFilter() value = %q, want 'uid=a,b'", v)
+	}
+} // Test_ldapParseFilter()
@@ -31,12 +31,16 @@ import (
 type (
 	// TPageHandler provides the handling of HTTP request/response.
 	TPageHandler struct {
-		cacheFS  http.Handler        // cache file server (i.e. thumbnails)
-		cssFS    http.Handler        // CSS file server
-		docFS    http.Handler        // document file server
-		staticFS http.Handler        // static file server
-		usrList  *passlist.TPassList // user/password list
-		viewList *TViewList          // list of template/views
+		auth     TAuthenticator          // authentication backend(s)
+		cacheFS  map[string]http.Handler // cache file servers (i.e. thumbnails) by library
+		cssFS    http.Handler            // CSS file server
+		docFS    map[string]http.Handler // document file servers by library
+		shares   *TShareStore            // the active share links
+		site     *TVirtualHost           // the site served
+		staticFS http.Handler            // static file server
+		tokens   *TTokenStore            // the users' API tokens
+		totp     *TTOTPstore             // two-factor authentication data
+		viewList *TViewList              // list of template/views
 	}
 )
 
@@ -52,42 +56,89 @@ func handleInternalError(aWriter http.ResponseWriter,
 		http.StatusInternalServerError)
 } // handleInternalError()
 
-// NewPageHandler returns a new `TPageHandler` instance.
+// NewPageHandler returns a new `TPageHandler` instance serving the
+// default site.
 func NewPageHandler() (*TPageHandler, error) {
+	result, err := newPageHandler(defaultSite())
+	if nil != err {
+		return nil, err
+	}
+
+	// Notify other services about the libraries' changes:
+	if hooks := NewWebhooks(AppArgs.Webhooks, AppArgs.WebhookSecret,
+		webhookBaseURL(), webhookLogFile()); nil != hooks {
+		for _, lib := range db.Libraries() {
+			lib.OnChange(hooks.Deliver)
+		}
+	}
+
+	// Initialise the databases:
+	db.Init()
+
+	// Update the thumbnails caches:
+	go ImageCacheScan()
+	for _, lib := range db.Libraries() {
+		go ThumbnailUpdate(lib)
+	}
+
+	// Avoid sessions for certain requests:
+	sessions.ExcludePaths("/certs", "/css/", "/favicon", "/file/", "/fonts", "/img/", "/robots", "/share/", "/changes/")
+
+	return result, nil
+} // NewPageHandler()
+
+// NewHostHandler returns a new `TPageHandler` instance serving the
+// virtual host `aSite`.
+//
+// The databases etc. are set up by `NewPageHandler()` which must be
+// called first.
+//
+//	`aSite` The virtual host to serve.
+func NewHostHandler(aSite *TVirtualHost) (*TPageHandler, error) {
+	return newPageHandler(aSite)
+} // NewHostHandler()
+
+// `newPageHandler()` returns a new `TPageHandler` instance serving
+// `aSite` with its own authentication and templates.
+//
+//	`aSite` The site to serve.
+func newPageHandler(aSite *TVirtualHost) (*TPageHandler, error) {
 	var (
 		err error
 	)
-	result := new(TPageHandler)
+	result := &TPageHandler{site: aSite}
 
-	result.cacheFS = jffs.FileServer(db.CalibreCachePath())
+	result.cacheFS = make(map[string]http.Handler)
 	result.cssFS = cssfs.FileServer(AppArgs.DataDir + `/`)
-	result.docFS = jffs.FileServer(db.CalibreLibraryPath())
+	result.docFS = make(map[string]http.Handler)
+	for _, lib := range db.Libraries() {
+		result.cacheFS[lib.Name()] = jffs.FileServer(lib.CachePath())
+		result.docFS[lib.Name()] = jffs.FileServer(lib.Path())
+	}
 	result.staticFS = jffs.FileServer(AppArgs.DataDir)
 
-	if s := AppArgs.PassFile; 0 == len(s) {
-		s = "missing user/password file\nAUTHENTICATION DISABLED!`"
-		apachelogger.Err("NewPageHandler()", s)
-	} else if result.usrList, err = passlist.LoadPasswords(s); nil != err {
-		s = fmt.Sprintf("%v\nAUTHENTICATION DISABLED!", err)
-		apachelogger.Err("NewPageHandler()", s)
-		result.usrList = nil
+	if result.auth, err = newAuthenticator(aSite.PassFile); nil != err {
+		// A configured but unusable backend must not result in an
+		// unprotected site:
+		return nil, fmt.Errorf("newPageHandler(%s): %w", aSite.Host, err)
 	}
-
-	if result.viewList, err = newViewList(filepath.Join(AppArgs.DataDir, `views`)); nil != err {
+	if nil == result.auth {
+		apachelogger.Err("newPageHandler()", aSite.Host+
+			" missing authentication backend\nAUTHENTICATION DISABLED!")
+	} else if result.totp, err = NewTOTPstore(totpFilename(aSite.PassFile)); nil != err {
+		return nil, err
+	} else if result.tokens, err = NewTokenStore(tokensFilename(aSite.PassFile)); nil != err {
+		return nil, err
+	} else if result.shares, err = NewShareStore(sharesFilename(aSite.PassFile)); nil != err {
 		return nil, err
 	}
 
-	// Initialise the database:
-	db.Init()
-
-	// Update the thumbnails cache:
-	go ThumbnailUpdate()
-
-	// Avoid sessions for certain requests:
-	sessions.ExcludePaths("/certs", "/css/", "/favicon", "/file/", "/fonts", "/img/", "/robots")
+	if result.viewList, err = newViewList(aSite.ViewsDir); nil != err {
+		return nil, err
+	}
 
 	return result, nil
-} // NewPageHandler()
+} // newPageHandler()
 
 // `newViewList()` returns a list of views found in `aDirectory`
 // and a possible I/O error.
@@ -139,6 +190,108 @@ func URLparts(aURL string) (rDir, rPath string) {
 	return aURL, ""
 } // URLparts()
 
+// `dotDotPath()` returns whether `aPath` contains a `..` segment,
+// either as is or after the additional unescaping done by `URLparts()`.
+//
+//	`aPath` The request's URL path to check.
+func dotDotPath(aPath string) bool {
+	paths := []string{aPath}
+	if unescaped, err := url.QueryUnescape(aPath); nil == err {
+		paths = append(paths, unescaped)
+	}
+	isSeparator := func(aRune rune) bool {
+		return ('/' == aRune) || ('\\' == aRune)
+	}
+	for _, path := range paths {
+		for _, segment := range strings.FieldsFunc(path, isSeparator) {
+			if `..` == segment {
+				return true
+			}
+		}
+	}
+
+	return false
+} // dotDotPath()
+
+// `libraryRequest()` returns `aRequest` prepared for the library
+// named by a `/lib/<name>/…` URL, or `nil` if the request's site
+// doesn't serve such a library.
+//
+// Requests without a library prefix are served by the site's
+// default library.
+//
+//	`aRequest` The HTTP request received by the server.
+func libraryRequest(aRequest *http.Request) *http.Request {
+	site := requestSite(aRequest)
+	tail, ok := strings.CutPrefix(aRequest.URL.Path, `/lib/`)
+	if !ok {
+		if 0 == len(site.libraries()) {
+			return nil
+		}
+		if lib := site.library(); !lib.IsDefault() {
+			return aRequest.WithContext(db.NewLibraryContext(aRequest.Context(), lib))
+		}
+		return aRequest
+	}
+	name, tail, _ := strings.Cut(tail, `/`)
+	lib := site.libraryNamed(name)
+	if nil == lib {
+		return nil
+	}
+
+	result := aRequest.WithContext(db.NewLibraryContext(aRequest.Context(), lib))
+	u := *aRequest.URL
+	u.Path, u.RawPath = `/`+tail, ``
+	result.URL = &u
+
+	return result
+} // libraryRequest()
+
+// `originalURI()` returns the request's URI as sent by the remote
+// user, i.e. including a `/lib/<name>` prefix which was removed by
+// `libraryRequest()`.
+//
+//	`aRequest` The HTTP request received by the server.
+func originalURI(aRequest *http.Request) string {
+	if u, err := url.ParseRequestURI(aRequest.RequestURI); nil == err {
+		return u.RequestURI()
+	}
+
+	return aRequest.URL.RequestURI()
+} // originalURI()
+
+// `librarySessionKey()` returns the session key to store the value
+// `aKey` of `aLibrary` with.
+//
+// The default library uses `aKey` as is so that existing sessions
+// keep working.
+//
+//	`aKey` The name of the session value.
+//	`aLibrary` The library the value belongs to.
+func librarySessionKey(aKey string, aLibrary *db.TLibrary) string {
+	if aLibrary.IsDefault() {
+		return aKey
+	}
+
+	return aKey + `:` + aLibrary.Name()
+} // librarySessionKey()
+
+// `libraryRoles()` returns the roles allowed to access `aLibrary`.
+//
+// An empty list means that the library is open to everybody.
+//
+//	`aLibrary` The library to check.
+func libraryRoles(aLibrary *db.TLibrary) []string {
+	for _, entry := range strings.Split(AppArgs.LibraryRoles, `;`) {
+		name, roles, ok := strings.Cut(entry, `:`)
+		if ok && (aLibrary.Name() == strings.TrimSpace(name)) {
+			return splitList(roles)
+		}
+	}
+
+	return nil
+} // libraryRoles()
+
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
 // `basicTemplateData()` returns a list of common template values.
@@ -147,12 +300,24 @@ func URLparts(aURL string) (rDir, rPath string) {
 //	`aOptions` The current query options to use.
 func (ph *TPageHandler) basicTemplateData(aRequest *http.Request, aOptions *db.TQueryOptions) *TemplateData {
 	y, m, d := time.Now().Date()
+	lib := ph.site.library()
+	if nil != aRequest {
+		lib = db.ContextLibrary(aRequest.Context())
+	}
+	var libList []*db.TLibrary
+	if libs := ph.site.libraries(); 1 < len(libs) {
+		libList = libs
+	}
+	libTitle := lib.Title()
+	if (0 < len(ph.site.LibName)) && (lib == ph.site.library()) {
+		libTitle = ph.site.LibName
+	}
 
 	var lang, theme string
 	switch aOptions.GuiLang {
-	case db.QoLangEnglish:
+	case db.QoLangGerman:
 		lang = `de`
-	default: // case db.QoLangGerman:
+	default: // case db.QoLangEnglish:
 		lang = `en`
 	}
 
@@ -194,17 +359,61 @@ func (ph *TPageHandler) basicTemplateData(aRequest *http.Request, aOptions *db.T
 		Set("HasPrev", false).
 		Set("IsGrid", db.QoLayoutGrid == aOptions.Layout).
 		Set("Lang", lang).
-		Set("LibraryName", AppArgs.LibName).
+		Set("LibName", lib.Name()).
+		Set("LibURL", lib.URL()).
+		Set("Libraries", libList).
+		Set("LibraryName", libTitle).
 		Set("Robots", "noindex,nofollow").
 		Set("SLO", aOptions.SelectLayoutOptions()).
 		Set("SLL", aOptions.SelectLimitOptions()).
 		Set("SOO", aOptions.SelectOrderOptions()).
 		Set("SSB", aOptions.SelectSortByOptions()).
 		Set("THEME", aOptions.SelectThemeOptions()).
-		Set("Title", AppArgs.Realm+fmt.Sprintf(": %d-%02d-%02d", y, m, d)).
-		Set("VirtLib", aOptions.SelectVirtLibOptions()) // #nosec G203
+		Set("Title", ph.site.Realm+fmt.Sprintf(": %d-%02d-%02d", y, m, d)).
+		Set("VirtLib", aOptions.SelectVirtLibOptions(lib)) // #nosec G203
 } // basicTemplateData()
 
+// `libraryAllowed()` returns whether the user of `aRequest` may
+// access `aLibrary`.
+//
+//	`aRequest` The (authenticated) HTTP request received by the server.
+//	`aLibrary` The library to check.
+func (ph *TPageHandler) libraryAllowed(aRequest *http.Request, aLibrary *db.TLibrary) bool {
+	roles := libraryRoles(aLibrary)
+	if 0 == len(roles) {
+		return true
+	}
+	if (nil == ph.auth) || (nil == ph.totp) || (nil == aRequest.URL.User) {
+		return false
+	}
+
+	// The roles are matched the same way as the TOTP roles:
+	return totpRequired(roles, ph.userRoles(aRequest.URL.User.Username()))
+} // libraryAllowed()
+
+// `cacheServer()` returns the file server of the cache directory
+// (i.e. thumbnails) of `aLibrary`.
+//
+//	`aLibrary` The library to serve files of.
+func (ph *TPageHandler) cacheServer(aLibrary *db.TLibrary) http.Handler {
+	if fs, ok := ph.cacheFS[aLibrary.Name()]; ok {
+		return fs
+	}
+
+	return jffs.FileServer(aLibrary.CachePath())
+} // cacheServer()
+
+// `docServer()` returns the file server of the documents of `aLibrary`.
+//
+//	`aLibrary` The library to serve files of.
+func (ph *TPageHandler) docServer(aLibrary *db.TLibrary) http.Handler {
+	if fs, ok := ph.docFS[aLibrary.Name()]; ok {
+		return fs
+	}
+
+	return jffs.FileServer(aLibrary.Path())
+} // docServer()
+
 // GetErrorPage returns an error page for `aStatus`,
 // implementing the `TErrorPager` interface.
 //
@@ -250,11 +459,9 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 	}()
 
 	path, tail := URLparts(aRequest.URL.Path)
+	lib := db.ContextLibrary(aRequest.Context())
 	so := sessions.GetSession(aRequest)
-	qo := db.NewQueryOptions(AppArgs.BooksPerPage) // in `queryoptions.go`
-	if qos, ok := so.GetString("QOS"); ok {
-		qo.Scan(qos)
-	}
+	qo := ph.queryOptions(aRequest, so)
 
 	doOpenDatabase := func() *db.TDataBase {
 		if dbHandle, err = db.OpenDatabase(aRequest.Context()); nil != err {
@@ -273,6 +480,9 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 	} // doHandleQuery()
 
 	switch path {
+	case `admin`:
+		ph.handleAdmin(aWriter, aRequest, qo, so)
+
 	case "authors", "format", "languages", "publisher", "series", "tags":
 		parts := strings.Split(tail, `/`)
 		qo.Entity = path
@@ -290,10 +500,36 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 	case "certs": // these files are handled internally
 		http.Redirect(aWriter, aRequest, "/", http.StatusMovedPermanently)
 
+	case `changes`:
+		if `atom` == tail {
+			ph.handleChangesFeed(aWriter, aRequest)
+		} else {
+			ph.handleChanges(aWriter, aRequest, qo, so)
+		}
+
+	case `collage`:
+		if nil == doOpenDatabase() {
+			return
+		}
+		ph.serveCollage(aWriter, aRequest, dbHandle, tail)
+
+	case `comic`:
+		if nil == doOpenDatabase() {
+			return
+		}
+		ph.handleComic(aWriter, aRequest, qo, so, dbHandle, tail)
+
 	case `cover`:
 		if nil == doOpenDatabase() {
 			return
 		}
+		var width uint
+		if n, _ := fmt.Sscanf(tail, "%d/w/%d", &id, &width); 2 == n {
+			// `/cover/ID/w/NNN`: a rendition of the cover
+			ph.serveRendition(aWriter, aRequest,
+				dbHandle.QueryDocMini(aRequest.Context(), id), width)
+			return
+		}
 		_, _ = fmt.Sscanf(tail, "%d/%s", &id, &dummy)
 		doc := dbHandle.QueryDocMini(aRequest.Context(), id)
 		if nil == doc {
@@ -302,11 +538,21 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 		}
 		file, err := doc.CoverAbs(true)
 		if (nil != err) || (0 >= len(file)) {
-			http.NotFound(aWriter, aRequest)
+			// Use the cover extracted from the book file or
+			// a placeholder:
+			if file, err = coverSource(doc); nil == err {
+				file, err = filepath.Rel(lib.CachePath(), file)
+			}
+			if nil != err {
+				http.NotFound(aWriter, aRequest)
+				return
+			}
+			aRequest.URL.Path = file
+			ph.cacheServer(lib).ServeHTTP(aWriter, aRequest)
 			return
 		}
 		aRequest.URL.Path = file
-		ph.docFS.ServeHTTP(aWriter, aRequest)
+		ph.docServer(lib).ServeHTTP(aWriter, aRequest)
 
 	case "css":
 		ph.cssFS.ServeHTTP(aWriter, aRequest)
@@ -323,13 +569,22 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 			return
 		}
 		pageData := ph.basicTemplateData(aRequest, qo).
-			Set("Document", doc)
-		aWriter.Header().Set(`Cache-Control`, `private, max-age=864000`) // 10 days
+			Set("CanShare", nil != ph.shares).
+			Set("ComicURL", comicURL(doc)).
+			Set("Document", doc).
+			Set("ReaderURL", readerURL(doc))
+		if nil != ph.shares {
+			// the share form's token mustn't outlive the session
+			pageData.Set("CSRF", csrfToken(so))
+			aWriter.Header().Set(`Cache-Control`, `private, no-cache`)
+		} else {
+			aWriter.Header().Set(`Cache-Control`, `private, max-age=864000`) // 10 days
+		}
 		aWriter.Header().Set(`Last-Modified`, doc.LastModified())
-		ph.handleReply(`document`, aWriter, qo, so, pageData)
+		ph.handleReply(`document`, aWriter, aRequest, qo, so, pageData)
 
 	case `faq`:
-		ph.handleReply(`faq`, aWriter, qo, so, ph.basicTemplateData(aRequest, qo))
+		ph.handleReply(`faq`, aWriter, aRequest, qo, so, ph.basicTemplateData(aRequest, qo))
 
 	case "favicon.ico":
 		http.Redirect(aWriter, aRequest, "/img/"+path, http.StatusMovedPermanently)
@@ -354,7 +609,7 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 		aWriter.Header().Set(`Cache-Control`, `private, max-age=864000`) // 10 days
 		aWriter.Header().Set(`Last-Modified`, doc.LastModified())
 		aRequest.URL.Path = file
-		ph.docFS.ServeHTTP(aWriter, aRequest)
+		ph.docServer(lib).ServeHTTP(aWriter, aRequest)
 
 	case `first`, ``:
 		qo.LimitStart = 0
@@ -367,13 +622,16 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 		ph.staticFS.ServeHTTP(aWriter, aRequest)
 
 	case `help`, `hilfe`:
-		ph.handleReply(`help`, aWriter, qo, so, ph.basicTemplateData(aRequest, qo))
+		ph.handleReply(`help`, aWriter, aRequest, qo, so, ph.basicTemplateData(aRequest, qo))
 
 	case "img":
 		ph.staticFS.ServeHTTP(aWriter, aRequest)
 
 	case `imprint`, `impressum`:
-		ph.handleReply(`imprint`, aWriter, qo, so, ph.basicTemplateData(aRequest, qo))
+		ph.handleReply(`imprint`, aWriter, aRequest, qo, so, ph.basicTemplateData(aRequest, qo))
+
+	case "js":
+		ph.staticFS.ServeHTTP(aWriter, aRequest)
 
 	case "last":
 		if qo.QueryCount <= qo.LimitLength {
@@ -384,7 +642,7 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 		doHandleQuery()
 
 	case `licence`, `license`, `lizenz`:
-		ph.handleReply(`licence`, aWriter, qo, so, ph.basicTemplateData(aRequest, qo))
+		ph.handleReply(`licence`, aWriter, aRequest, qo, so, ph.basicTemplateData(aRequest, qo))
 
 	case `next`:
 		doHandleQuery()
@@ -399,19 +657,35 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 		doHandleQuery()
 
 	case `privacy`, `datenschutz`:
-		ph.handleReply(`privacy`, aWriter, qo, so, ph.basicTemplateData(aRequest, qo))
+		ph.handleReply(`privacy`, aWriter, aRequest, qo, so, ph.basicTemplateData(aRequest, qo))
 
 	case "qo":
 		// This gets called when user requests page source of
 		// a POST result page; try to handle it gracefully.
 		doHandleQuery()
 
+	case `read`:
+		if nil == doOpenDatabase() {
+			return
+		}
+		ph.handleReader(aWriter, aRequest, qo, so, dbHandle, tail)
+
 	case "robots.txt":
 		ph.staticFS.ServeHTTP(aWriter, aRequest)
 
 	case "sessions": // files are handled internally
 		http.Redirect(aWriter, aRequest, "/", http.StatusMovedPermanently)
 
+	case `search`:
+		ph.handleSearch(aWriter, aRequest, qo, so)
+
+	case `share`:
+		if 0 < len(tail) {
+			ph.serveShare(aWriter, aRequest, tail)
+		} else {
+			ph.handleShare(aWriter, aRequest, qo, so)
+		}
+
 	case `thumb`:
 		if nil == doOpenDatabase() {
 			return
@@ -422,18 +696,25 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 			http.NotFound(aWriter, aRequest)
 			return
 		}
-		tName, err := Thumbnail(doc)
+		tName, err := ThumbnailRequest(aRequest.Context(), doc)
 		if nil != err {
 			http.NotFound(aWriter, aRequest)
 			return
 		}
-		file, err := filepath.Rel(db.CalibreCachePath(), tName)
+		imgCache.access(tName)
+		file, err := filepath.Rel(lib.CachePath(), tName)
 		if nil != err {
 			http.NotFound(aWriter, aRequest)
 			return
 		}
 		aRequest.URL.Path = file
-		ph.cacheFS.ServeHTTP(aWriter, aRequest)
+		ph.cacheServer(lib).ServeHTTP(aWriter, aRequest)
+
+	case `tokens`:
+		ph.handleTokens(aWriter, aRequest, qo, so)
+
+	case `totp`:
+		ph.handleTOTP(aWriter, aRequest, qo, so)
 
 	case "views": // files are handled internally
 		http.Redirect(aWriter, aRequest, "/", http.StatusMovedPermanently)
@@ -455,13 +736,18 @@ func (ph *TPageHandler) handleGET(aWriter http.ResponseWriter, aRequest *http.Re
 func (ph *TPageHandler) handlePOST(aWriter http.ResponseWriter, aRequest *http.Request) {
 	path, _ := URLparts(aRequest.URL.Path)
 	switch path {
-	case "qo": // the only valid POST destination
-		qo := db.NewQueryOptions(AppArgs.BooksPerPage)
+	case "qo":
 		so := sessions.GetSession(aRequest)
-		if qos, ok := so.GetString("QOS"); ok {
-			qo.Scan(qos)
-		}
+		qo := ph.queryOptions(aRequest, so)
 		qo.Update(aRequest)
+		if matching := strings.TrimSpace(aRequest.FormValue(`matching`)); (0 < len(matching)) &&
+			(0 < len(aRequest.FormValue(`federated`))) {
+			// Keep the other options for the search of all libraries:
+			lib := db.ContextLibrary(aRequest.Context())
+			so.Set(librarySessionKey("QOS", lib), qo.String())
+			http.Redirect(aWriter, aRequest, lib.URL()+`/search?q=`+url.QueryEscape(matching)+`#bodypage`, http.StatusSeeOther)
+			return
+		}
 		// Since the query options hold the LimitStart of the
 		// _next_ query we have to go back here one page:
 		qo.DecLimit()
@@ -477,6 +763,18 @@ func (ph *TPageHandler) handlePOST(aWriter http.ResponseWriter, aRequest *http.R
 
 		ph.handleQuery(aWriter, aRequest, qo, so, dbHandle)
 
+	case `share`, `tokens`, `totp`:
+		so := sessions.GetSession(aRequest)
+		qo := ph.queryOptions(aRequest, so)
+		switch path {
+		case `share`:
+			ph.handleShare(aWriter, aRequest, qo, so)
+		case `tokens`:
+			ph.handleTokens(aWriter, aRequest, qo, so)
+		default:
+			ph.handleTOTP(aWriter, aRequest, qo, so)
+		}
+
 	default:
 		// // if nothing matched (above) reply to the request
 		// // with an HTTP 404 "not found" error.
@@ -487,6 +785,22 @@ func (ph *TPageHandler) handlePOST(aWriter http.ResponseWriter, aRequest *http.R
 	}
 } // handlePOST()
 
+// `queryOptions()` returns the query options of the request's library
+// stored in `aSession`, or the site's default options.
+//
+//	`aRequest` The HTTP request received by the server.
+//	`aSession` The current user session.
+func (ph *TPageHandler) queryOptions(aRequest *http.Request, aSession *sessions.TSession) *db.TQueryOptions {
+	result := db.NewQueryOptions(AppArgs.BooksPerPage) // in `queryoptions.go`
+	if qos, ok := aSession.GetString(librarySessionKey("QOS", db.ContextLibrary(aRequest.Context()))); ok {
+		result.Scan(qos)
+	} else {
+		ph.site.setDefaults(result)
+	}
+
+	return result
+} // queryOptions()
+
 // `handleQuery()` serves the logical web-root directory.
 //
 //	`aWriter` Used by the HTTP handler to construct an HTTP response.
@@ -524,33 +838,39 @@ func (ph *TPageHandler) handleQuery(aWriter http.ResponseWriter, aRequest *http.
 	hasLast := BLast < BCount
 	hasNext := BCount > BLast
 	hasPrev := aOptions.LimitStart >= aOptions.LimitLength
+	lib := db.ContextLibrary(aRequest.Context())
+	newIDs := changedIDs(lib, changesSeen(aSession, lib))
 	aOptions.IncLimit()
 	pageData := ph.basicTemplateData(aRequest, aOptions).
 		Set("BFirst", BFirst).
 		Set("BLast", BLast).
 		Set("BCount", BCount).
+		Set("Collage", collageURL(lib, aOptions.Entity, aOptions.ID)).
 		Set("Documents", doclist).
 		Set("HasFirst", hasFirst).
 		Set("HasLast", hasLast).
 		Set("HasNext", hasNext).
 		Set("HasPrev", hasPrev).
 		Set("Matching", aOptions.Matching).
+		Set("NewCount", len(newIDs)).
+		Set("NewIDs", newIDs).
 		Set("SID", aSession.ID()).
 		Set("SIDNAME", sessions.SIDname()).
 		Set("ShowForm", true)
-	ph.handleReply("index", aWriter, aOptions, aSession, pageData)
+	ph.handleReply("index", aWriter, aRequest, aOptions, aSession, pageData)
 } // handleQuery()
 
 // `handleReply()` sends the resulting page back to the remote user.
 //
 //	`aPage` Name of the template/view to use.
 //	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
 //	`aOptions` The current query options to use.
 //	`aSession` The current user session.
 //	`aPageData` List of current template values.
-func (ph *TPageHandler) handleReply(aPage string, aWriter http.ResponseWriter, aOptions *db.TQueryOptions, aSession *sessions.TSession, aPageData *TemplateData) {
-	// store query options in session data
-	aSession.Set("QOS", aOptions.String())
+func (ph *TPageHandler) handleReply(aPage string, aWriter http.ResponseWriter, aRequest *http.Request, aOptions *db.TQueryOptions, aSession *sessions.TSession, aPageData *TemplateData) {
+	// store the library's query options in session data
+	aSession.Set(librarySessionKey("QOS", db.ContextLibrary(aRequest.Context())), aOptions.String())
 
 	if err := ph.viewList.Render(aPage, aWriter, aPageData); nil != err {
 		handleInternalError(aWriter, `TPageHandler.handleReply()`,
@@ -565,14 +885,33 @@ func (ph *TPageHandler) handleReply(aPage string, aWriter http.ResponseWriter, a
 //
 //	`aRequest` The web request to check.
 func (ph *TPageHandler) NeedAuthentication(aRequest *http.Request) bool {
-	if nil == ph.usrList {
+	if nil == ph.auth {
 		return false
 	}
+	path, tail := URLparts(aRequest.URL.Path)
+	if (`share` == path) && (0 < len(tail)) {
+		return false // signed share links are checked by `serveShare()`
+	}
 	if AppArgs.AuthAll {
 		return true
 	}
-	path, _ := URLparts(aRequest.URL.Path)
-	return (`file` == path)
+	if 0 < len(libraryRoles(db.ContextLibrary(aRequest.Context()))) {
+		return true // the library isn't open to everybody
+	}
+	switch path {
+	case `admin`, `comic`, `file`, `read`, `share`, `tokens`, `totp`:
+		return true
+
+	case `search`:
+		// The user's roles decide which libraries are searched:
+		for _, lib := range ph.site.libraries() {
+			if 0 < len(libraryRoles(lib)) {
+				return true
+			}
+		}
+	}
+
+	return false
 } // NeedAuthentication()
 
 // ServeHTTP handles the incoming HTTP requests.
@@ -580,6 +919,9 @@ func (ph *TPageHandler) NeedAuthentication(aRequest *http.Request) bool {
 //	`aWriter` Used by the HTTP handler to construct an HTTP response.
 //	`aRequest` The HTTP request received by the server.
 func (ph *TPageHandler) ServeHTTP(aWriter http.ResponseWriter, aRequest *http.Request) {
+	// Collect the request's query timings for the `Server-Timing` header:
+	tw, aRequest := newTimingWriter(aWriter, aRequest)
+	aWriter = tw
 	defer func() {
 		if err := recover(); err != nil {
 			var msg string
@@ -593,11 +935,41 @@ func (ph *TPageHandler) ServeHTTP(aWriter http.ResponseWriter, aRequest *http.Re
 	}()
 
 	aWriter.Header().Set(`Access-Control-Allow-Methods`, `GET, HEAD, POST`)
+	if dotDotPath(aRequest.URL.Path) {
+		// the file servers must not leave their directories
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	aRequest = aRequest.WithContext(newSiteContext(aRequest.Context(), ph.site))
+	// Requests for `/lib/<name>/…` are served by that library:
+	req := libraryRequest(aRequest)
+	if nil == req {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	aRequest = req
 	if ph.NeedAuthentication(aRequest) {
-		if err := ph.usrList.IsAuthenticated(aRequest); nil != err {
-			passlist.Deny(AppArgs.Realm, aWriter)
-			return
+		// API tokens are used by apps which can't handle
+		// the two-factor authentication:
+		if (nil == ph.tokens) || (nil != ph.tokens.IsAuthenticated(aRequest)) {
+			if err := ph.auth.IsAuthenticated(aRequest); nil != err {
+				passlist.Deny(ph.site.Realm, aWriter)
+				return
+			}
+			if !ph.totpPassed(aWriter, aRequest) {
+				return
+			}
 		}
+		// Only the administrators get to see the query shapes:
+		tw.details = (nil != aRequest.URL.User) && ph.isAdmin(aRequest.URL.User.Username())
+	}
+	// The federated search checks each library by itself while
+	// signed share links are checked by `serveShare()`:
+	if path, tail := URLparts(aRequest.URL.Path); (`search` != path) &&
+		((`share` != path) || (0 == len(tail))) &&
+		!ph.libraryAllowed(aRequest, db.ContextLibrary(aRequest.Context())) {
+		http.NotFound(aWriter, aRequest)
+		return
 	}
 
 	switch aRequest.Method {
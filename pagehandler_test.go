@@ -6,7 +6,18 @@
 
 package kaliber
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mwat56/jffs"
+	"github.com/mwat56/kaliber/db"
+)
 
 //lint:file-ignore ST1017 - I prefer Yoda conditions
 
@@ -56,3 +67,147 @@ func TestURLparts(t *testing.T) {
 		})
 	}
 } // TestURLparts()
+
+func TestTPageHandler_NeedAuthentication(t *testing.T) {
+	ph := &TPageHandler{auth: NewProxyAuth(`X-Remote-User`, `127.0.0.1`)}
+	tests := []struct {
+		name     string
+		aPath    string
+		aAuthAll bool
+		want     bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `/doc/1/x`, false, false},
+		{" 2", `/file/1/EPUB/x.epub`, false, true},
+		{" 3", `/share`, false, true},
+		{" 4", `/share/abc/sig/1.epub`, false, false},
+		{" 5", `/share/abc/sig/1.epub`, true, false},
+		{" 6", `/doc/1/x`, true, true},
+		{" 7", `/read/1/2`, false, true},
+		{" 8", `/read/1/res/OEBPS/image.jpg`, false, true},
+		{" 9", `/comic/1/`, false, true},
+		{"10", `/comic/1/img/3/w/640`, false, true},
+	}
+	defer func() { AppArgs.AuthAll = false }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AppArgs.AuthAll = tt.aAuthAll
+			if got := ph.NeedAuthentication(httptest.NewRequest(`GET`, tt.aPath, nil)); got != tt.want {
+				t.Errorf("TPageHandler.NeedAuthentication() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // TestTPageHandler_NeedAuthentication()
+
+func Test_dotDotPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		aPath string
+		want  bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `/img/logo.png`, false},
+		{" 2", `/img/../kaliber.key`, true},
+		{" 3", `/fonts/..`, true},
+		{" 4", `/img/%2e%2e/private/kaliber.key`, true},
+		{" 5", `/img/..\\kaliber.key`, true},
+		{" 6", `/doc/12/...html`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dotDotPath(tt.aPath); got != tt.want {
+				t.Errorf("dotDotPath(%q) = %v, want %v", tt.aPath, got, tt.want)
+			}
+		})
+	}
+
+	// The static file server never sees such a path:
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, secretKeyName), []byte(`secret`), 0600); nil != err {
+		t.Fatal(err)
+	}
+	ph := &TPageHandler{staticFS: jffs.FileServer(dir)}
+	rec := httptest.NewRecorder()
+	ph.ServeHTTP(rec, httptest.NewRequest(`GET`, `/img/../`+secretKeyName, nil))
+	if (http.StatusNotFound != rec.Code) || strings.Contains(rec.Body.String(), `secret`) {
+		t.Errorf("ServeHTTP() = %d %q, want %d", rec.Code, rec.Body.String(), http.StatusNotFound)
+	}
+} // Test_dotDotPath()
+
+func Test_libraryRequest(t *testing.T) {
+	main := db.DefaultLibrary()
+	lib := db.Library(`fiction`)
+	if nil == lib {
+		var err error
+		if lib, err = db.NewLibrary(`fiction`, `Fiction`, t.TempDir(), t.TempDir()); nil != err {
+			t.Fatal(err)
+		}
+		if err = db.AddLibrary(lib); nil != err {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name     string
+		aPath    string
+		wantNil  bool
+		wantLib  *db.TLibrary
+		wantPath string
+	}{
+		// TODO: Add test cases.
+		{" 1", `/doc/12/doc.html`, false, main, `/doc/12/doc.html`},
+		{" 2", `/lib/fiction/doc/12/doc.html`, false, lib, `/doc/12/doc.html`},
+		{" 3", `/lib/fiction`, false, lib, `/`},
+		{" 4", `/lib/unknown/doc/12/doc.html`, true, nil, ``},
+		{" 5", `/library/doc`, false, main, `/library/doc`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(`GET`, tt.aPath, nil)
+			got := libraryRequest(req)
+			if (nil == got) != tt.wantNil {
+				t.Fatalf("libraryRequest() = %v, wantNil %v", got, tt.wantNil)
+			}
+			if tt.wantNil {
+				return
+			}
+			if gotLib := db.ContextLibrary(got.Context()); tt.wantLib != gotLib {
+				t.Errorf("libraryRequest() library = %q, want %q", gotLib.Name(), tt.wantLib.Name())
+			}
+			if tt.wantPath != got.URL.Path {
+				t.Errorf("libraryRequest() path = %q, want %q", got.URL.Path, tt.wantPath)
+			}
+			if tt.aPath != req.URL.Path {
+				t.Errorf("libraryRequest() changed the original path to %q", req.URL.Path)
+			}
+		})
+	}
+} // Test_libraryRequest()
+
+func Test_libraryRoles(t *testing.T) {
+	lib, err := db.NewLibrary(`fiction`, `Fiction`, t.TempDir(), t.TempDir())
+	if nil != err {
+		t.Fatal(err)
+	}
+	saved := AppArgs.LibraryRoles
+	defer func() { AppArgs.LibraryRoles = saved }()
+	tests := []struct {
+		name   string
+		aRoles string
+		want   []string
+	}{
+		// TODO: Add test cases.
+		{" 1", ``, nil},
+		{" 2", `main:staff`, nil},
+		{" 3", `fiction:staff`, []string{`staff`}},
+		{" 4", `main:admin; fiction: staff , readers ;x:y`, []string{`staff`, `readers`}},
+		{" 5", `fiction`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AppArgs.LibraryRoles = tt.aRoles
+			if got := libraryRoles(lib); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("libraryRoles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // Test_libraryRoles()
@@ -0,0 +1,402 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the placeholder covers of books without a cover
+ * image, showing the book's title and author(s) on a background whose
+ * colour is derived from the book's UUID.
+ *
+ * The placeholders are stored below the library's cache path and
+ * used like real covers for the thumbnails and cover renditions.
+ */
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mwat56/apachelogger"
+	"github.com/mwat56/kaliber/db"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// Name of the cache subdirectory holding the placeholder covers.
+	phDirectory = `placeholders`
+
+	// Size of a placeholder cover (in pixels).
+	phWidth, phHeight = 600, 900
+
+	// Space between the image's border and the text.
+	phMargin = 60
+
+	// Max. number of lines of the title and the author(s).
+	phTitleLines, phAuthorLines = 5, 3
+
+	// Font size of the author(s).
+	phAuthorSize = 34
+
+	// The fonts (in `dataDir/fonts/`) used for title and author(s).
+	phTitleFont, phAuthorFont = `NotoSans-Bold.ttf`, `NotoSans-Regular.ttf`
+)
+
+var (
+	// Font sizes to try (in order) to fit the title.
+	phTitleSizes = []float64{64, 54, 46, 38}
+
+	// The parsed fonts, loaded when the first placeholder is drawn.
+	phFonts struct {
+		once   sync.Once
+		title  *opentype.Font
+		author *opentype.Font
+	}
+)
+
+// `coverSource()` returns the path/filename of the image to use as
+// the cover of `aDoc`: either its real cover or a placeholder.
+//
+//	`aDoc` The document whose cover to use.
+func coverSource(aDoc *db.TDocument) (string, error) {
+	if name, err := aDoc.CoverFile(); nil == err {
+		return name, nil
+	}
+
+	return placeholderCover(aDoc)
+} // coverSource()
+
+// `makePlaceholder()` draws the placeholder cover of `aDoc` and
+// stores it in `aDstName`.
+//
+//	`aDoc` The document to draw the placeholder for.
+//	`aDstName` The name of the JPEG file to write.
+func makePlaceholder(aDoc *db.TDocument, aDstName string) error {
+	placeholderFonts()
+	bg := placeholderColor(placeholderKey(aDoc))
+	fg := color.RGBA{0xFA, 0xF8, 0xF0, 0xFF}
+	frame := mixColor(bg, fg, 35)
+	img := image.NewRGBA(image.Rect(0, 0, phWidth, phHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	// a frame inside the image's border:
+	const inset, thick = 22, 4
+	outer := image.Rect(inset, inset, phWidth-inset, phHeight-inset)
+	draw.Draw(img, outer, image.NewUniform(frame), image.Point{}, draw.Src)
+	draw.Draw(img, outer.Inset(thick), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	maxWidth := phWidth - 2*phMargin
+	title := strings.TrimSpace(aDoc.Title)
+	if 0 == len(title) {
+		title = fmt.Sprintf("#%d", aDoc.ID)
+	}
+	var (
+		tFace  font.Face
+		tLines []string
+	)
+	for _, size := range phTitleSizes {
+		if nil != tFace {
+			_ = tFace.Close()
+		}
+		tFace = placeholderFace(phFonts.title, size)
+		if tLines = wrapText(tFace, title, maxWidth); phTitleLines >= len(tLines) {
+			break
+		}
+	}
+	defer tFace.Close()
+	tLines = truncateLines(tFace, tLines, phTitleLines, maxWidth)
+	y := phHeight/5 + tFace.Metrics().Ascent.Ceil()
+	y = drawLines(img, tFace, fg, tLines, y)
+
+	// a short rule between title and author(s):
+	y += 10
+	rule := image.Rect(phWidth/2-60, y, phWidth/2+60, y+thick)
+	draw.Draw(img, rule, image.NewUniform(frame), image.Point{}, draw.Src)
+
+	if authors := strings.TrimSpace(aDoc.AuthorList()); 0 < len(authors) {
+		aFace := placeholderFace(phFonts.author, phAuthorSize)
+		defer aFace.Close()
+		aLines := truncateLines(aFace, wrapText(aFace, authors, maxWidth), phAuthorLines, maxWidth)
+		height := aFace.Metrics().Height.Ceil()
+		y = phHeight - phMargin - 40 - (len(aLines)-1)*height
+		drawLines(img, aFace, frame, aLines, y)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(aDstName), os.ModeDir|0775); nil != err {
+		return err
+	}
+
+	return saveJPEG(img, aDstName, rdQuality)
+} // makePlaceholder()
+
+// `drawLines()` draws `aLines` centred into `aImage` starting with
+// the baseline `aY`; it returns the position below the last line.
+//
+//	`aImage` The image to draw into.
+//	`aFace` The font face to use.
+//	`aColor` The text colour to use.
+//	`aLines` The lines of text to draw.
+//	`aY` The baseline of the first line.
+func drawLines(aImage draw.Image, aFace font.Face, aColor color.Color, aLines []string, aY int) int {
+	height := aFace.Metrics().Height.Ceil()
+	drawer := &font.Drawer{
+		Dst:  aImage,
+		Src:  image.NewUniform(aColor),
+		Face: aFace,
+	}
+	for _, line := range aLines {
+		x := (aImage.Bounds().Dx() - drawer.MeasureString(line).Ceil()) / 2
+		drawer.Dot = fixed.P(x, aY)
+		drawer.DrawString(line)
+		aY += height
+	}
+
+	return aY - height + aFace.Metrics().Descent.Ceil()
+} // drawLines()
+
+// `loadFont()` returns the font read from `aFilename`.
+//
+//	`aFilename` The name of the TrueType/OpenType font file.
+func loadFont(aFilename string) *opentype.Font {
+	data, err := os.ReadFile(aFilename) // #nosec G304
+	if nil == err {
+		var result *opentype.Font
+		if result, err = opentype.Parse(data); nil == err {
+			return result
+		}
+	}
+	msg := fmt.Sprintf("opentype.Parse(%s): %v", aFilename, err)
+	apachelogger.Err("loadFont()", msg)
+
+	return nil
+} // loadFont()
+
+// `mixColor()` returns the mix of `aColor` with `aPercent` of `aOther`.
+//
+//	`aColor` The base colour.
+//	`aOther` The colour to add.
+//	`aPercent` The part of `aOther` (`0` to `100`).
+func mixColor(aColor, aOther color.RGBA, aPercent int) color.RGBA {
+	mix := func(aBase, aAdd uint8) uint8 {
+		return uint8((int(aBase)*(100-aPercent) + int(aAdd)*aPercent) / 100)
+	}
+
+	return color.RGBA{mix(aColor.R, aOther.R), mix(aColor.G, aOther.G), mix(aColor.B, aOther.B), 0xFF}
+} // mixColor()
+
+// `placeholderColor()` returns the background colour derived from
+// `aKey`.
+//
+// The hue is taken from the key's hash while saturation and
+// lightness are fixed so that the (light) text is always readable.
+//
+//	`aKey` The text to derive the colour from.
+func placeholderColor(aKey string) color.RGBA {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(aKey))
+	// the hash's low bits are badly distributed, hence use them all:
+	hue := float64(hash.Sum32()) / (1 << 32) * 6
+
+	const saturation, lightness = 0.45, 0.32
+	chroma := (1 - math.Abs(2*lightness-1)) * saturation
+	x := chroma * (1 - math.Abs(math.Mod(hue, 2)-1))
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g = chroma, x
+	case 1:
+		r, g = x, chroma
+	case 2:
+		g, b = chroma, x
+	case 3:
+		g, b = x, chroma
+	case 4:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+	m := lightness - chroma/2
+	channel := func(aValue float64) uint8 {
+		return uint8((aValue+m)*255 + 0.5)
+	}
+
+	return color.RGBA{channel(r), channel(g), channel(b), 0xFF}
+} // placeholderColor()
+
+// `placeholderCover()` returns the path/filename of the placeholder
+// cover of `aDoc`, drawing it if necessary.
+//
+//	`aDoc` The document whose placeholder to use.
+func placeholderCover(aDoc *db.TDocument) (string, error) {
+	name := placeholderName(aDoc)
+	if fi, err := os.Stat(name); (nil == err) && fi.Mode().IsRegular() {
+		return name, nil
+	}
+
+	// Remove the placeholders showing an outdated title or author:
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	if idx := strings.LastIndex(base, `-`); 0 < idx {
+		previous, _ := filepath.Glob(base[:idx] + `-*.jpg`)
+		for _, pName := range previous {
+			_ = os.Remove(pName)
+		}
+	}
+	if err := makePlaceholder(aDoc, name); nil != err {
+		return "", err
+	}
+
+	return name, nil
+} // placeholderCover()
+
+// `placeholderFace()` returns a face of `aFont` with `aSize` points,
+// or a basic face if the font couldn't be loaded.
+//
+//	`aFont` The font to use.
+//	`aSize` The font size to use.
+func placeholderFace(aFont *opentype.Font, aSize float64) font.Face {
+	if nil != aFont {
+		face, err := opentype.NewFace(aFont, &opentype.FaceOptions{
+			Size:    aSize,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if nil == err {
+			return face
+		}
+	}
+
+	return basicfont.Face7x13
+} // placeholderFace()
+
+// `placeholderFonts()` loads the fonts used to draw the placeholders.
+func placeholderFonts() {
+	phFonts.once.Do(func() {
+		dir := filepath.Join(AppArgs.DataDir, `fonts`)
+		phFonts.title = loadFont(filepath.Join(dir, phTitleFont))
+		phFonts.author = loadFont(filepath.Join(dir, phAuthorFont))
+	})
+} // placeholderFonts()
+
+// `placeholderKey()` returns the text to derive the placeholder's
+// colour from: the document's UUID or – if there's none – its
+// author(s) or title.
+//
+//	`aDoc` The document to use.
+func placeholderKey(aDoc *db.TDocument) string {
+	if uuid := aDoc.UUID(); 0 < len(uuid) {
+		return uuid
+	}
+	if authors := aDoc.AuthorList(); 0 < len(authors) {
+		return authors
+	}
+
+	return aDoc.Title
+} // placeholderKey()
+
+// `placeholderName()` returns the name of the placeholder file of
+// `aDoc`.
+//
+// The name contains a hash of the text shown so that a changed title
+// or author results in a new placeholder.
+//
+//	`aDoc` The document for which to compute the placeholder name.
+func placeholderName(aDoc *db.TDocument) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(aDoc.Title + "\x00" + aDoc.AuthorList() + "\x00" + aDoc.UUID()))
+	name := fmt.Sprintf("%06d", aDoc.ID)
+
+	return filepath.Join(aDoc.Library().CachePath(), phDirectory, name[:4],
+		fmt.Sprintf("%s-%08x.jpg", name, hash.Sum32()))
+} // placeholderName()
+
+// `truncateLines()` returns the first `aMax` lines of `aLines`; if
+// lines were dropped the last one is shortened and ends with an
+// ellipsis.
+//
+//	`aFace` The font face to measure the text with.
+//	`aLines` The lines of text.
+//	`aMax` The max. number of lines.
+//	`aWidth` The max. width of a line (in pixels).
+func truncateLines(aFace font.Face, aLines []string, aMax, aWidth int) []string {
+	if len(aLines) <= aMax {
+		return aLines
+	}
+	result := append([]string{}, aLines[:aMax]...)
+	last := result[aMax-1]
+	for 0 < len(last) {
+		if font.MeasureString(aFace, last+`…`).Ceil() <= aWidth {
+			break
+		}
+		_, size := utf8.DecodeLastRuneInString(last)
+		last = last[:len(last)-size]
+	}
+	result[aMax-1] = strings.TrimSpace(last) + `…`
+
+	return result
+} // truncateLines()
+
+// `wrapText()` splits `aText` into lines not wider than `aWidth`.
+//
+// Words wider than `aWidth` are split at the rune fitting the line.
+//
+//	`aFace` The font face to measure the text with.
+//	`aText` The text to split.
+//	`aWidth` The max. width of a line (in pixels).
+func wrapText(aFace font.Face, aText string, aWidth int) []string {
+	fits := func(aLine string) bool {
+		return font.MeasureString(aFace, aLine).Ceil() <= aWidth
+	}
+	var (
+		result []string
+		line   string
+	)
+	for _, word := range strings.Fields(aText) {
+		if 0 < len(line) {
+			if fits(line + ` ` + word) {
+				line += ` ` + word
+				continue
+			}
+			result = append(result, line)
+			line = ``
+		}
+		for !fits(word) {
+			// split the word at the last rune fitting the line:
+			idx := 0
+			for pos := range word {
+				if (0 < pos) && !fits(word[:pos]) {
+					break
+				}
+				idx = pos
+			}
+			if 0 == idx {
+				_, idx = utf8.DecodeRuneInString(word)
+			}
+			result = append(result, word[:idx])
+			word = word[idx:]
+		}
+		line = word
+	}
+	if 0 < len(line) {
+		result = append(result, line)
+	}
+
+	return result
+} // wrapText()
+
+/* _EoF_ */
@@ -0,0 +1,167 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mwat56/kaliber/db"
+	"golang.org/x/image/font/basicfont"
+)
+
+func Test_placeholderColor(t *testing.T) {
+	tests := []struct {
+		name string
+		aKey string
+	}{
+		// TODO: Add test cases.
+		{" 1", `0a1b2c3d-4e5f-6789-abcd-ef0123456789`},
+		{" 2", `Jane Austen`},
+		{" 3", ``},
+	}
+	seen := make(map[string]string, len(tests))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := placeholderColor(tt.aKey)
+			if again := placeholderColor(tt.aKey); got != again {
+				t.Errorf("placeholderColor() = %v, then %v", got, again)
+			}
+			if (0xFF != got.A) || (0xA0 < got.R) || (0xA0 < got.G) || (0xA0 < got.B) {
+				t.Errorf("placeholderColor() = %v, too light for the text", got)
+			}
+			key := string([]byte{got.R, got.G, got.B})
+			if other, ok := seen[key]; ok {
+				t.Errorf("placeholderColor(%q) = placeholderColor(%q) = %v", tt.aKey, other, got)
+			}
+			seen[key] = tt.aKey
+		})
+	}
+} // Test_placeholderColor()
+
+func Test_wrapText(t *testing.T) {
+	face := basicfont.Face7x13 // 7 pixels per character
+
+	tests := []struct {
+		name   string
+		aText  string
+		aWidth int
+		want   []string
+	}{
+		// TODO: Add test cases.
+		{" 1", `Pride and Prejudice`, 70, []string{`Pride and`, `Prejudice`}},
+		{" 2", `  Emma  `, 70, []string{`Emma`}},
+		{" 3", `Sense and Sensibility`, 35, []string{`Sense`, `and`, `Sensi`, `bilit`, `y`}},
+		{" 4", ``, 70, nil},
+		{" 5", `Persuasion`, 1, []string{`P`, `e`, `r`, `s`, `u`, `a`, `s`, `i`, `o`, `n`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrapText(face, tt.aText, tt.aWidth); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrapText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_wrapText()
+
+func Test_truncateLines(t *testing.T) {
+	face := basicfont.Face7x13 // 7 pixels per character
+
+	tests := []struct {
+		name   string
+		aLines []string
+		aMax   int
+		want   []string
+	}{
+		// TODO: Add test cases.
+		{" 1", []string{`one`, `two`}, 2, []string{`one`, `two`}},
+		{" 2", []string{`one`, `two three`, `four`}, 2, []string{`one`, `two thr…`}},
+		{" 3", []string{`one`, `two`, `four`}, 2, []string{`one`, `two…`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateLines(face, tt.aLines, tt.aMax, 56); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("truncateLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_truncateLines()
+
+func Test_placeholderName(t *testing.T) {
+	doc := &db.TDocument{ID: 4711, Title: `Emma`}
+	name := placeholderName(doc)
+	if want := filepath.Join(phDirectory, `0047`, `004711-`); !strings.Contains(name, want) {
+		t.Errorf("placeholderName() = %q, want it to contain %q", name, want)
+	}
+	if again := placeholderName(&db.TDocument{ID: 4711, Title: `Emma`}); name != again {
+		t.Errorf("placeholderName() = %q, then %q", name, again)
+	}
+	doc.Title = `Emma (Illustrated)`
+	if changed := placeholderName(doc); name == changed {
+		t.Errorf("placeholderName() = %q for a changed title", changed)
+	}
+} // Test_placeholderName()
+
+func Test_makePlaceholder(t *testing.T) {
+	dir := t.TempDir()
+	doc := &db.TDocument{ID: 42, Title: `A Very Long Title of a Book Which Needs Several Lines to be Shown Completely`}
+	first := filepath.Join(dir, `first.jpg`)
+	second := filepath.Join(dir, `sub`, `second.jpg`)
+	for _, name := range []string{first, second} {
+		if err := makePlaceholder(doc, name); nil != err {
+			t.Fatalf("makePlaceholder() error = %v", err)
+		}
+	}
+
+	data1, err := os.ReadFile(first)
+	if nil != err {
+		t.Fatal(err)
+	}
+	data2, err := os.ReadFile(second)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data1, data2) {
+		t.Error("makePlaceholder() images differ for the same document")
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data1))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if (phWidth != cfg.Width) || (phHeight != cfg.Height) {
+		t.Errorf("makePlaceholder() size = %dx%d, want %dx%d",
+			cfg.Width, cfg.Height, phWidth, phHeight)
+	}
+
+	// The title is drawn in the upper part of the image:
+	img, _, err := image.Decode(bytes.NewReader(data1))
+	if nil != err {
+		t.Fatal(err)
+	}
+	bg := placeholderColor(placeholderKey(doc))
+	light := 0
+	for y := phHeight / 5; y < phHeight/2; y++ {
+		for x := phMargin; x < phWidth-phMargin; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); uint32(bg.R)<<8+0x4000 < r {
+				light++
+			}
+		}
+	}
+	if 1000 > light {
+		t.Errorf("makePlaceholder() drew %d light pixels, want a visible title", light)
+	}
+} // Test_makePlaceholder()
+
+/* _EoF_ */
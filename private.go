@@ -0,0 +1,76 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the private directory holding the server's
+ * secret key and state files (two-factor authentication data, API
+ * tokens, share links, webhook log) which must never be served.
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Name of the `AppArgs.DataDir` subdirectory holding the private
+	// files.
+	privateDirName = `private`
+)
+
+// `privateDir()` returns the directory holding the private files,
+// creating it (accessible by the owner only) if necessary.
+func privateDir() string {
+	dir := filepath.Join(AppArgs.DataDir, privateDirName)
+	if err := os.MkdirAll(dir, os.ModeDir|0700); nil == err {
+		_ = os.Chmod(dir, os.ModeDir|0700)
+	}
+
+	return dir
+} // privateDir()
+
+// `privateFilename()` returns the name of the private file with the
+// extension `aExt` belonging to the password file `aPassFile`, or the
+// file `aDefault` if there's no password file.
+//
+// A password file inside `AppArgs.DataDir` gets its private files in
+// the private directory; otherwise they are stored next to it.
+// Files found at their former place (next to the password file or in
+// `AppArgs.DataDir`) are moved to the private directory.
+//
+//	`aPassFile` The name of the password file in use.
+//	`aExt` The filename extension of the private file.
+//	`aDefault` The private file's name without a password file.
+func privateFilename(aPassFile, aExt, aDefault string) string {
+	var oldName, name string
+	if 0 == len(aPassFile) {
+		oldName = filepath.Join(AppArgs.DataDir, aDefault)
+		name = aDefault
+	} else {
+		rel, err := filepath.Rel(AppArgs.DataDir, aPassFile)
+		if (nil != err) || (`..` == rel) || strings.HasPrefix(rel, `..`+string(filepath.Separator)) {
+			// the password file is outside the served directory
+			return aPassFile + aExt
+		}
+		oldName = aPassFile + aExt
+		name = strings.ReplaceAll(rel, string(filepath.Separator), `_`) + aExt
+	}
+	result := filepath.Join(privateDir(), name)
+	if _, err := os.Stat(result); os.IsNotExist(err) {
+		if _, err = os.Stat(oldName); nil == err {
+			_ = os.Rename(oldName, result)
+		}
+	}
+
+	return result
+} // privateFilename()
+
+/* _EoF_ */
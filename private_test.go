@@ -0,0 +1,67 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_privateFilename(t *testing.T) {
+	saved := AppArgs.DataDir
+	defer func() { AppArgs.DataDir = saved }()
+	AppArgs.DataDir = t.TempDir()
+	outside := t.TempDir()
+	private := filepath.Join(AppArgs.DataDir, privateDirName)
+
+	tests := []struct {
+		name      string
+		aPassFile string
+		aExt      string
+		aDefault  string
+		want      string
+	}{
+		// TODO: Add test cases.
+		{" 1", ``, ``, secretKeyName, filepath.Join(private, secretKeyName)},
+		{" 2", filepath.Join(AppArgs.DataDir, `pwaccess.db`), `.totp`, `totp.db`, filepath.Join(private, `pwaccess.db.totp`)},
+		{" 3", filepath.Join(AppArgs.DataDir, `sites`, `comics.db`), `.tokens`, `tokens.db`, filepath.Join(private, `sites_comics.db.tokens`)},
+		{" 4", filepath.Join(outside, `pwaccess.db`), `.shares`, `shares.db`, filepath.Join(outside, `pwaccess.db.shares`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := privateFilename(tt.aPassFile, tt.aExt, tt.aDefault); got != tt.want {
+				t.Errorf("privateFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	fi, err := os.Stat(private)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); 0700 != perm {
+		t.Errorf("privateDir() permissions = %o, want 700", perm)
+	}
+
+	// A file at its former place is moved:
+	oldName := filepath.Join(AppArgs.DataDir, `pwaccess.db.shares`)
+	if err = os.WriteFile(oldName, []byte(`links`), 0600); nil != err {
+		t.Fatal(err)
+	}
+	got := privateFilename(filepath.Join(AppArgs.DataDir, `pwaccess.db`), `.shares`, `shares.db`)
+	if data, err := os.ReadFile(got); (nil != err) || (`links` != string(data)) {
+		t.Errorf("privateFilename() = %q, %q, %v, want the moved file", got, data, err)
+	}
+	if _, err = os.Stat(oldName); !os.IsNotExist(err) {
+		t.Errorf("privateFilename() left %q in place", oldName)
+	}
+} // Test_privateFilename()
+
+/* _EoF_ */
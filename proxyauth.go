@@ -0,0 +1,142 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the authentication by a trusted reverse proxy
+ * which passes the (already authenticated) username in a HTTP header.
+ */
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type (
+	// `tTrustedProxies` is a list of networks allowed to send
+	// the user identifying header.
+	tTrustedProxies []*net.IPNet
+
+	// TProxyAuth authenticates requests by a header set by a
+	// trusted reverse proxy (e.g. `X-Remote-User`).
+	TProxyAuth struct {
+		header  string          // name of the user identifying header
+		trusted tTrustedProxies // addresses allowed to send `header`
+	}
+)
+
+// `parseTrustedProxies()` returns the list of networks in `aList`.
+//
+// Both single addresses (`127.0.0.1`, `::1`) and networks in CIDR
+// notation (`10.0.0.0/8`) are accepted; invalid entries are skipped.
+//
+//	`aList` Comma separated list of IP addresses and/or networks.
+func parseTrustedProxies(aList string) tTrustedProxies {
+	result := make(tTrustedProxies, 0, 4)
+	for _, entry := range strings.Split(aList, `,`) {
+		if entry = strings.TrimSpace(entry); 0 == len(entry) {
+			continue
+		}
+		if !strings.Contains(entry, `/`) {
+			if ip := net.ParseIP(entry); nil != ip {
+				if nil != ip.To4() {
+					entry += `/32`
+				} else {
+					entry += `/128`
+				}
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); nil == err {
+			result = append(result, ipNet)
+		}
+	}
+
+	return result
+} // parseTrustedProxies()
+
+// `contains()` returns whether `aRemoteAddr` belongs to one of
+// the trusted networks.
+//
+//	`aRemoteAddr` The remote address (`host:port`) of a request.
+func (tp tTrustedProxies) contains(aRemoteAddr string) bool {
+	host, _, err := net.SplitHostPort(aRemoteAddr)
+	if nil != err {
+		host = aRemoteAddr
+	}
+	ip := net.ParseIP(strings.Trim(host, `[]`))
+	if nil == ip {
+		return false
+	}
+	for _, ipNet := range tp {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+} // contains()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// NewProxyAuth returns a new `TProxyAuth` instance or `nil`
+// if either `aHeader` or `aTrusted` is empty.
+//
+//	`aHeader` The name of the header identifying the user.
+//	`aTrusted` Comma separated list of trusted proxy addresses.
+func NewProxyAuth(aHeader, aTrusted string) *TProxyAuth {
+	aHeader = strings.TrimSpace(aHeader)
+	if 0 == len(aHeader) {
+		return nil
+	}
+	trusted := parseTrustedProxies(aTrusted)
+	if 0 == len(trusted) {
+		return nil
+	}
+
+	return &TProxyAuth{
+		header:  http.CanonicalHeaderKey(aHeader),
+		trusted: trusted,
+	}
+} // NewProxyAuth()
+
+// IsAuthenticated checks `aRequest` for the user identifying header,
+// returning `nil` for successful authentication, or an `error` otherwise.
+//
+// The header is only accepted if the request was sent by one of the
+// trusted proxies; otherwise it's removed from the request to avoid
+// spoofing by later handlers.
+//
+// On success the username is stored in the `aRequest.URL.User`
+// structure (just like `passlist` does) to allow for other handlers
+// checking its existence and act accordingly.
+//
+//	`aRequest` The HTTP request received by the server.
+func (pa *TProxyAuth) IsAuthenticated(aRequest *http.Request) error {
+	if nil == pa {
+		return errors.New(`IsAuthenticated: proxy authentication disabled`)
+	}
+	if !pa.trusted.contains(aRequest.RemoteAddr) {
+		aRequest.Header.Del(pa.header)
+		return errors.New(`IsAuthenticated: untrusted proxy ` + aRequest.RemoteAddr)
+	}
+	user := strings.TrimSpace(aRequest.Header.Get(pa.header))
+	if 0 == len(user) {
+		return errors.New(`IsAuthenticated: missing ` + pa.header + ` header`)
+	}
+
+	// Store the user info so others can check for it
+	aRequest.URL.User = url.User(user)
+
+	return nil
+} // IsAuthenticated()
+
+/* _EoF_ */
@@ -0,0 +1,107 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_parseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		aList   string
+		wantLen int
+	}{
+		// TODO: Add test cases.
+		{" 1", ``, 0},
+		{" 2", `127.0.0.1`, 1},
+		{" 3", `127.0.0.1, ::1`, 2},
+		{" 4", `10.0.0.0/8, 192.168.1.0/24,::1`, 3},
+		{" 5", `no.such.address, 10.1.2.3`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTrustedProxies(tt.aList); len(got) != tt.wantLen {
+				t.Errorf("parseTrustedProxies() = %d, want %d", len(got), tt.wantLen)
+			}
+		})
+	}
+} // Test_parseTrustedProxies()
+
+func Test_tTrustedProxies_contains(t *testing.T) {
+	tp := parseTrustedProxies(`127.0.0.1, 10.0.0.0/8, ::1`)
+	tests := []struct {
+		name        string
+		aRemoteAddr string
+		want        bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `127.0.0.1:12345`, true},
+		{" 2", `127.0.0.2:12345`, false},
+		{" 3", `10.20.30.40:80`, true},
+		{" 4", `[::1]:8383`, true},
+		{" 5", `192.168.1.1:8383`, false},
+		{" 6", `garbage`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tp.contains(tt.aRemoteAddr); got != tt.want {
+				t.Errorf("tTrustedProxies.contains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // Test_tTrustedProxies_contains()
+
+func TestTProxyAuth_IsAuthenticated(t *testing.T) {
+	pa := NewProxyAuth(`x-remote-user`, `127.0.0.1`)
+	if nil == pa {
+		t.Fatal("NewProxyAuth() = nil")
+	}
+	tests := []struct {
+		name     string
+		aRemote  string
+		aUser    string
+		wantErr  bool
+		wantUser string
+	}{
+		// TODO: Add test cases.
+		{" 1", `127.0.0.1:4711`, `tester`, false, `tester`},
+		{" 2", `127.0.0.1:4711`, ``, true, ``},
+		{" 3", `192.168.1.1:4711`, `tester`, true, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(`GET`, `/`, nil)
+			req.RemoteAddr = tt.aRemote
+			if 0 < len(tt.aUser) {
+				req.Header.Set(`X-Remote-User`, tt.aUser)
+			}
+			err := pa.IsAuthenticated(req)
+			if (nil != err) != tt.wantErr {
+				t.Errorf("TProxyAuth.IsAuthenticated() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			var got string
+			if nil != req.URL.User {
+				got = req.URL.User.Username()
+			}
+			if got != tt.wantUser {
+				t.Errorf("TProxyAuth.IsAuthenticated() user = %q, want %q", got, tt.wantUser)
+			}
+		})
+	}
+
+	if nil != NewProxyAuth(``, `127.0.0.1`) {
+		t.Error("NewProxyAuth() with empty header != nil")
+	}
+	if nil != NewProxyAuth(`X-Remote-User`, ``) {
+		t.Error("NewProxyAuth() without trusted proxies != nil")
+	}
+} // TestTProxyAuth_IsAuthenticated()
@@ -0,0 +1,294 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the cover renditions, i.e. the documents' covers
+ * scaled to a set of widths, generated on demand and cached below the
+ * library's cache path.
+ */
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mwat56/kaliber/db"
+	"github.com/nfnt/resize"
+)
+
+const (
+	// Name of the cache subdirectory holding the renditions.
+	rdDirectory = `renditions`
+
+	// Smallest and largest width of a rendition.
+	rdMinWidth, rdMaxWidth = 32, 4096
+)
+
+var (
+	// The JPEG quality of the generated renditions.
+	rdQuality = 85
+
+	// The widths of the renditions served (sorted ascending).
+	rdWidths = []uint{160, 320, 640, 1024}
+)
+
+// `coverSrcset()` returns the `srcset` attribute value listing the
+// cover renditions of `aDoc`.
+//
+//	`aDoc` The document to use.
+func coverSrcset(aDoc *db.TDocument) template.Srcset {
+	list := make([]string, 0, len(rdWidths))
+	for _, width := range rdWidths {
+		list = append(list, fmt.Sprintf("%s %dw", aDoc.CoverWidth(width), width))
+	}
+
+	return template.Srcset(strings.Join(list, `, `)) // #nosec G203
+} // coverSrcset()
+
+// `makeRendition()` generates a rendition of `aSrcName` with a width
+// of `aWidth` pixels and stores it in `aDstName`.
+//
+//	`aSrcName` The filename of a document's cover image.
+//	`aDstName` The name of the generated rendition file.
+//	`aWidth` The width of the rendition.
+func makeRendition(aSrcName, aDstName string, aWidth uint) error {
+	sFile, err := os.OpenFile(aSrcName, os.O_RDONLY, 0) // #nosec G304
+	if nil != err {
+		return err
+	}
+	defer sFile.Close()
+
+	sImg, _, err := image.Decode(sFile)
+	if nil != err {
+		return err
+	}
+	_ = sFile.Close()
+
+	if err = os.MkdirAll(filepath.Dir(aDstName), os.ModeDir|0775); nil != err {
+		return err
+	}
+
+	return saveJPEG(scaleImage(sImg, aWidth), aDstName, rdQuality)
+} // makeRendition()
+
+// Rendition returns the name of the cover rendition file of `aDoc`
+// with `aWidth` pixels, generating it if necessary.
+//
+//	`aDoc` The document whose cover to use.
+//	`aWidth` The width of the rendition.
+func Rendition(aDoc *db.TDocument, aWidth uint) (string, error) {
+	sName, err := coverSource(aDoc)
+	if nil != err {
+		return "", err
+	}
+	sFI, err := os.Stat(sName)
+	if nil != err {
+		return "", err
+	}
+	if !sFI.Mode().IsRegular() {
+		return "", fmt.Errorf("not a regular file: %s", sName)
+	}
+
+	dName := renditionName(aDoc, aWidth)
+	if dFI, err := os.Stat(dName); (nil == err) && dFI.ModTime().After(sFI.ModTime()) {
+		// the rendition is younger than the original cover file
+		return dName, nil
+	}
+	if err = makeRendition(sName, dName, aWidth); nil != err {
+		return "", err
+	}
+	imgCache.add(dName)
+
+	return dName, nil
+} // Rendition()
+
+// RenditionRequest returns the name of the cover rendition file of
+// `aDoc` with `aWidth` pixels, generating it by the thumbnail workers
+// with a high priority if necessary.
+//
+// Concurrent requests for the same rendition share a single job.
+//
+//	`aContext` The context of the page request.
+//	`aDoc` The document whose cover to use.
+//	`aWidth` The width of the rendition.
+func RenditionRequest(aContext context.Context, aDoc *db.TDocument, aWidth uint) (string, error) {
+	job := thPool.submitJob(aDoc, renditionName(aDoc, aWidth), thPrioRequest, nil,
+		func() (string, error) {
+			return Rendition(aDoc, aWidth)
+		})
+
+	return thPool.wait(aContext, job)
+} // RenditionRequest()
+
+// `renditionETag()` returns the (strong) ETag of a rendition with
+// `aWidth` pixels of the cover file `aCover`.
+//
+//	`aCover` The info of the cover file.
+//	`aWidth` The width of the rendition.
+func renditionETag(aCover os.FileInfo, aWidth uint) string {
+	return fmt.Sprintf(`"%x-%x-w%d-q%d"`,
+		aCover.ModTime().UnixNano(), aCover.Size(), aWidth, rdQuality)
+} // renditionETag()
+
+// `renditionName()` returns the name of the rendition file of `aDoc`
+// with a width of `aWidth` pixels.
+//
+// The JPEG quality is part of the name so that changing it doesn't
+// serve renditions made with the old quality.
+//
+//	`aDoc` The document for which to compute the rendition name.
+//	`aWidth` The width of the rendition.
+func renditionName(aDoc *db.TDocument, aWidth uint) string {
+	name := fmt.Sprintf("%06d", aDoc.ID)
+
+	return filepath.Join(aDoc.Library().CachePath(), rdDirectory,
+		fmt.Sprintf("w%dq%d", aWidth, rdQuality), name[:4], name+`.jpg`)
+} // renditionName()
+
+// `renditionWidth()` returns whether `aWidth` is one of the widths
+// of the renditions served.
+//
+//	`aWidth` The width to check.
+func renditionWidth(aWidth uint) bool {
+	for _, width := range rdWidths {
+		if width == aWidth {
+			return true
+		}
+	}
+
+	return false
+} // renditionWidth()
+
+// `scaleImage()` downscales `aImage` to `aWidth` preserving the
+// original aspect ratio using the interpolation function
+// `resize.Bilinear`.
+//
+// Images not wider than `aWidth` keep their original size.
+//
+//	`aImage` The image to scale.
+//	`aWidth` The max. width of the resulting image.
+func scaleImage(aImage image.Image, aWidth uint) image.Image {
+	origBounds := aImage.Bounds()
+	origWidth, origHeight := uint(origBounds.Dx()), uint(origBounds.Dy())
+	newWidth, newHeight := origWidth, origHeight
+
+	// Preserve aspect ratio
+	if origWidth > aWidth {
+		newHeight = origHeight * aWidth / origWidth
+		if newHeight < 1 {
+			newHeight = 1
+		}
+		newWidth = aWidth
+	}
+
+	return resize.Resize(newWidth, newHeight, aImage, resize.Bilinear)
+} // scaleImage()
+
+// `serveRendition()` sends the cover rendition of `aDoc` with `aWidth`
+// pixels to the remote user.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aDoc` The document whose cover to send.
+//	`aWidth` The width of the rendition.
+func (ph *TPageHandler) serveRendition(aWriter http.ResponseWriter, aRequest *http.Request, aDoc *db.TDocument, aWidth uint) {
+	if (nil == aDoc) || !renditionWidth(aWidth) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	cName, err := coverSource(aDoc)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	cFI, err := os.Stat(cName)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+
+	defer imgCache.access(renditionName(aDoc, aWidth))
+	etag := renditionETag(cFI, aWidth)
+	aWriter.Header().Set(`Cache-Control`, `private, max-age=3600, stale-while-revalidate=86400`)
+	aWriter.Header().Set(`ETag`, etag)
+	if match := aRequest.Header.Get(`If-None-Match`); (0 < len(match)) &&
+		(strings.Contains(match, etag) || (`*` == match)) {
+		// no need to (re-)generate the rendition:
+		aWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rName, err := RenditionRequest(aRequest.Context(), aDoc, aWidth)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	file, err := os.Open(rName) // #nosec G304
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	defer file.Close()
+
+	aWriter.Header().Set(`Content-Type`, `image/jpeg`)
+	http.ServeContent(aWriter, aRequest, rName, cFI.ModTime(), file)
+} // serveRendition()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// SetCoverQuality sets the JPEG quality of the cover renditions.
+//
+// Values outside the range `1` to `100` are moved into that range.
+//
+//	`aQuality` The new JPEG quality to use.
+func SetCoverQuality(aQuality int) int {
+	if 1 > aQuality {
+		aQuality = 1
+	} else if 100 < aQuality {
+		aQuality = 100
+	}
+	rdQuality = aQuality
+
+	return rdQuality
+} // SetCoverQuality()
+
+// SetCoverWidths sets the widths of the cover renditions served.
+//
+// Widths outside the range `32` to `4096` are ignored; if no valid
+// width remains the current list is kept.
+//
+//	`aList` Comma separated list of widths.
+func SetCoverWidths(aList string) []uint {
+	var widths []uint
+	seen := make(map[uint]bool)
+	for _, entry := range splitList(aList) {
+		width, err := strconv.ParseUint(entry, 10, 32)
+		if (nil != err) || (rdMinWidth > width) || (rdMaxWidth < width) || seen[uint(width)] {
+			continue
+		}
+		seen[uint(width)] = true
+		widths = append(widths, uint(width))
+	}
+	if 0 < len(widths) {
+		sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+		rdWidths = widths
+	}
+
+	return rdWidths
+} // SetCoverWidths()
+
+/* _EoF_ */
@@ -0,0 +1,115 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func Test_scaleImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		aImage image.Image
+		aWidth uint
+		wantW  int
+		wantH  int
+	}{
+		// TODO: Add test cases.
+		{" 1", image.NewRGBA(image.Rect(0, 0, 800, 1200)), 320, 320, 480},
+		{" 2", image.NewRGBA(image.Rect(0, 0, 800, 1200)), 1024, 800, 1200},
+		{" 3", image.NewRGBA(image.Rect(0, 0, 2000, 1)), 160, 160, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scaleImage(tt.aImage, tt.aWidth).Bounds()
+			if (got.Dx() != tt.wantW) || (got.Dy() != tt.wantH) {
+				t.Errorf("scaleImage() = %dx%d, want %dx%d",
+					got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+} // Test_scaleImage()
+
+func TestSetCoverQuality(t *testing.T) {
+	saved := rdQuality
+	defer func() { rdQuality = saved }()
+
+	tests := []struct {
+		name     string
+		aQuality int
+		want     int
+	}{
+		// TODO: Add test cases.
+		{" 1", 85, 85},
+		{" 2", 0, 1},
+		{" 3", 101, 100},
+		{" 4", 100, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SetCoverQuality(tt.aQuality); got != tt.want {
+				t.Errorf("SetCoverQuality() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+} // TestSetCoverQuality()
+
+func TestSetCoverWidths(t *testing.T) {
+	saved := rdWidths
+	defer func() { rdWidths = saved }()
+
+	tests := []struct {
+		name  string
+		aList string
+		want  []uint
+	}{
+		// TODO: Add test cases.
+		{" 1", `160, 320, 640, 1024`, []uint{160, 320, 640, 1024}},
+		{" 2", `640,160,640`, []uint{160, 640}},
+		{" 3", `8, 480, 9999, abc`, []uint{480}},
+		{" 4", ``, []uint{480}},
+		{" 5", `-1`, []uint{480}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SetCoverWidths(tt.aList); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SetCoverWidths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // TestSetCoverWidths()
+
+func Test_renditionWidth(t *testing.T) {
+	saved := rdWidths
+	defer func() { rdWidths = saved }()
+	rdWidths = []uint{160, 320}
+
+	tests := []struct {
+		name   string
+		aWidth uint
+		want   bool
+	}{
+		// TODO: Add test cases.
+		{" 1", 160, true},
+		{" 2", 320, true},
+		{" 3", 321, false},
+		{" 4", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renditionWidth(tt.aWidth); got != tt.want {
+				t.Errorf("renditionWidth() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // Test_renditionWidth()
+
+/* _EoF_ */
@@ -0,0 +1,92 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the page searching all libraries the user may
+ * access.
+ */
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mwat56/apachelogger"
+	"github.com/mwat56/kaliber/db"
+	"github.com/mwat56/sessions"
+)
+
+// `allowedLibraries()` returns the libraries of the site the user
+// of `aRequest` may access.
+//
+//	`aRequest` The HTTP request received by the server.
+func (ph *TPageHandler) allowedLibraries(aRequest *http.Request) []*db.TLibrary {
+	var result []*db.TLibrary
+	for _, lib := range ph.site.libraries() {
+		if ph.libraryAllowed(aRequest, lib) {
+			result = append(result, lib)
+		}
+	}
+
+	return result
+} // allowedLibraries()
+
+// `handleSearch()` serves the `/search?q=…&page=N` page searching
+// all libraries the user may access.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aOptions` The current query options to use.
+//	`aSession` The current user session.
+func (ph *TPageHandler) handleSearch(aWriter http.ResponseWriter, aRequest *http.Request, aOptions *db.TQueryOptions, aSession *sessions.TSession) {
+	var (
+		count     int
+		hits      db.TFederatedList
+		truncated bool
+		err       error
+	)
+	pageData := ph.basicTemplateData(aRequest, aOptions).
+		Set("SID", aSession.ID()).
+		Set("SIDNAME", sessions.SIDname()).
+		Set("ShowForm", false)
+
+	query := strings.TrimSpace(aRequest.FormValue(`q`))
+	page, _ := strconv.Atoi(aRequest.FormValue(`page`))
+	if 1 > page {
+		page = 1
+	}
+	if 0 < len(query) {
+		// Use the sort order and page size of the current library
+		// without changing its query options:
+		qo := *aOptions
+		qo.Matching = query
+		qo.LimitStart = uint(page-1) * qo.LimitLength
+
+		count, hits, truncated, err = db.FederatedSearch(aRequest.Context(), ph.allowedLibraries(aRequest), &qo)
+		if nil != err {
+			msg := fmt.Sprintf("db.FederatedSearch(%q): %v", query, err)
+			apachelogger.Err("TPageHandler.handleSearch()", msg)
+		}
+		if (1 < page) && (0 < len(hits)) {
+			pageData.Set("PrevPage", page-1)
+		}
+		if int(qo.LimitStart)+len(hits) < count {
+			pageData.Set("NextPage", page+1)
+		}
+	}
+	pageData.Set("BCount", count).
+		Set("Hits", hits).
+		Set("Query", query).
+		Set("Truncated", truncated)
+	ph.handleReply(`search`, aWriter, aRequest, aOptions, aSession, pageData)
+} // handleSearch()
+
+/* _EoF_ */
@@ -0,0 +1,103 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the server's secret key used to sign data
+ * handed out to the remote users (e.g. cookies and links).
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/mwat56/apachelogger"
+)
+
+const (
+	// Length (in bytes) of the server's secret key.
+	secretKeyLen = 32
+
+	// Name of the file (in the private directory) storing the secret key.
+	secretKeyName = `kaliber.key`
+)
+
+var (
+	// The server's secret key, see `serverKey()`.
+	skKey []byte
+
+	// Guard to load the secret key only once.
+	skOnce sync.Once
+)
+
+// `loadSecretKey()` reads the secret key from `aFilename`, creating
+// the file with a new random key if it doesn't exist yet.
+//
+//	`aFilename` The name of the key file to use.
+func loadSecretKey(aFilename string) ([]byte, error) {
+	key, err := os.ReadFile(aFilename) // #nosec G304
+	if nil == err {
+		if secretKeyLen > len(key) {
+			return nil, fmt.Errorf("loadSecretKey(%s): key too short", aFilename)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, secretKeyLen)
+	if _, err = rand.Read(key); nil != err {
+		return nil, err
+	}
+	if err = os.WriteFile(aFilename, key, 0600); nil != err {
+		return nil, err
+	}
+
+	return key, nil
+} // loadSecretKey()
+
+// `serverKey()` returns the server's secret key.
+//
+// If the key file can't be read or written a random key is used
+// which means that all signatures become invalid when the server
+// gets restarted.
+func serverKey() []byte {
+	skOnce.Do(func() {
+		var err error
+		fName := privateFilename(``, ``, secretKeyName)
+		if skKey, err = loadSecretKey(fName); nil != err {
+			apachelogger.Err("serverKey()", fmt.Sprintf("%v", err))
+			skKey = make([]byte, secretKeyLen)
+			_, _ = rand.Read(skKey)
+		}
+	})
+
+	return skKey
+} // serverKey()
+
+// `signData()` returns the HMAC-SHA256 of `aData` using `aKey`.
+//
+//	`aKey` The secret key to use.
+//	`aData` The data to sign.
+func signData(aKey []byte, aData ...string) []byte {
+	mac := hmac.New(sha256.New, aKey)
+	for _, data := range aData {
+		_, _ = mac.Write([]byte(data))
+		_, _ = mac.Write([]byte{0})
+	}
+
+	return mac.Sum(nil)
+} // signData()
+
+/* _EoF_ */
@@ -0,0 +1,393 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides signed, expiring links to share single
+ * documents with people who don't have an account.
+ */
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Longest lifetime allowed for a share link.
+	shareMaxAge = 90 * 24 * time.Hour
+)
+
+type (
+	// TShareLink describes a link sharing a single document file.
+	TShareLink struct {
+		Count   int       // number of downloads so far
+		Created time.Time // time the link was created
+		DocID   int       // ID of the shared document
+		Expires time.Time // expiry time of the link
+		Format  string    // the document's shared file format
+		ID      string    // the link's public ID
+		Library string    // name of the document's library (empty: default library)
+		Max     int       // max. number of downloads (0: unlimited)
+		User    string    // the user who created the link
+	}
+
+	// TShareStore manages the active share links.
+	//
+	// The data is kept in a text file next to the password file;
+	// each line holds the colon separated fields
+	//
+	//	id:docID:format:created:expires:max:count:user[:library]
+	TShareStore struct {
+		filename string                 // name of the data file
+		mtx      *sync.Mutex            // guard for `links`
+		links    map[string]*TShareLink // list of links by ID
+	}
+)
+
+// Active returns whether the link can be used at `aTime`.
+//
+//	`aTime` The time to check.
+func (sl TShareLink) Active(aTime time.Time) bool {
+	if !aTime.Before(sl.Expires) {
+		return false
+	}
+
+	return (0 == sl.Max) || (sl.Count < sl.Max)
+} // Active()
+
+// DocPath returns the URL path of the shared document's page.
+func (sl TShareLink) DocPath() string {
+	if 0 < len(sl.Library) {
+		return fmt.Sprintf("/lib/%s/doc/%d/doc.html", sl.Library, sl.DocID)
+	}
+
+	return fmt.Sprintf("/doc/%d/doc.html", sl.DocID)
+} // DocPath()
+
+// Path returns the (signed) URL path of the link.
+//
+//	`aKey` The secret key to sign the link with.
+//	`aName` The filename to show to the remote user.
+func (sl TShareLink) Path(aKey []byte, aName string) string {
+	return fmt.Sprintf("/share/%s/%s/%s", sl.ID, sl.signature(aKey), aName)
+} // Path()
+
+// `signature()` returns the link's HMAC using `aKey`.
+//
+// All the link's immutable fields are signed so that neither the
+// document nor the limits can be changed by the remote user.
+// The library is signed only if it's not the default one so that
+// links created before there were several libraries stay valid.
+//
+//	`aKey` The secret key to use.
+func (sl TShareLink) signature(aKey []byte) string {
+	fields := []string{`share`, sl.ID, strconv.Itoa(sl.DocID), sl.Format,
+		strconv.FormatInt(sl.Expires.Unix(), 10), strconv.Itoa(sl.Max)}
+	if 0 < len(sl.Library) {
+		fields = append(fields, sl.Library)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(signData(aKey, fields...))
+} // signature()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// NewShareStore returns a new `TShareStore` instance using `aFilename`.
+//
+// A missing file is not an error; it gets created when the first
+// link is added.
+//
+//	`aFilename` The name of the data file to use.
+func NewShareStore(aFilename string) (*TShareStore, error) {
+	result := &TShareStore{
+		filename: aFilename,
+		mtx:      new(sync.Mutex),
+		links:    make(map[string]*TShareLink, 16),
+	}
+	if err := result.load(); nil != err {
+		return nil, err
+	}
+
+	return result, nil
+} // NewShareStore()
+
+// SharesFilename returns the name of the share link file.
+//
+// It's the password file's name with a `.shares` extension or –
+// if there's no password file – `shares.db` in the private directory
+// (see `privateFilename()`).
+func SharesFilename() string {
+	return sharesFilename(AppArgs.PassFile)
+} // SharesFilename()
+
+// `sharesFilename()` returns the name of the share link file belonging to
+// the password file `aPassFile`.
+//
+//	`aPassFile` The name of the password file in use.
+func sharesFilename(aPassFile string) string {
+	return privateFilename(aPassFile, `.shares`, `shares.db`)
+} // sharesFilename()
+
+// Add creates a new share link for the `aFormat` file of document
+// `aDocID` in library `aLibrary`.
+//
+//	`aUser` The user creating the link.
+//	`aLibrary` The name of the document's library (empty: default library).
+//	`aDocID` The ID of the document to share.
+//	`aFormat` The file format to share.
+//	`aExpires` The link's expiry time.
+//	`aMax` The max. number of downloads (0: unlimited).
+func (ss *TShareStore) Add(aUser, aLibrary string, aDocID int, aFormat string, aExpires time.Time, aMax int) (*TShareLink, error) {
+	if (0 == len(aUser)) || strings.ContainsAny(aUser, ":\n") {
+		return nil, fmt.Errorf("Add: invalid username '%s'", aUser)
+	}
+	if strings.ContainsAny(aLibrary, ":\n") {
+		return nil, fmt.Errorf("Add: invalid library '%s'", aLibrary)
+	}
+	aFormat = strings.ToUpper(strings.TrimSpace(aFormat))
+	if (0 >= aDocID) || (0 == len(aFormat)) || strings.ContainsAny(aFormat, ":/\n") {
+		return nil, errors.New(`Add: invalid document`)
+	}
+	now := time.Now().Truncate(time.Second)
+	if !aExpires.After(now) || (shareMaxAge < aExpires.Sub(now)) {
+		return nil, errors.New(`Add: invalid expiry time`)
+	}
+	if 0 > aMax {
+		aMax = 0
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); nil != err {
+		return nil, err
+	}
+	link := &TShareLink{
+		Created: now,
+		DocID:   aDocID,
+		Expires: aExpires.Truncate(time.Second),
+		Format:  aFormat,
+		ID:      hex.EncodeToString(buf),
+		Library: aLibrary,
+		Max:     aMax,
+		User:    aUser,
+	}
+
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	ss.links[link.ID] = link
+	if err := ss.save(); nil != err {
+		delete(ss.links, link.ID)
+		return nil, err
+	}
+	result := *link
+
+	return &result, nil
+} // Add()
+
+// Check verifies the link `aID` with `aSignature`, returning the
+// link's data if it can be used.
+//
+// If `aCount` is `true` the link's download counter is incremented.
+//
+//	`aKey` The secret key the link was signed with.
+//	`aID` The link's ID.
+//	`aSignature` The link's signature sent by the remote user.
+//	`aCount` Flag whether to count the request as a download.
+func (ss *TShareStore) Check(aKey []byte, aID, aSignature string, aCount bool) (*TShareLink, error) {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	link, ok := ss.links[aID]
+	if (!ok) || !hmac.Equal([]byte(link.signature(aKey)), []byte(aSignature)) {
+		return nil, errors.New(`Check: unknown share link`)
+	}
+	if !link.Active(time.Now()) {
+		return nil, fmt.Errorf("Check: share link '%s' expired", aID)
+	}
+	if aCount {
+		link.Count++
+		if err := ss.save(); nil != err {
+			// Without storing the counter the limit can't be enforced:
+			link.Count--
+			return nil, err
+		}
+	}
+	result := *link
+
+	return &result, nil
+} // Check()
+
+// List returns the active links of `aUser` (or all active links if
+// `aUser` is empty), sorted by user and creation time.
+//
+//	`aUser` The user whose links to list.
+func (ss *TShareStore) List(aUser string) []TShareLink {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	now := time.Now()
+	result := make([]TShareLink, 0, len(ss.links))
+	for _, link := range ss.links {
+		if link.Active(now) && ((0 == len(aUser)) || (aUser == link.User)) {
+			result = append(result, *link)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].User != result[j].User {
+			return result[i].User < result[j].User
+		}
+		if !result[i].Created.Equal(result[j].Created) {
+			return result[i].Created.Before(result[j].Created)
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+} // List()
+
+// `load()` reads the data file.
+func (ss *TShareStore) load() error {
+	file, err := os.Open(ss.filename) // #nosec G304
+	if nil != err {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	links := make(map[string]*TShareLink, 16)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if (0 == len(line)) || ('#' == line[0]) {
+			continue
+		}
+		fields := strings.SplitN(line, `:`, 9)
+		if 8 > len(fields) {
+			return fmt.Errorf("%s:%d: invalid number of fields", ss.filename, lineNo)
+		}
+		library := ``
+		if 9 == len(fields) {
+			library = fields[8]
+		}
+		var numbers [5]int64
+		for i, field := range []string{fields[1], fields[3], fields[4], fields[5], fields[6]} {
+			if numbers[i], err = strconv.ParseInt(field, 10, 64); nil != err {
+				return fmt.Errorf("%s:%d: %w", ss.filename, lineNo, err)
+			}
+		}
+		links[fields[0]] = &TShareLink{
+			Count:   int(numbers[4]),
+			Created: time.Unix(numbers[1], 0),
+			DocID:   int(numbers[0]),
+			Expires: time.Unix(numbers[2], 0),
+			Format:  fields[2],
+			ID:      fields[0],
+			Library: library,
+			Max:     int(numbers[3]),
+			User:    fields[7],
+		}
+	}
+	if err = scanner.Err(); nil != err {
+		return err
+	}
+	ss.links = links
+
+	return nil
+} // load()
+
+// Remove deletes the link `aID`; if `aUser` is not empty the link
+// must belong to that user.
+//
+//	`aID` The ID of the link to remove.
+//	`aUser` The (optional) creator of the link.
+func (ss *TShareStore) Remove(aID, aUser string) error {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	link, ok := ss.links[aID]
+	if (!ok) || ((0 < len(aUser)) && (aUser != link.User)) {
+		return fmt.Errorf("Remove: unknown share link '%s'", aID)
+	}
+	delete(ss.links, aID)
+
+	return ss.save()
+} // Remove()
+
+// RemoveUser deletes all links of `aUser`, returning their number.
+//
+//	`aUser` The user whose links to remove.
+func (ss *TShareStore) RemoveUser(aUser string) (int, error) {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	count := 0
+	for id, link := range ss.links {
+		if aUser == link.User {
+			delete(ss.links, id)
+			count++
+		}
+	}
+	if 0 == count {
+		return 0, nil
+	}
+
+	return count, ss.save()
+} // RemoveUser()
+
+// `save()` writes the data file, dropping all links which can't be
+// used anymore.
+//
+// NOTE: The caller is expected to hold the lock.
+func (ss *TShareStore) save() error {
+	now := time.Now()
+	ids := make([]string, 0, len(ss.links))
+	for id, link := range ss.links {
+		if link.Active(now) {
+			ids = append(ids, id)
+		} else {
+			delete(ss.links, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var sb strings.Builder
+	sb.WriteString("# Kaliber share links: id:docID:format:created:expires:max:count:user[:library]\n")
+	for _, id := range ids {
+		link := ss.links[id]
+		fmt.Fprintf(&sb, "%s:%d:%s:%d:%d:%d:%d:%s", id, link.DocID,
+			link.Format, link.Created.Unix(), link.Expires.Unix(),
+			link.Max, link.Count, link.User)
+		if 0 < len(link.Library) {
+			sb.WriteString(`:` + link.Library)
+		}
+		sb.WriteString("\n")
+	}
+
+	tmpName := ss.filename + `~`
+	if err := os.WriteFile(tmpName, []byte(sb.String()), 0600); nil != err {
+		return err
+	}
+
+	return os.Rename(tmpName, ss.filename)
+} // save()
+
+/* _EoF_ */
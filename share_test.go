@@ -0,0 +1,120 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTShareStore(t *testing.T) {
+	key := []byte(`0123456789abcdef0123456789abcdef`)
+	fName := filepath.Join(t.TempDir(), `pwaccess.db.shares`)
+	ss, err := NewShareStore(fName)
+	if nil != err {
+		t.Fatalf("NewShareStore() error = %v", err)
+	}
+	expires := time.Now().Add(time.Hour)
+	once, err := ss.Add(`alice`, ``, 12, `epub`, expires, 1)
+	if nil != err {
+		t.Fatalf("TShareStore.Add() error = %v", err)
+	}
+	if `EPUB` != once.Format {
+		t.Errorf("TShareStore.Add() format = %q, want %q", once.Format, `EPUB`)
+	}
+	open, _ := ss.Add(`alice`, ``, 13, `PDF`, expires, 0)
+	bobs, _ := ss.Add(`bob`, `comics`, 14, `PDF`, expires, 0)
+
+	invalid := []struct {
+		name     string
+		aUser    string
+		aLibrary string
+		aDocID   int
+		aFormat  string
+		aExpires time.Time
+	}{
+		// TODO: Add test cases.
+		{" 1", `carol:x`, ``, 1, `PDF`, expires},
+		{" 2", `carol`, ``, 0, `PDF`, expires},
+		{" 3", `carol`, ``, 1, ``, expires},
+		{" 4", `carol`, ``, 1, `PDF`, time.Now().Add(-time.Hour)},
+		{" 5", `carol`, ``, 1, `PDF`, time.Now().Add(shareMaxAge + time.Hour)},
+		{" 6", `carol`, `comics:x`, 1, `PDF`, expires},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ss.Add(tt.aUser, tt.aLibrary, tt.aDocID, tt.aFormat, tt.aExpires, 0); nil == err {
+				t.Error("TShareStore.Add() error = nil, want error")
+			}
+		})
+	}
+
+	// Data must survive a reload:
+	if ss, err = NewShareStore(fName); nil != err {
+		t.Fatalf("NewShareStore() reload error = %v", err)
+	}
+	if got := ss.List(`alice`); 2 != len(got) {
+		t.Errorf("TShareStore.List() = %v", got)
+	}
+	if got := ss.List(`bob`); (1 != len(got)) || (`comics` != got[0].Library) {
+		t.Errorf("TShareStore.List() = %v", got)
+	}
+
+	sig := func(aLink *TShareLink) string {
+		return strings.Split(aLink.Path(key, `x`), `/`)[3]
+	}
+	tests := []struct {
+		name    string
+		aKey    []byte
+		aID     string
+		aSig    string
+		aCount  bool
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+		{" 1", key, once.ID, sig(once), false, false},
+		{" 2", key, once.ID, sig(open), false, true},
+		{" 3", []byte(`another key`), once.ID, sig(once), false, true},
+		{" 4", key, once.ID, sig(once), true, false},
+		{" 5", key, once.ID, sig(once), false, true}, // limit reached
+		{" 6", key, open.ID, sig(open), true, false},
+		{" 7", key, open.ID, sig(open), true, false},
+		{" 8", key, `unknown`, sig(open), false, true},
+		{" 9", key, bobs.ID, sig(bobs), false, false},
+		{"10", key, bobs.ID, sig(&TShareLink{DocID: bobs.DocID, Expires: bobs.Expires, Format: bobs.Format, ID: bobs.ID}), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ss.Check(tt.aKey, tt.aID, tt.aSig, tt.aCount); (nil != err) != tt.wantErr {
+				t.Errorf("TShareStore.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if got := ss.List(`alice`); (1 != len(got)) || (2 != got[0].Count) {
+		t.Errorf("TShareStore.List() = %v", got)
+	}
+
+	if err = ss.Remove(bobs.ID, `alice`); nil == err {
+		t.Error("TShareStore.Remove() of foreign link: error = nil")
+	}
+	if err = ss.Remove(open.ID, `alice`); nil != err {
+		t.Errorf("TShareStore.Remove() error = %v", err)
+	}
+	if _, err = ss.Check(key, open.ID, sig(open), false); nil == err {
+		t.Error("TShareStore.Check() of revoked link: error = nil")
+	}
+	if n, _ := ss.RemoveUser(`bob`); 1 != n {
+		t.Errorf("TShareStore.RemoveUser() = %d, want 1", n)
+	}
+	if got := ss.List(``); 0 != len(got) {
+		t.Errorf("TShareStore.List() = %v", got)
+	}
+} // TestTShareStore()
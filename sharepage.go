@@ -0,0 +1,202 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the web page to manage the share links and
+ * the handler serving the shared files.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwat56/apachelogger"
+	"github.com/mwat56/kaliber/db"
+	"github.com/mwat56/sessions"
+)
+
+// `shareName()` returns the filename used in the URL of `aLink`.
+//
+//	`aLink` The share link to use.
+func shareName(aLink TShareLink) string {
+	return fmt.Sprintf("%d.%s", aLink.DocID, strings.ToLower(aLink.Format))
+} // shareName()
+
+// `shareURL()` returns the absolute URL of `aLink`.
+//
+//	`aRequest` The HTTP request received by the server.
+//	`aLink` The share link to use.
+func shareURL(aRequest *http.Request, aLink TShareLink) string {
+	return absoluteURL(aRequest, aLink.Path(serverKey(), shareName(aLink)))
+} // shareURL()
+
+// `handleShare()` serves the `/share` page (GET and POST).
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aOptions` The current query options to use.
+//	`aSession` The current user session.
+func (ph *TPageHandler) handleShare(aWriter http.ResponseWriter, aRequest *http.Request, aOptions *db.TQueryOptions, aSession *sessions.TSession) {
+	if (nil == ph.shares) || (nil == aRequest.URL.User) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	user := aRequest.URL.User.Username()
+	token := csrfToken(aSession)
+	pageData := ph.basicTemplateData(aRequest, aOptions).
+		Set("CSRF", token).
+		Set("SID", aSession.ID()).
+		Set("SIDNAME", sessions.SIDname()).
+		Set("ShowForm", false).
+		Set("User", user)
+
+	if `POST` == aRequest.Method {
+		var err error
+		if !csrfPassed(aRequest, token) {
+			apachelogger.Err("TPageHandler.handleShare()",
+				fmt.Sprintf("rejected cross-site request for '%s'", user))
+			err = errCSRF
+		} else if id := aRequest.FormValue(`revoke`); 0 < len(id) {
+			err = ph.shares.Remove(id, user)
+		} else if `create` == aRequest.FormValue(`action`) {
+			err = ph.createShare(aRequest, user, pageData)
+		}
+		if nil != err {
+			pageData.Set("Error", fmt.Sprintf("%v", err))
+		}
+	}
+
+	links := ph.shares.List(user)
+	urls := make(map[string]string, len(links))
+	for _, link := range links {
+		urls[link.ID] = shareURL(aRequest, link)
+	}
+	pageData.Set("Links", links).
+		Set("URLs", urls)
+	aWriter.Header().Set(`Cache-Control`, `no-store`)
+	ph.handleReply(`share`, aWriter, aRequest, aOptions, aSession, pageData)
+} // handleShare()
+
+// `createShare()` adds a new share link using the form data sent
+// with `aRequest`.
+//
+//	`aRequest` The HTTP request received by the server.
+//	`aUser` The user creating the link.
+//	`aPageData` The template values to update.
+func (ph *TPageHandler) createShare(aRequest *http.Request, aUser string, aPageData *TemplateData) error {
+	docID, _ := strconv.Atoi(aRequest.FormValue(`doc`))
+	format := aRequest.FormValue(`format`)
+	days, _ := strconv.Atoi(aRequest.FormValue(`days`))
+	if 0 >= days {
+		days = 1
+	}
+	limit, _ := strconv.Atoi(aRequest.FormValue(`max`))
+
+	lib := db.ContextLibrary(aRequest.Context())
+	dbHandle, err := lib.Open(aRequest.Context())
+	if nil != err {
+		return err
+	}
+	defer dbHandle.Close()
+
+	doc := dbHandle.QueryDocMini(aRequest.Context(), db.TID(docID))
+	if (nil == doc) || (0 == len(doc.Filename(format))) {
+		return errors.New(`unknown document`)
+	}
+	libName := ``
+	if !lib.IsDefault() {
+		libName = lib.Name()
+	}
+	link, err := ph.shares.Add(aUser, libName, docID, format,
+		time.Now().AddDate(0, 0, days), limit)
+	if nil != err {
+		return err
+	}
+	aPageData.Set("Document", doc).
+		Set("Link", shareURL(aRequest, *link))
+
+	return nil
+} // createShare()
+
+// `serveShare()` sends the file of a share link to the remote user.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aTail` The URL part following `/share/`.
+func (ph *TPageHandler) serveShare(aWriter http.ResponseWriter, aRequest *http.Request, aTail string) {
+	parts := strings.SplitN(aTail, `/`, 3)
+	if (nil == ph.shares) || (2 > len(parts)) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+
+	// The link is counted only after its file was found:
+	link, err := ph.shares.Check(serverKey(), parts[0], parts[1], false)
+	if nil != err {
+		apachelogger.Err("TPageHandler.serveShare()", fmt.Sprintf("%v", err))
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+
+	// The link is valid only on a site serving the document's library:
+	libName := link.Library
+	if 0 == len(libName) {
+		libName = db.DefaultLibrary().Name()
+	}
+	lib := ph.site.libraryNamed(libName)
+	if nil == lib {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	dbHandle, err := lib.Open(aRequest.Context())
+	if nil != err {
+		handleInternalError(aWriter, `TPageHandler.serveShare()`,
+			fmt.Sprintf("TLibrary.Open(): %v", err))
+		return
+	}
+	defer dbHandle.Close()
+
+	doc := dbHandle.QueryDocMini(aRequest.Context(), db.TID(link.DocID))
+	if nil == doc {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	file := doc.Filename(link.Format)
+	if 0 == len(file) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	if fi, err := os.Stat(filepath.Join(lib.Path(), file)); (nil != err) || !fi.Mode().IsRegular() {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+
+	// Every `GET` request is counted as a download (including the
+	// continuation of an interrupted one), `HEAD` requests are not:
+	if `GET` == aRequest.Method {
+		if _, err = ph.shares.Check(serverKey(), parts[0], parts[1], true); nil != err {
+			apachelogger.Err("TPageHandler.serveShare()", fmt.Sprintf("%v", err))
+			http.NotFound(aWriter, aRequest)
+			return
+		}
+	}
+	aWriter.Header().Set(`Cache-Control`, `no-store`)
+	aWriter.Header().Set(`Last-Modified`, doc.LastModified())
+	aRequest.URL.Path = file
+	ph.docServer(lib).ServeHTTP(aWriter, aRequest)
+} // serveShare()
+
+/* _EoF_ */
@@ -0,0 +1,250 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the metadata of the thumbnails, i.e. the
+ * BlurHash and dominant colour of each document's cover, computed
+ * when the thumbnail is generated.
+ *
+ * The data is kept in the text file `thumbinfo.txt` in the library's
+ * cache directory; each line holds the tab separated fields
+ *
+ *	docID	colour	BlurHash
+ *
+ * New data is appended to the file and later lines replace earlier
+ * ones; the file is compacted when it's read.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mwat56/apachelogger"
+	"github.com/mwat56/kaliber/db"
+)
+
+const (
+	// Name of the thumbnails' metadata file in the cache directory.
+	tiFilename = `thumbinfo.txt`
+)
+
+type (
+	// TThumbInfo holds the placeholder data of a document's thumbnail.
+	TThumbInfo struct {
+		BlurHash string // the cover's BlurHash
+		Color    string // the cover's dominant colour (`#rrggbb`)
+	}
+
+	// `tThumbInfoStore` holds the thumbnails' metadata of a library.
+	tThumbInfoStore struct {
+		filename string                // name of the data file
+		infos    map[db.TID]TThumbInfo // metadata by document ID
+		lines    int                   // number of lines in the data file
+		loaded   bool                  // flag whether the file was read
+		mtx      sync.Mutex
+	}
+)
+
+var (
+	// The thumbnails' metadata stores by library name.
+	tiStores = struct {
+		sync.Mutex
+		list map[string]*tThumbInfoStore
+	}{list: make(map[string]*tThumbInfoStore)}
+)
+
+// `newThumbInfo()` returns the placeholder data of `aImage`.
+//
+//	`aImage` The thumbnail (or cover) image to use.
+func newThumbInfo(aImage image.Image) TThumbInfo {
+	sample := scaleImage(aImage, bhSampleWidth)
+
+	return TThumbInfo{
+		BlurHash: blurHash(sample, bhXComponents, bhYComponents),
+		Color:    dominantColor(sample),
+	}
+} // newThumbInfo()
+
+// `newThumbInfoStore()` returns a store using `aFilename`.
+//
+//	`aFilename` The name of the data file.
+func newThumbInfoStore(aFilename string) *tThumbInfoStore {
+	return &tThumbInfoStore{
+		filename: aFilename,
+		infos:    make(map[db.TID]TThumbInfo, 1024),
+	}
+} // newThumbInfoStore()
+
+// `thumbInfoStore()` returns the thumbnails' metadata store of
+// `aLibrary`.
+//
+//	`aLibrary` The library whose store to return.
+func thumbInfoStore(aLibrary *db.TLibrary) *tThumbInfoStore {
+	tiStores.Lock()
+	defer tiStores.Unlock()
+
+	if store, ok := tiStores.list[aLibrary.Name()]; ok {
+		return store
+	}
+	store := newThumbInfoStore(filepath.Join(aLibrary.CachePath(), tiFilename))
+	tiStores.list[aLibrary.Name()] = store
+
+	return store
+} // thumbInfoStore()
+
+// `get()` returns the metadata of the document `aID`.
+//
+//	`aID` The ID of the document.
+func (ts *tThumbInfoStore) get(aID db.TID) (TThumbInfo, bool) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	ts.load()
+
+	info, ok := ts.infos[aID]
+
+	return info, ok
+} // get()
+
+// `load()` reads the data file (once).
+//
+// NOTE: The caller must hold the store's lock.
+func (ts *tThumbInfoStore) load() {
+	if ts.loaded {
+		return
+	}
+	ts.loaded = true
+
+	file, err := os.Open(ts.filename) // #nosec G304
+	if nil != err {
+		return // no thumbnails yet
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		ts.lines++
+		fields := strings.Split(scanner.Text(), "\t")
+		if 3 != len(fields) {
+			continue
+		}
+		id, err := strconv.Atoi(fields[0])
+		if nil != err {
+			continue
+		}
+		ts.infos[id] = TThumbInfo{BlurHash: fields[2], Color: fields[1]}
+	}
+	_ = file.Close()
+
+	if ts.lines > 2*len(ts.infos) {
+		ts.save()
+	}
+} // load()
+
+// `save()` writes all metadata to the data file.
+//
+// NOTE: The caller must hold the store's lock.
+func (ts *tThumbInfoStore) save() {
+	ids := make([]int, 0, len(ts.infos))
+	for id := range ts.infos {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var data strings.Builder
+	for _, id := range ids {
+		info := ts.infos[id]
+		fmt.Fprintf(&data, "%d\t%s\t%s\n", id, info.Color, info.BlurHash)
+	}
+
+	tName := ts.filename + `~`
+	err := os.WriteFile(tName, []byte(data.String()), 0640)
+	if nil == err {
+		err = os.Rename(tName, ts.filename)
+	}
+	if nil != err {
+		msg := fmt.Sprintf("os.WriteFile(%s): %v", ts.filename, err)
+		apachelogger.Err("tThumbInfoStore.save()", msg)
+		return
+	}
+	ts.lines = len(ids)
+} // save()
+
+// `set()` stores the metadata `aInfo` of the document `aID`.
+//
+//	`aID` The ID of the document.
+//	`aInfo` The document's thumbnail metadata.
+func (ts *tThumbInfoStore) set(aID db.TID, aInfo TThumbInfo) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	ts.load()
+
+	if info, ok := ts.infos[aID]; ok && (info == aInfo) {
+		return
+	}
+	ts.infos[aID] = aInfo
+
+	file, err := os.OpenFile(ts.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640) // #nosec G302 G304
+	if nil == err {
+		_, err = fmt.Fprintf(file, "%d\t%s\t%s\n", aID, aInfo.Color, aInfo.BlurHash)
+		if cErr := file.Close(); nil == err {
+			err = cErr
+		}
+	}
+	if nil != err {
+		msg := fmt.Sprintf("os.OpenFile(%s): %v", ts.filename, err)
+		apachelogger.Err("tThumbInfoStore.set()", msg)
+		return
+	}
+	ts.lines++
+} // set()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `thumbInfo()` returns the thumbnail metadata of `aDoc` or `nil`
+// if it's not known yet.
+//
+//	`aDoc` The document whose metadata to return.
+func thumbInfo(aDoc *db.TDocument) *TThumbInfo {
+	if nil == aDoc {
+		return nil
+	}
+	if info, ok := thumbInfoStore(aDoc.Library()).get(aDoc.ID); ok {
+		return &info
+	}
+
+	return nil
+} // thumbInfo()
+
+// `thumbInfoFile()` computes the metadata of `aDoc` from its existing
+// thumbnail `aFilename` if it's not known yet.
+//
+//	`aDoc` The document whose metadata to check.
+//	`aFilename` The name of the document's thumbnail file.
+func thumbInfoFile(aDoc *db.TDocument, aFilename string) {
+	store := thumbInfoStore(aDoc.Library())
+	if _, ok := store.get(aDoc.ID); ok {
+		return
+	}
+	file, err := os.Open(aFilename) // #nosec G304
+	if nil != err {
+		return
+	}
+	defer file.Close()
+
+	if img, _, err := image.Decode(file); nil == err {
+		store.set(aDoc.ID, newThumbInfo(img))
+	}
+} // thumbInfoFile()
+
+/* _EoF_ */
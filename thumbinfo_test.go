@@ -0,0 +1,79 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_newThumbInfo(t *testing.T) {
+	got := newThumbInfo(prepImage(320, 480, color.RGBA{0xFF, 0, 0, 0xFF}))
+	if `#ff0000` != got.Color {
+		t.Errorf("newThumbInfo().Color = %q, want %q", got.Color, `#ff0000`)
+	}
+	// 3×4 components with a red average colour:
+	if (28 != len(got.BlurHash)) || !strings.HasPrefix(got.BlurHash, `T`) ||
+		(`TI:j` != got.BlurHash[2:6]) {
+		t.Errorf("newThumbInfo().BlurHash = %q, want a red 3×4 hash", got.BlurHash)
+	}
+} // Test_newThumbInfo()
+
+func TestTThumbInfoStore(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), tiFilename)
+	info1 := TThumbInfo{BlurHash: `00TI:j`, Color: `#ff0000`}
+	info2 := TThumbInfo{BlurHash: `0000fQ`, Color: `#ffffff`}
+
+	ts := newThumbInfoStore(fName)
+	if _, ok := ts.get(1); ok {
+		t.Error("get(1) found data in an empty store")
+	}
+	ts.set(1, info1)
+	ts.set(2, info1)
+	ts.set(2, info1) // unchanged, hence not written again
+	ts.set(2, info2)
+	if got, ok := ts.get(2); !ok || (got != info2) {
+		t.Errorf("get(2) = %+v, %v, want %+v", got, ok, info2)
+	}
+	data, err := os.ReadFile(fName)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); 3 != lines {
+		t.Errorf("data file has %d lines, want 3:\n%s", lines, data)
+	}
+
+	// Later lines replace earlier ones when the file is read again:
+	ts = newThumbInfoStore(fName)
+	if got, ok := ts.get(1); !ok || (got != info1) {
+		t.Errorf("get(1) = %+v, %v, want %+v", got, ok, info1)
+	}
+	if got, ok := ts.get(2); !ok || (got != info2) {
+		t.Errorf("get(2) = %+v, %v, want %+v", got, ok, info2)
+	}
+
+	// A file with many outdated lines is compacted:
+	for idx := 0; idx < 4; idx++ {
+		ts.set(1, info2)
+		ts.set(1, info1)
+	}
+	ts = newThumbInfoStore(fName)
+	if got, ok := ts.get(1); !ok || (got != info1) {
+		t.Errorf("get(1) = %+v, %v, want %+v", got, ok, info1)
+	}
+	data, _ = os.ReadFile(fName)
+	if want := "1\t#ff0000\t00TI:j\n2\t#ffffff\t0000fQ\n"; string(data) != want {
+		t.Errorf("compacted data file = %q, want %q", data, want)
+	}
+} // TestTThumbInfoStore()
+
+/* _EoF_ */
@@ -0,0 +1,414 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the thumbnail jobs: a bounded pool of workers
+ * generating the thumbnails (and the other scaled images, i.e. cover
+ * renditions and comic pages), coalescing the requests for the same
+ * file, and preferring the images requested by a page over the
+ * background backfill of a library.
+ */
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mwat56/apachelogger"
+	"github.com/mwat56/kaliber/db"
+)
+
+const (
+	// Priority of thumbnails needed by a page request.
+	thPrioRequest = 1
+
+	// Priority of thumbnails generated in the background.
+	thPrioBackfill = 0
+
+	// Max. number of errors kept for the admin page.
+	thMaxErrors = 20
+
+	// Max. number of workers generating thumbnails.
+	thMaxWorkers = 64
+)
+
+type (
+	// `tThumbJob` is the generation of a single thumbnail.
+	tThumbJob struct {
+		doc      *db.TDocument          // the document whose thumbnail to make
+		key      string                 // the generated file's name
+		prio     int                    // the job's priority
+		seq      uint64                 // the job's sequence number
+		index    int                    // the job's index in the queue
+		backfill *TThumbBackfill        // the backfill the job belongs to
+		done     chan struct{}          // closed when the job is finished
+		name     string                 // the generated thumbnail's filename
+		err      error                  // the error of the generation
+		make     func() (string, error) // the file's generation (`nil`: thumbnail)
+	}
+
+	// `tThumbQueue` is the priority queue of the waiting jobs
+	// (implementing `heap.Interface`).
+	tThumbQueue []*tThumbJob
+
+	// TThumbBackfill is the progress of a library's thumbnail update.
+	TThumbBackfill struct {
+		Library  string    // the library's name
+		Total    int       // number of thumbnails to check
+		Done     int       // number of thumbnails checked
+		Failed   int       // number of failed thumbnails
+		Started  time.Time // time the update started
+		Finished time.Time // time the update finished (or zero)
+	}
+
+	// TThumbError is a failed thumbnail generation.
+	TThumbError struct {
+		Library string    // the document's library
+		ID      db.TID    // the document's ID
+		Time    time.Time // time of the failure
+		Err     string    // the error message
+	}
+
+	// TThumbStats is a snapshot of the thumbnail jobs' state.
+	TThumbStats struct {
+		Workers   int              // number of workers
+		Queued    int              // number of waiting jobs
+		Running   int              // number of jobs being processed
+		Done      uint64           // number of jobs finished successfully
+		Failed    uint64           // number of failed jobs
+		Coalesced uint64           // number of requests joining a pending job
+		Backfills []TThumbBackfill // the libraries' background updates
+		Errors    []TThumbError    // the latest errors (newest first)
+	}
+
+	// `tThumbPool` is the pool of workers processing the jobs.
+	tThumbPool struct {
+		mtx       sync.Mutex
+		cond      *sync.Cond
+		backfills []*TThumbBackfill
+		coalesced uint64
+		done      uint64
+		errors    []TThumbError
+		failed    uint64
+		jobs      map[string]*tThumbJob // pending jobs by thumbnail name
+		queue     tThumbQueue
+		running   int
+		seq       uint64
+		started   bool
+		workers   int
+		work      func(*db.TDocument) (string, error)
+	}
+)
+
+var (
+	// The number of workers generating thumbnails.
+	thWorkers = 2
+
+	// The pool processing the thumbnail jobs.
+	thPool = newThumbPool(Thumbnail)
+)
+
+// Len returns the number of waiting jobs.
+func (tq tThumbQueue) Len() int {
+	return len(tq)
+} // Len()
+
+// Less returns whether the job at `i` is to be processed before
+// the job at `j`.
+//
+//	`i` The index of the first job.
+//	`j` The index of the second job.
+func (tq tThumbQueue) Less(i, j int) bool {
+	if tq[i].prio != tq[j].prio {
+		return tq[i].prio > tq[j].prio
+	}
+
+	return tq[i].seq < tq[j].seq
+} // Less()
+
+// Pop removes the last job.
+func (tq *tThumbQueue) Pop() any {
+	old := *tq
+	last := len(old) - 1
+	job := old[last]
+	old[last] = nil
+	job.index = -1
+	*tq = old[:last]
+
+	return job
+} // Pop()
+
+// Push appends `aJob`.
+//
+//	`aJob` The job to append.
+func (tq *tThumbQueue) Push(aJob any) {
+	job := aJob.(*tThumbJob)
+	job.index = len(*tq)
+	*tq = append(*tq, job)
+} // Push()
+
+// Swap exchanges the jobs at `i` and `j`.
+//
+//	`i` The index of the first job.
+//	`j` The index of the second job.
+func (tq tThumbQueue) Swap(i, j int) {
+	tq[i], tq[j] = tq[j], tq[i]
+	tq[i].index = i
+	tq[j].index = j
+} // Swap()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `newThumbPool()` returns a new pool using `aWork` to generate the
+// thumbnails.
+//
+//	`aWork` The function generating a document's thumbnail.
+func newThumbPool(aWork func(*db.TDocument) (string, error)) *tThumbPool {
+	result := &tThumbPool{
+		jobs: make(map[string]*tThumbJob),
+		work: aWork,
+	}
+	result.cond = sync.NewCond(&result.mtx)
+
+	return result
+} // newThumbPool()
+
+// `backfill()` registers the background update of `aLibrary` with
+// `aTotal` thumbnails to check.
+//
+//	`aLibrary` The library whose thumbnails get updated.
+//	`aTotal` The number of thumbnails to check.
+func (tp *tThumbPool) backfill(aLibrary *db.TLibrary, aTotal int) *TThumbBackfill {
+	result := &TThumbBackfill{
+		Library: aLibrary.Name(),
+		Total:   aTotal,
+		Started: time.Now(),
+	}
+	if 0 >= aTotal {
+		result.Finished = result.Started
+	}
+	tp.mtx.Lock()
+	defer tp.mtx.Unlock()
+	for idx, bf := range tp.backfills {
+		if bf.Library == result.Library {
+			tp.backfills[idx] = result
+			return result
+		}
+	}
+	tp.backfills = append(tp.backfills, result)
+
+	return result
+} // backfill()
+
+// `finish()` records the result of `aJob` and wakes up its waiters.
+//
+//	`aJob` The job processed.
+func (tp *tThumbPool) finish(aJob *tThumbJob) {
+	tp.mtx.Lock()
+	defer tp.mtx.Unlock()
+
+	tp.running--
+	delete(tp.jobs, aJob.key)
+	if nil == aJob.err {
+		tp.done++
+	} else {
+		tp.failed++
+		tp.errors = append([]TThumbError{{
+			Library: aJob.doc.Library().Name(),
+			ID:      aJob.doc.ID,
+			Time:    time.Now(),
+			Err:     aJob.err.Error(),
+		}}, tp.errors...)
+		if thMaxErrors < len(tp.errors) {
+			tp.errors = tp.errors[:thMaxErrors]
+		}
+	}
+	if bf := aJob.backfill; nil != bf {
+		bf.Done++
+		if nil != aJob.err {
+			bf.Failed++
+		}
+		if bf.Done >= bf.Total {
+			bf.Finished = time.Now()
+		}
+	}
+	close(aJob.done)
+} // finish()
+
+// `run()` processes the queued jobs (until the program terminates).
+func (tp *tThumbPool) run() {
+	for {
+		tp.mtx.Lock()
+		for 0 == len(tp.queue) {
+			tp.cond.Wait()
+		}
+		job := heap.Pop(&tp.queue).(*tThumbJob)
+		tp.running++
+		tp.mtx.Unlock()
+
+		msg := fmt.Sprintf("Thumbnail(%d)", job.doc.ID)
+		if nil == job.make {
+			job.name, job.err = tp.work(job.doc)
+		} else {
+			msg = job.key
+			job.name, job.err = job.make()
+		}
+		if nil != job.err {
+			msg = fmt.Sprintf("%s: %v", msg, job.err)
+			apachelogger.Err("tThumbPool.run()", msg)
+		}
+		tp.finish(job)
+	}
+} // run()
+
+// `stats()` returns a snapshot of the pool's state.
+func (tp *tThumbPool) stats() TThumbStats {
+	tp.mtx.Lock()
+	defer tp.mtx.Unlock()
+
+	result := TThumbStats{
+		Workers:   tp.workers,
+		Queued:    len(tp.queue),
+		Running:   tp.running,
+		Done:      tp.done,
+		Failed:    tp.failed,
+		Coalesced: tp.coalesced,
+		Errors:    append([]TThumbError(nil), tp.errors...),
+	}
+	for _, bf := range tp.backfills {
+		result.Backfills = append(result.Backfills, *bf)
+	}
+
+	return result
+} // stats()
+
+// `submit()` queues the generation of the thumbnail of `aDoc` with
+// `aPrio` returning the (possibly pending) job.
+//
+// If there's already a job for that thumbnail it's returned instead,
+// with its priority raised to `aPrio` if necessary.
+//
+//	`aDoc` The document whose thumbnail to generate.
+//	`aPrio` The job's priority.
+//	`aBackfill` The background update the job belongs to (or `nil`).
+func (tp *tThumbPool) submit(aDoc *db.TDocument, aPrio int, aBackfill *TThumbBackfill) *tThumbJob {
+	return tp.submitJob(aDoc, thumbnailName(aDoc), aPrio, aBackfill, nil)
+} // submit()
+
+// `submitJob()` queues the generation of the file `aKey` of `aDoc`
+// by `aMake` with `aPrio` returning the (possibly pending) job.
+//
+// If there's already a job for that file it's returned instead,
+// with its priority raised to `aPrio` if necessary.
+//
+//	`aDoc` The document whose image to generate.
+//	`aKey` The name of the file to generate.
+//	`aPrio` The job's priority.
+//	`aBackfill` The background update the job belongs to (or `nil`).
+//	`aMake` The function generating the file (`nil` for the thumbnail).
+func (tp *tThumbPool) submitJob(aDoc *db.TDocument, aKey string, aPrio int, aBackfill *TThumbBackfill, aMake func() (string, error)) *tThumbJob {
+	tp.mtx.Lock()
+	defer tp.mtx.Unlock()
+
+	if !tp.started {
+		tp.started = true
+		tp.workers = thWorkers
+		for n := 0; n < tp.workers; n++ {
+			go tp.run()
+		}
+	}
+
+	if job, ok := tp.jobs[aKey]; ok {
+		tp.coalesced++
+		if (aPrio > job.prio) && (0 <= job.index) {
+			job.prio = aPrio
+			heap.Fix(&tp.queue, job.index)
+		}
+		if (nil != aBackfill) && (nil == job.backfill) {
+			job.backfill = aBackfill
+		} else if nil != aBackfill {
+			// the job is already counted by another update
+			aBackfill.Done++
+			if aBackfill.Done >= aBackfill.Total {
+				aBackfill.Finished = time.Now()
+			}
+		}
+		return job
+	}
+
+	tp.seq++
+	job := &tThumbJob{
+		doc:      aDoc,
+		key:      aKey,
+		prio:     aPrio,
+		seq:      tp.seq,
+		backfill: aBackfill,
+		done:     make(chan struct{}),
+		make:     aMake,
+	}
+	tp.jobs[aKey] = job
+	heap.Push(&tp.queue, job)
+	tp.cond.Signal()
+
+	return job
+} // submitJob()
+
+// `wait()` waits for `aJob` to finish or `aContext` to be cancelled.
+//
+//	`aContext` The context of the waiting request.
+//	`aJob` The job to wait for.
+func (tp *tThumbPool) wait(aContext context.Context, aJob *tThumbJob) (string, error) {
+	select {
+	case <-aJob.done:
+		return aJob.name, aJob.err
+	case <-aContext.Done():
+		// the job is finished anyway for the next request
+		return "", aContext.Err()
+	}
+} // wait()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// SetThumbWorkers sets the number of workers generating thumbnails.
+//
+// The value is used when the first thumbnail job is queued; values
+// outside the range `1` to `64` are moved into that range.
+//
+//	`aWorkers` The number of workers to use.
+func SetThumbWorkers(aWorkers int) int {
+	if 1 > aWorkers {
+		aWorkers = 1
+	} else if thMaxWorkers < aWorkers {
+		aWorkers = thMaxWorkers
+	}
+	thWorkers = aWorkers
+
+	return thWorkers
+} // SetThumbWorkers()
+
+// ThumbnailRequest returns the name of the thumbnail of `aDoc`,
+// generating it with a high priority if necessary.
+//
+// Concurrent requests for the same thumbnail share a single job.
+//
+//	`aContext` The context of the page request.
+//	`aDoc` The document whose thumbnail is needed.
+func ThumbnailRequest(aContext context.Context, aDoc *db.TDocument) (string, error) {
+	return thPool.wait(aContext, thPool.submit(aDoc, thPrioRequest, nil))
+} // ThumbnailRequest()
+
+// ThumbnailStats returns a snapshot of the thumbnail jobs' state.
+func ThumbnailStats() TThumbStats {
+	return thPool.stats()
+} // ThumbnailStats()
+
+/* _EoF_ */
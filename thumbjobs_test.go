@@ -0,0 +1,171 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mwat56/kaliber/db"
+)
+
+func TestSetThumbWorkers(t *testing.T) {
+	saved := thWorkers
+	defer func() { thWorkers = saved }()
+
+	tests := []struct {
+		name     string
+		aWorkers int
+		want     int
+	}{
+		// TODO: Add test cases.
+		{" 1", 4, 4},
+		{" 2", 0, 1},
+		{" 3", -3, 1},
+		{" 4", 1000, thMaxWorkers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SetThumbWorkers(tt.aWorkers); got != tt.want {
+				t.Errorf("SetThumbWorkers() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+} // TestSetThumbWorkers()
+
+func Test_tThumbQueue(t *testing.T) {
+	var queue tThumbQueue
+	heap.Push(&queue, &tThumbJob{key: `b1`, prio: thPrioBackfill, seq: 1})
+	heap.Push(&queue, &tThumbJob{key: `b2`, prio: thPrioBackfill, seq: 2})
+	heap.Push(&queue, &tThumbJob{key: `r3`, prio: thPrioRequest, seq: 3})
+	b4 := &tThumbJob{key: `b4`, prio: thPrioBackfill, seq: 4}
+	heap.Push(&queue, b4)
+	heap.Push(&queue, &tThumbJob{key: `r5`, prio: thPrioRequest, seq: 5})
+
+	// raise the priority of a waiting job:
+	b4.prio = thPrioRequest
+	heap.Fix(&queue, b4.index)
+
+	var got []string
+	for 0 < queue.Len() {
+		got = append(got, heap.Pop(&queue).(*tThumbJob).key)
+	}
+	want := []string{`r3`, `b4`, `r5`, `b1`, `b2`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tThumbQueue order = %v, want %v", got, want)
+	}
+} // Test_tThumbQueue()
+
+func TestTThumbPool(t *testing.T) {
+	saved := thWorkers
+	defer func() { thWorkers = saved }()
+	thWorkers = 1
+
+	var (
+		mtx   sync.Mutex
+		calls []db.TID
+	)
+	release := make(chan struct{})
+	pool := newThumbPool(func(aDoc *db.TDocument) (string, error) {
+		<-release
+		mtx.Lock()
+		calls = append(calls, aDoc.ID)
+		mtx.Unlock()
+		if 4 == aDoc.ID {
+			return "", errors.New("no cover")
+		}
+		return thumbnailName(aDoc), nil
+	})
+
+	// Block the only worker with the first job:
+	first := pool.submit(&db.TDocument{ID: 1}, thPrioBackfill, nil)
+	for 1 != pool.stats().Running {
+		time.Sleep(time.Millisecond)
+	}
+	bf := pool.backfill(db.DefaultLibrary(), 2)
+	pool.submit(&db.TDocument{ID: 2}, thPrioBackfill, bf)
+	pool.submit(&db.TDocument{ID: 3}, thPrioBackfill, bf)
+
+	// Concurrent requests for the same thumbnail share a job:
+	var wg sync.WaitGroup
+	names := make([]string, 5)
+	for idx := range names {
+		wg.Add(1)
+		go func(aIdx int) {
+			defer wg.Done()
+			names[aIdx], _ = pool.wait(context.Background(),
+				pool.submit(&db.TDocument{ID: 4}, thPrioRequest, nil))
+		}(idx)
+	}
+	for 4 != pool.stats().Coalesced {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	<-first.done
+	for {
+		if st := pool.stats(); (0 == st.Queued) && (0 == st.Running) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	// the request (4) goes before the backfill (2, 3):
+	if want := []db.TID{1, 4, 2, 3}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("work order = %v, want %v", calls, want)
+	}
+	st := pool.stats()
+	if (1 != st.Failed) || (1 != len(st.Errors)) || (4 != st.Errors[0].ID) {
+		t.Errorf("stats() = %+v, want one failure of ID 4", st)
+	}
+	if 4 != st.Coalesced {
+		t.Errorf("stats().Coalesced = %d, want 4", st.Coalesced)
+	}
+	if (1 != len(st.Backfills)) || (2 != st.Backfills[0].Done) || st.Backfills[0].Finished.IsZero() {
+		t.Errorf("stats().Backfills = %+v, want 2 done and finished", st.Backfills)
+	}
+} // TestTThumbPool()
+
+func TestTThumbPool_submitJob(t *testing.T) {
+	saved := thWorkers
+	defer func() { thWorkers = saved }()
+	thWorkers = 1
+
+	pool := newThumbPool(func(aDoc *db.TDocument) (string, error) {
+		return thumbnailName(aDoc), nil
+	})
+	release := make(chan struct{})
+	var calls int
+	gen := func() (string, error) {
+		<-release
+		calls++
+		return `/tmp/w160.jpg`, nil
+	}
+	doc := &db.TDocument{ID: 5}
+	first := pool.submitJob(doc, `/tmp/w160.jpg`, thPrioRequest, nil, gen)
+	second := pool.submitJob(doc, `/tmp/w160.jpg`, thPrioRequest, nil, gen)
+	if first != second {
+		t.Errorf("submitJob() didn't share the pending job")
+	}
+	close(release)
+	if got, err := pool.wait(context.Background(), second); (nil != err) || (`/tmp/w160.jpg` != got) {
+		t.Errorf("wait() = %q, %v, want %q", got, err, `/tmp/w160.jpg`)
+	}
+	if 1 != calls {
+		t.Errorf("submitJob() generated the file %d times, want 1", calls)
+	}
+} // TestTThumbPool_submitJob()
+
+/* _EoF_ */
@@ -12,7 +12,9 @@ import (
 	"context"
 	"fmt"
 	"image"
+	_ "image/gif" // covers extracted from book files
 	"image/jpeg"
+	_ "image/png" // covers extracted from book files
 	"os"
 	"path"
 	"path/filepath"
@@ -21,18 +23,22 @@ import (
 
 	"github.com/mwat56/apachelogger"
 	"github.com/mwat56/kaliber/db"
-	"github.com/nfnt/resize"
 )
 
 /*
  * This file provides functions for thumbnail generation and maintenance.
  */
 
-// `goThumbCleanup()` removes orphaned thumbnails.
+// `goThumbCleanup()` removes orphaned thumbnails of `aLibrary`.
 //
-//	`aDB` The DB handle to access the `Calibre` database.
-func goThumbCleanup(aDB *db.TDataBase) {
-	bd := db.CalibreCachePath()
+// The database handle is closed when done.
+//
+//	`aLibrary` The library whose thumbnails to check.
+//	`aDB` The DB handle to access the library's database.
+func goThumbCleanup(aLibrary *db.TLibrary, aDB *db.TDataBase) {
+	defer aDB.Close()
+
+	bd := aLibrary.CachePath()
 	dirNames, err := filepath.Glob(bd + "/*")
 	if nil != err {
 		msg := fmt.Sprintf("filepath.Glob(%s): %v", bd, err)
@@ -40,6 +46,9 @@ func goThumbCleanup(aDB *db.TDataBase) {
 		return
 	}
 	for _, numDir := range dirNames {
+		if _, err = strconv.Atoi(filepath.Base(numDir)); nil != err {
+			continue // not a thumbnail directory (e.g. renditions)
+		}
 		checkThumbBase(numDir, aDB)
 	}
 
@@ -93,9 +102,10 @@ func checkThumbFile(aFilename string, aDB *db.TDataBase) {
 		return
 	}
 
-	doc := aDB.QueryDocument(context.Background(), docID)
+	doc := aDB.QueryDocMini(context.Background(), docID)
 	if nil == doc {
 		// remove thumbnail for non-existing document
+		imgCache.forget(aFilename)
 		if err = os.Remove(aFilename); nil != err {
 			msg = fmt.Sprintf("os.Remove(%s): %v", aFilename, err)
 			apachelogger.Err("checkThumbFile()", msg)
@@ -103,9 +113,9 @@ func checkThumbFile(aFilename string, aDB *db.TDataBase) {
 		return
 	}
 
-	cFile, err := doc.CoverFile()
+	cFile, err := coverSource(doc)
 	if nil != err {
-		msg = fmt.Sprintf("doc.CoverFile(%d): %v", docID, err)
+		msg = fmt.Sprintf("coverSource(%d): %v", docID, err)
 		apachelogger.Err("checkThumbFile()", msg)
 		return
 	}
@@ -125,15 +135,8 @@ func checkThumbFile(aFilename string, aDB *db.TDataBase) {
 	}
 
 	if tFI.ModTime().Before(cFI.ModTime()) {
-		// remove outdated thumbnail
-		if err = os.Remove(aFilename); nil != err {
-			msg = fmt.Sprintf("os.Remove(%s): %v", aFilename, err)
-			apachelogger.Err("checkThumbFile()", msg)
-		}
-		if err = makeThumbnail(cFile, aFilename); nil != err {
-			msg = fmt.Sprintf("makeThumbnail(%s): %v", aFilename, err)
-			apachelogger.Err("checkThumbFile()", msg)
-		}
+		// let the workers replace the outdated thumbnail
+		thPool.submit(doc, thPrioBackfill, nil)
 	}
 } // checkThumbFile()
 
@@ -151,40 +154,33 @@ func makeThumbDir(aDoc *db.TDocument) error {
 } // makeThumbDir()
 
 // `makeThumbnail()` generates a thumbnail for `aSrcName` and stores it
-// in `aDstName`.
+// in `aDstName`; it returns the thumbnail image.
 //
 //	`aSrcName` The filename of a document's cover image.
 //	`aDstName` The name of the generated thumbnail file.
-func makeThumbnail(aSrcName, aDstName string) error {
+func makeThumbnail(aSrcName, aDstName string) (image.Image, error) {
 	var (
-		sImg         image.Image
-		err          error
-		dFile, sFile *os.File
+		sImg  image.Image
+		err   error
+		sFile *os.File
 	)
 
 	if sFile, err = os.OpenFile(aSrcName, os.O_RDONLY, 0); /* #nosec G304 */ nil != err {
-		return err
+		return nil, err
 	}
 	defer sFile.Close()
 
 	if sImg, _, err = image.Decode(sFile); nil != err {
-		return err
+		return nil, err
 	}
 	_ = sFile.Close()
 
-	dImg := makeThumbPrim(sImg)
-	if dFile, err = os.OpenFile(aDstName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0640); /* #nosec G302 */ nil != err {
-		return err
+	tImg := makeThumbPrim(sImg)
+	if err = saveJPEG(tImg, aDstName, 100); nil != err {
+		return nil, err
 	}
-	defer func() {
-		_ = dFile.Close()
-		if nil != err {
-			_ = os.Remove(aDstName)
-		}
-	}()
-	err = jpeg.Encode(dFile, dImg, &jpeg.Options{Quality: 100})
 
-	return err
+	return tImg, nil
 } // makeThumbnail()
 
 var (
@@ -198,23 +194,49 @@ var (
 // It will return original image, without processing it, if original sizes
 // are already smaller than provided constraints.
 func makeThumbPrim(img image.Image) image.Image {
-	origBounds := img.Bounds()
-	origWidth, origHeight := uint(origBounds.Dx()), uint(origBounds.Dy())
-	newWidth, newHeight := origWidth, origHeight
-
-	// Preserve aspect ratio
-	if origWidth > thThumbwidth {
-		newHeight = origHeight * thThumbwidth / origWidth
-		if newHeight < 1 {
-			newHeight = 1
+	return scaleImage(img, thThumbwidth)
+} // makeThumbPrim()
+
+// `saveJPEG()` stores `aImage` with `aQuality` in `aDstName`.
+//
+// The image is written to a temporary file first so that concurrent
+// requests never see an incomplete image.
+//
+//	`aImage` The image to store.
+//	`aDstName` The name of the JPEG file to write.
+//	`aQuality` The JPEG quality to use.
+func saveJPEG(aImage image.Image, aDstName string, aQuality int) (rErr error) {
+	dFile, err := os.CreateTemp(filepath.Dir(aDstName), `.jpeg-*`)
+	if nil != err {
+		return err
+	}
+	tName := dFile.Name()
+	defer func() {
+		if nil != rErr {
+			_ = os.Remove(tName)
 		}
-		newWidth = thThumbwidth
+	}()
+
+	err = jpeg.Encode(dFile, aImage, &jpeg.Options{Quality: aQuality})
+	if cErr := dFile.Close(); nil == err {
+		err = cErr
+	}
+	if nil != err {
+		return err
+	}
+	if err = os.Chmod(tName, 0640); nil != err {
+		return err
 	}
 
-	return resize.Resize(newWidth, newHeight, img, resize.Bilinear)
-} // makeThumbPrim()
+	return os.Rename(tName, aDstName)
+} // saveJPEG()
 
-// Thumbnail generates a thumbnail of the document's cover.
+// Thumbnail generates a thumbnail of the document's cover along with
+// the cover's BlurHash and dominant colour.
+//
+// It should only be called by the thumbnail workers (or by
+// `ThumbnailRequest()`) to avoid concurrent generations of the
+// same thumbnail.
 //
 //	`aDoc` The document to check the thumbnail for.
 func Thumbnail(aDoc *db.TDocument) (string, error) {
@@ -224,8 +246,8 @@ func Thumbnail(aDoc *db.TDocument) (string, error) {
 		dFI, sFI os.FileInfo
 	)
 
-	// Get the path/filename of the document's cover:
-	if sName, err = aDoc.CoverFile(); nil != err {
+	// Get the path/filename of the document's cover (or placeholder):
+	if sName, err = coverSource(aDoc); nil != err {
 		return "", err
 	}
 	if sFI, err = os.Stat(sName); nil != err {
@@ -239,15 +261,19 @@ func Thumbnail(aDoc *db.TDocument) (string, error) {
 	if dFI, err = os.Stat(dName); nil == err {
 		if dFI.ModTime().After(sFI.ModTime()) {
 			// dest file exists and is younger than the original cover file
+			thumbInfoFile(aDoc, dName)
 			return dName, nil
 		}
 	}
 	if err = makeThumbDir(aDoc); nil != err {
 		return "", err
 	}
-	if err = makeThumbnail(sName, dName); nil != err {
+	tImg, err := makeThumbnail(sName, dName)
+	if nil != err {
 		return "", err
 	}
+	imgCache.add(dName)
+	thumbInfoStore(aDoc.Library()).set(aDoc.ID, newThumbInfo(tImg))
 
 	return dName, nil
 } // Thumbnail()
@@ -258,7 +284,7 @@ func Thumbnail(aDoc *db.TDocument) (string, error) {
 func thumbnailName(aDoc *db.TDocument) string {
 	name := fmt.Sprintf("%06d", aDoc.ID)
 
-	return filepath.Join(db.CalibreCachePath(), name[:4], name+`.jpg`)
+	return filepath.Join(aDoc.Library().CachePath(), name[:4], name+`.jpg`)
 } // thumbnailName()
 
 // `thumbnailRemove()` deletes the thumbnail of `aDoc`.
@@ -268,6 +294,7 @@ func thumbnailName(aDoc *db.TDocument) string {
 //	`aDoc` The document to remove the thumbnail for.
 func thumbnailRemove(aDoc *db.TDocument) error {
 	fName := thumbnailName(aDoc)
+	imgCache.forget(fName)
 	err := os.Remove(fName)
 	if nil == err {
 		return nil
@@ -279,32 +306,64 @@ func thumbnailRemove(aDoc *db.TDocument) error {
 	return err
 } // thumbnailRemove()
 
-// ThumbnailUpdate creates thumbnails for all existing documents.
-func ThumbnailUpdate() {
+// `thumbnailsUpdate()` queues the thumbnails of all documents of
+// `aLibrary` for the thumbnail workers with a priority lower than
+// those requested by pages; it returns when all thumbnails are checked.
+//
+//	`aLibrary` The library whose thumbnails to update.
+//	`aDB` The DB handle to access the library's database.
+//	`aMissing` Flag whether to generate missing thumbnails as well.
+func thumbnailsUpdate(aLibrary *db.TLibrary, aDB *db.TDataBase, aMissing bool) {
+	docList, err := aDB.QueryIDs(context.Background())
+	if nil != err {
+		msg := fmt.Sprintf("TDataBase.QueryIDs(%s): %v", aLibrary.Name(), err)
+		apachelogger.Err("thumbnailsUpdate()", msg)
+		return
+	}
+	docs := make([]*db.TDocument, 0, len(*docList))
+	for idx := range *docList {
+		doc := &(*docList)[idx]
+		if !aMissing {
+			if _, err := os.Stat(thumbnailName(doc)); nil != err {
+				continue // generated on request
+			}
+		}
+		docs = append(docs, doc)
+	}
+
+	bf := thPool.backfill(aLibrary, len(docs))
+	jobs := make([]*tThumbJob, 0, len(docs))
+	for _, doc := range docs {
+		jobs = append(jobs, thPool.submit(doc, thPrioBackfill, bf))
+	}
+	for _, job := range jobs {
+		<-job.done
+	}
+} // thumbnailsUpdate()
+
+// ThumbnailUpdate creates thumbnails for all existing documents
+// of `aLibrary`.
+//
+// If the image cache has a size limit only the existing thumbnails
+// are updated while the missing ones are generated when requested.
+//
+//	`aLibrary` The library whose thumbnails to update.
+func ThumbnailUpdate(aLibrary *db.TLibrary) {
 	// Since this maintenance tasks does not depend on a certain
 	// page request we can use `context.Background()` here and
 	// open a separate DB connetion.
 	ctx := context.Background()
-	dbHandle, err := db.OpenDatabase(ctx)
+	dbHandle, err := aLibrary.Open(ctx)
 	if nil != err {
-		msg := fmt.Sprintf("OpenDatabase(): %v", err)
+		msg := fmt.Sprintf("TLibrary.Open(%s): %v", aLibrary.Name(), err)
 		apachelogger.Err("ThumbnailUpdate()", msg)
 		return
 	}
 
-	docList, err := dbHandle.QueryIDs(ctx)
-	if nil != err {
-		return
-	}
-	for _, doc := range *docList {
-		if _, err = Thumbnail(&doc); nil != err {
-			msg := fmt.Sprintf("Thumbnail(%d): %v", doc.ID, err)
-			apachelogger.Err("ThumbnailUpdate()", msg)
-		}
-	}
+	thumbnailsUpdate(aLibrary, dbHandle, 0 == imgCache.stats().Budget)
 
 	// Delete/update all orphaned/outdated thumbnails:
-	go goThumbCleanup(dbHandle)
+	go goThumbCleanup(aLibrary, dbHandle)
 } // ThumbnailUpdate()
 
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
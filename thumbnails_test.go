@@ -162,7 +162,7 @@ func Test_goThumbCleanup(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			goThumbCleanup(dbHandle)
+			goThumbCleanup(db.DefaultLibrary(), dbHandle)
 		})
 	}
 } // Test_goThumbCleanup()
@@ -0,0 +1,82 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the web page to manage the users' API tokens.
+ */
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwat56/apachelogger"
+	"github.com/mwat56/kaliber/db"
+	"github.com/mwat56/sessions"
+)
+
+// `handleTokens()` serves the `/tokens` page (GET and POST).
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aOptions` The current query options to use.
+//	`aSession` The current user session.
+func (ph *TPageHandler) handleTokens(aWriter http.ResponseWriter, aRequest *http.Request, aOptions *db.TQueryOptions, aSession *sessions.TSession) {
+	if (nil == ph.tokens) || (nil == aRequest.URL.User) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	user := aRequest.URL.User.Username()
+	token := csrfToken(aSession)
+	pageData := ph.basicTemplateData(aRequest, aOptions).
+		Set("CSRF", token).
+		Set("SID", aSession.ID()).
+		Set("SIDNAME", sessions.SIDname()).
+		Set("ShowForm", false).
+		Set("User", user)
+
+	if `POST` == aRequest.Method {
+		var err error
+		if !csrfPassed(aRequest, token) {
+			apachelogger.Err("TPageHandler.handleTokens()",
+				fmt.Sprintf("rejected cross-site request for '%s'", user))
+			err = errCSRF
+		} else if id := aRequest.FormValue(`revoke`); 0 < len(id) {
+			err = ph.tokens.Remove(id, user)
+		} else if `create` == aRequest.FormValue(`action`) {
+			var (
+				expires time.Time
+				scopes  []string
+				token   string
+			)
+			_ = aRequest.ParseForm()
+			if scopes, err = parseScopes(strings.Join(aRequest.Form[`scope`], `,`)); nil == err {
+				if days, _ := strconv.Atoi(aRequest.FormValue(`days`)); 0 < days {
+					expires = time.Now().AddDate(0, 0, days)
+				}
+				if token, err = ph.tokens.Add(user, aRequest.FormValue(`name`), scopes, expires); nil == err {
+					pageData.Set("Token", token)
+				}
+			}
+		}
+		if nil != err {
+			pageData.Set("Error", fmt.Sprintf("%v", err))
+		}
+	}
+
+	pageData.Set("Now", time.Now()).
+		Set("Tokens", ph.tokens.List(user))
+	aWriter.Header().Set(`Cache-Control`, `no-store`)
+	ph.handleReply(`tokens`, aWriter, aRequest, aOptions, aSession, pageData)
+} // handleTokens()
+
+/* _EoF_ */
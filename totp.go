@@ -0,0 +1,639 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the time-based one-time passwords (RFC 6238)
+ * used for the optional two-factor authentication.
+ */
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // #nosec G505
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of digits of a TOTP code.
+	totpDigits = 6
+
+	// Number of failed attempts before a user gets locked out.
+	totpMaxFails = 5
+
+	// Time a user gets locked out after `totpMaxFails` attempts.
+	totpLockout = 5 * time.Minute
+
+	// Time step (in seconds) of the TOTP codes.
+	totpPeriod = 30
+
+	// Number of recovery codes generated for a user.
+	totpRecoveryCount = 10
+
+	// Number of time steps accepted before/after the current one.
+	totpSkew = 1
+
+	// Name of the cookie marking a successful TOTP verification.
+	totpCookieName = `kaliberTOTP`
+
+	// Time a successful TOTP verification remains valid.
+	totpCookieTTL = 12 * time.Hour
+)
+
+var (
+	// Encoding used for TOTP secrets (RFC 4648 without padding).
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// `totpCode()` returns the HOTP value (RFC 4226) of `aCounter`.
+//
+//	`aKey` The shared secret.
+//	`aCounter` The moving factor (i.e. the time step).
+func totpCode(aKey []byte, aCounter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], aCounter)
+	mac := hmac.New(sha1.New, aKey)
+	_, _ = mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+} // totpCode()
+
+// `totpMatch()` checks whether `aCode` is valid for `aSecret` at
+// `aTime`, returning the matching time step.
+//
+// Time steps not greater than `aLastStep` are rejected to prevent
+// a code's reuse.
+//
+//	`aSecret` The base32 encoded shared secret.
+//	`aCode` The code entered by the user.
+//	`aTime` The time to check the code for.
+//	`aLastStep` The time step of the last successful verification.
+func totpMatch(aSecret, aCode string, aTime time.Time, aLastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(aSecret))
+	if (nil != err) || (totpDigits != len(aCode)) {
+		return 0, false
+	}
+	step := aTime.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		s := step + int64(i)
+		if (s <= aLastStep) || (0 > s) {
+			continue
+		}
+		if 1 == subtle.ConstantTimeCompare([]byte(totpCode(key, uint64(s))), []byte(aCode)) {
+			return s, true
+		}
+	}
+
+	return 0, false
+} // totpMatch()
+
+// `totpNewSecret()` returns a new random base32 encoded secret.
+func totpNewSecret() (string, error) {
+	key := make([]byte, 20) // 160 bits as recommended by RFC 4226
+	if _, err := rand.Read(key); nil != err {
+		return ``, err
+	}
+
+	return totpEncoding.EncodeToString(key), nil
+} // totpNewSecret()
+
+// `totpNormalise()` removes all characters from `aCode` which are
+// neither letters nor digits, returning the result in lower case.
+//
+//	`aCode` The code entered by the user.
+func totpNormalise(aCode string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(aCode) {
+		if (('0' <= r) && ('9' >= r)) || (('a' <= r) && ('z' >= r)) {
+			sb.WriteRune(r)
+		}
+	}
+
+	return sb.String()
+} // totpNormalise()
+
+// `totpRecoveryHash()` returns the hash stored for a recovery code.
+//
+//	`aCode` The (normalised) recovery code.
+func totpRecoveryHash(aCode string) string {
+	sum := sha256.Sum256([]byte(aCode))
+
+	return hex.EncodeToString(sum[:])
+} // totpRecoveryHash()
+
+// `totpURI()` returns the provisioning URI used by authenticator
+// apps (usually presented as a QR code).
+//
+//	`aIssuer` The name of the service.
+//	`aUser` The user's name.
+//	`aSecret` The base32 encoded shared secret.
+func totpURI(aIssuer, aUser, aSecret string) string {
+	label := url.PathEscape(aIssuer) + `:` + url.PathEscape(aUser)
+	query := url.Values{}
+	query.Set(`secret`, aSecret)
+	query.Set(`issuer`, aIssuer)
+	query.Set(`algorithm`, `SHA1`)
+	query.Set(`digits`, strconv.Itoa(totpDigits))
+	query.Set(`period`, strconv.Itoa(totpPeriod))
+
+	return `otpauth://totp/` + label + `?` + query.Encode()
+} // totpURI()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `totpCookieValue()` returns a signed cookie value marking `aUser`
+// as verified until `aExpires`.
+//
+//	`aKey` The secret key to sign the value with.
+//	`aUser` The verified user.
+//	`aExpires` The time the verification expires.
+func totpCookieValue(aKey []byte, aUser string, aExpires time.Time) string {
+	user := base64.RawURLEncoding.EncodeToString([]byte(aUser))
+	expires := strconv.FormatInt(aExpires.Unix(), 10)
+	sig := signData(aKey, totpCookieName, user, expires)
+
+	return user + `.` + expires + `.` + base64.RawURLEncoding.EncodeToString(sig)
+} // totpCookieValue()
+
+// `totpCookieUser()` returns the user marked as verified by the
+// cookie value `aValue`, or an empty string if the value is invalid
+// or expired.
+//
+//	`aKey` The secret key the value was signed with.
+//	`aValue` The cookie value to check.
+//	`aNow` The current time.
+func totpCookieUser(aKey []byte, aValue string, aNow time.Time) string {
+	parts := strings.Split(aValue, `.`)
+	if 3 != len(parts) {
+		return ``
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if (nil != err) || !hmac.Equal(sig, signData(aKey, totpCookieName, parts[0], parts[1])) {
+		return ``
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if (nil != err) || (aNow.Unix() >= expires) {
+		return ``
+	}
+	user, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if nil != err {
+		return ``
+	}
+
+	return string(user)
+} // totpCookieUser()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+type (
+	// `tTOTPuser` holds the two-factor data of a single user.
+	tTOTPuser struct {
+		confirmed bool      // whether the secret was confirmed
+		fails     int       // number of failed attempts
+		lastStep  int64     // time step of last successful code
+		locked    time.Time // end of lockout after too many fails
+		recovery  []string  // hashes of unused recovery codes
+		roles     []string  // the user's roles
+		secret    string    // base32 encoded shared secret
+	}
+
+	// TTOTPstore manages the users' TOTP secrets, recovery codes,
+	// and roles.
+	//
+	// The data is kept in a text file next to the password file;
+	// each line holds the colon separated fields
+	//
+	//	user:secret:confirmed:lastStep:role,role:hash,hash
+	TTOTPstore struct {
+		filename string                // name of the data file
+		mtx      *sync.Mutex           // guard for `users`
+		users    map[string]*tTOTPuser // list of user data
+	}
+)
+
+// NewTOTPstore returns a new `TTOTPstore` instance using `aFilename`.
+//
+// A missing file is not an error; it gets created when data is
+// stored for the first time.
+//
+//	`aFilename` The name of the data file to use.
+func NewTOTPstore(aFilename string) (*TTOTPstore, error) {
+	result := &TTOTPstore{
+		filename: aFilename,
+		mtx:      new(sync.Mutex),
+		users:    make(map[string]*tTOTPuser, 16),
+	}
+	if err := result.load(); nil != err {
+		return nil, err
+	}
+
+	return result, nil
+} // NewTOTPstore()
+
+// TOTPfilename returns the name of the TOTP data file.
+//
+// It's the password file's name with a `.totp` extension or –
+// if there's no password file – `totp.db` in the private directory
+// (see `privateFilename()`).
+func TOTPfilename() string {
+	return totpFilename(AppArgs.PassFile)
+} // TOTPfilename()
+
+// `totpFilename()` returns the name of the TOTP data file belonging to
+// the password file `aPassFile`.
+//
+//	`aPassFile` The name of the password file in use.
+func totpFilename(aPassFile string) string {
+	return privateFilename(aPassFile, `.totp`, `totp.db`)
+} // totpFilename()
+
+// Confirm checks `aCode` against the pending secret of `aUser`.
+//
+// On success the two-factor authentication is activated and the
+// new recovery codes are returned.
+//
+//	`aUser` The user to confirm the enrollment for.
+//	`aCode` The code entered by the user.
+func (ts *TTOTPstore) Confirm(aUser, aCode string) ([]string, error) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	tu, ok := ts.users[aUser]
+	if (!ok) || (0 == len(tu.secret)) || tu.confirmed {
+		return nil, fmt.Errorf("Confirm: no pending enrollment for '%s'", aUser)
+	}
+	if err := ts.check(tu, aCode, false); nil != err {
+		return nil, err
+	}
+	tu.confirmed = true
+	codes, err := ts.newRecovery(tu)
+	if nil != err {
+		return nil, err
+	}
+
+	return codes, ts.save()
+} // Confirm()
+
+// `check()` verifies `aCode` for `tu` taking care of lockouts.
+//
+//	`tu` The user's data.
+//	`aCode` The code entered by the user.
+//	`aRecovery` Whether recovery codes are accepted as well.
+func (ts *TTOTPstore) check(tu *tTOTPuser, aCode string, aRecovery bool) error {
+	now := time.Now()
+	if now.Before(tu.locked) {
+		return errors.New(`too many failed attempts`)
+	}
+
+	aCode = totpNormalise(aCode)
+	if step, ok := totpMatch(tu.secret, aCode, now, tu.lastStep); ok {
+		tu.fails, tu.lastStep = 0, step
+		return nil
+	}
+	if aRecovery {
+		hash := totpRecoveryHash(aCode)
+		for idx, rc := range tu.recovery {
+			if 1 == subtle.ConstantTimeCompare([]byte(rc), []byte(hash)) {
+				// each recovery code can be used only once
+				tu.recovery = append(tu.recovery[:idx], tu.recovery[idx+1:]...)
+				tu.fails = 0
+				return nil
+			}
+		}
+	}
+
+	if tu.fails++; totpMaxFails <= tu.fails {
+		tu.fails, tu.locked = 0, now.Add(totpLockout)
+	}
+
+	return errors.New(`invalid code`)
+} // check()
+
+// Disable removes the TOTP secret and recovery codes of `aUser`
+// (the user's roles are kept).
+//
+//	`aUser` The user to disable the two-factor authentication for.
+func (ts *TTOTPstore) Disable(aUser string) error {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	tu, ok := ts.users[aUser]
+	if !ok {
+		return nil
+	}
+	if 0 == len(tu.roles) {
+		delete(ts.users, aUser)
+	} else {
+		tu.confirmed, tu.lastStep = false, 0
+		tu.recovery, tu.secret = nil, ``
+	}
+
+	return ts.save()
+} // Disable()
+
+// Enrolled returns whether `aUser` has an active TOTP secret.
+//
+//	`aUser` The user to check.
+func (ts *TTOTPstore) Enrolled(aUser string) bool {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	tu, ok := ts.users[aUser]
+
+	return ok && tu.confirmed
+} // Enrolled()
+
+// `load()` reads the data file.
+func (ts *TTOTPstore) load() error {
+	file, err := os.Open(ts.filename) // #nosec G304
+	if nil != err {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	users := make(map[string]*tTOTPuser, 16)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if (0 == len(line)) || ('#' == line[0]) {
+			continue
+		}
+		fields := strings.Split(line, `:`)
+		if 6 != len(fields) {
+			return fmt.Errorf("%s:%d: invalid number of fields", ts.filename, lineNo)
+		}
+		tu := &tTOTPuser{
+			confirmed: `1` == fields[2],
+			recovery:  splitList(fields[5]),
+			roles:     splitList(fields[4]),
+			secret:    fields[1],
+		}
+		if tu.lastStep, err = strconv.ParseInt(fields[3], 10, 64); nil != err {
+			return fmt.Errorf("%s:%d: %w", ts.filename, lineNo, err)
+		}
+		users[fields[0]] = tu
+	}
+	if err = scanner.Err(); nil != err {
+		return err
+	}
+	ts.users = users
+
+	return nil
+} // load()
+
+// NewRecoveryCodes replaces the recovery codes of `aUser` by new
+// ones and returns them.
+//
+//	`aUser` The user to create the recovery codes for.
+func (ts *TTOTPstore) NewRecoveryCodes(aUser string) ([]string, error) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	tu, ok := ts.users[aUser]
+	if (!ok) || !tu.confirmed {
+		return nil, fmt.Errorf("NewRecoveryCodes: '%s' not enrolled", aUser)
+	}
+	codes, err := ts.newRecovery(tu)
+	if nil != err {
+		return nil, err
+	}
+
+	return codes, ts.save()
+} // NewRecoveryCodes()
+
+// `newRecovery()` sets new recovery codes for `tu` returning the
+// plain codes.
+//
+//	`tu` The user's data.
+func (ts *TTOTPstore) newRecovery(tu *tTOTPuser) ([]string, error) {
+	codes := make([]string, 0, totpRecoveryCount)
+	hashes := make([]string, 0, totpRecoveryCount)
+	buf := make([]byte, 5)
+	for i := 0; i < totpRecoveryCount; i++ {
+		if _, err := rand.Read(buf); nil != err {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(buf)) // 8 chars
+		hashes = append(hashes, totpRecoveryHash(code))
+		codes = append(codes, code[:4]+`-`+code[4:])
+	}
+	tu.recovery = hashes
+
+	return codes, nil
+} // newRecovery()
+
+// Recovery returns the number of unused recovery codes of `aUser`.
+//
+//	`aUser` The user to check.
+func (ts *TTOTPstore) Recovery(aUser string) int {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	if tu, ok := ts.users[aUser]; ok {
+		return len(tu.recovery)
+	}
+
+	return 0
+} // Recovery()
+
+// Roles returns the roles assigned to `aUser`.
+//
+//	`aUser` The user to lookup.
+func (ts *TTOTPstore) Roles(aUser string) []string {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	if tu, ok := ts.users[aUser]; ok {
+		return append([]string{}, tu.roles...)
+	}
+
+	return nil
+} // Roles()
+
+// `save()` writes the data file.
+//
+// NOTE: The caller is expected to hold the lock.
+func (ts *TTOTPstore) save() error {
+	names := make([]string, 0, len(ts.users))
+	for name := range ts.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("# Kaliber TOTP data: user:secret:confirmed:lastStep:roles:recovery\n")
+	for _, name := range names {
+		tu := ts.users[name]
+		confirmed := `0`
+		if tu.confirmed {
+			confirmed = `1`
+		}
+		fmt.Fprintf(&sb, "%s:%s:%s:%d:%s:%s\n", name, tu.secret, confirmed,
+			tu.lastStep, strings.Join(tu.roles, `,`), strings.Join(tu.recovery, `,`))
+	}
+
+	tmpName := ts.filename + `~`
+	if err := os.WriteFile(tmpName, []byte(sb.String()), 0600); nil != err {
+		return err
+	}
+
+	return os.Rename(tmpName, ts.filename)
+} // save()
+
+// Secret returns the pending (i.e. not yet confirmed) secret of
+// `aUser`, creating a new one if necessary.
+//
+// An error is returned if the user is already enrolled.
+//
+//	`aUser` The user to get the secret for.
+func (ts *TTOTPstore) Secret(aUser string) (string, error) {
+	if (0 == len(aUser)) || strings.ContainsAny(aUser, ":\n") {
+		return ``, fmt.Errorf("Secret: invalid username '%s'", aUser)
+	}
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	tu, ok := ts.users[aUser]
+	if !ok {
+		tu = &tTOTPuser{}
+		ts.users[aUser] = tu
+	} else if tu.confirmed {
+		return ``, fmt.Errorf("Secret: '%s' already enrolled", aUser)
+	}
+	if 0 < len(tu.secret) {
+		return tu.secret, nil
+	}
+
+	secret, err := totpNewSecret()
+	if nil != err {
+		return ``, err
+	}
+	tu.secret = secret
+
+	return secret, ts.save()
+} // Secret()
+
+// SetRoles replaces the roles of `aUser` by `aRoles`.
+//
+//	`aUser` The user to set the roles for.
+//	`aRoles` The user's new roles.
+func (ts *TTOTPstore) SetRoles(aUser string, aRoles []string) error {
+	if (0 == len(aUser)) || strings.ContainsAny(aUser, ":\n") {
+		return fmt.Errorf("SetRoles: invalid username '%s'", aUser)
+	}
+	roles := make([]string, 0, len(aRoles))
+	for _, role := range aRoles {
+		if role = strings.TrimSpace(role); 0 == len(role) {
+			continue
+		}
+		if strings.ContainsAny(role, ":,\n") {
+			return fmt.Errorf("SetRoles: invalid role '%s'", role)
+		}
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	tu, ok := ts.users[aUser]
+	if !ok {
+		if 0 == len(roles) {
+			return nil
+		}
+		tu = &tTOTPuser{}
+		ts.users[aUser] = tu
+	}
+	tu.roles = roles
+	if (0 == len(roles)) && (0 == len(tu.secret)) {
+		delete(ts.users, aUser)
+	}
+
+	return ts.save()
+} // SetRoles()
+
+// Verify checks `aCode` – a TOTP or recovery code – for `aUser`.
+//
+//	`aUser` The user to verify.
+//	`aCode` The code entered by the user.
+func (ts *TTOTPstore) Verify(aUser, aCode string) error {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	tu, ok := ts.users[aUser]
+	if (!ok) || !tu.confirmed {
+		return fmt.Errorf("Verify: '%s' not enrolled", aUser)
+	}
+	if err := ts.check(tu, aCode, true); nil != err {
+		return err
+	}
+
+	return ts.save()
+} // Verify()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `splitList()` returns the non-empty entries of the comma separated
+// `aList`.
+//
+//	`aList` The list to split.
+func splitList(aList string) []string {
+	var result []string
+	for _, entry := range strings.Split(aList, `,`) {
+		if entry = strings.TrimSpace(entry); 0 < len(entry) {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+} // splitList()
+
+// `totpRequired()` returns whether one of `aRoles` is listed in
+// `aRequired` (the special role `*` matches everybody).
+//
+//	`aRequired` The roles requiring two-factor authentication.
+//	`aRoles` The user's roles.
+func totpRequired(aRequired, aRoles []string) bool {
+	for _, req := range aRequired {
+		if `*` == req {
+			return true
+		}
+		for _, role := range aRoles {
+			if strings.EqualFold(req, role) {
+				return true
+			}
+		}
+	}
+
+	return false
+} // totpRequired()
+
+/* _EoF_ */
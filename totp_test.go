@@ -0,0 +1,300 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_totpCode(t *testing.T) {
+	key := []byte(`12345678901234567890`) // RFC 6238, appendix B
+	tests := []struct {
+		name  string
+		aTime int64
+		want  string
+	}{
+		// TODO: Add test cases.
+		{" 1", 59, `287082`},
+		{" 2", 1111111109, `081804`},
+		{" 3", 1111111111, `050471`},
+		{" 4", 1234567890, `005924`},
+		{" 5", 2000000000, `279037`},
+		{" 6", 20000000000, `353130`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := totpCode(key, uint64(tt.aTime/totpPeriod)); got != tt.want {
+				t.Errorf("totpCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // Test_totpCode()
+
+func Test_totpMatch(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte(`12345678901234567890`))
+	now := time.Unix(1111111109, 0) // step 37037036
+	tests := []struct {
+		name      string
+		aCode     string
+		aLastStep int64
+		wantStep  int64
+		wantOK    bool
+	}{
+		// TODO: Add test cases.
+		{" 1", `081804`, 0, 37037036, true},
+		{" 2", `050471`, 0, 37037037, true},  // next step accepted
+		{" 3", `081804`, 37037036, 0, false}, // replay
+		{" 4", `081805`, 0, 0, false},
+		{" 5", `81804`, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := totpMatch(secret, tt.aCode, now, tt.aLastStep)
+			if (gotStep != tt.wantStep) || (gotOK != tt.wantOK) {
+				t.Errorf("totpMatch() = %d, %v, want %d, %v", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+} // Test_totpMatch()
+
+func Test_totpURI(t *testing.T) {
+	got := totpURI(`My Library`, `jane doe`, `ABCDEF`)
+	want := `otpauth://totp/My%20Library:jane%20doe?algorithm=SHA1&digits=6&issuer=My+Library&period=30&secret=ABCDEF`
+	if got != want {
+		t.Errorf("totpURI() = %q,\nwant %q", got, want)
+	}
+} // Test_totpURI()
+
+func Test_totpCookie(t *testing.T) {
+	key := []byte(`0123456789abcdef0123456789abcdef`)
+	now := time.Now()
+	value := totpCookieValue(key, `alice`, now.Add(time.Hour))
+	tests := []struct {
+		name   string
+		aKey   []byte
+		aValue string
+		aNow   time.Time
+		want   string
+	}{
+		// TODO: Add test cases.
+		{" 1", key, value, now, `alice`},
+		{" 2", key, value, now.Add(2 * time.Hour), ``},
+		{" 3", []byte(`another key`), value, now, ``},
+		{" 4", key, strings.Replace(value, `.`, `x.`, 1), now, ``},
+		{" 5", key, ``, now, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := totpCookieUser(tt.aKey, tt.aValue, tt.aNow); got != tt.want {
+				t.Errorf("totpCookieUser() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_totpCookie()
+
+func Test_totpNext(t *testing.T) {
+	tests := []struct {
+		name  string
+		aNext string
+		want  string
+	}{
+		// TODO: Add test cases.
+		{" 1", ``, `/`},
+		{" 2", `/doc/12/title`, `/doc/12/title`},
+		{" 3", `//evil.example/`, `/`},
+		{" 4", `/\evil.example/`, `/`},
+		{" 5", `https://evil.example/`, `/`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := totpNext(tt.aNext); got != tt.want {
+				t.Errorf("totpNext() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_totpNext()
+
+func Test_totpRequired(t *testing.T) {
+	tests := []struct {
+		name      string
+		aRequired []string
+		aRoles    []string
+		want      bool
+	}{
+		// TODO: Add test cases.
+		{" 1", nil, []string{`admin`}, false},
+		{" 2", []string{`admin`}, []string{`Admin`}, true},
+		{" 3", []string{`admin`}, []string{`reader`}, false},
+		{" 4", []string{`*`}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := totpRequired(tt.aRequired, tt.aRoles); got != tt.want {
+				t.Errorf("totpRequired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+} // Test_totpRequired()
+
+func TestTTOTPstore(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), `pwaccess.db.totp`)
+	ts, err := NewTOTPstore(fName)
+	if nil != err {
+		t.Fatalf("NewTOTPstore() error = %v", err)
+	}
+	if ts.Enrolled(`alice`) {
+		t.Error("TTOTPstore.Enrolled() = true, want false")
+	}
+	if err = ts.SetRoles(`alice`, []string{`reader`, ` admin `, ``}); nil != err {
+		t.Fatalf("TTOTPstore.SetRoles() error = %v", err)
+	}
+	if got := ts.Roles(`alice`); !reflect.DeepEqual(got, []string{`admin`, `reader`}) {
+		t.Errorf("TTOTPstore.Roles() = %v", got)
+	}
+
+	secret, err := ts.Secret(`alice`)
+	if nil != err {
+		t.Fatalf("TTOTPstore.Secret() error = %v", err)
+	}
+	if again, _ := ts.Secret(`alice`); again != secret {
+		t.Errorf("TTOTPstore.Secret() = %q, want pending %q", again, secret)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	code := totpCode(key, uint64(time.Now().Unix()/totpPeriod))
+
+	n, _ := strconv.Atoi(code)
+	if _, err = ts.Confirm(`alice`, fmt.Sprintf("%06d", (n+500000)%1000000)); nil == err {
+		t.Error("TTOTPstore.Confirm() with wrong code: error = nil")
+	}
+	codes, err := ts.Confirm(`alice`, code[:3]+` `+code[3:])
+	if nil != err {
+		t.Fatalf("TTOTPstore.Confirm() error = %v", err)
+	}
+	if totpRecoveryCount != len(codes) {
+		t.Errorf("TTOTPstore.Confirm() = %d codes, want %d", len(codes), totpRecoveryCount)
+	}
+	if !ts.Enrolled(`alice`) {
+		t.Error("TTOTPstore.Enrolled() = false, want true")
+	}
+	if _, err = ts.Secret(`alice`); nil == err {
+		t.Error("TTOTPstore.Secret() for enrolled user: error = nil")
+	}
+	if err = ts.Verify(`alice`, code); nil == err {
+		t.Error("TTOTPstore.Verify() with reused code: error = nil")
+	}
+
+	// Data must survive a reload:
+	if ts, err = NewTOTPstore(fName); nil != err {
+		t.Fatalf("NewTOTPstore() reload error = %v", err)
+	}
+	if !ts.Enrolled(`alice`) || (totpRecoveryCount != ts.Recovery(`alice`)) {
+		t.Errorf("TTOTPstore reload: enrolled = %v, recovery = %d", ts.Enrolled(`alice`), ts.Recovery(`alice`))
+	}
+
+	if err = ts.Verify(`alice`, strings.ToUpper(codes[0])); nil != err {
+		t.Errorf("TTOTPstore.Verify() with recovery code error = %v", err)
+	}
+	if err = ts.Verify(`alice`, codes[0]); nil == err {
+		t.Error("TTOTPstore.Verify() with used recovery code: error = nil")
+	}
+	if (totpRecoveryCount - 1) != ts.Recovery(`alice`) {
+		t.Errorf("TTOTPstore.Recovery() = %d, want %d", ts.Recovery(`alice`), totpRecoveryCount-1)
+	}
+
+	// Too many failures lock the user out:
+	for i := 0; i < totpMaxFails; i++ {
+		_ = ts.Verify(`alice`, `nonsense`)
+	}
+	if err = ts.Verify(`alice`, codes[1]); nil == err {
+		t.Error("TTOTPstore.Verify() while locked: error = nil")
+	}
+
+	if err = ts.Disable(`alice`); nil != err {
+		t.Fatalf("TTOTPstore.Disable() error = %v", err)
+	}
+	if ts.Enrolled(`alice`) || (0 != ts.Recovery(`alice`)) {
+		t.Error("TTOTPstore.Disable() kept the TOTP data")
+	}
+	if got := ts.Roles(`alice`); 2 != len(got) {
+		t.Errorf("TTOTPstore.Disable() roles = %v", got)
+	}
+
+	if err = ts.SetRoles(`bob:x`, nil); nil == err {
+		t.Error("TTOTPstore.SetRoles() with invalid name: error = nil")
+	}
+} // TestTTOTPstore()
+
+func TestTPageHandler_totpPassed(t *testing.T) {
+	// use a fixed key instead of creating a key file:
+	skOnce.Do(func() { skKey = []byte(`0123456789abcdef0123456789abcdef`) })
+	ts, _ := NewTOTPstore(filepath.Join(t.TempDir(), `pwaccess.db.totp`))
+	_ = ts.SetRoles(`admin`, []string{`admin`})
+	secret, _ := ts.Secret(`carol`)
+	key, _ := totpEncoding.DecodeString(secret)
+	_, _ = ts.Confirm(`carol`, totpCode(key, uint64(time.Now().Unix()/totpPeriod)))
+	ph := &TPageHandler{totp: ts}
+	AppArgs.TOTProles = `admin`
+	defer func() { AppArgs.TOTProles = `` }()
+
+	tests := []struct {
+		name       string
+		aUser      string
+		aPath      string
+		aVerified  string
+		want       bool
+		wantHeader string
+	}{
+		// TODO: Add test cases.
+		{" 1", `bob`, `/doc/1/x`, ``, true, ``},
+		{" 2", `admin`, `/doc/1/x`, ``, false, `/totp?next=%2Fdoc%2F1%2Fx`},
+		{" 3", `carol`, `/file/1/x.epub`, ``, false, `/totp?next=%2Ffile%2F1%2Fx.epub`},
+		{" 4", `carol`, `/file/1/x.epub`, `carol`, true, ``},
+		{" 5", `carol`, `/file/1/x.epub`, `bob`, false, `/totp?next=%2Ffile%2F1%2Fx.epub`},
+		{" 6", `carol`, `/totp`, ``, true, ``},
+		{" 7", `carol`, `/css/stylesheet.css`, ``, true, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(`GET`, tt.aPath, nil)
+			req.URL.User = url.User(tt.aUser)
+			if 0 < len(tt.aVerified) {
+				req.AddCookie(&http.Cookie{
+					Name:  totpCookieName,
+					Value: totpCookieValue(serverKey(), tt.aVerified, time.Now().Add(time.Hour)),
+				})
+			}
+			w := httptest.NewRecorder()
+			if got := ph.totpPassed(w, req); got != tt.want {
+				t.Errorf("TPageHandler.totpPassed() = %v, want %v", got, tt.want)
+			}
+			if got := w.Header().Get(`Location`); got != tt.wantHeader {
+				t.Errorf("TPageHandler.totpPassed() Location = %q, want %q", got, tt.wantHeader)
+			}
+		})
+	}
+
+	// A library's page is returned to including its `/lib/` prefix:
+	req := httptest.NewRequest(`GET`, `/lib/comics/doc/1/x?a=b`, nil)
+	req.URL.Path = `/doc/1/x` // as done by `libraryRequest()`
+	req.URL.User = url.User(`carol`)
+	w := httptest.NewRecorder()
+	_ = ph.totpPassed(w, req)
+	if got, want := w.Header().Get(`Location`), `/totp?next=%2Flib%2Fcomics%2Fdoc%2F1%2Fx%3Fa%3Db`; got != want {
+		t.Errorf("TPageHandler.totpPassed() Location = %q, want %q", got, want)
+	}
+} // TestTPageHandler_totpPassed()
@@ -0,0 +1,260 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the web pages of the two-factor authentication.
+ */
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mwat56/apachelogger"
+	"github.com/mwat56/kaliber/db"
+	"github.com/mwat56/sessions"
+	"rsc.io/qr"
+)
+
+// `totpNext()` returns `aNext` if it's a local URL, or `/` otherwise.
+//
+//	`aNext` The URL to continue with after the verification.
+func totpNext(aNext string) string {
+	if (0 == len(aNext)) || ('/' != aNext[0]) ||
+		strings.HasPrefix(aNext, `//`) || strings.HasPrefix(aNext, `/\`) {
+		return `/`
+	}
+
+	return aNext
+} // totpNext()
+
+// `totpSetCookie()` marks the remote user as verified.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aUser` The verified user.
+func totpSetCookie(aWriter http.ResponseWriter, aRequest *http.Request, aUser string) {
+	expires := time.Now().Add(totpCookieTTL)
+	http.SetCookie(aWriter, &http.Cookie{
+		Expires:  expires,
+		HttpOnly: true,
+		Name:     totpCookieName,
+		Path:     `/`,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   nil != aRequest.TLS,
+		Value:    totpCookieValue(serverKey(), aUser, expires),
+	})
+} // totpSetCookie()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `handleTOTP()` serves the `/totp` pages (GET and POST).
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aOptions` The current query options to use.
+//	`aSession` The current user session.
+func (ph *TPageHandler) handleTOTP(aWriter http.ResponseWriter, aRequest *http.Request, aOptions *db.TQueryOptions, aSession *sessions.TSession) {
+	if (nil == ph.totp) || (nil == aRequest.URL.User) {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	user := aRequest.URL.User.Username()
+	if _, tail := URLparts(aRequest.URL.Path); `qr` == tail {
+		ph.handleTOTPqr(aWriter, aRequest, user)
+		return
+	}
+
+	next := totpNext(aRequest.FormValue(`next`))
+	enrolled := ph.totp.Enrolled(user)
+	verified := ph.totpVerified(aRequest, user)
+	token := csrfToken(aSession)
+	pageData := ph.basicTemplateData(aRequest, aOptions).
+		Set("CSRF", token).
+		Set("Next", next).
+		Set("SID", aSession.ID()).
+		Set("SIDNAME", sessions.SIDname()).
+		Set("ShowForm", false).
+		Set("User", user)
+
+	if `POST` == aRequest.Method {
+		var (
+			codes []string
+			err   error
+		)
+		code := aRequest.FormValue(`code`)
+		action := aRequest.FormValue(`action`)
+		if !csrfPassed(aRequest, token) {
+			apachelogger.Err("TPageHandler.handleTOTP()",
+				fmt.Sprintf("rejected cross-site request for '%s'", user))
+			err, action = errCSRF, ``
+		}
+		switch action {
+		case `confirm`:
+			if codes, err = ph.totp.Confirm(user, code); nil == err {
+				totpSetCookie(aWriter, aRequest, user)
+			}
+
+		case `disable`:
+			if !enrolled || !verified {
+				break
+			}
+			if totpRequired(splitList(AppArgs.TOTProles), ph.userRoles(user)) {
+				err = fmt.Errorf("two-factor authentication is required for '%s'", user)
+			} else if err = ph.totp.Verify(user, code); nil == err {
+				err = ph.totp.Disable(user)
+			}
+
+		case `recovery`:
+			if enrolled && verified {
+				codes, err = ph.totp.NewRecoveryCodes(user)
+			}
+
+		case `verify`:
+			if !enrolled {
+				break
+			}
+			if err = ph.totp.Verify(user, code); nil == err {
+				totpSetCookie(aWriter, aRequest, user)
+				http.Redirect(aWriter, aRequest, next, http.StatusSeeOther)
+				return
+			}
+			apachelogger.Err("TPageHandler.handleTOTP()",
+				fmt.Sprintf("verification failed for '%s': %v", user, err))
+		}
+
+		if nil != err {
+			pageData.Set("Error", err.Error())
+		} else if 0 < len(codes) {
+			pageData.Set("Codes", codes).Set("TOTP", `codes`)
+			ph.handleReply(`totp`, aWriter, aRequest, aOptions, aSession, pageData)
+			return
+		}
+		enrolled = ph.totp.Enrolled(user)
+	}
+
+	switch {
+	case enrolled && !verified:
+		pageData.Set("TOTP", `verify`)
+
+	case enrolled:
+		pageData.Set("Recovery", ph.totp.Recovery(user)).
+			Set("TOTP", `status`)
+
+	default:
+		secret, err := ph.totp.Secret(user)
+		if nil != err {
+			handleInternalError(aWriter, `TPageHandler.handleTOTP()`,
+				fmt.Sprintf("TTOTPstore.Secret(%s): %v", user, err))
+			return
+		}
+		pageData.Set("Required", ph.totpNeeded(user)).
+			Set("Secret", secret).
+			Set("TOTP", `setup`).
+			Set("URI", totpURI(ph.site.LibName, user, secret))
+	}
+
+	aWriter.Header().Set(`Cache-Control`, `no-store`)
+	ph.handleReply(`totp`, aWriter, aRequest, aOptions, aSession, pageData)
+} // handleTOTP()
+
+// `handleTOTPqr()` sends the QR code of the user's pending TOTP
+// secret.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+//	`aUser` The current user.
+func (ph *TPageHandler) handleTOTPqr(aWriter http.ResponseWriter, aRequest *http.Request, aUser string) {
+	secret, err := ph.totp.Secret(aUser)
+	if nil != err {
+		http.NotFound(aWriter, aRequest)
+		return
+	}
+	code, err := qr.Encode(totpURI(ph.site.LibName, aUser, secret), qr.M)
+	if nil != err {
+		handleInternalError(aWriter, `TPageHandler.handleTOTPqr()`,
+			fmt.Sprintf("qr.Encode(): %v", err))
+		return
+	}
+	code.Scale = 6
+
+	aWriter.Header().Set(`Cache-Control`, `no-store`)
+	aWriter.Header().Set(`Content-Type`, `image/png`)
+	_, _ = aWriter.Write(code.PNG())
+} // handleTOTPqr()
+
+// `totpNeeded()` returns whether `aUser` has to pass the two-factor
+// authentication.
+//
+//	`aUser` The user to check.
+func (ph *TPageHandler) totpNeeded(aUser string) bool {
+	return ph.totp.Enrolled(aUser) ||
+		totpRequired(splitList(AppArgs.TOTProles), ph.userRoles(aUser))
+} // totpNeeded()
+
+// `totpPassed()` returns whether the (already authenticated) remote
+// user passed the two-factor authentication; if not, the request is
+// redirected to the `/totp` page and `false` is returned.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+func (ph *TPageHandler) totpPassed(aWriter http.ResponseWriter, aRequest *http.Request) bool {
+	if (nil == ph.totp) || (nil == aRequest.URL.User) {
+		return true
+	}
+	path, _ := URLparts(aRequest.URL.Path)
+	switch path {
+	case `css`, `favicon.ico`, `fonts`, `img`, `js`, `totp`:
+		return true
+	}
+	user := aRequest.URL.User.Username()
+	if (!ph.totpNeeded(user)) || ph.totpVerified(aRequest, user) {
+		return true
+	}
+
+	// Return to the page requested, even if it's another library's:
+	http.Redirect(aWriter, aRequest,
+		`/totp?next=`+url.QueryEscape(originalURI(aRequest)),
+		http.StatusSeeOther)
+
+	return false
+} // totpPassed()
+
+// `totpVerified()` returns whether `aRequest` carries a valid
+// verification cookie for `aUser`.
+//
+//	`aRequest` The HTTP request received by the server.
+//	`aUser` The authenticated user.
+func (ph *TPageHandler) totpVerified(aRequest *http.Request, aUser string) bool {
+	cookie, err := aRequest.Cookie(totpCookieName)
+	if nil != err {
+		return false
+	}
+
+	return aUser == totpCookieUser(serverKey(), cookie.Value, time.Now())
+} // totpVerified()
+
+// `userRoles()` returns the roles of `aUser` as assigned in the
+// TOTP store and provided by the authentication backend (e.g. the
+// LDAP groups).
+//
+//	`aUser` The user to lookup.
+func (ph *TPageHandler) userRoles(aUser string) []string {
+	roles := ph.totp.Roles(aUser)
+	if gl, ok := ph.auth.(tGroupLister); ok {
+		roles = append(roles, gl.Groups(aUser)...)
+	}
+
+	return roles
+} // userRoles()
+
+/* _EoF_ */
@@ -0,0 +1,384 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the virtual hosts (sites) served by the same
+ * process, each one with its own libraries, name, realm, password
+ * file, templates, and default GUI settings.
+ */
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mwat56/ini"
+	"github.com/mwat56/kaliber/db"
+)
+
+const (
+	// Prefix of the INI sections configuring a virtual host.
+	vhSectionPrefix = `host:`
+)
+
+type (
+	// TVirtualHost is a site served for certain `Host` header values.
+	TVirtualHost struct {
+		Aliases   []string // further host names of the site
+		Host      string   // the site's host name (e.g. `books.example`)
+		Lang      string   // default GUI language
+		Libraries []string // names of the libraries served (the first one is the site's default)
+		LibName   string   // the site's name (shown on every page)
+		PassFile  string   // the site's password file
+		Realm     string   // host/domain to secure by BasicAuth
+		Theme     string   // default display theme
+		ViewsDir  string   // directory of the site's templates
+	}
+
+	// `tSiteContextKey` is the type of the request context's key
+	// of the site.
+	tSiteContextKey struct{}
+
+	// THostSwitch is a HTTP handler passing the requests to the
+	// handler of the virtual host named by the `Host` header.
+	THostSwitch struct {
+		def   http.Handler            // the default site's handler
+		hosts map[string]http.Handler // the virtual hosts' handlers
+	}
+)
+
+var (
+	// The virtual hosts configured by the INI file(s).
+	vhList []*TVirtualHost
+)
+
+// `claimedLibraries()` returns the names of the libraries listed
+// by the virtual hosts.
+func claimedLibraries() map[string]bool {
+	result := make(map[string]bool)
+	for _, vh := range vhList {
+		for _, name := range vh.Libraries {
+			result[name] = true
+		}
+	}
+
+	return result
+} // claimedLibraries()
+
+// `defaultSite()` returns the site configured by the INI file's
+// default section and the commandline arguments.
+func defaultSite() *TVirtualHost {
+	return &TVirtualHost{
+		Lang:     AppArgs.Lang,
+		LibName:  AppArgs.LibName,
+		PassFile: AppArgs.PassFile,
+		Realm:    AppArgs.Realm,
+		Theme:    AppArgs.Theme,
+		ViewsDir: filepath.Join(AppArgs.DataDir, `views`),
+	}
+} // defaultSite()
+
+// `hostName()` returns the host name of `aHost` without a port
+// number in lowercase.
+//
+//	`aHost` The `Host` header value of a request.
+func hostName(aHost string) string {
+	if host, _, err := net.SplitHostPort(aHost); nil == err {
+		aHost = host
+	}
+
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(aHost)), `.`)
+} // hostName()
+
+// `iniHostList()` returns the INI sections of all the INI files
+// read by `readIniFiles()`.
+func iniHostList() *ini.TIniList {
+	var fNames []string
+	if fName, err := filepath.Abs(`./kaliber.ini`); nil == err {
+		fNames = append(fNames, fName)
+	}
+	fNames = append(fNames, `/etc/kaliber.ini`)
+	if dir, err := os.UserHomeDir(); (nil == err) && (0 < len(dir)) {
+		fNames = append(fNames, filepath.Join(dir, `.kaliber.ini`))
+	}
+	if dir, err := os.UserConfigDir(); nil == err {
+		fNames = append(fNames, filepath.Join(dir, `kaliber.ini`))
+	}
+	for idx := 1; idx < len(os.Args)-1; idx++ {
+		if `-ini` == os.Args[idx] {
+			fName, _ := filepath.Abs(os.Args[idx+1])
+			fNames = append(fNames, fName)
+			break
+		}
+	}
+
+	var result *ini.TIniList
+	for _, fName := range fNames {
+		list, err := ini.New(fName)
+		if nil != err {
+			continue
+		}
+		if nil == result {
+			result = list
+		} else {
+			result.Merge(list)
+		}
+	}
+
+	return result
+} // iniHostList()
+
+// `newSiteContext()` returns a copy of `aContext` carrying `aSite`.
+//
+//	`aContext` The current request's context.
+//	`aSite` The site serving the request.
+func newSiteContext(aContext context.Context, aSite *TVirtualHost) context.Context {
+	return context.WithValue(aContext, tSiteContextKey{}, aSite)
+} // newSiteContext()
+
+// `newVirtualHost()` returns the virtual host `aHost` configured by
+// `aSection` using the default site's values for missing settings.
+//
+//	`aHost` The site's host name.
+//	`aSection` The INI section with the site's settings.
+func newVirtualHost(aHost string, aSection *ini.TSection) (*TVirtualHost, error) {
+	result := defaultSite()
+	result.Host = hostName(aHost)
+	if 0 == len(result.Host) {
+		return nil, fmt.Errorf("missing host name of section '%s%s'", vhSectionPrefix, aHost)
+	}
+	if s, ok := aSection.AsString(`aliases`); ok {
+		for _, alias := range splitList(s) {
+			result.Aliases = append(result.Aliases, hostName(alias))
+		}
+	}
+	if s, ok := aSection.AsString(`hostLibraries`); ok {
+		result.Libraries = splitList(s)
+		for _, name := range result.Libraries {
+			if nil == db.Library(name) {
+				return nil, fmt.Errorf("host '%s': unknown library '%s'", result.Host, name)
+			}
+		}
+	}
+	if s, ok := aSection.AsString(`lang`); ok {
+		switch s = strings.ToLower(s); s {
+		case `de`, `en`:
+			result.Lang = s
+		}
+	}
+	if s, ok := aSection.AsString(`libraryName`); ok && (0 < len(s)) {
+		result.LibName = s
+	}
+	if s, ok := aSection.AsString(`passFile`); ok {
+		result.PassFile = ``
+		if 0 < len(s) {
+			// The other backends use one user list for all sites.
+			if AuthBackendPasslist != AppArgs.AuthBackend {
+				return nil, fmt.Errorf("host '%s': `passFile` needs the `%s` backend", result.Host, AuthBackendPasslist)
+			}
+			result.PassFile = absolute(AppArgs.DataDir, s)
+		}
+	}
+	if s, ok := aSection.AsString(`realm`); ok && (0 < len(s)) {
+		result.Realm = s
+	}
+	if s, ok := aSection.AsString(`theme`); ok {
+		switch s = strings.ToLower(s); s {
+		case `dark`, `light`:
+			result.Theme = s
+		}
+	}
+	if s, ok := aSection.AsString(`viewsDir`); ok && (0 < len(s)) {
+		result.ViewsDir = absolute(AppArgs.DataDir, s)
+	}
+
+	return result, nil
+} // newVirtualHost()
+
+// `readVirtualHosts()` returns the virtual hosts configured by the
+// `[host:<name>]` sections of `aList`.
+//
+//	`aList` The INI data to use.
+func readVirtualHosts(aList *ini.TIniList) ([]*TVirtualHost, error) {
+	if nil == aList {
+		return nil, nil
+	}
+	var names []string
+	aList.Walk(func(aSection, _, _ string) {
+		if !strings.HasPrefix(aSection, vhSectionPrefix) {
+			return
+		}
+		for _, name := range names {
+			if name == aSection {
+				return
+			}
+		}
+		names = append(names, aSection)
+	})
+	sort.Strings(names)
+
+	var result []*TVirtualHost
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		vh, err := newVirtualHost(name[len(vhSectionPrefix):], aList.GetSection(name))
+		if nil != err {
+			return nil, err
+		}
+		for _, host := range append([]string{vh.Host}, vh.Aliases...) {
+			if seen[host] {
+				return nil, fmt.Errorf("host '%s' configured twice", host)
+			}
+			seen[host] = true
+		}
+		result = append(result, vh)
+	}
+
+	return result, nil
+} // readVirtualHosts()
+
+// `requestSite()` returns the site serving `aRequest`.
+//
+// If the request's context doesn't name a site the default site
+// is returned.
+//
+//	`aRequest` The HTTP request received by the server.
+func requestSite(aRequest *http.Request) *TVirtualHost {
+	if site, ok := aRequest.Context().Value(tSiteContextKey{}).(*TVirtualHost); ok && (nil != site) {
+		return site
+	}
+
+	return defaultSite()
+} // requestSite()
+
+// VirtualHosts returns the virtual hosts configured by the INI file(s).
+func VirtualHosts() []*TVirtualHost {
+	return vhList
+} // VirtualHosts()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `libraries()` returns the libraries served by the site.
+//
+// If the site doesn't list its libraries all libraries not listed
+// by a virtual host are served.
+func (vh *TVirtualHost) libraries() []*db.TLibrary {
+	if 0 == len(vh.Libraries) {
+		claimed := claimedLibraries()
+		libs := db.Libraries()
+		if 0 == len(libs) {
+			libs = []*db.TLibrary{db.DefaultLibrary()}
+		}
+		result := make([]*db.TLibrary, 0, len(libs))
+		for _, lib := range libs {
+			if !claimed[lib.Name()] {
+				result = append(result, lib)
+			}
+		}
+		return result
+	}
+	result := make([]*db.TLibrary, 0, len(vh.Libraries))
+	for _, name := range vh.Libraries {
+		if lib := db.Library(name); nil != lib {
+			result = append(result, lib)
+		}
+	}
+
+	return result
+} // libraries()
+
+// `library()` returns the site's default library.
+//
+// If the site doesn't serve any library the default library is
+// returned; requests for it are rejected by `libraryRequest()`.
+func (vh *TVirtualHost) library() *db.TLibrary {
+	if libs := vh.libraries(); 0 < len(libs) {
+		return libs[0]
+	}
+
+	return db.DefaultLibrary()
+} // library()
+
+// `libraryNamed()` returns the site's library named `aName`, or `nil`
+// if the site doesn't serve such a library.
+//
+//	`aName` The name of the library to lookup.
+func (vh *TVirtualHost) libraryNamed(aName string) *db.TLibrary {
+	for _, lib := range vh.libraries() {
+		if aName == lib.Name() {
+			return lib
+		}
+	}
+
+	return nil
+} // libraryNamed()
+
+// `setDefaults()` sets the site's default GUI language and theme
+// in `aOptions`.
+//
+//	`aOptions` The query options to update.
+func (vh *TVirtualHost) setDefaults(aOptions *db.TQueryOptions) {
+	if `de` == vh.Lang {
+		aOptions.GuiLang = db.QoLangGerman
+	} else {
+		aOptions.GuiLang = db.QoLangEnglish
+	}
+	if `dark` == vh.Theme {
+		aOptions.Theme = db.QoThemeDark
+	} else {
+		aOptions.Theme = db.QoThemeLight
+	}
+} // setDefaults()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// NewHostSwitch returns a new `THostSwitch` instance.
+//
+//	`aDefault` The handler of requests for hosts not configured.
+func NewHostSwitch(aDefault http.Handler) *THostSwitch {
+	return &THostSwitch{
+		def:   aDefault,
+		hosts: make(map[string]http.Handler),
+	}
+} // NewHostSwitch()
+
+// Add registers `aHandler` for the host name and aliases of `aSite`.
+//
+//	`aSite` The virtual host to serve.
+//	`aHandler` The handler of the site's requests.
+func (hs *THostSwitch) Add(aSite *TVirtualHost, aHandler http.Handler) *THostSwitch {
+	hs.hosts[aSite.Host] = aHandler
+	for _, alias := range aSite.Aliases {
+		hs.hosts[alias] = aHandler
+	}
+
+	return hs
+} // Add()
+
+// ServeHTTP passes the request to the handler of the host named by
+// its `Host` header or – if there's no such host – to the default
+// site's handler.
+//
+//	`aWriter` Used by the HTTP handler to construct an HTTP response.
+//	`aRequest` The HTTP request received by the server.
+func (hs *THostSwitch) ServeHTTP(aWriter http.ResponseWriter, aRequest *http.Request) {
+	if handler, ok := hs.hosts[hostName(aRequest.Host)]; ok {
+		handler.ServeHTTP(aWriter, aRequest)
+		return
+	}
+
+	hs.def.ServeHTTP(aWriter, aRequest)
+} // ServeHTTP()
+
+/* _EoF_ */
@@ -0,0 +1,159 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mwat56/ini"
+	"github.com/mwat56/kaliber/db"
+)
+
+func Test_hostName(t *testing.T) {
+	tests := []struct {
+		name  string
+		aHost string
+		want  string
+	}{
+		// TODO: Add test cases.
+		{" 1", `books.example`, `books.example`},
+		{" 2", `Books.Example:8383`, `books.example`},
+		{" 3", `books.example.`, `books.example`},
+		{" 4", `[::1]:8383`, `::1`},
+		{" 5", ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostName(tt.aHost); got != tt.want {
+				t.Errorf("hostName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // Test_hostName()
+
+func Test_readVirtualHosts(t *testing.T) {
+	if nil == db.Library(`comics`) {
+		lib, err := db.NewLibrary(`comics`, `Comics`, t.TempDir(), t.TempDir())
+		if nil != err {
+			t.Fatal(err)
+		}
+		if err = db.AddLibrary(lib); nil != err {
+			t.Fatal(err)
+		}
+	}
+	saved := AppArgs
+	defer func() { AppArgs = saved }()
+	AppArgs.DataDir, AppArgs.Lang, AppArgs.Realm, AppArgs.Theme = `/tmp`, `en`, `eBooks Host`, `dark`
+	AppArgs.AuthBackend = AuthBackendPasslist
+
+	write := func(aText string) *ini.TIniList {
+		fName := filepath.Join(t.TempDir(), `kaliber.ini`)
+		if err := os.WriteFile(fName, []byte(aText), 0600); nil != err {
+			t.Fatal(err)
+		}
+		list, err := ini.New(fName)
+		if nil != err {
+			t.Fatal(err)
+		}
+		return list
+	}
+	tests := []struct {
+		name      string
+		aText     string
+		wantHosts []string
+		wantErr   bool
+	}{
+		// TODO: Add test cases.
+		{" 1", "[Default]\nlang = de\n", nil, false},
+		{" 2", "[host:Comics.Example]\nhostLibraries = comics\nrealm = Comics\ntheme = light\npassFile = comics.db\n", []string{`comics.example`}, false},
+		{" 3", "[host:comics.example]\nhostLibraries = unknown\n", nil, true},
+		{" 4", "[host:b.example]\naliases = www.b.example\n[host:a.example]\nlang = de\n", []string{`a.example`, `b.example`}, false},
+		{" 5", "[host:a.example]\nrealm = A\n[host:b.example]\naliases = a.example\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readVirtualHosts(write(tt.aText))
+			if (nil != err) != tt.wantErr {
+				t.Fatalf("readVirtualHosts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.wantHosts) {
+				t.Fatalf("readVirtualHosts() = %d hosts, want %d", len(got), len(tt.wantHosts))
+			}
+			for idx, vh := range got {
+				if vh.Host != tt.wantHosts[idx] {
+					t.Errorf("readVirtualHosts()[%d] = %q, want %q", idx, vh.Host, tt.wantHosts[idx])
+				}
+			}
+		})
+	}
+
+	got, _ := readVirtualHosts(write(tests[1].aText))
+	vh := got[0]
+	if (`Comics` != vh.Realm) || (`light` != vh.Theme) || (`en` != vh.Lang) ||
+		(`/tmp/comics.db` != vh.PassFile) || (`comics` != vh.library().Name()) {
+		t.Errorf("readVirtualHosts() = %#v", vh)
+	}
+	if nil != vh.libraryNamed(db.DefaultLibrary().Name()) {
+		t.Errorf("libraryNamed() served a library not listed")
+	}
+
+	savedList := vhList
+	defer func() { vhList = savedList }()
+	vhList = got
+	site := defaultSite()
+	if nil != site.libraryNamed(`comics`) {
+		t.Errorf("defaultSite().libraryNamed() served a library claimed by %q", vh.Host)
+	}
+	if nil == site.libraryNamed(db.DefaultLibrary().Name()) {
+		t.Errorf("defaultSite().libraryNamed() didn't serve an unclaimed library")
+	}
+
+	AppArgs.AuthBackend = AuthBackendHtpasswd
+	if _, err := readVirtualHosts(write(tests[1].aText)); nil == err {
+		t.Errorf("readVirtualHosts() accepted `passFile` with the %q backend", AppArgs.AuthBackend)
+	}
+} // Test_readVirtualHosts()
+
+func TestTHostSwitch_ServeHTTP(t *testing.T) {
+	handler := func(aName string) http.Handler {
+		return http.HandlerFunc(func(aWriter http.ResponseWriter, _ *http.Request) {
+			_, _ = aWriter.Write([]byte(aName))
+		})
+	}
+	hs := NewHostSwitch(handler(`default`)).
+		Add(&TVirtualHost{Host: `comics.example`, Aliases: []string{`www.comics.example`}}, handler(`comics`))
+	tests := []struct {
+		name  string
+		aHost string
+		want  string
+	}{
+		// TODO: Add test cases.
+		{" 1", `books.example`, `default`},
+		{" 2", `comics.example`, `comics`},
+		{" 3", `WWW.Comics.Example:8383`, `comics`},
+		{" 4", `127.0.0.1:8383`, `default`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(`GET`, `/`, nil)
+			req.Host = tt.aHost
+			w := httptest.NewRecorder()
+			hs.ServeHTTP(w, req)
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("THostSwitch.ServeHTTP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+} // TestTHostSwitch_ServeHTTP()
+
+/* _EoF_ */
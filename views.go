@@ -60,8 +60,10 @@ var (
 	// A list of functions to be used from within templates;
 	// see `NewView()`.
 	viewFunctionMap = template.FuncMap{
+		"coverSrcset":  coverSrcset,  // returns a cover's `srcset` value
 		"htmlSafe":     htmlSafe,     // returns `aText` as template.HTML
 		"selectOption": selectOption, // returns a Select Option
+		"thumbInfo":    thumbInfo,    // returns a thumbnail's placeholder data
 	}
 )
 
@@ -28,6 +28,10 @@ func TestNewView(t *testing.T) {
 		{" 1", args{"./views/", "test1"}, false, true},
 		{" 2", args{"./views/", "index"}, true, false},
 		{" 3", args{"./views/", "document"}, true, false},
+		{" 4", args{"./views/", "totp"}, true, false},
+		{" 5", args{"./views/", "tokens"}, true, false},
+		{" 6", args{"./views/", "share"}, true, false},
+		{" 7", args{"./views/", "changes"}, true, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
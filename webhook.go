@@ -0,0 +1,362 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+/*
+ * This file provides the outgoing webhooks notifying other services
+ * about the library's changes found by the database synchronisation.
+ */
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mwat56/apachelogger"
+	"github.com/mwat56/kaliber/db"
+)
+
+const (
+	// Delay before the first retry of a failed delivery (doubled
+	// for each further retry).
+	webhookBackoff = 30 * time.Second
+
+	// Name of the delivery logfile in the data directory.
+	webhookLogName = `webhooks.log`
+
+	// Timeout of a single delivery attempt.
+	webhookTimeout = 15 * time.Second
+
+	// Max. number of delivery attempts per target.
+	webhookTries = 6
+)
+
+type (
+	// `tWebhookBook` is a single book in a webhook's payload.
+	tWebhookBook struct {
+		Authors  []string          `json:"authors,omitempty"`
+		BlurHash string            `json:"blurhash,omitempty"`
+		Color    string            `json:"color,omitempty"`
+		Cover    string            `json:"cover,omitempty"`
+		Files    map[string]string `json:"files,omitempty"`
+		Formats  []string          `json:"formats,omitempty"`
+		ID       db.TID            `json:"id"`
+		Title    string            `json:"title"`
+		URL      string            `json:"url,omitempty"`
+	}
+
+	// `tWebhookPayload` is the JSON data sent to the webhooks.
+	tWebhookPayload struct {
+		Event    string         `json:"event"`
+		Library  string         `json:"library"`
+		Time     string         `json:"time"`
+		Added    []tWebhookBook `json:"added"`
+		Modified []tWebhookBook `json:"modified"`
+		Removed  []tWebhookBook `json:"removed"`
+	}
+
+	// TWebhooks sends the library's changes to a list of URLs.
+	//
+	// Each request carries the headers
+	//
+	//	X-Kaliber-Delivery: <unique ID, the same for all retries>
+	//	X-Kaliber-Event: library.changed
+	//	X-Kaliber-Signature: sha256=<hex HMAC of the body>
+	//
+	// Every attempt is written to the delivery logfile.
+	TWebhooks struct {
+		backoff time.Duration // delay before the first retry
+		baseURL string        // public URL of this server
+		client  *http.Client  // client to send the requests
+		logMtx  *sync.Mutex   // guard for the logfile
+		logName string        // name of the delivery logfile
+		secret  []byte        // key to sign the payload
+		targets []string      // the URLs to notify
+		tries   int           // max. number of attempts
+	}
+)
+
+// NewWebhooks returns a new webhooks sender or `nil` if `aTargets`
+// is empty.
+//
+//	`aTargets` Comma separated list of URLs to notify.
+//	`aSecret` The secret to sign the payloads with.
+//	`aBaseURL` The public URL of this server.
+//	`aLogName` The path-/filename of the delivery log.
+func NewWebhooks(aTargets, aSecret, aBaseURL, aLogName string) *TWebhooks {
+	var targets []string
+	for _, target := range strings.Split(aTargets, `,`) {
+		if target = strings.TrimSpace(target); 0 < len(target) {
+			targets = append(targets, target)
+		}
+	}
+	if 0 == len(targets) {
+		return nil
+	}
+	if 0 == len(aSecret) {
+		apachelogger.Err("NewWebhooks()",
+			"missing `webhookSecret`: webhook payloads will be unsigned")
+	}
+
+	return &TWebhooks{
+		backoff: webhookBackoff,
+		baseURL: strings.TrimRight(aBaseURL, `/`),
+		client:  &http.Client{Timeout: webhookTimeout},
+		logMtx:  new(sync.Mutex),
+		logName: aLogName,
+		secret:  []byte(aSecret),
+		targets: targets,
+		tries:   webhookTries,
+	}
+} // NewWebhooks()
+
+// `attempt()` sends `aBody` once to `aTarget` returning the response's
+// status code and a possible error.
+//
+//	`aTarget` The URL to send the payload to.
+//	`aDelivery` The delivery's unique ID.
+//	`aBody` The JSON payload to send.
+func (wh *TWebhooks) attempt(aTarget, aDelivery string, aBody []byte) (int, error) {
+	req, err := http.NewRequest(`POST`, aTarget, bytes.NewReader(aBody))
+	if nil != err {
+		return 0, err
+	}
+	req.Header.Set(`Content-Type`, `application/json`)
+	req.Header.Set(`User-Agent`, `kaliber-webhook/1`)
+	req.Header.Set(`X-Kaliber-Delivery`, aDelivery)
+	req.Header.Set(`X-Kaliber-Event`, `library.changed`)
+	if sig := wh.sign(aBody); 0 < len(sig) {
+		req.Header.Set(`X-Kaliber-Signature`, sig)
+	}
+
+	resp, err := wh.client.Do(req)
+	if nil != err {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if (200 > resp.StatusCode) || (300 <= resp.StatusCode) {
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+} // attempt()
+
+// `deliver()` sends `aBody` to `aTarget` retrying with an increasing
+// delay until it's accepted, rejected, or the attempts are used up.
+//
+// Network errors, server errors (5xx), and `429 Too Many Requests`
+// are retried; any other failure is final.
+//
+//	`aTarget` The URL to send the payload to.
+//	`aDelivery` The delivery's unique ID.
+//	`aBody` The JSON payload to send.
+func (wh *TWebhooks) deliver(aTarget, aDelivery string, aBody []byte) error {
+	var err error
+	delay := wh.backoff
+	for try := 1; try <= wh.tries; try++ {
+		start := time.Now()
+		status, e := wh.attempt(aTarget, aDelivery, aBody)
+		wh.log(aDelivery, aTarget, try, status, time.Since(start), e)
+		if err = e; nil == err {
+			return nil
+		}
+		if (0 != status) && (http.StatusTooManyRequests != status) && (500 > status) {
+			break
+		}
+		if try < wh.tries {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	apachelogger.Err("TWebhooks.deliver()",
+		fmt.Sprintf("delivery %s to %s failed: %v", aDelivery, aTarget, err))
+
+	return err
+} // deliver()
+
+// Deliver sends `aList` to all configured URLs, returning after all
+// deliveries are finished.
+//
+// The method's signature matches `db.TLibrary.OnChange()`.
+//
+//	`aList` The changes of the library.
+func (wh *TWebhooks) Deliver(aList db.TChangeList) {
+	if 0 == len(aList) {
+		return
+	}
+	body, err := json.Marshal(wh.payload(aList, time.Now()))
+	if nil != err {
+		apachelogger.Err("TWebhooks.Deliver()", fmt.Sprintf("%v", err))
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range wh.targets {
+		wg.Add(1)
+		go func(aTarget string) {
+			defer wg.Done()
+			_ = wh.deliver(aTarget, newDeliveryID(), body)
+		}(target)
+	}
+	wg.Wait()
+} // Deliver()
+
+// `log()` appends a delivery attempt to the logfile.
+//
+//	`aDelivery` The delivery's unique ID.
+//	`aTarget` The URL the payload was sent to.
+//	`aTry` The attempt's number.
+//	`aStatus` The response's status code (0 for network errors).
+//	`aDuration` The time the attempt took.
+//	`aErr` The attempt's error (if any).
+func (wh *TWebhooks) log(aDelivery, aTarget string, aTry, aStatus int, aDuration time.Duration, aErr error) {
+	result := `ok`
+	if nil != aErr {
+		result = strings.ReplaceAll(aErr.Error(), "\n", ` `)
+	}
+	line := fmt.Sprintf("%s %s %s try=%d status=%d time=%dms %s\n",
+		time.Now().Format(time.RFC3339), aDelivery, aTarget, aTry, aStatus,
+		aDuration.Milliseconds(), result)
+
+	wh.logMtx.Lock()
+	defer wh.logMtx.Unlock()
+
+	file, err := os.OpenFile(wh.logName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600) // #nosec G304
+	if nil != err {
+		apachelogger.Err("TWebhooks.log()", fmt.Sprintf("%v", err))
+		return
+	}
+	defer file.Close()
+	_, _ = file.WriteString(line)
+} // log()
+
+// `payload()` returns the webhook data of `aList`.
+//
+//	`aList` The changes of the library.
+//	`aTime` The time of the delivery.
+func (wh *TWebhooks) payload(aList db.TChangeList, aTime time.Time) *tWebhookPayload {
+	// All changes of a delivery belong to the same library:
+	lib := db.DefaultLibrary()
+	if 0 < len(aList) {
+		lib = aList[0].Library()
+	}
+	result := &tWebhookPayload{
+		Event:    `library.changed`,
+		Library:  lib.Title(),
+		Time:     aTime.UTC().Format(time.RFC3339),
+		Added:    []tWebhookBook{},
+		Modified: []tWebhookBook{},
+		Removed:  []tWebhookBook{},
+	}
+	for _, ch := range aList {
+		book := tWebhookBook{
+			Formats: ch.FormatList(),
+			ID:      ch.ID,
+			Title:   ch.Title,
+		}
+		if 0 < len(ch.Authors) {
+			book.Authors = strings.Split(ch.Authors, `, `)
+		}
+		if db.ChangeRemoved != ch.Kind {
+			id := strconv.Itoa(ch.ID)
+			book.Cover = wh.baseURL + lib.URL() + `/cover/` + id + `/cover.jpg`
+			book.URL = wh.baseURL + ch.DocLink()
+			if info, ok := thumbInfoStore(lib).get(ch.ID); ok {
+				book.BlurHash, book.Color = info.BlurHash, info.Color
+			}
+			if 0 < len(book.Formats) {
+				book.Files = make(map[string]string, len(book.Formats))
+				for _, format := range book.Formats {
+					book.Files[format] = wh.baseURL + lib.URL() + `/file/` + id + `/` +
+						format + `/` + id + `.` + strings.ToLower(format)
+				}
+			}
+		}
+
+		switch ch.Kind {
+		case db.ChangeAdded:
+			result.Added = append(result.Added, book)
+		case db.ChangeModified:
+			result.Modified = append(result.Modified, book)
+		case db.ChangeRemoved:
+			result.Removed = append(result.Removed, book)
+		}
+	}
+
+	return result
+} // payload()
+
+// `sign()` returns the signature header value of `aBody` (or an empty
+// string if there's no secret).
+//
+//	`aBody` The payload to sign.
+func (wh *TWebhooks) sign(aBody []byte) string {
+	if 0 == len(wh.secret) {
+		return ``
+	}
+	mac := hmac.New(sha256.New, wh.secret)
+	_, _ = mac.Write(aBody)
+
+	return `sha256=` + hex.EncodeToString(mac.Sum(nil))
+} // sign()
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+// `newDeliveryID()` returns a random ID for a webhook delivery.
+func newDeliveryID() string {
+	buf := make([]byte, 12)
+	_, _ = rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+} // newDeliveryID()
+
+// `webhookBaseURL()` returns the public URL of this server to use in
+// the webhook payloads.
+//
+// Without an explicit `webhookBaseURL` setting it's derived from the
+// listen address and the TLS configuration.
+func webhookBaseURL() string {
+	if 0 < len(AppArgs.WebhookBase) {
+		return AppArgs.WebhookBase
+	}
+	scheme := `http`
+	if (0 < len(AppArgs.CertKey)) && (0 < len(AppArgs.CertPem)) {
+		scheme = `https`
+	}
+	host := AppArgs.listen
+	if (0 == len(host)) || (`0.0.0.0` == host) || (`::` == host) {
+		host, _ = os.Hostname()
+	}
+
+	return scheme + `://` + net.JoinHostPort(host, strconv.Itoa(AppArgs.port))
+} // webhookBaseURL()
+
+// `webhookLogFile()` returns the path-/filename of the delivery log.
+//
+// The log names the webhooks' URLs (which often contain a token),
+// hence it's kept in the private directory.
+func webhookLogFile() string {
+	return privateFilename(``, ``, webhookLogName)
+} // webhookLogFile()
+
+/* _EoF_ */
@@ -0,0 +1,169 @@
+/*
+   Copyright © 2024 M.Watermann, 10247 Berlin, Germany
+                  All rights reserved
+               EMail : <support@mwat.de>
+*/
+
+package kaliber
+
+//lint:file-ignore ST1017 - I prefer Yoda conditions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mwat56/kaliber/db"
+)
+
+func TestNewWebhooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		aTargets string
+		want     int
+	}{
+		// TODO: Add test cases.
+		{" 1", ``, 0},
+		{" 2", ` , `, 0},
+		{" 3", `http://one.example`, 1},
+		{" 4", `http://one.example, http://two.example,`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewWebhooks(tt.aTargets, `secret`, ``, ``)
+			if (0 == tt.want) != (nil == got) {
+				t.Fatalf("NewWebhooks() = %v, want %d targets", got, tt.want)
+			}
+			if (nil != got) && (len(got.targets) != tt.want) {
+				t.Errorf("NewWebhooks() targets = %v, want %d", got.targets, tt.want)
+			}
+		})
+	}
+} // TestNewWebhooks()
+
+func TestTWebhooks_payload(t *testing.T) {
+	wh := NewWebhooks(`http://hook.example`, ``, `https://books.example/`, ``)
+	list := db.TChangeList{
+		{Authors: `Jane Doe, John Roe`, Formats: `EPUB,PDF`, ID: 3, Kind: db.ChangeAdded, Title: `three`},
+		{ID: 2, Kind: db.ChangeModified, Title: `two`},
+		{Formats: `EPUB`, ID: 1, Kind: db.ChangeRemoved, Title: `one`},
+	}
+	// Provide the placeholder data of book 2 without a data file:
+	store := thumbInfoStore(list[0].Library())
+	store.mtx.Lock()
+	store.load()
+	store.infos[2] = TThumbInfo{BlurHash: `00TI:j`, Color: `#ff0000`}
+	store.mtx.Unlock()
+	defer func() {
+		store.mtx.Lock()
+		delete(store.infos, 2)
+		store.mtx.Unlock()
+	}()
+	got := wh.payload(list, time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		// TODO: Add test cases.
+		{" 1", got.Time, `2024-02-01T10:00:00Z`},
+		{" 2", len(got.Added), 1},
+		{" 3", got.Added[0].Authors, []string{`Jane Doe`, `John Roe`}},
+		{" 4", got.Added[0].URL, `https://books.example/doc/3/doc.html`},
+		{" 5", got.Added[0].Files[`PDF`], `https://books.example/file/3/PDF/3.pdf`},
+		{" 6", got.Modified[0].Cover, `https://books.example/cover/2/cover.jpg`},
+		{" 7", got.Removed[0].Formats, []string{`EPUB`}},
+		{" 8", got.Removed[0].URL, ``},
+		{" 9", got.Modified[0].BlurHash, `00TI:j`},
+		{"10", got.Modified[0].Color, `#ff0000`},
+		{"11", got.Added[0].BlurHash, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("payload() = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+} // TestTWebhooks_payload()
+
+func TestTWebhooks_Deliver(t *testing.T) {
+	const secret = `s3cr3t`
+	var (
+		mtx      sync.Mutex
+		bodies   [][]byte
+		failures = map[string]int{`/flaky`: 2, `/broken`: 99}
+		statuses = map[string]int{`/flaky`: http.StatusServiceUnavailable, `/broken`: http.StatusBadRequest}
+		hits     = make(map[string]int)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(aWriter http.ResponseWriter, aRequest *http.Request) {
+		body, _ := io.ReadAll(aRequest.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(body)
+		if aRequest.Header.Get(`X-Kaliber-Signature`) != `sha256=`+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("Deliver() bad signature %q", aRequest.Header.Get(`X-Kaliber-Signature`))
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		hits[aRequest.URL.Path]++
+		if hits[aRequest.URL.Path] <= failures[aRequest.URL.Path] {
+			aWriter.WriteHeader(statuses[aRequest.URL.Path])
+			return
+		}
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	logName := filepath.Join(t.TempDir(), webhookLogName)
+	wh := NewWebhooks(server.URL+`/flaky,`+server.URL+`/broken`, secret, `http://books.example`, logName)
+	wh.backoff = time.Millisecond
+
+	wh.Deliver(db.TChangeList{{ID: 7, Kind: db.ChangeAdded, Title: `seven`}})
+
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		// TODO: Add test cases.
+		{" 1", hits[`/flaky`], 3},  // two failures, then success
+		{" 2", hits[`/broken`], 1}, // client errors aren't retried
+		{" 3", len(bodies), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("Deliver() = %d, want %d", tt.got, tt.want)
+			}
+		})
+	}
+
+	var payload tWebhookPayload
+	if err := json.Unmarshal(bodies[0], &payload); nil != err {
+		t.Fatalf("Deliver() payload error = %v", err)
+	}
+	if (1 != len(payload.Added)) || (7 != payload.Added[0].ID) {
+		t.Errorf("Deliver() payload = %v", payload)
+	}
+
+	data, err := os.ReadFile(logName)
+	if nil != err {
+		t.Fatalf("Deliver() log error = %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); 4 != len(lines) {
+		t.Errorf("Deliver() log = %q, want 4 lines", lines)
+	}
+} // TestTWebhooks_Deliver()